@@ -1,13 +1,30 @@
 package api
 
 import (
+	"context"
 	"database/sql"
+	"fmt"
 	"log"
 	"net/http"
+	"time"
 
+	"github.com/eugenius-watchman/ecom_go_rest_api/cmd/service/address"
 	"github.com/eugenius-watchman/ecom_go_rest_api/cmd/service/cart"
+	"github.com/eugenius-watchman/ecom_go_rest_api/cmd/service/category"
+	"github.com/eugenius-watchman/ecom_go_rest_api/cmd/service/currency"
+	"github.com/eugenius-watchman/ecom_go_rest_api/cmd/service/idempotency"
+	"github.com/eugenius-watchman/ecom_go_rest_api/cmd/service/invoice"
+	"github.com/eugenius-watchman/ecom_go_rest_api/cmd/service/order"
+	"github.com/eugenius-watchman/ecom_go_rest_api/cmd/service/payment"
 	"github.com/eugenius-watchman/ecom_go_rest_api/cmd/service/product"
+	"github.com/eugenius-watchman/ecom_go_rest_api/cmd/service/promotion"
+	"github.com/eugenius-watchman/ecom_go_rest_api/cmd/service/search"
+	"github.com/eugenius-watchman/ecom_go_rest_api/cmd/service/shipping"
+	"github.com/eugenius-watchman/ecom_go_rest_api/cmd/service/tax"
 	"github.com/eugenius-watchman/ecom_go_rest_api/cmd/service/user"
+	"github.com/eugenius-watchman/ecom_go_rest_api/cmd/service/variant"
+	"github.com/eugenius-watchman/ecom_go_rest_api/config"
+	"github.com/eugenius-watchman/ecom_go_rest_api/types"
 	"github.com/gorilla/mux"
 )
 
@@ -30,24 +47,94 @@ func (s *APIServer) Run() error {
 	router := mux.NewRouter()
 	subrouter := router.PathPrefix("/api/v1").Subrouter()
 
-	// handler for users
+	// users ... the handler is registered after carts, logins merge guest carts
 	userStore := user.NewStore(s.db)
-	userHandler := user.NewHandler(userStore)
-	userHandler.RegisterRoutes(subrouter)
+	tokenStore := user.NewTokenStore(s.db)
+
+	// the category tree, products are listed by category through the
+	// product handler
+	categoryStore := category.NewStore(s.db)
+	categoryHandler := category.NewHandler(categoryStore, userStore)
+	categoryHandler.RegisterRoutes(subrouter)
 
-	// handler for product
+	// handler for product ... prices in the currency each request asks for
 	productStore := product.NewStore(s.db)
-	productHandler := product.NewHandler(productStore)
+	variantStore := variant.NewStore(s.db)
+	pricer := currency.NewConverter(productStore, currency.NewStore(s.db))
+	productHandler := product.NewHandler(productStore, categoryStore, variantStore, userStore, pricer)
 	productHandler.RegisterRoutes(subrouter)
 
-	// cart handler with both stores
+	// options and variants, customers see them on the product
+	variantHandler := variant.NewHandler(variantStore, userStore)
+	variantHandler.RegisterRoutes(subrouter)
+
+	// product search on the FULLTEXT indexes
+	searchHandler := search.NewHandler(search.NewStore(s.db), pricer)
+	searchHandler.RegisterRoutes(subrouter)
+
+	// payments through the configured provider
+	orderStore := order.NewStore(s.db)
+	paymentProvider, err := newPaymentProvider(config.Envs.PaymentProvider)
+	if err != nil {
+		return err
+	}
+	paymentStore := payment.NewStore(s.db)
+	paymentService := payment.NewService(paymentProvider, paymentStore, orderStore)
+	paymentHandler := payment.NewHandler(paymentService, paymentStore, orderStore, userStore)
+	paymentHandler.RegisterRoutes(subrouter)
+
+	// order history ... cancelling a paid order refunds it
+	orderHandler := order.NewHandler(orderStore, paymentService, userStore)
+	orderHandler.RegisterRoutes(subrouter)
+
+	// invoices are numbered the first time a paid order's PDF is fetched
+	invoiceHandler := invoice.NewHandler(invoice.NewStore(s.db), orderStore, userStore)
+	invoiceHandler.RegisterRoutes(subrouter)
+
+	// cart handler ... checkout runs in a transaction owned by cartStore
 	cartStore := cart.NewStore(s.db)
-	cartHandler := cart.NewHandler(cartStore, productStore) // passing product
+	idempotencyStore := idempotency.NewStore(s.db)
+	taxCalculator := tax.NewCalculator(tax.NewStore(s.db))
+	shippingStore := shipping.NewStore(s.db)
+	shippingCalculator := shipping.NewCalculator(shippingStore, pricer)
+	addressStore := address.NewStore(s.db)
+	cartHandler := cart.NewHandler(cartStore, productStore, variantStore, pricer, taxCalculator, shippingCalculator, addressStore, idempotencyStore, paymentService, userStore)
 
 	cartHandler.RegisterRoutes(subrouter)
 
+	// promotion codes, admins manage them and checkout applies them
+	promotionStore := promotion.NewStore(s.db)
+	promotionHandler := promotion.NewHandler(promotionStore, userStore)
+	promotionHandler.RegisterRoutes(subrouter)
+
+	// shipping methods, admins manage them and checkout charges the chosen one
+	shippingHandler := shipping.NewHandler(shippingStore, userStore)
+	shippingHandler.RegisterRoutes(subrouter)
+
+	// the user's address book, checkout copies addresses from it
+	addressHandler := address.NewHandler(addressStore, userStore)
+	addressHandler.RegisterRoutes(subrouter)
+
+	// expired guest carts are cleaned up in the background
+	gcInterval := time.Duration(config.Envs.GuestCartGCIntervalInSeconds) * time.Second
+	go cart.CollectExpiredGuestCarts(context.Background(), cartStore, gcInterval)
+
+	userHandler := user.NewHandler(userStore, tokenStore, cartHandler)
+	userHandler.RegisterRoutes(subrouter)
+
 
 	log.Println("Listening on", s.addr)
 	
 	return http.ListenAndServe(s.addr, router)
 }
+
+// only the local fake gateway exists so far
+func newPaymentProvider(name string) (types.PaymentProvider, error) {
+	switch name {
+	case "fake":
+		tolerance := time.Second * time.Duration(config.Envs.PaymentWebhookToleranceInSeconds)
+		return payment.NewFakeProvider(config.Envs.PaymentWebhookSecret, tolerance), nil
+	}
+
+	return nil, fmt.Errorf("unknown payment provider %q", name)
+}
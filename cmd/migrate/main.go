@@ -21,6 +21,8 @@ func main() {
 		Net:                  "tcp",
 		AllowNativePasswords: true,
 		ParseTime:            true,
+		// migrations often hold more than one statement
+		MultiStatements: true,
 	})
 
 	if err != nil {
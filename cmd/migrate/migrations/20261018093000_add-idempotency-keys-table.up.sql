@@ -0,0 +1,13 @@
+CREATE TABLE IF NOT EXISTS idempotency_keys (
+    `userId` INT UNSIGNED NOT NULL,
+    `idempotencyKey` VARCHAR(255) NOT NULL,
+    `requestHash` CHAR(64) NOT NULL,
+    `responseStatus` SMALLINT UNSIGNED NULL DEFAULT NULL,
+    `responseBody` MEDIUMBLOB NULL,
+    `expiresAt` TIMESTAMP NOT NULL,
+    `createdAt` TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+
+    PRIMARY KEY (`userId`, `idempotencyKey`),
+    KEY (`expiresAt`),
+    FOREIGN KEY (`userId`) REFERENCES users(`id`)
+);
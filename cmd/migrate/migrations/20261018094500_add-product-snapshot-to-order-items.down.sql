@@ -0,0 +1,3 @@
+ALTER TABLE order_items
+    DROP COLUMN `productName`,
+    DROP COLUMN `productImage`;
@@ -0,0 +1,8 @@
+ALTER TABLE order_items
+    ADD COLUMN `productName` VARCHAR(255) NOT NULL DEFAULT '' AFTER `productId`,
+    ADD COLUMN `productImage` VARCHAR(255) NOT NULL DEFAULT '' AFTER `productName`;
+
+-- backfill existing rows from the current catalog
+UPDATE order_items oi
+    JOIN products p ON p.id = oi.productId
+SET oi.productName = p.name, oi.productImage = p.image;
@@ -0,0 +1,9 @@
+DROP TABLE IF EXISTS order_status_history;
+
+ALTER TABLE orders
+    MODIFY `status` ENUM('pending', 'completed', 'paid', 'shipped', 'delivered', 'cancelled') NOT NULL DEFAULT 'pending';
+
+UPDATE orders SET `status` = 'pending' WHERE `status` = 'paid';
+
+ALTER TABLE orders
+    MODIFY `status` ENUM('pending', 'completed', 'shipped', 'delivered', 'cancelled') NOT NULL DEFAULT 'pending';
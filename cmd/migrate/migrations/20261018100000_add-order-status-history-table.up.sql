@@ -0,0 +1,23 @@
+-- "paid" replaces the never used "completed"
+ALTER TABLE orders
+    MODIFY `status` ENUM('pending', 'completed', 'paid', 'shipped', 'delivered', 'cancelled') NOT NULL DEFAULT 'pending';
+
+UPDATE orders SET `status` = 'delivered' WHERE `status` = 'completed';
+
+ALTER TABLE orders
+    MODIFY `status` ENUM('pending', 'paid', 'shipped', 'delivered', 'cancelled') NOT NULL DEFAULT 'pending';
+
+CREATE TABLE IF NOT EXISTS order_status_history (
+    `id` INT UNSIGNED NOT NULL AUTO_INCREMENT,
+    `orderId` INT UNSIGNED NOT NULL,
+    `fromStatus` VARCHAR(32) NOT NULL,
+    `toStatus` VARCHAR(32) NOT NULL,
+    `changedBy` INT UNSIGNED NULL DEFAULT NULL,
+    `reason` VARCHAR(255) NOT NULL DEFAULT '',
+    `createdAt` TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+
+    PRIMARY KEY (`id`),
+    KEY (`orderId`, `createdAt`),
+    FOREIGN KEY (`orderId`) REFERENCES orders(`id`),
+    FOREIGN KEY (`changedBy`) REFERENCES users(`id`)
+);
@@ -0,0 +1,17 @@
+CREATE TABLE IF NOT EXISTS payments (
+    `id` INT UNSIGNED NOT NULL AUTO_INCREMENT,
+    `orderId` INT UNSIGNED NOT NULL,
+    `provider` VARCHAR(32) NOT NULL,
+    `providerIntentId` VARCHAR(255) NOT NULL,
+    `amount` BIGINT NOT NULL COMMENT 'minor units',
+    `currency` CHAR(3) NOT NULL,
+    `status` VARCHAR(32) NOT NULL,
+    `failureReason` VARCHAR(255) NOT NULL DEFAULT '',
+    `createdAt` TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    `updatedAt` TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP,
+
+    PRIMARY KEY (`id`),
+    UNIQUE KEY (`provider`, `providerIntentId`),
+    KEY (`orderId`),
+    FOREIGN KEY (`orderId`) REFERENCES orders(`id`)
+);
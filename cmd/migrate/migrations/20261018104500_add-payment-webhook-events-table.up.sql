@@ -0,0 +1,8 @@
+CREATE TABLE IF NOT EXISTS payment_webhook_events (
+    `provider` VARCHAR(32) NOT NULL,
+    `eventId` VARCHAR(255) NOT NULL,
+    `type` VARCHAR(64) NOT NULL,
+    `receivedAt` TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+
+    PRIMARY KEY (`provider`, `eventId`)
+);
@@ -0,0 +1,9 @@
+DROP TABLE IF EXISTS refund_items;
+DROP TABLE IF EXISTS refunds;
+
+-- the refund states go away with this migration
+UPDATE orders SET `status` = 'paid' WHERE `status` IN ('partially_refunded', 'refunded');
+
+ALTER TABLE orders
+    MODIFY `status` ENUM('pending', 'paid', 'shipped', 'delivered', 'cancelled') NOT NULL DEFAULT 'pending',
+    DROP COLUMN `refundedTotal`;
@@ -0,0 +1,33 @@
+ALTER TABLE orders
+    MODIFY `status` ENUM('pending', 'paid', 'shipped', 'delivered', 'cancelled', 'partially_refunded', 'refunded') NOT NULL DEFAULT 'pending',
+    ADD COLUMN `refundedTotal` DECIMAL(10,2) NOT NULL DEFAULT 0 AFTER `total`;
+
+CREATE TABLE IF NOT EXISTS refunds (
+    `id` INT UNSIGNED NOT NULL AUTO_INCREMENT,
+    `orderId` INT UNSIGNED NOT NULL,
+    `paymentId` INT UNSIGNED NOT NULL,
+    `providerRefundId` VARCHAR(255) NOT NULL,
+    `amount` BIGINT NOT NULL COMMENT 'minor units',
+    `currency` CHAR(3) NOT NULL,
+    `reason` VARCHAR(255) NOT NULL DEFAULT '',
+    `restock` BOOLEAN NOT NULL DEFAULT FALSE,
+    `createdBy` INT UNSIGNED NOT NULL,
+    `createdAt` TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+
+    PRIMARY KEY (`id`),
+    KEY (`orderId`),
+    FOREIGN KEY (`orderId`) REFERENCES orders(`id`),
+    FOREIGN KEY (`paymentId`) REFERENCES payments(`id`),
+    FOREIGN KEY (`createdBy`) REFERENCES users(`id`)
+);
+
+CREATE TABLE IF NOT EXISTS refund_items (
+    `refundId` INT UNSIGNED NOT NULL,
+    `orderItemId` INT UNSIGNED NOT NULL,
+    `quantity` INT NOT NULL,
+
+    PRIMARY KEY (`refundId`, `orderItemId`),
+    KEY (`orderItemId`),
+    FOREIGN KEY (`refundId`) REFERENCES refunds(`id`),
+    FOREIGN KEY (`orderItemId`) REFERENCES order_items(`id`)
+);
@@ -0,0 +1,5 @@
+ALTER TABLE orders
+    DROP COLUMN `currency`;
+
+DROP TABLE IF EXISTS exchange_rates;
+DROP TABLE IF EXISTS product_prices;
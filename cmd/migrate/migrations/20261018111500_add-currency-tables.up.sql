@@ -0,0 +1,24 @@
+-- explicit prices, products.price stays the price in the default currency
+CREATE TABLE IF NOT EXISTS product_prices (
+    `productId` INT UNSIGNED NOT NULL,
+    `currency` CHAR(3) NOT NULL,
+    `price` DECIMAL(10,2) NOT NULL,
+    `updatedAt` TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP,
+
+    PRIMARY KEY (`productId`, `currency`),
+    FOREIGN KEY (`productId`) REFERENCES products(`id`)
+);
+
+-- units of currency per one unit of the default currency, loaded from a
+-- local file by cmd/rates
+CREATE TABLE IF NOT EXISTS exchange_rates (
+    `currency` CHAR(3) NOT NULL,
+    `rate` DECIMAL(18,8) NOT NULL,
+    `updatedAt` TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP,
+
+    PRIMARY KEY (`currency`)
+);
+
+-- existing orders were all placed in the default currency
+ALTER TABLE orders
+    ADD COLUMN `currency` CHAR(3) NOT NULL DEFAULT 'USD' AFTER `userId`;
@@ -0,0 +1,26 @@
+-- one stored cart per user
+CREATE TABLE IF NOT EXISTS carts (
+    `id` INT UNSIGNED NOT NULL AUTO_INCREMENT,
+    `userId` INT UNSIGNED NOT NULL,
+    `createdAt` TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    `updatedAt` TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP,
+
+    PRIMARY KEY (`id`),
+    UNIQUE KEY (`userId`),
+    FOREIGN KEY (`userId`) REFERENCES users(`id`)
+);
+
+-- price and currency are what the customer saw when adding the item, so
+-- later changes can be pointed out
+CREATE TABLE IF NOT EXISTS cart_items (
+    `cartId` INT UNSIGNED NOT NULL,
+    `productId` INT UNSIGNED NOT NULL,
+    `quantity` INT NOT NULL,
+    `price` DECIMAL(10,2) NOT NULL,
+    `currency` CHAR(3) NOT NULL,
+    `addedAt` TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+
+    PRIMARY KEY (`cartId`, `productId`),
+    FOREIGN KEY (`cartId`) REFERENCES carts(`id`) ON DELETE CASCADE,
+    FOREIGN KEY (`productId`) REFERENCES products(`id`)
+);
@@ -0,0 +1,6 @@
+DELETE FROM carts WHERE userId IS NULL;
+
+ALTER TABLE carts
+    DROP COLUMN `expiresAt`,
+    DROP COLUMN `guestToken`,
+    MODIFY COLUMN `userId` INT UNSIGNED NOT NULL;
@@ -0,0 +1,7 @@
+-- guest carts have a token instead of a user and expire when left alone
+ALTER TABLE carts
+    MODIFY COLUMN `userId` INT UNSIGNED NULL,
+    ADD COLUMN `guestToken` CHAR(32) NULL AFTER `userId`,
+    ADD COLUMN `expiresAt` TIMESTAMP NULL AFTER `guestToken`,
+    ADD UNIQUE KEY (`guestToken`),
+    ADD KEY (`expiresAt`);
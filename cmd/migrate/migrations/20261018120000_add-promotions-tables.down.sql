@@ -0,0 +1,10 @@
+ALTER TABLE order_items DROP COLUMN `discount`;
+ALTER TABLE orders DROP COLUMN `discountTotal`;
+
+DROP TABLE IF EXISTS order_discounts;
+DROP TABLE IF EXISTS promotion_redemptions;
+DROP TABLE IF EXISTS promotion_categories;
+DROP TABLE IF EXISTS promotion_products;
+DROP TABLE IF EXISTS promotions;
+DROP TABLE IF EXISTS product_categories;
+DROP TABLE IF EXISTS categories;
@@ -0,0 +1,100 @@
+-- flat for now, promotions can be scoped to a category
+CREATE TABLE IF NOT EXISTS categories (
+    `id` INT UNSIGNED NOT NULL AUTO_INCREMENT,
+    `name` VARCHAR(255) NOT NULL,
+    `slug` VARCHAR(255) NOT NULL,
+    `createdAt` TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+
+    PRIMARY KEY (`id`),
+    UNIQUE KEY (`slug`)
+);
+
+CREATE TABLE IF NOT EXISTS product_categories (
+    `productId` INT UNSIGNED NOT NULL,
+    `categoryId` INT UNSIGNED NOT NULL,
+
+    PRIMARY KEY (`productId`, `categoryId`),
+    KEY (`categoryId`),
+    FOREIGN KEY (`productId`) REFERENCES products(`id`) ON DELETE CASCADE,
+    FOREIGN KEY (`categoryId`) REFERENCES categories(`id`) ON DELETE CASCADE
+);
+
+-- amountOff and minSpend are in currency, percentOff is a whole percent
+CREATE TABLE IF NOT EXISTS promotions (
+    `id` INT UNSIGNED NOT NULL AUTO_INCREMENT,
+    `code` VARCHAR(64) NOT NULL,
+    `description` VARCHAR(255) NOT NULL DEFAULT '',
+    `type` ENUM('percent', 'fixed', 'free_shipping', 'buy_x_get_y') NOT NULL,
+    `percentOff` INT NOT NULL DEFAULT 0,
+    `amountOff` DECIMAL(10,2) NOT NULL DEFAULT 0,
+    `currency` CHAR(3) NOT NULL,
+    `minSpend` DECIMAL(10,2) NOT NULL DEFAULT 0,
+    `buyQuantity` INT NOT NULL DEFAULT 0,
+    `getQuantity` INT NOT NULL DEFAULT 0,
+    `startsAt` TIMESTAMP NULL,
+    `endsAt` TIMESTAMP NULL,
+    `usageLimit` INT NULL,
+    `perUserLimit` INT NULL,
+    `usageCount` INT NOT NULL DEFAULT 0,
+    `active` BOOLEAN NOT NULL DEFAULT TRUE,
+    `createdAt` TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+
+    PRIMARY KEY (`id`),
+    UNIQUE KEY (`code`)
+);
+
+-- no rows in either scope table means the whole order is eligible
+CREATE TABLE IF NOT EXISTS promotion_products (
+    `promotionId` INT UNSIGNED NOT NULL,
+    `productId` INT UNSIGNED NOT NULL,
+
+    PRIMARY KEY (`promotionId`, `productId`),
+    FOREIGN KEY (`promotionId`) REFERENCES promotions(`id`) ON DELETE CASCADE,
+    FOREIGN KEY (`productId`) REFERENCES products(`id`) ON DELETE CASCADE
+);
+
+CREATE TABLE IF NOT EXISTS promotion_categories (
+    `promotionId` INT UNSIGNED NOT NULL,
+    `categoryId` INT UNSIGNED NOT NULL,
+
+    PRIMARY KEY (`promotionId`, `categoryId`),
+    FOREIGN KEY (`promotionId`) REFERENCES promotions(`id`) ON DELETE CASCADE,
+    FOREIGN KEY (`categoryId`) REFERENCES categories(`id`) ON DELETE CASCADE
+);
+
+-- one row per order a promotion was used on, counted for per-user limits
+CREATE TABLE IF NOT EXISTS promotion_redemptions (
+    `id` INT UNSIGNED NOT NULL AUTO_INCREMENT,
+    `promotionId` INT UNSIGNED NOT NULL,
+    `userId` INT UNSIGNED NOT NULL,
+    `orderId` INT UNSIGNED NOT NULL,
+    `createdAt` TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+
+    PRIMARY KEY (`id`),
+    KEY (`promotionId`, `userId`),
+    FOREIGN KEY (`promotionId`) REFERENCES promotions(`id`) ON DELETE CASCADE,
+    FOREIGN KEY (`userId`) REFERENCES users(`id`),
+    FOREIGN KEY (`orderId`) REFERENCES orders(`id`)
+);
+
+-- code and type are copied so the line survives the promotion being deleted
+CREATE TABLE IF NOT EXISTS order_discounts (
+    `id` INT UNSIGNED NOT NULL AUTO_INCREMENT,
+    `orderId` INT UNSIGNED NOT NULL,
+    `promotionId` INT UNSIGNED NULL,
+    `code` VARCHAR(64) NOT NULL,
+    `type` VARCHAR(32) NOT NULL,
+    `amount` DECIMAL(10,2) NOT NULL,
+    `freeShipping` BOOLEAN NOT NULL DEFAULT FALSE,
+
+    PRIMARY KEY (`id`),
+    FOREIGN KEY (`orderId`) REFERENCES orders(`id`),
+    FOREIGN KEY (`promotionId`) REFERENCES promotions(`id`) ON DELETE SET NULL
+);
+
+ALTER TABLE orders
+    ADD COLUMN `discountTotal` DECIMAL(10,2) NOT NULL DEFAULT 0 AFTER `total`;
+
+-- each line's share of the order discount, refunds give back the net price
+ALTER TABLE order_items
+    ADD COLUMN `discount` DECIMAL(10,2) NOT NULL DEFAULT 0 AFTER `price`;
@@ -0,0 +1,17 @@
+DROP TABLE IF EXISTS order_item_taxes;
+
+ALTER TABLE order_items
+    DROP COLUMN `total`,
+    DROP COLUMN `tax`;
+
+ALTER TABLE orders
+    DROP COLUMN `region`,
+    DROP COLUMN `country`,
+    DROP COLUMN `shippingTotal`,
+    DROP COLUMN `taxIncluded`,
+    DROP COLUMN `taxTotal`,
+    DROP COLUMN `subtotal`;
+
+DROP TABLE IF EXISTS tax_rates;
+
+ALTER TABLE products DROP COLUMN `taxClass`;
@@ -0,0 +1,48 @@
+-- picks the product's rate, e.g. reduced for books or food
+ALTER TABLE products
+    ADD COLUMN `taxClass` VARCHAR(32) NOT NULL DEFAULT 'standard' AFTER `quantity`;
+
+-- rate is in basis points. A rate with region '' applies to the whole
+-- country and adds up with the region's own rates. Inclusive rates are
+-- already part of the prices, exclusive ones are added on top.
+CREATE TABLE IF NOT EXISTS tax_rates (
+    `id` INT UNSIGNED NOT NULL AUTO_INCREMENT,
+    `country` CHAR(2) NOT NULL,
+    `region` VARCHAR(64) NOT NULL DEFAULT '',
+    `taxClass` VARCHAR(32) NOT NULL DEFAULT 'standard',
+    `name` VARCHAR(64) NOT NULL,
+    `rate` INT UNSIGNED NOT NULL,
+    `inclusive` BOOLEAN NOT NULL DEFAULT FALSE,
+
+    PRIMARY KEY (`id`),
+    UNIQUE KEY (`country`, `region`, `taxClass`, `name`)
+);
+
+ALTER TABLE orders
+    ADD COLUMN `subtotal` DECIMAL(10,2) NOT NULL DEFAULT 0 AFTER `currency`,
+    ADD COLUMN `taxTotal` DECIMAL(10,2) NOT NULL DEFAULT 0 AFTER `discountTotal`,
+    ADD COLUMN `taxIncluded` DECIMAL(10,2) NOT NULL DEFAULT 0 AFTER `taxTotal`,
+    ADD COLUMN `shippingTotal` DECIMAL(10,2) NOT NULL DEFAULT 0 AFTER `taxIncluded`,
+    ADD COLUMN `country` CHAR(2) NOT NULL DEFAULT '' AFTER `status`,
+    ADD COLUMN `region` VARCHAR(64) NOT NULL DEFAULT '' AFTER `country`;
+
+ALTER TABLE order_items
+    ADD COLUMN `tax` DECIMAL(10,2) NOT NULL DEFAULT 0 AFTER `discount`,
+    ADD COLUMN `total` DECIMAL(10,2) NOT NULL DEFAULT 0 AFTER `tax`;
+
+-- older orders carried neither tax nor shipping
+UPDATE orders SET subtotal = total + discountTotal;
+UPDATE order_items SET total = price * quantity - discount;
+
+CREATE TABLE IF NOT EXISTS order_item_taxes (
+    `id` INT UNSIGNED NOT NULL AUTO_INCREMENT,
+    `orderItemId` INT UNSIGNED NOT NULL,
+    `name` VARCHAR(64) NOT NULL,
+    `rate` INT UNSIGNED NOT NULL,
+    `inclusive` BOOLEAN NOT NULL,
+    `taxable` DECIMAL(10,2) NOT NULL,
+    `amount` DECIMAL(10,2) NOT NULL,
+
+    PRIMARY KEY (`id`),
+    FOREIGN KEY (`orderItemId`) REFERENCES order_items(`id`) ON DELETE CASCADE
+);
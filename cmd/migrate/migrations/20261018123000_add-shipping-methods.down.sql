@@ -0,0 +1,10 @@
+ALTER TABLE orders DROP COLUMN `shippingMethod`;
+
+DROP TABLE IF EXISTS shipping_method_countries;
+DROP TABLE IF EXISTS shipping_methods;
+
+ALTER TABLE products
+    DROP COLUMN `height`,
+    DROP COLUMN `width`,
+    DROP COLUMN `length`,
+    DROP COLUMN `weight`;
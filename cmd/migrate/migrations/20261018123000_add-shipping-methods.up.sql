@@ -0,0 +1,36 @@
+-- grams and millimetres, 0 when unknown
+ALTER TABLE products
+    ADD COLUMN `weight` INT UNSIGNED NOT NULL DEFAULT 0 AFTER `taxClass`,
+    ADD COLUMN `length` INT UNSIGNED NOT NULL DEFAULT 0 AFTER `weight`,
+    ADD COLUMN `width` INT UNSIGNED NOT NULL DEFAULT 0 AFTER `length`,
+    ADD COLUMN `height` INT UNSIGNED NOT NULL DEFAULT 0 AFTER `width`;
+
+-- amounts are in the default currency. price is the flat rate, the base of
+-- a weight rate, or what's charged below freeOver
+CREATE TABLE IF NOT EXISTS shipping_methods (
+    `id` INT UNSIGNED NOT NULL AUTO_INCREMENT,
+    `code` VARCHAR(64) NOT NULL,
+    `name` VARCHAR(255) NOT NULL,
+    `type` ENUM('flat', 'weight', 'free_over') NOT NULL,
+    `price` DECIMAL(10,2) NOT NULL DEFAULT 0,
+    `perKg` DECIMAL(10,2) NOT NULL DEFAULT 0,
+    `freeOver` DECIMAL(10,2) NOT NULL DEFAULT 0,
+    `maxWeight` INT UNSIGNED NOT NULL DEFAULT 0,
+    `active` BOOLEAN NOT NULL DEFAULT TRUE,
+    `createdAt` TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+
+    PRIMARY KEY (`id`),
+    UNIQUE KEY (`code`)
+);
+
+-- a method without countries ships everywhere
+CREATE TABLE IF NOT EXISTS shipping_method_countries (
+    `shippingMethodId` INT UNSIGNED NOT NULL,
+    `country` CHAR(2) NOT NULL,
+
+    PRIMARY KEY (`shippingMethodId`, `country`),
+    FOREIGN KEY (`shippingMethodId`) REFERENCES shipping_methods(`id`) ON DELETE CASCADE
+);
+
+ALTER TABLE orders
+    ADD COLUMN `shippingMethod` VARCHAR(64) NOT NULL DEFAULT '' AFTER `shippingTotal`;
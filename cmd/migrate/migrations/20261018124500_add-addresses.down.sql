@@ -0,0 +1,21 @@
+ALTER TABLE orders
+    DROP COLUMN `billingPhone`,
+    DROP COLUMN `billingCountry`,
+    DROP COLUMN `billingPostalCode`,
+    DROP COLUMN `billingRegion`,
+    DROP COLUMN `billingCity`,
+    DROP COLUMN `billingLine2`,
+    DROP COLUMN `billingLine1`,
+    DROP COLUMN `billingName`,
+    DROP COLUMN `shippingPhone`,
+    DROP COLUMN `shippingPostalCode`,
+    DROP COLUMN `shippingCity`,
+    DROP COLUMN `shippingLine2`,
+    DROP COLUMN `shippingLine1`,
+    DROP COLUMN `shippingName`;
+
+ALTER TABLE orders
+    RENAME COLUMN `shippingCountry` TO `country`,
+    RENAME COLUMN `shippingRegion` TO `region`;
+
+DROP TABLE IF EXISTS user_addresses;
@@ -0,0 +1,41 @@
+-- a user's saved addresses, at most one of them is the default
+CREATE TABLE IF NOT EXISTS user_addresses (
+    `id` INT UNSIGNED NOT NULL AUTO_INCREMENT,
+    `userId` INT UNSIGNED NOT NULL,
+    `label` VARCHAR(64) NOT NULL DEFAULT '',
+    `name` VARCHAR(255) NOT NULL,
+    `line1` VARCHAR(255) NOT NULL,
+    `line2` VARCHAR(255) NOT NULL DEFAULT '',
+    `city` VARCHAR(128) NOT NULL,
+    `region` VARCHAR(64) NOT NULL DEFAULT '',
+    `postalCode` VARCHAR(32) NOT NULL DEFAULT '',
+    `country` CHAR(2) NOT NULL,
+    `phone` VARCHAR(32) NOT NULL DEFAULT '',
+    `isDefault` BOOLEAN NOT NULL DEFAULT FALSE,
+    `createdAt` TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+
+    PRIMARY KEY (`id`),
+    KEY (`userId`, `isDefault`),
+    FOREIGN KEY (`userId`) REFERENCES users(`id`) ON DELETE CASCADE
+);
+
+-- orders keep a copy of both addresses, editing the address book later
+-- doesn't change where an order went. The tax country and region become
+-- the shipping address's own.
+ALTER TABLE orders
+    ADD COLUMN `shippingName` VARCHAR(255) NOT NULL DEFAULT '' AFTER `status`,
+    ADD COLUMN `shippingLine1` VARCHAR(255) NOT NULL DEFAULT '' AFTER `shippingName`,
+    ADD COLUMN `shippingLine2` VARCHAR(255) NOT NULL DEFAULT '' AFTER `shippingLine1`,
+    ADD COLUMN `shippingCity` VARCHAR(128) NOT NULL DEFAULT '' AFTER `shippingLine2`,
+    CHANGE COLUMN `region` `shippingRegion` VARCHAR(64) NOT NULL DEFAULT '' AFTER `shippingCity`,
+    ADD COLUMN `shippingPostalCode` VARCHAR(32) NOT NULL DEFAULT '' AFTER `shippingRegion`,
+    CHANGE COLUMN `country` `shippingCountry` CHAR(2) NOT NULL DEFAULT '' AFTER `shippingPostalCode`,
+    ADD COLUMN `shippingPhone` VARCHAR(32) NOT NULL DEFAULT '' AFTER `shippingCountry`,
+    ADD COLUMN `billingName` VARCHAR(255) NOT NULL DEFAULT '' AFTER `shippingPhone`,
+    ADD COLUMN `billingLine1` VARCHAR(255) NOT NULL DEFAULT '' AFTER `billingName`,
+    ADD COLUMN `billingLine2` VARCHAR(255) NOT NULL DEFAULT '' AFTER `billingLine1`,
+    ADD COLUMN `billingCity` VARCHAR(128) NOT NULL DEFAULT '' AFTER `billingLine2`,
+    ADD COLUMN `billingRegion` VARCHAR(64) NOT NULL DEFAULT '' AFTER `billingCity`,
+    ADD COLUMN `billingPostalCode` VARCHAR(32) NOT NULL DEFAULT '' AFTER `billingRegion`,
+    ADD COLUMN `billingCountry` CHAR(2) NOT NULL DEFAULT '' AFTER `billingPostalCode`,
+    ADD COLUMN `billingPhone` VARCHAR(32) NOT NULL DEFAULT '' AFTER `billingCountry`;
@@ -0,0 +1,6 @@
+ALTER TABLE orders
+    DROP INDEX `invoiceNumber`,
+    DROP COLUMN `invoicedAt`,
+    DROP COLUMN `invoiceNumber`;
+
+DROP TABLE IF EXISTS invoice_sequences;
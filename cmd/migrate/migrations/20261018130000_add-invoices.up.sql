@@ -0,0 +1,13 @@
+-- the last invoice number issued each year ... the row is locked while an
+-- order takes the next number, so numbers have no gaps
+CREATE TABLE IF NOT EXISTS invoice_sequences (
+    `year` SMALLINT UNSIGNED NOT NULL,
+    `lastNumber` INT UNSIGNED NOT NULL,
+
+    PRIMARY KEY (`year`)
+);
+
+ALTER TABLE orders
+    ADD COLUMN `invoiceNumber` VARCHAR(32) NULL AFTER `status`,
+    ADD COLUMN `invoicedAt` TIMESTAMP NULL AFTER `invoiceNumber`,
+    ADD UNIQUE KEY (`invoiceNumber`);
@@ -0,0 +1,4 @@
+ALTER TABLE products
+    DROP INDEX `products_createdAt_id`,
+    DROP INDEX `products_price_id`,
+    DROP INDEX `products_name_id`;
@@ -0,0 +1,5 @@
+-- one per sort, the ID breaks ties so pages never overlap
+ALTER TABLE products
+    ADD INDEX `products_createdAt_id` (`createdAt`, `id`),
+    ADD INDEX `products_price_id` (`price`, `id`),
+    ADD INDEX `products_name_id` (`name`, `id`);
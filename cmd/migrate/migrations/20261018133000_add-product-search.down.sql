@@ -0,0 +1,2 @@
+ALTER TABLE products DROP INDEX `products_name_ngram`;
+ALTER TABLE products DROP INDEX `products_search`;
@@ -0,0 +1,4 @@
+-- words for ranked search, and name bigrams for the typo fallback ... InnoDB
+-- builds one FULLTEXT index per statement
+ALTER TABLE products ADD FULLTEXT INDEX `products_search` (`name`, `description`);
+ALTER TABLE products ADD FULLTEXT INDEX `products_name_ngram` (`name`) WITH PARSER ngram;
@@ -0,0 +1,4 @@
+ALTER TABLE categories
+    DROP FOREIGN KEY `categories_parent`,
+    DROP KEY `categories_parentId`,
+    DROP COLUMN `parentId`;
@@ -0,0 +1,6 @@
+-- an adjacency list, top level categories have no parent ... a category
+-- with subcategories can't be deleted
+ALTER TABLE categories
+    ADD COLUMN `parentId` INT UNSIGNED NULL AFTER `slug`,
+    ADD KEY `categories_parentId` (`parentId`),
+    ADD CONSTRAINT `categories_parent` FOREIGN KEY (`parentId`) REFERENCES categories(`id`);
@@ -0,0 +1,15 @@
+DELETE FROM cart_items WHERE variantId <> 0;
+ALTER TABLE cart_items
+    DROP PRIMARY KEY,
+    ADD PRIMARY KEY (`cartId`, `productId`),
+    DROP COLUMN `variantId`;
+
+ALTER TABLE order_items
+    DROP FOREIGN KEY `order_items_variant`,
+    DROP COLUMN `sku`,
+    DROP COLUMN `variantId`;
+
+DROP TABLE IF EXISTS product_variant_values;
+DROP TABLE IF EXISTS product_variants;
+DROP TABLE IF EXISTS product_option_values;
+DROP TABLE IF EXISTS product_options;
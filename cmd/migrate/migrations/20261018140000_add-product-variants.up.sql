@@ -0,0 +1,61 @@
+-- the ways a product comes in, like size and colour, with their values in
+-- display order
+CREATE TABLE IF NOT EXISTS product_options (
+    `id` INT UNSIGNED NOT NULL AUTO_INCREMENT,
+    `productId` INT UNSIGNED NOT NULL,
+    `name` VARCHAR(64) NOT NULL,
+    `position` INT NOT NULL,
+
+    PRIMARY KEY (`id`),
+    UNIQUE KEY (`productId`, `name`),
+    FOREIGN KEY (`productId`) REFERENCES products(`id`) ON DELETE CASCADE
+);
+
+CREATE TABLE IF NOT EXISTS product_option_values (
+    `id` INT UNSIGNED NOT NULL AUTO_INCREMENT,
+    `optionId` INT UNSIGNED NOT NULL,
+    `value` VARCHAR(64) NOT NULL,
+    `position` INT NOT NULL,
+
+    PRIMARY KEY (`id`),
+    UNIQUE KEY (`optionId`, `value`),
+    FOREIGN KEY (`optionId`) REFERENCES product_options(`id`) ON DELETE CASCADE
+);
+
+-- a NULL price sells at the product's price, an empty image shows the
+-- product's ... products.quantity is kept the sum of its variants'
+CREATE TABLE IF NOT EXISTS product_variants (
+    `id` INT UNSIGNED NOT NULL AUTO_INCREMENT,
+    `productId` INT UNSIGNED NOT NULL,
+    `sku` VARCHAR(64) NOT NULL,
+    `price` DECIMAL(10,2) NULL,
+    `image` VARCHAR(255) NOT NULL DEFAULT '',
+    `quantity` INT UNSIGNED NOT NULL,
+    `createdAt` TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+
+    PRIMARY KEY (`id`),
+    UNIQUE KEY (`sku`),
+    FOREIGN KEY (`productId`) REFERENCES products(`id`) ON DELETE CASCADE
+);
+
+-- one value of every option of the product per variant
+CREATE TABLE IF NOT EXISTS product_variant_values (
+    `variantId` INT UNSIGNED NOT NULL,
+    `optionValueId` INT UNSIGNED NOT NULL,
+
+    PRIMARY KEY (`variantId`, `optionValueId`),
+    FOREIGN KEY (`variantId`) REFERENCES product_variants(`id`) ON DELETE CASCADE,
+    FOREIGN KEY (`optionValueId`) REFERENCES product_option_values(`id`)
+);
+
+-- the sku is a snapshot, the variant may be deleted later
+ALTER TABLE order_items
+    ADD COLUMN `variantId` INT UNSIGNED NULL AFTER `productId`,
+    ADD COLUMN `sku` VARCHAR(64) NOT NULL DEFAULT '' AFTER `variantId`,
+    ADD CONSTRAINT `order_items_variant` FOREIGN KEY (`variantId`) REFERENCES product_variants(`id`) ON DELETE SET NULL;
+
+-- 0 for a product without variants, so it can be part of the key
+ALTER TABLE cart_items
+    ADD COLUMN `variantId` INT UNSIGNED NOT NULL DEFAULT 0 AFTER `productId`,
+    DROP PRIMARY KEY,
+    ADD PRIMARY KEY (`cartId`, `productId`, `variantId`);
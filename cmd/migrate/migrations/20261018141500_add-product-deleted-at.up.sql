@@ -0,0 +1,4 @@
+-- a deleted product keeps its row so order items still point at it ... it's
+-- hidden from the catalog and can't be bought until restored
+ALTER TABLE products
+    ADD COLUMN `deletedAt` TIMESTAMP NULL DEFAULT NULL;
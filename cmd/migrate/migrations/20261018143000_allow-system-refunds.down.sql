@@ -0,0 +1,2 @@
+ALTER TABLE refunds
+    MODIFY `createdBy` INT UNSIGNED NOT NULL;
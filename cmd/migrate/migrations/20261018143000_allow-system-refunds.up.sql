@@ -0,0 +1,4 @@
+-- a refund issued by the system, like one for a payment captured after its
+-- order was cancelled, has nobody behind it
+ALTER TABLE refunds
+    MODIFY `createdBy` INT UNSIGNED NULL DEFAULT NULL;
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+
+	"github.com/eugenius-watchman/ecom_go_rest_api/cmd/service/currency"
+	"github.com/eugenius-watchman/ecom_go_rest_api/config"
+	"github.com/eugenius-watchman/ecom_go_rest_api/db"
+	"github.com/eugenius-watchman/ecom_go_rest_api/types"
+	"github.com/go-sql-driver/mysql"
+)
+
+// rate file ... rates are strings so they are loaded exactly
+type rateFile struct {
+	Base  string            `json:"base"`
+	Rates map[string]string `json:"rates"`
+}
+
+// refreshes exchange_rates from a local file, no live service involved
+//
+//	go run cmd/rates/main.go [file]
+func main() {
+	path := config.Envs.ExchangeRatesFile
+	if len(os.Args) > 1 {
+		path = os.Args[1]
+	}
+
+	rates, err := loadRates(path)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	db, err := db.NewMySQLStorage(mysql.Config{
+		User:                 config.Envs.DBUser,
+		Passwd:               config.Envs.DBPassword,
+		Addr:                 config.Envs.DBAddress,
+		DBName:               config.Envs.DBName,
+		Net:                  "tcp",
+		AllowNativePasswords: true,
+		ParseTime:            true,
+	})
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	if err := currency.NewStore(db).ReplaceExchangeRates(rates); err != nil {
+		log.Fatal(err)
+	}
+
+	log.Printf("Loaded %d exchange rates from %s", len(rates), path)
+}
+
+func loadRates(path string) ([]types.ExchangeRate, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read rate file: %w", err)
+	}
+
+	var file rateFile
+	if err := json.Unmarshal(data, &file); err != nil {
+		return nil, fmt.Errorf("invalid rate file: %w", err)
+	}
+
+	// every rate is relative to the currency products are priced in
+	if file.Base != config.Envs.DefaultCurrency {
+		return nil, fmt.Errorf("rate file base %q does not match the default currency %q", file.Base, config.Envs.DefaultCurrency)
+	}
+
+	rates := make([]types.ExchangeRate, 0, len(file.Rates))
+	for code, rate := range file.Rates {
+		if !currency.IsCode(code) || code == file.Base {
+			return nil, fmt.Errorf("invalid currency %q in rate file", code)
+		}
+
+		// reject anything Convert couldn't use later
+		if _, err := currency.Convert(types.NewMoney(100, file.Base), rate, code); err != nil {
+			return nil, err
+		}
+
+		rates = append(rates, types.ExchangeRate{Currency: code, Rate: rate})
+	}
+
+	sort.Slice(rates, func(i, j int) bool {
+		return rates[i].Currency < rates[j].Currency
+	})
+
+	return rates, nil
+}
@@ -0,0 +1,108 @@
+package address
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/eugenius-watchman/ecom_go_rest_api/types"
+)
+
+var (
+	ErrAddressNotFound = errors.New("address not found")
+	ErrInvalidAddress  = errors.New("invalid address")
+	ErrAddressRequired = errors.New("a shipping address is required")
+)
+
+// format is how addresses are written in one country. Countries without
+// one take any postal code, or none.
+type format struct {
+	postalCode *regexp.Regexp
+	// position of the space from the end of the postal code, 0 for none
+	postalSpace int
+	// region is required, and must match when set
+	region *regexp.Regexp
+}
+
+var (
+	fiveDigits = regexp.MustCompile(`^\d{5}$`)
+	stateCode  = regexp.MustCompile(`^[A-Z]{2,3}$`)
+)
+
+var formats = map[string]format{
+	"US": {postalCode: regexp.MustCompile(`^\d{5}(-\d{4})?$`), region: stateCode},
+	"CA": {postalCode: regexp.MustCompile(`^[A-Z]\d[A-Z] \d[A-Z]\d$`), postalSpace: 3, region: stateCode},
+	"AU": {postalCode: regexp.MustCompile(`^\d{4}$`), region: stateCode},
+	"GB": {postalCode: regexp.MustCompile(`^[A-Z]{1,2}\d[A-Z\d]? \d[A-Z]{2}$`), postalSpace: 3},
+	"NL": {postalCode: regexp.MustCompile(`^\d{4} [A-Z]{2}$`), postalSpace: 2},
+	"JP": {postalCode: regexp.MustCompile(`^\d{3}-\d{4}$`)},
+	"BR": {postalCode: regexp.MustCompile(`^\d{5}-\d{3}$`)},
+	"IN": {postalCode: regexp.MustCompile(`^\d{6}$`)},
+	"DE": {postalCode: fiveDigits},
+	"FR": {postalCode: fiveDigits},
+	"ES": {postalCode: fiveDigits},
+	"IT": {postalCode: fiveDigits},
+}
+
+// Normalize trims every field and writes codes the way the country does,
+// "sw1a1aa" in GB becomes "SW1A 1AA"
+func Normalize(address types.Address) types.Address {
+	fields := []*string{
+		&address.Name, &address.Line1, &address.Line2, &address.City,
+		&address.Region, &address.PostalCode, &address.Country, &address.Phone,
+	}
+	for _, field := range fields {
+		*field = strings.TrimSpace(*field)
+	}
+
+	address.Country = strings.ToUpper(address.Country)
+
+	f, ok := formats[address.Country]
+	if !ok {
+		return address
+	}
+
+	if f.postalCode != nil {
+		code := strings.ToUpper(strings.ReplaceAll(address.PostalCode, " ", ""))
+		if f.postalSpace > 0 && len(code) > f.postalSpace {
+			code = code[:len(code)-f.postalSpace] + " " + code[len(code)-f.postalSpace:]
+		}
+		address.PostalCode = code
+	}
+
+	if f.region != nil {
+		address.Region = strings.ToUpper(address.Region)
+	}
+
+	return address
+}
+
+// Validate checks a normalized address against its country's format ...
+// required fields are left to the payload's validate tags
+func Validate(address types.Address) error {
+	f, ok := formats[address.Country]
+	if !ok {
+		return nil
+	}
+
+	if f.postalCode != nil {
+		if address.PostalCode == "" {
+			return fmt.Errorf("%w: a postal code is required in %s", ErrInvalidAddress, address.Country)
+		}
+		if !f.postalCode.MatchString(address.PostalCode) {
+			return fmt.Errorf("%w: %s is not a postal code in %s", ErrInvalidAddress, address.PostalCode, address.Country)
+		}
+	}
+
+	if f.region != nil {
+		if address.Region == "" {
+			return fmt.Errorf("%w: a state or province is required in %s", ErrInvalidAddress, address.Country)
+		}
+		if !f.region.MatchString(address.Region) {
+			return fmt.Errorf("%w: %s is not a state or province code in %s", ErrInvalidAddress, address.Region, address.Country)
+		}
+	}
+
+	return nil
+}
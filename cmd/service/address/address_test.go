@@ -0,0 +1,63 @@
+package address
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/eugenius-watchman/ecom_go_rest_api/types"
+)
+
+func TestNormalize(t *testing.T) {
+	tests := []struct {
+		name       string
+		address    types.Address
+		postalCode string
+		region     string
+	}{
+		{"spaces a GB postcode", types.Address{Country: "gb", PostalCode: "sw1a1aa"}, "SW1A 1AA", ""},
+		{"spaces a NL postcode", types.Address{Country: "NL", PostalCode: "1012 ab"}, "1012 AB", ""},
+		{"uppercases states", types.Address{Country: "us", Region: " ny ", PostalCode: " 10001 "}, "10001", "NY"},
+		{"leaves unknown formats alone", types.Address{Country: "se", Region: "Skåne", PostalCode: "211 20"}, "211 20", "Skåne"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := Normalize(tt.address)
+			if got.PostalCode != tt.postalCode || got.Region != tt.region {
+				t.Errorf("expected %q %q, got %q %q", tt.postalCode, tt.region, got.PostalCode, got.Region)
+			}
+		})
+	}
+}
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		name    string
+		address types.Address
+		valid   bool
+	}{
+		{"US zip", types.Address{Country: "US", Region: "CA", PostalCode: "94105"}, true},
+		{"US zip+4", types.Address{Country: "US", Region: "CA", PostalCode: "94105-1234"}, true},
+		{"US without a state", types.Address{Country: "US", PostalCode: "94105"}, false},
+		{"US with a state name", types.Address{Country: "US", Region: "CALIFORNIA", PostalCode: "94105"}, false},
+		{"US short zip", types.Address{Country: "US", Region: "CA", PostalCode: "9410"}, false},
+		{"CA postal code", types.Address{Country: "CA", Region: "ON", PostalCode: "K1A 0B1"}, true},
+		{"GB postcode", types.Address{Country: "GB", PostalCode: "EC1A 1BB"}, true},
+		{"GB without a postcode", types.Address{Country: "GB"}, false},
+		{"DE postcode", types.Address{Country: "DE", PostalCode: "10115"}, true},
+		{"DE letters", types.Address{Country: "DE", PostalCode: "1O115"}, false},
+		{"no format without a postal code", types.Address{Country: "IE"}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := Validate(Normalize(tt.address))
+			if tt.valid && err != nil {
+				t.Errorf("expected a valid address, got %v", err)
+			}
+			if !tt.valid && !errors.Is(err, ErrInvalidAddress) {
+				t.Errorf("expected an invalid address, got %v", err)
+			}
+		})
+	}
+}
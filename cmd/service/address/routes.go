@@ -0,0 +1,197 @@
+package address
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/eugenius-watchman/ecom_go_rest_api/cmd/service/auth"
+	"github.com/eugenius-watchman/ecom_go_rest_api/types"
+	"github.com/eugenius-watchman/ecom_go_rest_api/utils"
+	"github.com/go-playground/validator/v10"
+	"github.com/gorilla/mux"
+)
+
+type Handler struct {
+	store     types.AddressStore
+	userStore types.UserStore
+}
+
+func NewHandler(store types.AddressStore, userStore types.UserStore) *Handler {
+	return &Handler{store: store, userStore: userStore}
+}
+
+func (h *Handler) RegisterRoutes(router *mux.Router) {
+	// the logged in user's own address book
+	router.HandleFunc("/me/addresses", auth.WithJWTAuth(h.handleGetAddresses, h.userStore)).Methods("GET")
+	router.HandleFunc("/me/addresses", auth.WithJWTAuth(h.handleCreateAddress, h.userStore)).Methods("POST")
+	router.HandleFunc("/me/addresses/{id}", auth.WithJWTAuth(h.handleGetAddress, h.userStore)).Methods("GET")
+	router.HandleFunc("/me/addresses/{id}", auth.WithJWTAuth(h.handleUpdateAddress, h.userStore)).Methods("PUT")
+	router.HandleFunc("/me/addresses/{id}", auth.WithJWTAuth(h.handleDeleteAddress, h.userStore)).Methods("DELETE")
+	router.HandleFunc("/me/addresses/{id}/default", auth.WithJWTAuth(h.handleSetDefaultAddress, h.userStore)).Methods("POST")
+}
+
+func (h *Handler) handleGetAddresses(w http.ResponseWriter, r *http.Request) {
+	userID := auth.GetUserIDFromContext(r.Context())
+
+	addresses, err := h.store.GetUserAddresses(userID)
+	if err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	utils.WriteJSON(w, http.StatusOK, addresses)
+}
+
+func (h *Handler) handleGetAddress(w http.ResponseWriter, r *http.Request) {
+	userID := auth.GetUserIDFromContext(r.Context())
+
+	id, ok := addressID(w, r)
+	if !ok {
+		return
+	}
+
+	address, err := h.store.GetUserAddress(userID, id)
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+
+	utils.WriteJSON(w, http.StatusOK, address)
+}
+
+func (h *Handler) handleCreateAddress(w http.ResponseWriter, r *http.Request) {
+	userID := auth.GetUserIDFromContext(r.Context())
+
+	address, ok := parseAddress(w, r)
+	if !ok {
+		return
+	}
+	address.UserID = userID
+
+	id, err := h.store.CreateUserAddress(address)
+	if err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	created, err := h.store.GetUserAddress(userID, id)
+	if err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	utils.WriteJSON(w, http.StatusCreated, created)
+}
+
+func (h *Handler) handleUpdateAddress(w http.ResponseWriter, r *http.Request) {
+	userID := auth.GetUserIDFromContext(r.Context())
+
+	id, ok := addressID(w, r)
+	if !ok {
+		return
+	}
+
+	address, ok := parseAddress(w, r)
+	if !ok {
+		return
+	}
+	address.ID = id
+	address.UserID = userID
+
+	if err := h.store.UpdateUserAddress(address); err != nil {
+		writeStoreError(w, err)
+		return
+	}
+
+	updated, err := h.store.GetUserAddress(userID, id)
+	if err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	utils.WriteJSON(w, http.StatusOK, updated)
+}
+
+func (h *Handler) handleDeleteAddress(w http.ResponseWriter, r *http.Request) {
+	userID := auth.GetUserIDFromContext(r.Context())
+
+	id, ok := addressID(w, r)
+	if !ok {
+		return
+	}
+
+	if err := h.store.DeleteUserAddress(userID, id); err != nil {
+		writeStoreError(w, err)
+		return
+	}
+
+	utils.WriteJSON(w, http.StatusOK, map[string]string{"message": "address deleted"})
+}
+
+func (h *Handler) handleSetDefaultAddress(w http.ResponseWriter, r *http.Request) {
+	userID := auth.GetUserIDFromContext(r.Context())
+
+	id, ok := addressID(w, r)
+	if !ok {
+		return
+	}
+
+	if err := h.store.SetDefaultAddress(userID, id); err != nil {
+		writeStoreError(w, err)
+		return
+	}
+
+	address, err := h.store.GetUserAddress(userID, id)
+	if err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	utils.WriteJSON(w, http.StatusOK, address)
+}
+
+func addressID(w http.ResponseWriter, r *http.Request) (int, bool) {
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		utils.WriteError(w, http.StatusBadRequest, fmt.Errorf("invalid address ID"))
+		return 0, false
+	}
+
+	return id, true
+}
+
+// parses, normalizes and validates the payload, writing the error response
+// on failure
+func parseAddress(w http.ResponseWriter, r *http.Request) (types.UserAddress, bool) {
+	var payload types.UserAddressPayload
+	if err := utils.ParseJSON(r, &payload); err != nil {
+		utils.WriteError(w, http.StatusBadRequest, err)
+		return types.UserAddress{}, false
+	}
+
+	payload.Address = Normalize(payload.Address)
+
+	if err := utils.Validate.Struct(payload); err != nil {
+		errors := err.(validator.ValidationErrors)
+		utils.WriteError(w, http.StatusBadRequest, fmt.Errorf("invalid payload %w", errors))
+		return types.UserAddress{}, false
+	}
+
+	if err := Validate(payload.Address); err != nil {
+		utils.WriteError(w, http.StatusBadRequest, err)
+		return types.UserAddress{}, false
+	}
+
+	return types.UserAddress{Label: payload.Label, Address: payload.Address, IsDefault: payload.Default}, true
+}
+
+func writeStoreError(w http.ResponseWriter, err error) {
+	if errors.Is(err, ErrAddressNotFound) {
+		utils.WriteError(w, http.StatusNotFound, err)
+		return
+	}
+
+	utils.WriteError(w, http.StatusInternalServerError, err)
+}
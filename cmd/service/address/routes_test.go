@@ -0,0 +1,238 @@
+package address
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/eugenius-watchman/ecom_go_rest_api/cmd/service/auth/authtest"
+	"github.com/eugenius-watchman/ecom_go_rest_api/types"
+	"github.com/gorilla/mux"
+)
+
+func TestAddressServiceHandlers(t *testing.T) {
+	store := &mockAddressStore{addresses: map[int]types.UserAddress{}}
+	handler := NewHandler(store, userStore)
+
+	router := mux.NewRouter()
+	handler.RegisterRoutes(router)
+
+	send := func(method, path string, userID int, payload any) *httptest.ResponseRecorder {
+		return authtest.Serve(t, router, method, path, userID, payload)
+	}
+
+	decode := func(rr *httptest.ResponseRecorder) types.UserAddress {
+		var address types.UserAddress
+		json.NewDecoder(rr.Body).Decode(&address)
+		return address
+	}
+
+	home := types.UserAddressPayload{
+		Label: "home",
+		Address: types.Address{
+			Name: "Ada Lovelace", Line1: "10 Downing Street", City: "London", PostalCode: "sw1a2aa", Country: "gb",
+		},
+	}
+	work := types.UserAddressPayload{
+		Label: "work",
+		Address: types.Address{
+			Name: "Ada Lovelace", Line1: "1 Market Street", City: "San Francisco", Region: "ca", PostalCode: "94105", Country: "US",
+		},
+	}
+
+	t.Run("should fail without a token", func(t *testing.T) {
+		if rr := send(http.MethodGet, "/me/addresses", 0, nil); rr.Code != http.StatusUnauthorized {
+			t.Errorf("expected status code %d, got %d", http.StatusUnauthorized, rr.Code)
+		}
+	})
+
+	t.Run("should make the first address the default", func(t *testing.T) {
+		rr := send(http.MethodPost, "/me/addresses", 1, home)
+		if rr.Code != http.StatusCreated {
+			t.Fatalf("expected status code %d, got %d: %s", http.StatusCreated, rr.Code, rr.Body)
+		}
+
+		created := decode(rr)
+		if !created.IsDefault || created.PostalCode != "SW1A 2AA" || created.Country != "GB" || created.UserID != 1 {
+			t.Errorf("unexpected address %+v", created)
+		}
+
+		rr = send(http.MethodPost, "/me/addresses", 1, work)
+		if rr.Code != http.StatusCreated {
+			t.Fatalf("expected status code %d, got %d: %s", http.StatusCreated, rr.Code, rr.Body)
+		}
+		if created := decode(rr); created.IsDefault || created.Region != "CA" {
+			t.Errorf("unexpected address %+v", created)
+		}
+	})
+
+	t.Run("should reject an address in the wrong format", func(t *testing.T) {
+		invalid := work
+		invalid.PostalCode = "SW1A 2AA"
+		if rr := send(http.MethodPost, "/me/addresses", 1, invalid); rr.Code != http.StatusBadRequest {
+			t.Errorf("expected status code %d, got %d", http.StatusBadRequest, rr.Code)
+		}
+	})
+
+	t.Run("should reject a missing street", func(t *testing.T) {
+		invalid := home
+		invalid.Line1 = ""
+		if rr := send(http.MethodPost, "/me/addresses", 1, invalid); rr.Code != http.StatusBadRequest {
+			t.Errorf("expected status code %d, got %d", http.StatusBadRequest, rr.Code)
+		}
+	})
+
+	t.Run("should switch the default", func(t *testing.T) {
+		rr := send(http.MethodPost, "/me/addresses/2/default", 1, nil)
+		if rr.Code != http.StatusOK {
+			t.Fatalf("expected status code %d, got %d: %s", http.StatusOK, rr.Code, rr.Body)
+		}
+		if !store.addresses[2].IsDefault || store.addresses[1].IsDefault {
+			t.Errorf("expected address 2 to be the only default, got %+v", store.addresses)
+		}
+	})
+
+	t.Run("should keep addresses to their owner", func(t *testing.T) {
+		if rr := send(http.MethodGet, "/me/addresses/1", 2, nil); rr.Code != http.StatusNotFound {
+			t.Errorf("expected status code %d, got %d", http.StatusNotFound, rr.Code)
+		}
+		if rr := send(http.MethodPut, "/me/addresses/1", 2, home); rr.Code != http.StatusNotFound {
+			t.Errorf("expected status code %d, got %d", http.StatusNotFound, rr.Code)
+		}
+		if rr := send(http.MethodDelete, "/me/addresses/1", 2, nil); rr.Code != http.StatusNotFound {
+			t.Errorf("expected status code %d, got %d", http.StatusNotFound, rr.Code)
+		}
+
+		var addresses []types.UserAddress
+		json.NewDecoder(send(http.MethodGet, "/me/addresses", 2, nil).Body).Decode(&addresses)
+		if len(addresses) != 0 {
+			t.Errorf("expected no addresses for user 2, got %d", len(addresses))
+		}
+	})
+
+	t.Run("should update an address", func(t *testing.T) {
+		update := home
+		update.Line2 = "Flat 1"
+		rr := send(http.MethodPut, "/me/addresses/1", 1, update)
+		if rr.Code != http.StatusOK {
+			t.Fatalf("expected status code %d, got %d: %s", http.StatusOK, rr.Code, rr.Body)
+		}
+		if updated := decode(rr); updated.Line2 != "Flat 1" || updated.IsDefault {
+			t.Errorf("unexpected address %+v", updated)
+		}
+	})
+
+	t.Run("should pick a new default when the default is deleted", func(t *testing.T) {
+		if rr := send(http.MethodDelete, "/me/addresses/2", 1, nil); rr.Code != http.StatusOK {
+			t.Fatalf("expected status code %d, got %d", http.StatusOK, rr.Code)
+		}
+		if !store.addresses[1].IsDefault {
+			t.Error("expected the remaining address to become the default")
+		}
+	})
+}
+
+// mockAddressStore keeps the default rules of the real store
+type mockAddressStore struct {
+	addresses map[int]types.UserAddress
+	nextID    int
+}
+
+func (m *mockAddressStore) GetUserAddresses(userID int) ([]types.UserAddress, error) {
+	addresses := []types.UserAddress{}
+	for _, a := range m.addresses {
+		if a.UserID == userID {
+			addresses = append(addresses, a)
+		}
+	}
+	return addresses, nil
+}
+
+func (m *mockAddressStore) GetUserAddress(userID int, id int) (*types.UserAddress, error) {
+	a, ok := m.addresses[id]
+	if !ok || a.UserID != userID {
+		return nil, ErrAddressNotFound
+	}
+	return &a, nil
+}
+
+func (m *mockAddressStore) GetDefaultAddress(userID int) (*types.UserAddress, error) {
+	for _, a := range m.addresses {
+		if a.UserID == userID && a.IsDefault {
+			return &a, nil
+		}
+	}
+	return nil, ErrAddressNotFound
+}
+
+func (m *mockAddressStore) CreateUserAddress(a types.UserAddress) (int, error) {
+	existing, _ := m.GetUserAddresses(a.UserID)
+	if len(existing) == 0 {
+		a.IsDefault = true
+	} else if a.IsDefault {
+		m.clearDefault(a.UserID)
+	}
+
+	m.nextID++
+	a.ID = m.nextID
+	m.addresses[a.ID] = a
+	return a.ID, nil
+}
+
+func (m *mockAddressStore) UpdateUserAddress(a types.UserAddress) error {
+	current, err := m.GetUserAddress(a.UserID, a.ID)
+	if err != nil {
+		return err
+	}
+	if a.IsDefault {
+		m.clearDefault(a.UserID)
+	}
+	a.IsDefault = a.IsDefault || current.IsDefault
+	m.addresses[a.ID] = a
+	return nil
+}
+
+func (m *mockAddressStore) DeleteUserAddress(userID int, id int) error {
+	current, err := m.GetUserAddress(userID, id)
+	if err != nil {
+		return err
+	}
+	delete(m.addresses, id)
+
+	if current.IsDefault {
+		newest := 0
+		for _, a := range m.addresses {
+			if a.UserID == userID && a.ID > newest {
+				newest = a.ID
+			}
+		}
+		if a, ok := m.addresses[newest]; ok {
+			a.IsDefault = true
+			m.addresses[newest] = a
+		}
+	}
+	return nil
+}
+
+func (m *mockAddressStore) SetDefaultAddress(userID int, id int) error {
+	a, err := m.GetUserAddress(userID, id)
+	if err != nil {
+		return err
+	}
+	m.clearDefault(userID)
+	a.IsDefault = true
+	m.addresses[id] = *a
+	return nil
+}
+
+func (m *mockAddressStore) clearDefault(userID int) {
+	for id, a := range m.addresses {
+		if a.UserID == userID {
+			a.IsDefault = false
+			m.addresses[id] = a
+		}
+	}
+}
+
+var userStore = &authtest.UserStore{Default: types.RoleCustomer}
@@ -0,0 +1,262 @@
+package address
+
+import (
+	"database/sql"
+	"fmt"
+
+	"github.com/eugenius-watchman/ecom_go_rest_api/types"
+)
+
+type Store struct {
+	db *sql.DB
+}
+
+func NewStore(db *sql.DB) *Store {
+	return &Store{db: db}
+}
+
+const selectUserAddress = `
+	SELECT id, userId, label, name, line1, line2, city, region, postalCode, country, phone, isDefault, createdAt
+	FROM user_addresses`
+
+// the default address first, then the newest
+func (s *Store) GetUserAddresses(userID int) ([]types.UserAddress, error) {
+	rows, err := s.db.Query(selectUserAddress+" WHERE userId = ? ORDER BY isDefault DESC, createdAt DESC, id DESC", userID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query addresses: %w", err)
+	}
+	defer rows.Close()
+
+	addresses := []types.UserAddress{}
+	for rows.Next() {
+		address, err := scanRowIntoUserAddress(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan address: %w", err)
+		}
+		addresses = append(addresses, *address)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("rows iteration error: %w", err)
+	}
+
+	return addresses, nil
+}
+
+// another user's address is not found either
+func (s *Store) GetUserAddress(userID int, id int) (*types.UserAddress, error) {
+	return s.getUserAddress(selectUserAddress+" WHERE userId = ? AND id = ?", userID, id)
+}
+
+func (s *Store) GetDefaultAddress(userID int) (*types.UserAddress, error) {
+	return s.getUserAddress(selectUserAddress+" WHERE userId = ? AND isDefault", userID)
+}
+
+// the user's first address becomes the default whatever IsDefault says
+func (s *Store) CreateUserAddress(address types.UserAddress) (int, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return 0, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	// lock the user's addresses so two first addresses can't both become
+	// the default
+	var count int
+	if err := tx.QueryRow("SELECT COUNT(*) FROM user_addresses WHERE userId = ? FOR UPDATE", address.UserID).Scan(&count); err != nil {
+		return 0, fmt.Errorf("failed to count addresses: %w", err)
+	}
+
+	if count == 0 {
+		address.IsDefault = true
+	} else if address.IsDefault {
+		if err := clearDefault(tx, address.UserID); err != nil {
+			return 0, err
+		}
+	}
+
+	const query = `
+		INSERT INTO user_addresses (userId, label, name, line1, line2, city, region, postalCode, country, phone, isDefault)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`
+
+	result, err := tx.Exec(query, address.UserID, address.Label, address.Name, address.Line1, address.Line2,
+		address.City, address.Region, address.PostalCode, address.Country, address.Phone, address.IsDefault)
+	if err != nil {
+		return 0, fmt.Errorf("failed to create address: %w", err)
+	}
+
+	id, err := result.LastInsertId()
+	if err != nil {
+		return 0, fmt.Errorf("failed to get address ID: %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, fmt.Errorf("failed to commit transaction: %w", err)
+	}
+
+	return int(id), nil
+}
+
+// IsDefault makes the address the default, leaving it unset keeps whatever
+// it was ... a user always has a default while they have addresses
+func (s *Store) UpdateUserAddress(address types.UserAddress) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	if err := lockUserAddress(tx, address.UserID, address.ID, nil); err != nil {
+		return err
+	}
+
+	if address.IsDefault {
+		if err := clearDefault(tx, address.UserID); err != nil {
+			return err
+		}
+	}
+
+	const query = `
+		UPDATE user_addresses
+		SET label = ?, name = ?, line1 = ?, line2 = ?, city = ?, region = ?, postalCode = ?, country = ?, phone = ?,
+			isDefault = isDefault OR ?
+		WHERE id = ? AND userId = ?`
+
+	_, err = tx.Exec(query, address.Label, address.Name, address.Line1, address.Line2, address.City,
+		address.Region, address.PostalCode, address.Country, address.Phone, address.IsDefault, address.ID, address.UserID)
+	if err != nil {
+		return fmt.Errorf("failed to update address: %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+
+	return nil
+}
+
+// deleting the default makes the newest remaining address the default
+func (s *Store) DeleteUserAddress(userID int, id int) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	var isDefault bool
+	if err := lockUserAddress(tx, userID, id, &isDefault); err != nil {
+		return err
+	}
+
+	if _, err := tx.Exec("DELETE FROM user_addresses WHERE id = ? AND userId = ?", id, userID); err != nil {
+		return fmt.Errorf("failed to delete address: %w", err)
+	}
+
+	if isDefault {
+		const query = `
+			UPDATE user_addresses SET isDefault = TRUE
+			WHERE userId = ?
+			ORDER BY createdAt DESC, id DESC
+			LIMIT 1`
+
+		if _, err := tx.Exec(query, userID); err != nil {
+			return fmt.Errorf("failed to pick a new default address: %w", err)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+
+	return nil
+}
+
+func (s *Store) SetDefaultAddress(userID int, id int) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	if err := lockUserAddress(tx, userID, id, nil); err != nil {
+		return err
+	}
+
+	if _, err := tx.Exec("UPDATE user_addresses SET isDefault = (id = ?) WHERE userId = ?", id, userID); err != nil {
+		return fmt.Errorf("failed to set default address: %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+
+	return nil
+}
+
+// lockUserAddress locks the user's address until the transaction ends,
+// reading isDefault into isDefault when it isn't nil
+func lockUserAddress(tx *sql.Tx, userID int, id int, isDefault *bool) error {
+	var current bool
+	err := tx.QueryRow("SELECT isDefault FROM user_addresses WHERE id = ? AND userId = ? FOR UPDATE", id, userID).Scan(&current)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return ErrAddressNotFound
+		}
+		return fmt.Errorf("failed to get address: %w", err)
+	}
+
+	if isDefault != nil {
+		*isDefault = current
+	}
+
+	return nil
+}
+
+func clearDefault(tx *sql.Tx, userID int) error {
+	if _, err := tx.Exec("UPDATE user_addresses SET isDefault = FALSE WHERE userId = ? AND isDefault", userID); err != nil {
+		return fmt.Errorf("failed to clear default address: %w", err)
+	}
+
+	return nil
+}
+
+func (s *Store) getUserAddress(query string, args ...any) (*types.UserAddress, error) {
+	address, err := scanRowIntoUserAddress(s.db.QueryRow(query, args...))
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, ErrAddressNotFound
+		}
+		return nil, fmt.Errorf("failed to get address: %w", err)
+	}
+
+	return address, nil
+}
+
+// scanner is satisfied by both *sql.Row and *sql.Rows
+type scanner interface {
+	Scan(dest ...any) error
+}
+
+func scanRowIntoUserAddress(row scanner) (*types.UserAddress, error) {
+	var address types.UserAddress
+	err := row.Scan(
+		&address.ID,
+		&address.UserID,
+		&address.Label,
+		&address.Name,
+		&address.Line1,
+		&address.Line2,
+		&address.City,
+		&address.Region,
+		&address.PostalCode,
+		&address.Country,
+		&address.Phone,
+		&address.IsDefault,
+		&address.CreatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	return &address, nil
+}
@@ -0,0 +1,71 @@
+// Package authtest signs requests in for route tests and stands in for the
+// user store the auth middleware checks roles against.
+package authtest
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/eugenius-watchman/ecom_go_rest_api/cmd/service/auth"
+	"github.com/eugenius-watchman/ecom_go_rest_api/config"
+	"github.com/eugenius-watchman/ecom_go_rest_api/types"
+)
+
+// UserStore knows users only by their role ... a user missing from Roles
+// gets Default, or isn't found when Default is empty
+type UserStore struct {
+	Roles   map[int]string
+	Default string
+}
+
+func (s *UserStore) GetUserByEmail(email string) (*types.User, error) {
+	return nil, fmt.Errorf("user not found")
+}
+
+func (s *UserStore) GetUserByID(id int) (*types.User, error) {
+	role, ok := s.Roles[id]
+	if !ok {
+		role = s.Default
+	}
+	if role == "" {
+		return nil, fmt.Errorf("user not found")
+	}
+	return &types.User{ID: id, Role: role}, nil
+}
+
+func (s *UserStore) CreateUser(types.User) error {
+	return nil
+}
+
+func (s *UserStore) UpdateUserRole(id int, role string) error {
+	return nil
+}
+
+// SignIn adds a bearer token for userID to req, userID 0 stays anonymous
+func SignIn(req *http.Request, userID int) {
+	if userID == 0 {
+		return
+	}
+	token, _ := auth.CreateJWT([]byte(config.Envs.JWTSecret), userID, "")
+	req.Header.Set("Authorization", "Bearer "+token)
+}
+
+// Serve sends payload as JSON to handler, signed in as userID
+func Serve(t testing.TB, handler http.Handler, method, path string, userID int, payload any) *httptest.ResponseRecorder {
+	t.Helper()
+
+	marshalled, _ := json.Marshal(payload)
+	req, err := http.NewRequest(method, path, bytes.NewBuffer(marshalled))
+	if err != nil {
+		t.Fatal(err)
+	}
+	SignIn(req, userID)
+
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+	return rr
+}
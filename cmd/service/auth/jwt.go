@@ -1,20 +1,33 @@
 package auth
 
 import (
-	// "strconv"
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
 	"time"
 
 	"github.com/eugenius-watchman/ecom_go_rest_api/config"
+	"github.com/eugenius-watchman/ecom_go_rest_api/types"
+	"github.com/eugenius-watchman/ecom_go_rest_api/utils"
 	"github.com/golang-jwt/jwt/v4"
 )
 
-func CreateJWT(secret []byte, userID int) (string, error) {
+type contextKey string
+
+const (
+	UserKey contextKey = "userID"
+	RoleKey contextKey = "role"
+)
+
+func CreateJWT(secret []byte, userID int, role string) (string, error) {
 	expiration := time.Second * time.Duration(config.Envs.
 		JWTExpirationInSeconds)
 
 	token := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
 		// "userID": strconv.Itoa(userID),
 		"userID": userID,  // Keep as integer
+		"role": role,
 		"exp": time.Now().Add(expiration).Unix(),
 	})
 
@@ -24,4 +37,146 @@ func CreateJWT(secret []byte, userID int) (string, error) {
 	}
 
 	return tokenString, nil
-}
\ No newline at end of file
+}
+
+// WithJWTAuth only calls handlerFunc when the request carries a valid
+// bearer token for an existing user, otherwise it answers 401
+func WithJWTAuth(handlerFunc http.HandlerFunc, store types.UserStore) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		tokenString, err := getTokenFromRequest(r)
+		if err != nil {
+			unauthorized(w, err)
+			return
+		}
+
+		userID, err := validateJWT(tokenString, []byte(config.Envs.JWTSecret))
+		if err != nil {
+			unauthorized(w, err)
+			return
+		}
+
+		// token is valid but the user could have been removed since
+		u, err := store.GetUserByID(userID)
+		if err != nil {
+			unauthorized(w, fmt.Errorf("user not found"))
+			return
+		}
+
+		// add user ID and role to the request context ... the role comes from
+		// the db rather than the claims so demotions apply immediately
+		ctx := context.WithValue(r.Context(), UserKey, u.ID)
+		ctx = context.WithValue(ctx, RoleKey, u.Role)
+		handlerFunc(w, r.WithContext(ctx))
+	}
+}
+
+// WithOptionalJWTAuth lets requests without an Authorization header through
+// with no user in the context ... a token that is sent must still be valid
+func WithOptionalJWTAuth(handlerFunc http.HandlerFunc, store types.UserStore) http.HandlerFunc {
+	authenticated := WithJWTAuth(handlerFunc, store)
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") == "" {
+			handlerFunc(w, r)
+			return
+		}
+
+		authenticated(w, r)
+	}
+}
+
+// WithRole is WithJWTAuth plus a role check, answering 403 when the
+// authenticated user holds none of the given roles
+func WithRole(handlerFunc http.HandlerFunc, store types.UserStore, roles ...string) http.HandlerFunc {
+	return WithJWTAuth(func(w http.ResponseWriter, r *http.Request) {
+		if !HasRole(r.Context(), roles...) {
+			permissionDenied(w)
+			return
+		}
+
+		handlerFunc(w, r)
+	}, store)
+}
+
+// HasRole reports whether the authenticated user holds one of roles
+func HasRole(ctx context.Context, roles ...string) bool {
+	role := GetRoleFromContext(ctx)
+	for _, allowed := range roles {
+		if role == allowed {
+			return true
+		}
+	}
+
+	return false
+}
+
+// GetUserIDFromContext returns the user ID set by WithJWTAuth, or -1
+func GetUserIDFromContext(ctx context.Context) int {
+	userID, ok := ctx.Value(UserKey).(int)
+	if !ok {
+		return -1
+	}
+
+	return userID
+}
+
+// GetRoleFromContext returns the role set by WithJWTAuth, or ""
+func GetRoleFromContext(ctx context.Context) string {
+	role, _ := ctx.Value(RoleKey).(string)
+	return role
+}
+
+func getTokenFromRequest(r *http.Request) (string, error) {
+	header := r.Header.Get("Authorization")
+	if header == "" {
+		return "", fmt.Errorf("missing authorization header")
+	}
+
+	scheme, token, found := strings.Cut(header, " ")
+	if !found || !strings.EqualFold(scheme, "Bearer") || strings.TrimSpace(token) == "" {
+		return "", fmt.Errorf("invalid authorization header")
+	}
+
+	return strings.TrimSpace(token), nil
+}
+
+// validateJWT checks signature, algorithm and expiry and returns the user ID
+func validateJWT(tokenString string, secret []byte) (int, error) {
+	token, err := jwt.Parse(tokenString, func(t *jwt.Token) (interface{}, error) {
+		// pin the algorithm ... never trust the header alone
+		if _, ok := t.Method.(*jwt.SigningMethodHMAC); !ok {
+			return nil, fmt.Errorf("unexpected signing method: %v", t.Header["alg"])
+		}
+		return secret, nil
+	}, jwt.WithValidMethods([]string{jwt.SigningMethodHS256.Alg()}))
+	if err != nil || !token.Valid {
+		return 0, fmt.Errorf("invalid token")
+	}
+
+	claims, ok := token.Claims.(jwt.MapClaims)
+	if !ok {
+		return 0, fmt.Errorf("invalid token claims")
+	}
+
+	// MapClaims.Valid only checks exp when present, we require it
+	if !claims.VerifyExpiresAt(time.Now().Unix(), true) {
+		return 0, fmt.Errorf("token expired")
+	}
+
+	// JSON numbers decode as float64
+	rawUserID, ok := claims["userID"].(float64)
+	if !ok || rawUserID <= 0 || rawUserID != float64(int(rawUserID)) {
+		return 0, fmt.Errorf("invalid token claims")
+	}
+
+	return int(rawUserID), nil
+}
+
+func unauthorized(w http.ResponseWriter, err error) {
+	w.Header().Set("WWW-Authenticate", `Bearer realm="api"`)
+	utils.WriteError(w, http.StatusUnauthorized, err)
+}
+
+func permissionDenied(w http.ResponseWriter) {
+	utils.WriteError(w, http.StatusForbidden, fmt.Errorf("permission denied"))
+}
@@ -1,11 +1,21 @@
 package auth
 
-import "testing"
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/eugenius-watchman/ecom_go_rest_api/config"
+	"github.com/eugenius-watchman/ecom_go_rest_api/types"
+	"github.com/golang-jwt/jwt/v4"
+)
 
 func TestCreateJWT(t *testing.T) {
 	secret := []byte("secret")
 
-	token, err := CreateJWT(secret, 1)
+	token, err := CreateJWT(secret, 1, types.RoleCustomer)
 	if err != nil {
 		t.Errorf("error creating JWT: %v", err)
 	}
@@ -14,3 +24,227 @@ func TestCreateJWT(t *testing.T) {
 		t.Error("expected token not to be empty")
 	}
 }
+
+func TestWithJWTAuth(t *testing.T) {
+	secret := []byte(config.Envs.JWTSecret)
+	store := &mockUserStore{}
+
+	var gotUserID int
+	handler := WithJWTAuth(func(w http.ResponseWriter, r *http.Request) {
+		gotUserID = GetUserIDFromContext(r.Context())
+		w.WriteHeader(http.StatusOK)
+	}, store)
+
+	serve := func(header string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		if header != "" {
+			req.Header.Set("Authorization", header)
+		}
+		rr := httptest.NewRecorder()
+		handler(rr, req)
+		return rr
+	}
+
+	signed := func(method jwt.SigningMethod, key interface{}, claims jwt.MapClaims) string {
+		token, err := jwt.NewWithClaims(method, claims).SignedString(key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return token
+	}
+
+	t.Run("should set the user ID for a valid token", func(t *testing.T) {
+		token, err := CreateJWT(secret, 42, types.RoleCustomer)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		rr := serve("Bearer " + token)
+		if rr.Code != http.StatusOK {
+			t.Errorf("expected status code %d, got %d", http.StatusOK, rr.Code)
+		}
+		if gotUserID != 42 {
+			t.Errorf("expected user ID 42, got %d", gotUserID)
+		}
+	})
+
+	t.Run("should fail without an authorization header", func(t *testing.T) {
+		rr := serve("")
+		if rr.Code != http.StatusUnauthorized {
+			t.Errorf("expected status code %d, got %d", http.StatusUnauthorized, rr.Code)
+		}
+	})
+
+	t.Run("should fail for a non bearer scheme", func(t *testing.T) {
+		token, _ := CreateJWT(secret, 42, types.RoleCustomer)
+		rr := serve("Basic " + token)
+		if rr.Code != http.StatusUnauthorized {
+			t.Errorf("expected status code %d, got %d", http.StatusUnauthorized, rr.Code)
+		}
+	})
+
+	t.Run("should fail for a token signed with another secret", func(t *testing.T) {
+		token, _ := CreateJWT([]byte("other"), 42, types.RoleCustomer)
+		rr := serve("Bearer " + token)
+		if rr.Code != http.StatusUnauthorized {
+			t.Errorf("expected status code %d, got %d", http.StatusUnauthorized, rr.Code)
+		}
+	})
+
+	t.Run("should fail for an expired token", func(t *testing.T) {
+		token := signed(jwt.SigningMethodHS256, secret, jwt.MapClaims{
+			"userID": 42,
+			"exp":    time.Now().Add(-time.Minute).Unix(),
+		})
+		rr := serve("Bearer " + token)
+		if rr.Code != http.StatusUnauthorized {
+			t.Errorf("expected status code %d, got %d", http.StatusUnauthorized, rr.Code)
+		}
+	})
+
+	t.Run("should fail for a token without exp", func(t *testing.T) {
+		token := signed(jwt.SigningMethodHS256, secret, jwt.MapClaims{"userID": 42})
+		rr := serve("Bearer " + token)
+		if rr.Code != http.StatusUnauthorized {
+			t.Errorf("expected status code %d, got %d", http.StatusUnauthorized, rr.Code)
+		}
+	})
+
+	t.Run("should fail for a token using another algorithm", func(t *testing.T) {
+		token := signed(jwt.SigningMethodHS512, secret, jwt.MapClaims{
+			"userID": 42,
+			"exp":    time.Now().Add(time.Minute).Unix(),
+		})
+		rr := serve("Bearer " + token)
+		if rr.Code != http.StatusUnauthorized {
+			t.Errorf("expected status code %d, got %d", http.StatusUnauthorized, rr.Code)
+		}
+	})
+
+	t.Run("should fail for an unsigned token", func(t *testing.T) {
+		token := signed(jwt.SigningMethodNone, jwt.UnsafeAllowNoneSignatureType, jwt.MapClaims{
+			"userID": 42,
+			"exp":    time.Now().Add(time.Minute).Unix(),
+		})
+		rr := serve("Bearer " + token)
+		if rr.Code != http.StatusUnauthorized {
+			t.Errorf("expected status code %d, got %d", http.StatusUnauthorized, rr.Code)
+		}
+	})
+
+	t.Run("should fail when the user no longer exists", func(t *testing.T) {
+		token, _ := CreateJWT(secret, 7, types.RoleCustomer)
+		rr := serve("Bearer " + token)
+		if rr.Code != http.StatusUnauthorized {
+			t.Errorf("expected status code %d, got %d", http.StatusUnauthorized, rr.Code)
+		}
+	})
+}
+
+func TestWithOptionalJWTAuth(t *testing.T) {
+	store := &mockUserStore{}
+
+	var gotUserID int
+	handler := WithOptionalJWTAuth(func(w http.ResponseWriter, r *http.Request) {
+		gotUserID = GetUserIDFromContext(r.Context())
+		w.WriteHeader(http.StatusOK)
+	}, store)
+
+	serve := func(header string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		if header != "" {
+			req.Header.Set("Authorization", header)
+		}
+		rr := httptest.NewRecorder()
+		handler(rr, req)
+		return rr
+	}
+
+	t.Run("should let anonymous requests through without a user", func(t *testing.T) {
+		rr := serve("")
+		if rr.Code != http.StatusOK || gotUserID != -1 {
+			t.Errorf("expected anonymous access, got status %d and user %d", rr.Code, gotUserID)
+		}
+	})
+
+	t.Run("should set the user ID for a valid token", func(t *testing.T) {
+		token, _ := CreateJWT([]byte(config.Envs.JWTSecret), 42, types.RoleCustomer)
+		rr := serve("Bearer " + token)
+		if rr.Code != http.StatusOK || gotUserID != 42 {
+			t.Errorf("expected user 42, got status %d and user %d", rr.Code, gotUserID)
+		}
+	})
+
+	t.Run("should fail for an invalid token", func(t *testing.T) {
+		if rr := serve("Bearer nope"); rr.Code != http.StatusUnauthorized {
+			t.Errorf("expected status code %d, got %d", http.StatusUnauthorized, rr.Code)
+		}
+	})
+}
+
+func TestWithRole(t *testing.T) {
+	secret := []byte(config.Envs.JWTSecret)
+	store := &mockUserStore{}
+
+	handler := WithRole(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}, store, types.RoleAdmin, types.RoleStaff)
+
+	serve := func(userID int) *httptest.ResponseRecorder {
+		token, err := CreateJWT(secret, userID, types.RoleAdmin)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.Header.Set("Authorization", "Bearer "+token)
+		rr := httptest.NewRecorder()
+		handler(rr, req)
+		return rr
+	}
+
+	t.Run("should allow a user holding an allowed role", func(t *testing.T) {
+		if rr := serve(1); rr.Code != http.StatusOK {
+			t.Errorf("expected status code %d, got %d", http.StatusOK, rr.Code)
+		}
+	})
+
+	t.Run("should use the stored role rather than the token claim", func(t *testing.T) {
+		if rr := serve(42); rr.Code != http.StatusForbidden {
+			t.Errorf("expected status code %d, got %d", http.StatusForbidden, rr.Code)
+		}
+	})
+
+	t.Run("should fail without a token", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		rr := httptest.NewRecorder()
+		handler(rr, req)
+		if rr.Code != http.StatusUnauthorized {
+			t.Errorf("expected status code %d, got %d", http.StatusUnauthorized, rr.Code)
+		}
+	})
+}
+
+type mockUserStore struct{}
+
+func (m *mockUserStore) GetUserByEmail(email string) (*types.User, error) {
+	return nil, fmt.Errorf("user not found")
+}
+
+// user 1 is an admin, user 42 a customer, everyone else is unknown
+func (m *mockUserStore) GetUserByID(id int) (*types.User, error) {
+	switch id {
+	case 1:
+		return &types.User{ID: id, Role: types.RoleAdmin}, nil
+	case 42:
+		return &types.User{ID: id, Role: types.RoleCustomer}, nil
+	}
+	return nil, fmt.Errorf("user not found")
+}
+
+func (m *mockUserStore) CreateUser(types.User) error {
+	return nil
+}
+
+func (m *mockUserStore) UpdateUserRole(id int, role string) error {
+	return nil
+}
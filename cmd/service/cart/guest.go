@@ -0,0 +1,111 @@
+package cart
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/eugenius-watchman/ecom_go_rest_api/config"
+	"github.com/eugenius-watchman/ecom_go_rest_api/types"
+)
+
+// GuestCartCookie holds "<token>.<signature>" for anonymous shoppers
+const GuestCartCookie = "cart_token"
+
+func guestCartTTL() time.Duration {
+	return time.Duration(config.Envs.GuestCartTTLInSeconds) * time.Second
+}
+
+// 16 random bytes, hex encoded to fit guestToken CHAR(32)
+func newGuestCartToken() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(b), nil
+}
+
+// the signature stops clients from guessing other guests' tokens without
+// a db lookup
+func signGuestCartToken(token string) string {
+	mac := hmac.New(sha256.New, []byte(config.Envs.CartTokenSecret))
+	mac.Write([]byte(token))
+	return token + "." + hex.EncodeToString(mac.Sum(nil))
+}
+
+// guestCartToken returns the token from a correctly signed cookie
+func guestCartToken(r *http.Request) (string, bool) {
+	cookie, err := r.Cookie(GuestCartCookie)
+	if err != nil {
+		return "", false
+	}
+
+	token, _, found := strings.Cut(cookie.Value, ".")
+	if !found || len(token) != 32 {
+		return "", false
+	}
+
+	if !hmac.Equal([]byte(signGuestCartToken(token)), []byte(cookie.Value)) {
+		return "", false
+	}
+
+	return token, true
+}
+
+func setGuestCartCookie(w http.ResponseWriter, token string, expiresAt time.Time) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     GuestCartCookie,
+		Value:    signGuestCartToken(token),
+		Path:     "/",
+		Expires:  expiresAt,
+		HttpOnly: true,
+		Secure:   strings.HasPrefix(config.Envs.PublicHost, "https://"),
+		SameSite: http.SameSiteLaxMode,
+	})
+}
+
+func clearGuestCartCookie(w http.ResponseWriter) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     GuestCartCookie,
+		Value:    "",
+		Path:     "/",
+		MaxAge:   -1,
+		HttpOnly: true,
+	})
+}
+
+// CollectExpiredGuestCarts deletes expired guest carts every interval until
+// ctx is done ... run it in its own goroutine. A non-positive interval
+// disables it rather than panicking in time.NewTicker.
+func CollectExpiredGuestCarts(ctx context.Context, store types.CartStore, interval time.Duration) {
+	if interval <= 0 {
+		log.Printf("guest carts: invalid collection interval %s, not collecting", interval)
+		return
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			deleted, err := store.DeleteExpiredGuestCarts(now)
+			if err != nil {
+				log.Println("guest carts:", err)
+				continue
+			}
+			if deleted > 0 {
+				log.Printf("guest carts: deleted %d expired carts", deleted)
+			}
+		}
+	}
+}
@@ -0,0 +1,226 @@
+package cart
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/eugenius-watchman/ecom_go_rest_api/cmd/service/auth/authtest"
+	"github.com/eugenius-watchman/ecom_go_rest_api/cmd/service/tax"
+	"github.com/eugenius-watchman/ecom_go_rest_api/types"
+	"github.com/gorilla/mux"
+)
+
+func TestGuestCart(t *testing.T) {
+	store := newMockCartStore(
+		types.Product{ID: 1, Name: "shirt", Price: types.NewMoney(2000, "USD"), Quantity: 3},
+		types.Product{ID: 2, Name: "hat", Price: types.NewMoney(1000, "USD"), Quantity: 5},
+		types.Product{ID: 3, Name: "scarf", Price: types.NewMoney(1500, "USD"), Quantity: 5},
+	)
+	handler := NewHandler(store, &mockProductStore{store: store}, &mockVariantStore{store: store}, &mockPricer{}, tax.NewCalculator(&mockTaxRateStore{}), newShippingCalculator(), newMockAddressStore(), &mockIdempotencyStore{}, &mockPaymentService{}, userStore)
+
+	router := mux.NewRouter()
+	handler.RegisterRoutes(router)
+
+	send := func(method, path string, body any, cookie *http.Cookie) *httptest.ResponseRecorder {
+		var buf bytes.Buffer
+		if body != nil {
+			json.NewEncoder(&buf).Encode(body)
+		}
+		req, err := http.NewRequest(method, path, &buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if cookie != nil {
+			req.AddCookie(cookie)
+		}
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		return rr
+	}
+
+	cartCookie := func(rr *httptest.ResponseRecorder) *http.Cookie {
+		for _, cookie := range rr.Result().Cookies() {
+			if cookie.Name == GuestCartCookie {
+				return cookie
+			}
+		}
+		return nil
+	}
+
+	var cookie *http.Cookie
+
+	t.Run("should give a guest an empty cart without creating one", func(t *testing.T) {
+		rr := send(http.MethodGet, "/cart", nil, nil)
+		if rr.Code != http.StatusOK {
+			t.Fatalf("expected status code %d, got %d", http.StatusOK, rr.Code)
+		}
+		if cartCookie(rr) != nil || len(store.guests) != 0 {
+			t.Error("expected no guest cart to be created")
+		}
+	})
+
+	t.Run("should create a guest cart on the first item", func(t *testing.T) {
+		rr := send(http.MethodPost, "/cart/items", types.AddCartItemPayload{ProductID: 1, Quantity: 2}, nil)
+		if rr.Code != http.StatusCreated {
+			t.Fatalf("expected status code %d, got %d", http.StatusCreated, rr.Code)
+		}
+		cookie = cartCookie(rr)
+		if cookie == nil || !cookie.HttpOnly {
+			t.Fatalf("expected an http-only cart cookie, got %+v", cookie)
+		}
+
+		send(http.MethodPost, "/cart/items", types.AddCartItemPayload{ProductID: 2, Quantity: 5}, cookie)
+		send(http.MethodPost, "/cart/items", types.AddCartItemPayload{ProductID: 3, Quantity: 1}, cookie)
+
+		var cart types.CartView
+		json.NewDecoder(send(http.MethodGet, "/cart", nil, cookie).Body).Decode(&cart)
+		if len(cart.Items) != 3 {
+			t.Errorf("expected 3 items in the guest cart, got %d", len(cart.Items))
+		}
+	})
+
+	t.Run("should ignore a tampered cookie", func(t *testing.T) {
+		forged := &http.Cookie{Name: GuestCartCookie, Value: cookie.Value[:len(cookie.Value)-1] + "0"}
+		if forged.Value == cookie.Value {
+			forged.Value = cookie.Value[:len(cookie.Value)-1] + "1"
+		}
+
+		var cart types.CartView
+		json.NewDecoder(send(http.MethodGet, "/cart", nil, forged).Body).Decode(&cart)
+		if len(cart.Items) != 0 {
+			t.Errorf("expected an empty cart, got %d items", len(cart.Items))
+		}
+	})
+
+	t.Run("should not let guests check out", func(t *testing.T) {
+		rr := send(http.MethodPost, "/cart/checkout", types.CheckoutPayload{ShippingMethod: "pickup", FromCart: true}, cookie)
+		if rr.Code != http.StatusUnauthorized {
+			t.Errorf("expected status code %d, got %d", http.StatusUnauthorized, rr.Code)
+		}
+	})
+
+	t.Run("should merge the guest cart on login", func(t *testing.T) {
+		// user 1 already has one shirt at an older price
+		userCart, _ := store.GetOrCreateCart(1)
+		store.SetCartItem(userCart.ID, types.CartItem{ProductID: 1, Quantity: 1, Price: types.NewMoney(1800, "USD")})
+
+		// the scarf is gone by the time they log in
+		store.mu.Lock()
+		delete(store.products, 3)
+		store.mu.Unlock()
+
+		req, _ := http.NewRequest(http.MethodPost, "/login", nil)
+		req.AddCookie(cookie)
+		rr := httptest.NewRecorder()
+		if err := handler.MergeGuestCart(rr, req, 1); err != nil {
+			t.Fatal(err)
+		}
+
+		if c := cartCookie(rr); c == nil || c.MaxAge >= 0 {
+			t.Errorf("expected the cart cookie to be cleared, got %+v", c)
+		}
+
+		items := store.cartItems[userCart.ID]
+		// 1 + 2 shirts capped at the 3 in stock, keeping the user's price
+		if shirt := items[types.LineKey{ProductID: 1}]; shirt.Quantity != 3 || shirt.Price.Amount != 1800 {
+			t.Errorf("unexpected shirt line %+v", shirt)
+		}
+		if hat := items[types.LineKey{ProductID: 2}]; hat.Quantity != 5 || hat.Price.Amount != 1000 {
+			t.Errorf("unexpected hat line %+v", hat)
+		}
+		if _, ok := items[types.LineKey{ProductID: 3}]; ok {
+			t.Error("expected the missing product to be dropped")
+		}
+		if len(store.guests) != 0 {
+			t.Error("expected the guest cart to be deleted")
+		}
+	})
+
+	t.Run("should not lower the user's own quantity", func(t *testing.T) {
+		guest, _ := store.CreateGuestCart("0123456789abcdef0123456789abcdef", time.Now().Add(time.Hour))
+		store.SetCartItem(guest.ID, types.CartItem{ProductID: 2, Quantity: 1, Price: types.NewMoney(1000, "USD")})
+
+		store.mu.Lock()
+		hat := store.products[2]
+		hat.Quantity = 2
+		store.products[2] = hat
+		store.mu.Unlock()
+
+		service := NewService(store, &mockProductStore{store: store}, &mockVariantStore{store: store}, &mockPricer{}, tax.NewCalculator(&mockTaxRateStore{}), newShippingCalculator(), newMockAddressStore())
+		if err := service.MergeGuestCart(guest, 1); err != nil {
+			t.Fatal(err)
+		}
+
+		userCart, _ := store.GetOrCreateCart(1)
+		if got := store.cartItems[userCart.ID][types.LineKey{ProductID: 2}].Quantity; got != 5 {
+			t.Errorf("expected the user's 5 hats to stay, got %d", got)
+		}
+	})
+
+	t.Run("should use the user's cart once logged in", func(t *testing.T) {
+		req, _ := http.NewRequest(http.MethodGet, "/cart", nil)
+		authtest.SignIn(req, 1)
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+
+		var cart types.CartView
+		json.NewDecoder(rr.Body).Decode(&cart)
+		if len(cart.Items) != 2 {
+			t.Errorf("expected 2 items in the user's cart, got %d", len(cart.Items))
+		}
+	})
+
+	t.Run("should reject an invalid token instead of treating it as a guest", func(t *testing.T) {
+		req, _ := http.NewRequest(http.MethodGet, "/cart", nil)
+		req.Header.Set("Authorization", "Bearer nope")
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		if rr.Code != http.StatusUnauthorized {
+			t.Errorf("expected status code %d, got %d", http.StatusUnauthorized, rr.Code)
+		}
+	})
+}
+
+func TestCollectExpiredGuestCarts(t *testing.T) {
+	store := newMockCartStore()
+	store.CreateGuestCart("expired", time.Now().Add(-time.Minute))
+	store.CreateGuestCart("fresh", time.Now().Add(time.Hour))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		CollectExpiredGuestCarts(ctx, store, 5*time.Millisecond)
+		close(done)
+	}()
+
+	deadline := time.Now().Add(time.Second)
+	for {
+		store.mu.Lock()
+		_, expired := store.guests["expired"]
+		_, fresh := store.guests["fresh"]
+		store.mu.Unlock()
+
+		if !expired {
+			if !fresh {
+				t.Error("expected the fresh cart to be kept")
+			}
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("expected the expired cart to be collected")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	cancel()
+	<-done
+
+	t.Run("should not start without a positive interval", func(t *testing.T) {
+		CollectExpiredGuestCarts(context.Background(), store, 0)
+	})
+}
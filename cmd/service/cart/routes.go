@@ -1,10 +1,21 @@
 package cart
 
 import (
+	"errors"
 	"fmt"
 	"net/http"
+	"strconv"
+	"strings"
 	"time"
 
+	"github.com/eugenius-watchman/ecom_go_rest_api/cmd/service/address"
+	"github.com/eugenius-watchman/ecom_go_rest_api/cmd/service/auth"
+	"github.com/eugenius-watchman/ecom_go_rest_api/cmd/service/currency"
+	"github.com/eugenius-watchman/ecom_go_rest_api/cmd/service/idempotency"
+	"github.com/eugenius-watchman/ecom_go_rest_api/cmd/service/promotion"
+	"github.com/eugenius-watchman/ecom_go_rest_api/cmd/service/shipping"
+	"github.com/eugenius-watchman/ecom_go_rest_api/cmd/service/variant"
+	"github.com/eugenius-watchman/ecom_go_rest_api/config"
 	"github.com/eugenius-watchman/ecom_go_rest_api/types"
 	"github.com/eugenius-watchman/ecom_go_rest_api/utils"
 	"github.com/go-playground/validator/v10"
@@ -12,29 +23,42 @@ import (
 )
 
 type Handler struct {
-	store        types.CartStore
-	productStore types.ProductStore
+	store            types.CartStore
+	service          *Service
+	idempotencyStore types.IdempotencyStore
+	payments         types.PaymentService
+	userStore        types.UserStore
 }
 
-func NewHandler(store types.CartStore, productStore types.ProductStore) *Handler {
+func NewHandler(store types.CartStore, productStore types.ProductStore, variantStore types.VariantStore, pricer types.ProductPricer, taxes types.TaxCalculator, shippingCalculator types.ShippingCalculator, addressStore types.AddressStore, idempotencyStore types.IdempotencyStore, payments types.PaymentService, userStore types.UserStore) *Handler {
 	return &Handler{
-		store:        store,
-		productStore: productStore,
+		store:            store,
+		service:          NewService(store, productStore, variantStore, pricer, taxes, shippingCalculator, addressStore),
+		idempotencyStore: idempotencyStore,
+		payments:         payments,
+		userStore:        userStore,
 	}
 }
 
 func (h *Handler) RegisterRoutes(router *mux.Router) {
-	router.HandleFunc("/cart/checkout", h.handleCheckout).Methods("POST")
+	// guests shop with a cart cookie, checkout needs an account
+	router.HandleFunc("/cart", auth.WithOptionalJWTAuth(h.handleGetCart, h.userStore)).Methods("GET")
+	router.HandleFunc("/cart/items", auth.WithOptionalJWTAuth(h.handleAddCartItem, h.userStore)).Methods("POST")
+	// ?variantId= picks the line of a product sold in variants
+	router.HandleFunc("/cart/items/{productId}", auth.WithOptionalJWTAuth(h.handleUpdateCartItem, h.userStore)).Methods("PATCH")
+	router.HandleFunc("/cart/items/{productId}", auth.WithOptionalJWTAuth(h.handleRemoveCartItem, h.userStore)).Methods("DELETE")
+
+	// shipping options for the cart, ?country= defaults to the shop's own
+	router.HandleFunc("/shipping/quote", auth.WithOptionalJWTAuth(h.handleQuoteShipping, h.userStore)).Methods("GET")
+
+	// clients retry checkout with the same Idempotency-Key to avoid duplicate orders
+	checkout := idempotency.WithIdempotencyKey(h.handleCheckout, h.idempotencyStore)
+	router.HandleFunc("/cart/checkout", auth.WithJWTAuth(checkout, h.userStore)).Methods("POST")
 }
 
 func (h *Handler) handleCheckout(w http.ResponseWriter, r *http.Request) {
-	// get user id from JWT token
-	userID, err := h.getUserIDFromToken(r)
-	if err != nil {
-		utils.WriteError(w, http.StatusUnauthorized, fmt.Errorf("unauthorized"))
-
-		return
-	}
+	// get user id set by the auth middleware
+	userID := auth.GetUserIDFromContext(r.Context())
 
 	var payload types.CheckoutPayload
 
@@ -45,6 +69,13 @@ func (h *Handler) handleCheckout(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// inline addresses are validated the way the address book stores them
+	for _, inline := range []*types.Address{payload.ShippingAddress, payload.BillingAddress} {
+		if inline != nil {
+			*inline = address.Normalize(*inline)
+		}
+	}
+
 	// validate payload
 	if err := utils.Validate.Struct(payload); err != nil {
 		errors := err.(validator.ValidationErrors)
@@ -53,132 +84,307 @@ func (h *Handler) handleCheckout(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// calculate total ... will need product prices from db
-	total, productPrices, err := h.calculateTotalWithPrices(payload.Items)
+	order, priceChanges, err := h.service.Checkout(userID, currency.FromRequest(r), payload)
 	if err != nil {
-		utils.WriteError(w, http.StatusInternalServerError, err)
+		switch {
+		// nothing was ordered, the client shows the new prices and confirms
+		case errors.Is(err, ErrPriceChanged):
+			utils.WriteJSON(w, http.StatusConflict, map[string]interface{}{
+				"error":        err.Error(),
+				"priceChanges": priceChanges,
+			})
+		case errors.Is(err, ErrProductNotFound), errors.Is(err, ErrCartEmpty), errors.Is(err, currency.ErrUnsupportedCurrency):
+			utils.WriteError(w, http.StatusBadRequest, err)
+		case errors.Is(err, variant.ErrVariantRequired), errors.Is(err, variant.ErrVariantNotFound):
+			utils.WriteError(w, http.StatusBadRequest, err)
+		case errors.Is(err, ErrInsufficientStock):
+			utils.WriteError(w, http.StatusConflict, err)
+		// limits reached while checking out, the code itself is fine
+		case errors.Is(err, promotion.ErrPromotionUsedUp), errors.Is(err, promotion.ErrPromotionUserLimit):
+			utils.WriteError(w, http.StatusConflict, err)
+		case errors.Is(err, promotion.ErrInvalidPromotion):
+			utils.WriteError(w, http.StatusBadRequest, err)
+		case errors.Is(err, shipping.ErrMethodNotFound), errors.Is(err, shipping.ErrMethodUnavailable):
+			utils.WriteError(w, http.StatusBadRequest, err)
+		case errors.Is(err, address.ErrAddressNotFound), errors.Is(err, address.ErrAddressRequired), errors.Is(err, address.ErrInvalidAddress):
+			utils.WriteError(w, http.StatusBadRequest, err)
+		default:
+			utils.WriteError(w, http.StatusInternalServerError, err)
+		}
 
 		return
 	}
 
-	// reduce quantities before creating order
-	for _, item := range payload.Items {
-		product, err := h.productStore.GetProductByID(item.ProductID)
+	response := map[string]interface{}{
+		"message":        "Order created successfully",
+		"orderId":        order.ID,
+		"subtotal":       order.Subtotal,
+		"discountTotal":  order.DiscountTotal,
+		"taxTotal":       order.TaxTotal,
+		"taxIncluded":    order.TaxIncluded,
+		"shippingTotal":  order.ShippingTotal,
+		"shippingMethod": order.ShippingMethod,
+		"total":          order.Total,
+		"currency":       order.Currency,
+		"status":         order.Status,
+	}
 
-		if err != nil {
-			utils.WriteError(w, http.StatusInternalServerError, 
-				fmt.Errorf("error fetching product %d: %w", item.ProductID, err))
+	// accepted with acceptPriceChanges ... echo what moved
+	if len(priceChanges) > 0 {
+		response["priceChanges"] = priceChanges
+	}
 
-			return
+	// the order stands even if paying fails ... the client can retry on
+	// /orders/{id}/payments
+	if payload.Payment != nil {
+		payment, err := h.payments.PayOrder(order, *payload.Payment)
+		if payment != nil {
+			response["payment"] = payment
+		}
+		if err != nil {
+			response["paymentError"] = err.Error()
 		}
+		response["status"] = order.Status
+	}
 
-		newQuantity := product.Quantity - item.Quantity
-		err = h.productStore.UpdateProductQuantity(item.ProductID, newQuantity)
+	utils.WriteJSON(w, http.StatusCreated, response)
+}
 
-		if err != nil {
-			utils.WriteError(w, http.StatusInternalServerError, 
-				fmt.Errorf("error updating stock for product %d: %w", item.ProductID, err))
+func (h *Handler) handleGetCart(w http.ResponseWriter, r *http.Request) {
+	cart, err := h.resolveCart(w, r, false)
+	if err != nil {
+		writeCartError(w, err)
+		return
+	}
 
-			 return
-		}
+	view, err := h.service.GetCart(cart, currency.FromRequest(r))
+	if err != nil {
+		writeCartError(w, err)
+		return
 	}
 
-	// create order
-	orderID, err := h.store.CreateOrder(types.Order{
-		UserID:    userID,
-		Total:     total,
-		Status:    "pending",
-		Address:   payload.Address,
-		CreatedAt: time.Now(),
-	})
+	utils.WriteJSON(w, http.StatusOK, view)
+}
 
+func (h *Handler) handleQuoteShipping(w http.ResponseWriter, r *http.Request) {
+	country := strings.ToUpper(r.URL.Query().Get("country"))
+	if country == "" {
+		country = config.Envs.ShopCountry
+	}
+	if err := utils.Validate.Var(country, "iso3166_1_alpha2"); err != nil {
+		utils.WriteError(w, http.StatusBadRequest, fmt.Errorf("invalid country %s", country))
+		return
+	}
+
+	cart, err := h.resolveCart(w, r, false)
 	if err != nil {
-		utils.WriteError(w, http.StatusInternalServerError, err)
+		writeCartError(w, err)
+		return
+	}
 
+	quotes, err := h.service.QuoteShipping(cart, currency.FromRequest(r), country)
+	if err != nil {
+		writeCartError(w, err)
 		return
 	}
 
-	// create order items
-	for _, item := range payload.Items {
-		// get price from productPrices map
-		price := productPrices[item.ProductID]
+	utils.WriteJSON(w, http.StatusOK, quotes)
+}
 
-		err := h.store.CreateOrderItem(types.OrderItem{
-			OrderID:   orderID,
-			ProductID: item.ProductID,
-			Quantity:  item.Quantity,
-			Price:     price, // from products db
-		})
-		if err != nil {
-			utils.WriteError(w, http.StatusInternalServerError, err)
+func (h *Handler) handleAddCartItem(w http.ResponseWriter, r *http.Request) {
+	var payload types.AddCartItemPayload
+	if err := utils.ParseJSON(r, &payload); err != nil {
+		utils.WriteError(w, http.StatusBadRequest, err)
+		return
+	}
 
-			return
-		}
+	if err := utils.Validate.Struct(payload); err != nil {
+		errors := err.(validator.ValidationErrors)
+		utils.WriteError(w, http.StatusBadRequest, fmt.Errorf("invalid payload %w", errors))
+		return
+	}
+
+	cart, err := h.resolveCart(w, r, true)
+	if err != nil {
+		writeCartError(w, err)
+		return
+	}
+
+	view, err := h.service.AddItem(cart, currency.FromRequest(r), payload)
+	if err != nil {
+		writeCartError(w, err)
+		return
+	}
+
+	utils.WriteJSON(w, http.StatusCreated, view)
+}
+
+func (h *Handler) handleUpdateCartItem(w http.ResponseWriter, r *http.Request) {
+	productID, err := strconv.Atoi(mux.Vars(r)["productId"])
+	if err != nil {
+		utils.WriteError(w, http.StatusBadRequest, fmt.Errorf("invalid product ID"))
+		return
+	}
+
+	variantID, ok := variantParam(w, r)
+	if !ok {
+		return
+	}
+
+	var payload types.UpdateCartItemPayload
+	if err := utils.ParseJSON(r, &payload); err != nil {
+		utils.WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	if err := utils.Validate.Struct(payload); err != nil {
+		errors := err.(validator.ValidationErrors)
+		utils.WriteError(w, http.StatusBadRequest, fmt.Errorf("invalid payload %w", errors))
+		return
 	}
 
-	utils.WriteJSON(w, http.StatusCreated, map[string]interface{}{
-		"message": "Order created successfully",
-		"orderId": orderID,
-		"total":   total,
-		"status":  "pending",
-	})
+	cart, err := h.resolveCart(w, r, false)
+	if err != nil {
+		writeCartError(w, err)
+		return
+	}
+	if cart == nil {
+		writeCartError(w, ErrCartItemNotFound)
+		return
+	}
+
+	view, err := h.service.UpdateItem(cart, currency.FromRequest(r), productID, variantID, payload.Quantity)
+	if err != nil {
+		writeCartError(w, err)
+		return
+	}
+
+	utils.WriteJSON(w, http.StatusOK, view)
 }
 
+func (h *Handler) handleRemoveCartItem(w http.ResponseWriter, r *http.Request) {
+	productID, err := strconv.Atoi(mux.Vars(r)["productId"])
+	if err != nil {
+		utils.WriteError(w, http.StatusBadRequest, fmt.Errorf("invalid product ID"))
+		return
+	}
 
-// helper func to get actual prices from db
-func (h *Handler) calculateTotalWithPrices(items []types.CheckoutItem) (float64, map[int]float64, error) {
-	var total float64
-	productPrices := make(map[int]float64)
+	variantID, ok := variantParam(w, r)
+	if !ok {
+		return
+	}
 
-	// get actual product price(s) from db
-	for _, item := range items {
-		product, err := h.productStore.GetProductByID(item.ProductID)
+	cart, err := h.resolveCart(w, r, false)
+	if err != nil {
+		writeCartError(w, err)
+		return
+	}
+	if cart == nil {
+		writeCartError(w, ErrCartItemNotFound)
+		return
+	}
 
-		if err != nil {
-			return 0, nil, fmt.Errorf("product with ID %d not found", item.ProductID)
-		}
+	view, err := h.service.RemoveItem(cart, currency.FromRequest(r), productID, variantID)
+	if err != nil {
+		writeCartError(w, err)
+		return
+	}
 
-		// ensure pdt exist
-		if product == nil {
-			return 0, nil, fmt.Errorf("product with ID %d not found", item.ProductID)
-		}
+	utils.WriteJSON(w, http.StatusOK, view)
+}
 
-		// product status
-		// if product.Status != "active" {
-		// 	return 0, nil, fmt.Errorf("product %s is not available", product.Name)
-		// }
+// resolveCart returns the logged in user's cart, or the guest cart behind
+// the cookie. A guest without one gets a new cart when create is set,
+// otherwise nil.
+func (h *Handler) resolveCart(w http.ResponseWriter, r *http.Request, create bool) (*types.Cart, error) {
+	if userID := auth.GetUserIDFromContext(r.Context()); userID > 0 {
+		return h.store.GetOrCreateCart(userID)
+	}
 
-		// check for sufficient quatity of product
-		if product.Quantity < item.Quantity {
-			return 0, nil, fmt.Errorf("insufficient quantity for priduct %s", product.Name)
+	// every visit pushes the expiry back
+	expiresAt := time.Now().Add(guestCartTTL())
+
+	if token, ok := guestCartToken(r); ok {
+		cart, err := h.store.GetGuestCart(token)
+		switch {
+		case err == nil:
+			if err := h.store.RenewGuestCart(cart.ID, expiresAt); err != nil {
+				return nil, err
+			}
+			cart.ExpiresAt = &expiresAt
+			setGuestCartCookie(w, token, expiresAt)
+			return cart, nil
+		case !errors.Is(err, ErrCartNotFound):
+			return nil, err
 		}
+	}
+
+	if !create {
+		return nil, nil
+	}
+
+	token, err := newGuestCartToken()
+	if err != nil {
+		return nil, err
+	}
 
-		itemTotal := product.Price * float64(item.Quantity)
-		total += itemTotal
-		productPrices[item.ProductID] = product.Price // store price for order items
+	cart, err := h.store.CreateGuestCart(token, expiresAt)
+	if err != nil {
+		return nil, err
 	}
 
-	return total, productPrices, nil
+	setGuestCartCookie(w, token, expiresAt)
+	return cart, nil
 }
 
-// helper function to get uer ID from JWT
-func (h *Handler) getUserIDFromToken(r *http.Request) (int, error) {
-	// implement JWT token extraction ...depends on auth middleware
-	// tentatively return a dummy user ID
-	return 1, nil
+// MergeGuestCart implements types.GuestCartMerger, the cookie is cleared
+// whether or not there was a cart behind it
+func (h *Handler) MergeGuestCart(w http.ResponseWriter, r *http.Request, userID int) error {
+	token, ok := guestCartToken(r)
+	if !ok {
+		return nil
+	}
+
+	clearGuestCartCookie(w)
+
+	guest, err := h.store.GetGuestCart(token)
+	if err != nil {
+		if errors.Is(err, ErrCartNotFound) {
+			return nil
+		}
+		return err
+	}
+
+	return h.service.MergeGuestCart(guest, userID)
 }
 
-// helper function to calculate order total
-func (h *Handler) calculateTotal(items []types.CheckoutItem) (float64, error) {
-	var total float64
+// variantParam reads ?variantId=, 0 when it's left out
+func variantParam(w http.ResponseWriter, r *http.Request) (int, bool) {
+	value := r.URL.Query().Get("variantId")
+	if value == "" {
+		return 0, true
+	}
 
-	// query product prices from db
-	// using place holder prices tentatively
-	for _, item := range items {
-		// in a real implementation, we get price from product table
-		productPrice := 10.0 // place holder
-		total += productPrice * float64(item.Quantity)
+	variantID, err := strconv.Atoi(value)
+	if err != nil || variantID < 1 {
+		utils.WriteError(w, http.StatusBadRequest, fmt.Errorf("invalid variant ID"))
+		return 0, false
 	}
 
-	return total, nil
+	return variantID, true
+}
+
+func writeCartError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, ErrProductNotFound), errors.Is(err, ErrCartEmpty), errors.Is(err, currency.ErrUnsupportedCurrency):
+		utils.WriteError(w, http.StatusBadRequest, err)
+	case errors.Is(err, variant.ErrVariantRequired), errors.Is(err, variant.ErrVariantNotFound):
+		utils.WriteError(w, http.StatusBadRequest, err)
+	case errors.Is(err, ErrCartItemNotFound):
+		utils.WriteError(w, http.StatusNotFound, err)
+	case errors.Is(err, ErrInsufficientStock):
+		utils.WriteError(w, http.StatusConflict, err)
+	default:
+		utils.WriteError(w, http.StatusInternalServerError, err)
+	}
 }
@@ -0,0 +1,1093 @@
+package cart
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/eugenius-watchman/ecom_go_rest_api/cmd/service/address"
+	"github.com/eugenius-watchman/ecom_go_rest_api/cmd/service/auth/authtest"
+	"github.com/eugenius-watchman/ecom_go_rest_api/cmd/service/currency"
+	"github.com/eugenius-watchman/ecom_go_rest_api/cmd/service/promotion"
+	"github.com/eugenius-watchman/ecom_go_rest_api/cmd/service/shipping"
+	"github.com/eugenius-watchman/ecom_go_rest_api/cmd/service/tax"
+	"github.com/eugenius-watchman/ecom_go_rest_api/cmd/service/variant"
+	"github.com/eugenius-watchman/ecom_go_rest_api/types"
+	"github.com/gorilla/mux"
+)
+
+func TestCartServiceHandlers(t *testing.T) {
+	store := newMockCartStore(types.Product{ID: 1, Name: "shirt", Price: types.NewMoney(2000, "USD"), Quantity: 2})
+	handler := NewHandler(store, &mockProductStore{store: store}, &mockVariantStore{store: store}, &mockPricer{}, tax.NewCalculator(&mockTaxRateStore{}), newShippingCalculator(), newMockAddressStore(), &mockIdempotencyStore{}, &mockPaymentService{}, userStore)
+
+	router := mux.NewRouter()
+	handler.RegisterRoutes(router)
+
+	checkout := func(userID int, payload types.CheckoutPayload) *httptest.ResponseRecorder {
+		marshalled, _ := json.Marshal(payload)
+		req, err := http.NewRequest(http.MethodPost, "/cart/checkout", bytes.NewBuffer(marshalled))
+		if err != nil {
+			t.Fatal(err)
+		}
+		authtest.SignIn(req, userID)
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		return rr
+	}
+
+	t.Run("should fail without a token", func(t *testing.T) {
+		rr := checkout(0, types.CheckoutPayload{
+			ShippingMethod: "pickup",
+			Items:          []types.CheckoutItem{{ProductID: 1, Quantity: 1}},
+		})
+		if rr.Code != http.StatusUnauthorized {
+			t.Errorf("expected status code %d, got %d", http.StatusUnauthorized, rr.Code)
+		}
+	})
+
+	t.Run("should fail if the payload is invalid", func(t *testing.T) {
+		rr := checkout(1, types.CheckoutPayload{ShippingMethod: "pickup"})
+		if rr.Code != http.StatusBadRequest {
+			t.Errorf("expected status code %d, got %d", http.StatusBadRequest, rr.Code)
+		}
+	})
+
+	t.Run("should fail for an unknown product", func(t *testing.T) {
+		rr := checkout(1, types.CheckoutPayload{
+			ShippingMethod: "pickup",
+			Items:          []types.CheckoutItem{{ProductID: 99, Quantity: 1}},
+		})
+		if rr.Code != http.StatusBadRequest {
+			t.Errorf("expected status code %d, got %d", http.StatusBadRequest, rr.Code)
+		}
+	})
+
+	t.Run("should create an order for the authenticated user", func(t *testing.T) {
+		rr := checkout(1, types.CheckoutPayload{
+			ShippingMethod: "pickup",
+			Items:          []types.CheckoutItem{{ProductID: 1, Quantity: 1}},
+		})
+		if rr.Code != http.StatusCreated {
+			t.Fatalf("expected status code %d, got %d", http.StatusCreated, rr.Code)
+		}
+		if got := store.orders[len(store.orders)-1].UserID; got != 1 {
+			t.Errorf("expected order for user 1, got user %d", got)
+		}
+	})
+
+	t.Run("should pay for the order when payment details are sent", func(t *testing.T) {
+		store.products[2] = types.Product{ID: 2, Name: "hat", Price: types.NewMoney(1000, "USD"), Quantity: 1}
+		rr := checkout(1, types.CheckoutPayload{
+			ShippingMethod: "pickup",
+			Items:          []types.CheckoutItem{{ProductID: 2, Quantity: 1}},
+			Payment:        &types.PaymentMethod{CardNumber: "4242424242424242"},
+		})
+		if rr.Code != http.StatusCreated {
+			t.Fatalf("expected status code %d, got %d", http.StatusCreated, rr.Code)
+		}
+
+		var response map[string]any
+		json.NewDecoder(rr.Body).Decode(&response)
+		if response["status"] != string(types.OrderStatusPaid) {
+			t.Errorf("expected order to be paid, got %v", response["status"])
+		}
+	})
+
+	t.Run("should reject malformed payment details", func(t *testing.T) {
+		rr := checkout(1, types.CheckoutPayload{
+			ShippingMethod: "pickup",
+			Items:          []types.CheckoutItem{{ProductID: 1, Quantity: 1}},
+			Payment:        &types.PaymentMethod{CardNumber: "abc"},
+		})
+		if rr.Code != http.StatusBadRequest {
+			t.Errorf("expected status code %d, got %d", http.StatusBadRequest, rr.Code)
+		}
+	})
+
+	t.Run("should reject an unsupported currency", func(t *testing.T) {
+		req, _ := http.NewRequest(http.MethodPost, "/cart/checkout?currency=XYZ", bytes.NewBufferString(
+			`{"shippingMethod":"pickup","items":[{"productId":1,"quantity":1}]}`))
+		authtest.SignIn(req, 1)
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		if rr.Code != http.StatusBadRequest {
+			t.Errorf("expected status code %d, got %d", http.StatusBadRequest, rr.Code)
+		}
+	})
+
+	t.Run("should reject an unknown promotion code", func(t *testing.T) {
+		rr := checkout(1, types.CheckoutPayload{
+			ShippingMethod: "pickup",
+			Items:          []types.CheckoutItem{{ProductID: 1, Quantity: 1}},
+			PromotionCode:  "NOPE",
+		})
+		if rr.Code != http.StatusBadRequest {
+			t.Errorf("expected status code %d, got %d", http.StatusBadRequest, rr.Code)
+		}
+	})
+
+	t.Run("should reject an invalid country", func(t *testing.T) {
+		rr := checkout(1, types.CheckoutPayload{
+			ShippingMethod:  "pickup",
+			Items:           []types.CheckoutItem{{ProductID: 1, Quantity: 1}},
+			ShippingAddress: testAddress("XX", "", ""),
+		})
+		if rr.Code != http.StatusBadRequest {
+			t.Errorf("expected status code %d, got %d", http.StatusBadRequest, rr.Code)
+		}
+	})
+
+	t.Run("should reject both a saved and an inline address", func(t *testing.T) {
+		rr := checkout(1, types.CheckoutPayload{
+			ShippingMethod:    "pickup",
+			Items:             []types.CheckoutItem{{ProductID: 1, Quantity: 1}},
+			ShippingAddressID: 1,
+			ShippingAddress:   testAddress("GB", "", "SW1A 1AA"),
+		})
+		if rr.Code != http.StatusBadRequest {
+			t.Errorf("expected status code %d, got %d", http.StatusBadRequest, rr.Code)
+		}
+	})
+
+	t.Run("should fail when stock runs out", func(t *testing.T) {
+		rr := checkout(1, types.CheckoutPayload{
+			ShippingMethod: "pickup",
+			Items:          []types.CheckoutItem{{ProductID: 1, Quantity: 2}},
+		})
+		if rr.Code != http.StatusConflict {
+			t.Errorf("expected status code %d, got %d", http.StatusConflict, rr.Code)
+		}
+	})
+}
+
+func TestStoredCart(t *testing.T) {
+	store := newMockCartStore(
+		types.Product{ID: 1, Name: "shirt", Price: types.NewMoney(2000, "USD"), Quantity: 3},
+		types.Product{ID: 2, Name: "hat", Price: types.NewMoney(1000, "USD"), Quantity: 5},
+	)
+	handler := NewHandler(store, &mockProductStore{store: store}, &mockVariantStore{store: store}, &mockPricer{}, tax.NewCalculator(&mockTaxRateStore{}), newShippingCalculator(), newMockAddressStore(), &mockIdempotencyStore{}, &mockPaymentService{}, userStore)
+
+	router := mux.NewRouter()
+	handler.RegisterRoutes(router)
+
+	send := func(method, path string, body any) *httptest.ResponseRecorder {
+		var buf bytes.Buffer
+		if body != nil {
+			json.NewEncoder(&buf).Encode(body)
+		}
+		req, err := http.NewRequest(method, path, &buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		authtest.SignIn(req, 1)
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		return rr
+	}
+
+	decode := func(rr *httptest.ResponseRecorder) map[string]any {
+		var response map[string]any
+		json.NewDecoder(rr.Body).Decode(&response)
+		return response
+	}
+
+	t.Run("should add items to the cart", func(t *testing.T) {
+		rr := send(http.MethodPost, "/cart/items", types.AddCartItemPayload{ProductID: 1, Quantity: 1})
+		if rr.Code != http.StatusCreated {
+			t.Fatalf("expected status code %d, got %d", http.StatusCreated, rr.Code)
+		}
+		send(http.MethodPost, "/cart/items", types.AddCartItemPayload{ProductID: 1, Quantity: 1})
+		send(http.MethodPost, "/cart/items", types.AddCartItemPayload{ProductID: 2, Quantity: 1})
+
+		if got := store.cartItems[1][types.LineKey{ProductID: 1}].Quantity; got != 2 {
+			t.Errorf("expected quantity 2, got %d", got)
+		}
+	})
+
+	t.Run("should not add more than is in stock", func(t *testing.T) {
+		rr := send(http.MethodPost, "/cart/items", types.AddCartItemPayload{ProductID: 1, Quantity: 2})
+		if rr.Code != http.StatusConflict {
+			t.Errorf("expected status code %d, got %d", http.StatusConflict, rr.Code)
+		}
+	})
+
+	t.Run("should fail for an unknown product", func(t *testing.T) {
+		rr := send(http.MethodPost, "/cart/items", types.AddCartItemPayload{ProductID: 99, Quantity: 1})
+		if rr.Code != http.StatusBadRequest {
+			t.Errorf("expected status code %d, got %d", http.StatusBadRequest, rr.Code)
+		}
+	})
+
+	t.Run("should update and remove items", func(t *testing.T) {
+		rr := send(http.MethodPatch, "/cart/items/2", types.UpdateCartItemPayload{Quantity: 4})
+		if rr.Code != http.StatusOK {
+			t.Fatalf("expected status code %d, got %d", http.StatusOK, rr.Code)
+		}
+		if got := store.cartItems[1][types.LineKey{ProductID: 2}].Quantity; got != 4 {
+			t.Errorf("expected quantity 4, got %d", got)
+		}
+
+		rr = send(http.MethodDelete, "/cart/items/2", nil)
+		if rr.Code != http.StatusOK {
+			t.Fatalf("expected status code %d, got %d", http.StatusOK, rr.Code)
+		}
+		rr = send(http.MethodDelete, "/cart/items/2", nil)
+		if rr.Code != http.StatusNotFound {
+			t.Errorf("expected status code %d, got %d", http.StatusNotFound, rr.Code)
+		}
+	})
+
+	t.Run("should surface price and stock changes on read", func(t *testing.T) {
+		store.mu.Lock()
+		shirt := store.products[1]
+		shirt.Price = types.NewMoney(2500, "USD")
+		store.products[1] = shirt
+		store.mu.Unlock()
+
+		rr := send(http.MethodGet, "/cart", nil)
+		if rr.Code != http.StatusOK {
+			t.Fatalf("expected status code %d, got %d", http.StatusOK, rr.Code)
+		}
+
+		var cart types.CartView
+		json.NewDecoder(rr.Body).Decode(&cart)
+		if !cart.HasChanges || len(cart.Items) != 1 {
+			t.Fatalf("expected one changed line, got %+v", cart)
+		}
+		line := cart.Items[0]
+		if line.PreviousPrice == nil || line.PreviousPrice.Amount != 2000 || line.Price.Amount != 2500 {
+			t.Errorf("expected price change from 20.00 to 25.00, got %+v", line)
+		}
+		if cart.Subtotal.Amount != 5000 {
+			t.Errorf("expected subtotal 50.00, got %v", cart.Subtotal)
+		}
+	})
+
+	t.Run("should not compare prices added in another currency", func(t *testing.T) {
+		rr := send(http.MethodGet, "/cart?currency=EUR", nil)
+		if rr.Code != http.StatusOK {
+			t.Fatalf("expected status code %d, got %d", http.StatusOK, rr.Code)
+		}
+		if response := decode(rr); response["hasChanges"] != false || response["subtotal"] != "25.00" {
+			t.Errorf("unexpected cart %v", response)
+		}
+	})
+
+	t.Run("should reject items together with fromCart", func(t *testing.T) {
+		rr := send(http.MethodPost, "/cart/checkout", types.CheckoutPayload{
+			ShippingMethod: "pickup",
+			FromCart:       true,
+			Items:          []types.CheckoutItem{{ProductID: 1, Quantity: 1}},
+		})
+		if rr.Code != http.StatusBadRequest {
+			t.Errorf("expected status code %d, got %d", http.StatusBadRequest, rr.Code)
+		}
+	})
+
+	t.Run("should refuse a stored cart whose prices moved until they're accepted", func(t *testing.T) {
+		rr := send(http.MethodPost, "/cart/checkout", types.CheckoutPayload{ShippingMethod: "pickup", FromCart: true})
+		if rr.Code != http.StatusConflict {
+			t.Fatalf("expected status code %d, got %d: %s", http.StatusConflict, rr.Code, rr.Body)
+		}
+
+		response := decode(rr)
+		if changes, _ := response["priceChanges"].([]any); len(changes) != 1 {
+			t.Errorf("expected one price change, got %v", response["priceChanges"])
+		}
+		if n := len(store.cartItems[1]); n == 0 {
+			t.Error("expected the cart to be kept")
+		}
+		if n := len(store.orders); n != 0 {
+			t.Errorf("expected no order, got %d", n)
+		}
+	})
+
+	t.Run("should check out the stored cart", func(t *testing.T) {
+		rr := send(http.MethodPost, "/cart/checkout", types.CheckoutPayload{ShippingMethod: "pickup", FromCart: true, AcceptPriceChanges: true})
+		if rr.Code != http.StatusCreated {
+			t.Fatalf("expected status code %d, got %d: %s", http.StatusCreated, rr.Code, rr.Body)
+		}
+
+		response := decode(rr)
+		if response["total"] != "50.00" {
+			t.Errorf("expected total 50.00, got %v", response["total"])
+		}
+		if changes, _ := response["priceChanges"].([]any); len(changes) != 1 {
+			t.Errorf("expected one price change, got %v", response["priceChanges"])
+		}
+		if n := len(store.cartItems[1]); n != 0 {
+			t.Errorf("expected the cart to be emptied, got %d items", n)
+		}
+		if q := store.quantity(1); q != 1 {
+			t.Errorf("expected remaining stock 1, got %d", q)
+		}
+	})
+
+	t.Run("should fail to check out an empty cart", func(t *testing.T) {
+		rr := send(http.MethodPost, "/cart/checkout", types.CheckoutPayload{ShippingMethod: "pickup", FromCart: true})
+		if rr.Code != http.StatusBadRequest {
+			t.Errorf("expected status code %d, got %d", http.StatusBadRequest, rr.Code)
+		}
+	})
+}
+
+func TestQuoteShipping(t *testing.T) {
+	store := newMockCartStore(types.Product{ID: 1, Name: "shirt", Price: types.NewMoney(2000, "USD"), Quantity: 5, Weight: 1500})
+	usd := func(cents int64) types.Money { return types.NewMoney(cents, "USD") }
+	methods := []types.ShippingMethod{
+		{Code: "express", Name: "Express", Type: types.ShippingFlat, Price: usd(1500), PerKg: usd(0), FreeOver: usd(0), Active: true},
+		{Code: "standard", Name: "Standard", Type: types.ShippingFreeOver, Price: usd(500), PerKg: usd(0), FreeOver: usd(5000), Countries: []string{"US"}, Active: true},
+		{Code: "retired", Name: "Retired", Type: types.ShippingFlat, Price: usd(100), PerKg: usd(0), FreeOver: usd(0)},
+	}
+	handler := NewHandler(store, &mockProductStore{store: store}, &mockVariantStore{store: store}, &mockPricer{}, tax.NewCalculator(&mockTaxRateStore{}), newShippingCalculator(methods...), newMockAddressStore(), &mockIdempotencyStore{}, &mockPaymentService{}, userStore)
+
+	router := mux.NewRouter()
+	handler.RegisterRoutes(router)
+
+	// userID 0 is a guest without a cart
+	quote := func(userID int, query string) *httptest.ResponseRecorder {
+		req, err := http.NewRequest(http.MethodGet, "/shipping/quote"+query, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		authtest.SignIn(req, userID)
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		return rr
+	}
+
+	codes := func(rr *httptest.ResponseRecorder) []string {
+		var quotes []types.ShippingQuote
+		json.NewDecoder(rr.Body).Decode(&quotes)
+		codes := []string{}
+		for _, q := range quotes {
+			codes = append(codes, fmt.Sprintf("%s=%s", q.Method, q.Price.String()))
+		}
+		return codes
+	}
+
+	cart, _ := store.GetOrCreateCart(1)
+	store.cartItems[cart.ID][types.LineKey{ProductID: 1}] = types.CartItem{CartID: cart.ID, ProductID: 1, Quantity: 2, Price: usd(2000)}
+
+	t.Run("should list the methods for the cart cheapest first", func(t *testing.T) {
+		rr := quote(1, "?country=us")
+		if rr.Code != http.StatusOK {
+			t.Fatalf("expected status code %d, got %d: %s", http.StatusOK, rr.Code, rr.Body)
+		}
+		got := fmt.Sprint(codes(rr))
+		if want := "[pickup=0.00 standard=5.00 express=15.00]"; got != want {
+			t.Errorf("expected %s, got %s", want, got)
+		}
+	})
+
+	t.Run("should leave out methods that don't ship to the country", func(t *testing.T) {
+		got := fmt.Sprint(codes(quote(1, "?country=DE")))
+		if want := "[pickup=0.00 express=15.00]"; got != want {
+			t.Errorf("expected %s, got %s", want, got)
+		}
+	})
+
+	t.Run("should reject an invalid country", func(t *testing.T) {
+		if rr := quote(1, "?country=XX"); rr.Code != http.StatusBadRequest {
+			t.Errorf("expected status code %d, got %d", http.StatusBadRequest, rr.Code)
+		}
+	})
+
+	t.Run("should reject an empty cart", func(t *testing.T) {
+		if rr := quote(0, ""); rr.Code != http.StatusBadRequest {
+			t.Errorf("expected status code %d, got %d", http.StatusBadRequest, rr.Code)
+		}
+	})
+}
+
+// mockCartStore keeps products in memory ... like MySQL, the conditional
+// decrement is atomic and a failed transaction undoes its writes
+type mockCartStore struct {
+	mu          sync.Mutex
+	nextOrderID int
+	products    map[int]types.Product
+	deleted     map[int]bool                   // soft deleted products
+	variants    map[int][]types.ProductVariant // product ID to its variants
+	orders      []types.Order
+	orderItems  []types.OrderItem
+	itemTaxes   []types.OrderItemTax
+	nextItemID  int
+	nextCartID  int
+	carts       map[int]int // user ID to cart ID
+	cartItems   map[int]map[types.LineKey]types.CartItem
+	guests      map[string]int // guest token to cart ID
+	expiries    map[int]time.Time
+
+	// held by a transaction from GetPromotionForUpdate until it ends
+	promotionLock sync.Mutex
+	promotions    map[string]*types.Promotion
+	redemptions   map[int][]int // promotion ID to the users who redeemed it
+	categories    map[int][]int
+	discounts     []types.OrderDiscount
+}
+
+func newMockCartStore(products ...types.Product) *mockCartStore {
+	m := &mockCartStore{
+		products:    make(map[int]types.Product),
+		deleted:     make(map[int]bool),
+		variants:    make(map[int][]types.ProductVariant),
+		carts:       make(map[int]int),
+		cartItems:   make(map[int]map[types.LineKey]types.CartItem),
+		guests:      make(map[string]int),
+		expiries:    make(map[int]time.Time),
+		promotions:  make(map[string]*types.Promotion),
+		redemptions: make(map[int][]int),
+		categories:  make(map[int][]int),
+	}
+	for _, p := range products {
+		m.products[p.ID] = p
+	}
+	return m
+}
+
+func (m *mockCartStore) quantity(id int) int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.products[id].Quantity
+}
+
+func (m *mockCartStore) variantQuantity(productID int, variantID int) int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, v := range m.variants[productID] {
+		if v.ID == variantID {
+			return v.Quantity
+		}
+	}
+	return 0
+}
+
+func (m *mockCartStore) WithinTx(fn func(tx types.CheckoutTx) error) error {
+	tx := &mockCheckoutTx{store: m}
+	if err := fn(tx); err != nil {
+		tx.rollback()
+		return err
+	}
+	tx.commit()
+	return nil
+}
+
+func (m *mockCartStore) GetOrCreateCart(userID int) (*types.Cart, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	cartID, ok := m.carts[userID]
+	if !ok {
+		m.nextCartID++
+		cartID = m.nextCartID
+		m.carts[userID] = cartID
+		m.cartItems[cartID] = make(map[types.LineKey]types.CartItem)
+	}
+	return &types.Cart{ID: cartID, UserID: userID}, nil
+}
+
+func (m *mockCartStore) GetCartItems(cartID int) ([]types.CartItem, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.sortedCartItems(cartID), nil
+}
+
+func (m *mockCartStore) SetCartItem(cartID int, item types.CartItem) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	item.CartID = cartID
+	m.cartItems[cartID][types.LineKey{ProductID: item.ProductID, VariantID: item.VariantID}] = item
+	return nil
+}
+
+func (m *mockCartStore) RemoveCartItem(cartID int, productID int, variantID int) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	key := types.LineKey{ProductID: productID, VariantID: variantID}
+	if _, ok := m.cartItems[cartID][key]; !ok {
+		return false, nil
+	}
+	delete(m.cartItems[cartID], key)
+	return true, nil
+}
+
+func (m *mockCartStore) CreateGuestCart(token string, expiresAt time.Time) (*types.Cart, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.nextCartID++
+	m.guests[token] = m.nextCartID
+	m.expiries[m.nextCartID] = expiresAt
+	m.cartItems[m.nextCartID] = make(map[types.LineKey]types.CartItem)
+	return &types.Cart{ID: m.nextCartID, ExpiresAt: &expiresAt}, nil
+}
+
+func (m *mockCartStore) GetGuestCart(token string) (*types.Cart, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	cartID, ok := m.guests[token]
+	if !ok || !m.expiries[cartID].After(time.Now()) {
+		return nil, ErrCartNotFound
+	}
+	expiresAt := m.expiries[cartID]
+	return &types.Cart{ID: cartID, ExpiresAt: &expiresAt}, nil
+}
+
+func (m *mockCartStore) RenewGuestCart(cartID int, expiresAt time.Time) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.expiries[cartID] = expiresAt
+	return nil
+}
+
+func (m *mockCartStore) MergeGuestCart(guestCartID int, userCartID int, items []types.CartItem) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.expiries[guestCartID]; !ok {
+		return ErrCartNotFound
+	}
+	for _, item := range items {
+		item.CartID = userCartID
+		m.cartItems[userCartID][types.LineKey{ProductID: item.ProductID, VariantID: item.VariantID}] = item
+	}
+	m.deleteGuestCart(guestCartID)
+	return nil
+}
+
+func (m *mockCartStore) DeleteExpiredGuestCarts(before time.Time) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var deleted int64
+	for cartID, expiresAt := range m.expiries {
+		if !expiresAt.After(before) {
+			m.deleteGuestCart(cartID)
+			deleted++
+		}
+	}
+	return deleted, nil
+}
+
+// callers hold m.mu
+func (m *mockCartStore) deleteGuestCart(cartID int) {
+	for token, id := range m.guests {
+		if id == cartID {
+			delete(m.guests, token)
+		}
+	}
+	delete(m.expiries, cartID)
+	delete(m.cartItems, cartID)
+}
+
+// callers hold m.mu
+func (m *mockCartStore) sortedCartItems(cartID int) []types.CartItem {
+	items := []types.CartItem{}
+	for _, item := range m.cartItems[cartID] {
+		items = append(items, item)
+	}
+	sort.Slice(items, func(i, j int) bool {
+		if items[i].ProductID != items[j].ProductID {
+			return items[i].ProductID < items[j].ProductID
+		}
+		return items[i].VariantID < items[j].VariantID
+	})
+	return items
+}
+
+type mockCheckoutTx struct {
+	store      *mockCartStore
+	decrements map[int]int
+	// variant ID to units taken, the product's total goes down with them
+	variantDecrements map[int]int
+	orders            []types.Order
+	orderItems        []types.OrderItem
+	itemTaxes         []types.OrderItemTax
+	clearedCarts      []int
+	discounts         []types.OrderDiscount
+	redemptions       map[int][]int
+	lockedPromo       bool
+}
+
+// deliberately takes no lock ... only the conditional decrement guards stock
+func (t *mockCheckoutTx) GetProductForUpdate(id int) (*types.Product, error) {
+	t.store.mu.Lock()
+	defer t.store.mu.Unlock()
+	p, ok := t.store.products[id]
+	if !ok || t.store.deleted[id] {
+		return nil, fmt.Errorf("%w: %d", ErrProductNotFound, id)
+	}
+	return &p, nil
+}
+
+func (t *mockCheckoutTx) DecrementProductQuantity(id int, quantity int) error {
+	t.store.mu.Lock()
+	defer t.store.mu.Unlock()
+	p := t.store.products[id]
+	if p.Quantity < quantity {
+		return fmt.Errorf("%w for product %d", ErrInsufficientStock, id)
+	}
+	p.Quantity -= quantity
+	t.store.products[id] = p
+	if t.decrements == nil {
+		t.decrements = make(map[int]int)
+	}
+	t.decrements[id] += quantity
+	return nil
+}
+
+func (t *mockCheckoutTx) GetProductVariantsForUpdate(productID int) ([]types.ProductVariant, error) {
+	t.store.mu.Lock()
+	defer t.store.mu.Unlock()
+	return append([]types.ProductVariant{}, t.store.variants[productID]...), nil
+}
+
+func (t *mockCheckoutTx) DecrementVariantQuantity(id int, quantity int) error {
+	t.store.mu.Lock()
+	defer t.store.mu.Unlock()
+	if !t.store.addVariantQuantity(id, -quantity) {
+		return fmt.Errorf("%w for variant %d", ErrInsufficientStock, id)
+	}
+	if t.variantDecrements == nil {
+		t.variantDecrements = make(map[int]int)
+	}
+	t.variantDecrements[id] += quantity
+	return nil
+}
+
+// callers hold m.mu ... false leaves stock as it was when it would go negative
+func (m *mockCartStore) addVariantQuantity(id int, delta int) bool {
+	for productID, variants := range m.variants {
+		for i := range variants {
+			if variants[i].ID != id {
+				continue
+			}
+			if variants[i].Quantity+delta < 0 {
+				return false
+			}
+			variants[i].Quantity += delta
+			p := m.products[productID]
+			p.Quantity += delta
+			m.products[productID] = p
+			return true
+		}
+	}
+	return false
+}
+
+func (t *mockCheckoutTx) CreateOrder(order types.Order) (int, error) {
+	t.store.mu.Lock()
+	defer t.store.mu.Unlock()
+	t.store.nextOrderID++
+	order.ID = t.store.nextOrderID
+	t.orders = append(t.orders, order)
+	return order.ID, nil
+}
+
+func (t *mockCheckoutTx) CreateOrderItem(item types.OrderItem) (int, error) {
+	t.store.mu.Lock()
+	defer t.store.mu.Unlock()
+	t.store.nextItemID++
+	item.ID = t.store.nextItemID
+	t.orderItems = append(t.orderItems, item)
+	return item.ID, nil
+}
+
+func (t *mockCheckoutTx) CreateOrderItemTax(tax types.OrderItemTax) error {
+	t.itemTaxes = append(t.itemTaxes, tax)
+	return nil
+}
+
+func (t *mockCheckoutTx) GetPromotionForUpdate(code string) (*types.Promotion, error) {
+	if !t.lockedPromo {
+		t.store.promotionLock.Lock()
+		t.lockedPromo = true
+	}
+	t.store.mu.Lock()
+	defer t.store.mu.Unlock()
+	p, ok := t.store.promotions[code]
+	if !ok {
+		return nil, promotion.ErrPromotionNotFound
+	}
+	copied := *p
+	return &copied, nil
+}
+
+func (t *mockCheckoutTx) CountPromotionRedemptions(promotionID int, userID int) (int, error) {
+	t.store.mu.Lock()
+	defer t.store.mu.Unlock()
+	count := 0
+	for _, id := range t.store.redemptions[promotionID] {
+		if id == userID {
+			count++
+		}
+	}
+	return count, nil
+}
+
+func (t *mockCheckoutTx) GetProductCategoryIDs(productIDs []int) (map[int][]int, error) {
+	t.store.mu.Lock()
+	defer t.store.mu.Unlock()
+	categories := make(map[int][]int)
+	for _, id := range productIDs {
+		categories[id] = t.store.categories[id]
+	}
+	return categories, nil
+}
+
+func (t *mockCheckoutTx) RecordPromotionRedemption(promotionID int, userID int, orderID int) error {
+	if t.redemptions == nil {
+		t.redemptions = make(map[int][]int)
+	}
+	t.redemptions[promotionID] = append(t.redemptions[promotionID], userID)
+	return nil
+}
+
+func (t *mockCheckoutTx) CreateOrderDiscount(discount types.OrderDiscount) error {
+	t.discounts = append(t.discounts, discount)
+	return nil
+}
+
+func (t *mockCheckoutTx) GetCartItemsForUpdate(cartID int) ([]types.CartItem, error) {
+	t.store.mu.Lock()
+	defer t.store.mu.Unlock()
+	return t.store.sortedCartItems(cartID), nil
+}
+
+func (t *mockCheckoutTx) ClearCart(cartID int) error {
+	t.clearedCarts = append(t.clearedCarts, cartID)
+	return nil
+}
+
+func (t *mockCheckoutTx) rollback() {
+	defer t.unlockPromotion()
+	t.store.mu.Lock()
+	defer t.store.mu.Unlock()
+	for id, quantity := range t.decrements {
+		p := t.store.products[id]
+		p.Quantity += quantity
+		t.store.products[id] = p
+	}
+	for id, quantity := range t.variantDecrements {
+		t.store.addVariantQuantity(id, quantity)
+	}
+}
+
+func (t *mockCheckoutTx) commit() {
+	defer t.unlockPromotion()
+	t.store.mu.Lock()
+	defer t.store.mu.Unlock()
+	t.store.discounts = append(t.store.discounts, t.discounts...)
+	for _, p := range t.store.promotions {
+		users := t.redemptions[p.ID]
+		p.UsageCount += len(users)
+		t.store.redemptions[p.ID] = append(t.store.redemptions[p.ID], users...)
+	}
+	t.store.orders = append(t.store.orders, t.orders...)
+	t.store.orderItems = append(t.store.orderItems, t.orderItems...)
+	t.store.itemTaxes = append(t.store.itemTaxes, t.itemTaxes...)
+	for _, cartID := range t.clearedCarts {
+		t.store.cartItems[cartID] = make(map[types.LineKey]types.CartItem)
+	}
+}
+
+func (t *mockCheckoutTx) unlockPromotion() {
+	if t.lockedPromo {
+		t.store.promotionLock.Unlock()
+	}
+}
+
+// mockVariantStore reads the cart store's variants
+type mockVariantStore struct {
+	store *mockCartStore
+}
+
+func (m *mockVariantStore) GetProductVariants(productID int) ([]types.ProductOption, []types.ProductVariant, error) {
+	m.store.mu.Lock()
+	defer m.store.mu.Unlock()
+	return []types.ProductOption{}, append([]types.ProductVariant{}, m.store.variants[productID]...), nil
+}
+
+func (m *mockVariantStore) GetVariantByID(id int) (*types.ProductVariant, error) {
+	return nil, variant.ErrVariantNotFound
+}
+
+func (m *mockVariantStore) GetVariantBySKU(sku string) (*types.ProductVariant, error) {
+	return nil, variant.ErrVariantNotFound
+}
+
+func (m *mockVariantStore) SetProductOptions(productID int, options []types.ProductOption) error {
+	return nil
+}
+
+func (m *mockVariantStore) CreateVariant(types.ProductVariant) (int, error) {
+	return 0, nil
+}
+
+func (m *mockVariantStore) UpdateVariant(types.ProductVariant) error {
+	return nil
+}
+
+func (m *mockVariantStore) DeleteVariant(id int) error {
+	return nil
+}
+
+// mockProductStore reads the cart store's products
+type mockProductStore struct {
+	store *mockCartStore
+}
+
+func (m *mockProductStore) GetProducts(query types.ProductQuery) ([]types.Product, error) {
+	return nil, nil
+}
+
+func (m *mockProductStore) CountProducts(query types.ProductQuery) (int, error) {
+	return 0, nil
+}
+
+func (m *mockProductStore) GetProductByID(id int) (*types.Product, error) {
+	m.store.mu.Lock()
+	defer m.store.mu.Unlock()
+	p, ok := m.store.products[id]
+	if !ok || m.store.deleted[id] {
+		return nil, fmt.Errorf("product not found")
+	}
+	return &p, nil
+}
+
+func (m *mockProductStore) CreateProduct(types.Product) error {
+	return nil
+}
+
+func (m *mockProductStore) UpdateProduct(id int, product types.Product) error {
+	return nil
+}
+
+func (m *mockProductStore) ProductExists(id int) (bool, error) {
+	_, err := m.GetProductByID(id)
+	return err == nil, nil
+}
+
+func (m *mockProductStore) UpdateProductQuantity(id int, quantity int) error {
+	return nil
+}
+
+func (m *mockProductStore) GetProductPrices(ids []int, currency string) (map[int]types.Money, error) {
+	return map[int]types.Money{}, nil
+}
+
+func (m *mockProductStore) SetProductPrice(productID int, price types.Money) error {
+	return nil
+}
+
+func (m *mockProductStore) DeleteProduct(id int) error {
+	m.store.mu.Lock()
+	defer m.store.mu.Unlock()
+	m.store.deleted[id] = true
+	return nil
+}
+
+func (m *mockProductStore) RestoreProduct(id int) error {
+	m.store.mu.Lock()
+	defer m.store.mu.Unlock()
+	delete(m.store.deleted, id)
+	return nil
+}
+
+// always grants the key ... idempotency has its own tests
+type mockIdempotencyStore struct{}
+
+func (m *mockIdempotencyStore) ReserveIdempotencyKey(record types.IdempotencyRecord) (*types.IdempotencyRecord, bool, error) {
+	return nil, true, nil
+}
+
+func (m *mockIdempotencyStore) CompleteIdempotencyKey(userID int, key string, status int, body []byte) error {
+	return nil
+}
+
+func (m *mockIdempotencyStore) DeleteIdempotencyKey(userID int, key string) error {
+	return nil
+}
+
+// pays every order ... payment has its own tests
+type mockPaymentService struct{}
+
+func (m *mockPaymentService) PayOrder(order *types.Order, method types.PaymentMethod) (*types.Payment, error) {
+	order.Status = types.OrderStatusPaid
+	return &types.Payment{OrderID: order.ID, Status: types.PaymentStatusSucceeded}, nil
+}
+
+var userStore = &authtest.UserStore{Roles: map[int]string{1: types.RoleCustomer, 2: types.RoleAdmin}}
+
+// mockPricer keeps USD prices and converts to EUR at 0.5
+type mockPricer struct{}
+
+func (m *mockPricer) PriceProducts(products []types.Product, code string) error {
+	for i := range products {
+		switch code {
+		case "USD":
+		case "EUR":
+			price, err := currency.Convert(products[i].Price, "0.5", code)
+			if err != nil {
+				return err
+			}
+			products[i].Price = price
+		default:
+			return currency.ErrUnsupportedCurrency
+		}
+		products[i].Currency = code
+	}
+	return nil
+}
+
+func (m *mockPricer) PriceVariants(variants []types.ProductVariant, code string) error {
+	for i := range variants {
+		switch code {
+		case "USD":
+		case "EUR":
+			price, err := currency.Convert(variants[i].Price, "0.5", code)
+			if err != nil {
+				return err
+			}
+			variants[i].Price = price
+		default:
+			return currency.ErrUnsupportedCurrency
+		}
+	}
+	return nil
+}
+
+// tax rates by country
+type mockTaxRateStore struct {
+	rates map[string][]types.TaxRate
+}
+
+func (m *mockTaxRateStore) GetTaxRates(country string) ([]types.TaxRate, error) {
+	return m.rates[country], nil
+}
+
+func (m *mockPricer) ConvertAmount(amount types.Money, code string) (types.Money, error) {
+	switch code {
+	case amount.Currency:
+		return amount, nil
+	case "EUR":
+		return currency.Convert(amount, "0.5", code)
+	}
+	return types.Money{}, currency.ErrUnsupportedCurrency
+}
+
+// newShippingCalculator quotes methods, plus a free "pickup" that ships
+// anything anywhere
+func newShippingCalculator(methods ...types.ShippingMethod) *shipping.Calculator {
+	usd := types.NewMoney(0, "USD")
+	pickup := types.ShippingMethod{
+		Code: "pickup", Name: "Pickup", Type: types.ShippingFlat,
+		Price: usd, PerKg: usd, FreeOver: usd, Active: true,
+	}
+
+	return shipping.NewCalculator(&mockShippingStore{methods: append(methods, pickup)}, &mockPricer{})
+}
+
+type mockShippingStore struct {
+	methods []types.ShippingMethod
+}
+
+func (m *mockShippingStore) GetShippingMethods() ([]types.ShippingMethod, error) {
+	return m.methods, nil
+}
+
+func (m *mockShippingStore) GetShippingMethodByID(id int) (*types.ShippingMethod, error) {
+	for _, method := range m.methods {
+		if method.ID == id {
+			return &method, nil
+		}
+	}
+	return nil, shipping.ErrMethodNotFound
+}
+
+func (m *mockShippingStore) GetShippingMethodByCode(code string) (*types.ShippingMethod, error) {
+	for _, method := range m.methods {
+		if method.Code == code {
+			return &method, nil
+		}
+	}
+	return nil, shipping.ErrMethodNotFound
+}
+
+func (m *mockShippingStore) CreateShippingMethod(types.ShippingMethod) (int, error) {
+	return 0, nil
+}
+
+func (m *mockShippingStore) UpdateShippingMethod(types.ShippingMethod) error {
+	return nil
+}
+
+func (m *mockShippingStore) DeleteShippingMethod(id int) error {
+	return nil
+}
+
+func testAddress(country, region, postalCode string) *types.Address {
+	return &types.Address{
+		Name: "Ada Lovelace", Line1: "1 Main Street", City: "Springfield",
+		Region: region, PostalCode: postalCode, Country: country,
+	}
+}
+
+// user 1 has a saved address with ID 1 ... unless noDefault is set, every
+// user's default address is in California
+type mockAddressStore struct {
+	addresses map[int]types.UserAddress
+	noDefault bool
+}
+
+func newMockAddressStore() *mockAddressStore {
+	return &mockAddressStore{addresses: map[int]types.UserAddress{
+		1: {ID: 1, UserID: 1, Address: *testAddress("GB", "", "SW1A 1AA")},
+	}}
+}
+
+func (m *mockAddressStore) GetUserAddresses(userID int) ([]types.UserAddress, error) {
+	addresses := []types.UserAddress{}
+	for _, a := range m.addresses {
+		if a.UserID == userID {
+			addresses = append(addresses, a)
+		}
+	}
+	return addresses, nil
+}
+
+func (m *mockAddressStore) GetUserAddress(userID int, id int) (*types.UserAddress, error) {
+	a, ok := m.addresses[id]
+	if !ok || a.UserID != userID {
+		return nil, address.ErrAddressNotFound
+	}
+	return &a, nil
+}
+
+func (m *mockAddressStore) GetDefaultAddress(userID int) (*types.UserAddress, error) {
+	if m.noDefault {
+		return nil, address.ErrAddressNotFound
+	}
+	return &types.UserAddress{UserID: userID, Address: *testAddress("US", "CA", "94105"), IsDefault: true}, nil
+}
+
+func (m *mockAddressStore) CreateUserAddress(a types.UserAddress) (int, error) {
+	a.ID = len(m.addresses) + 1
+	m.addresses[a.ID] = a
+	return a.ID, nil
+}
+
+func (m *mockAddressStore) UpdateUserAddress(a types.UserAddress) error {
+	return nil
+}
+
+func (m *mockAddressStore) DeleteUserAddress(userID int, id int) error {
+	delete(m.addresses, id)
+	return nil
+}
+
+func (m *mockAddressStore) SetDefaultAddress(userID int, id int) error {
+	return nil
+}
@@ -1 +1,873 @@
-package cart
\ No newline at end of file
+package cart
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/eugenius-watchman/ecom_go_rest_api/cmd/service/address"
+	"github.com/eugenius-watchman/ecom_go_rest_api/cmd/service/promotion"
+	"github.com/eugenius-watchman/ecom_go_rest_api/cmd/service/shipping"
+	"github.com/eugenius-watchman/ecom_go_rest_api/cmd/service/variant"
+	"github.com/eugenius-watchman/ecom_go_rest_api/types"
+)
+
+var (
+	ErrProductNotFound   = errors.New("product not found")
+	ErrInsufficientStock = errors.New("insufficient stock")
+	ErrCartEmpty         = errors.New("cart is empty")
+	ErrCartItemNotFound  = errors.New("product is not in the cart")
+	ErrCartNotFound      = errors.New("cart not found")
+	ErrPriceChanged      = errors.New("cart prices changed since the items were added")
+)
+
+// Service owns the checkout flow ... everything it writes goes through a
+// single transaction so a failure never leaves stock decremented without
+// an order
+type Service struct {
+	store     types.CartStore
+	products  types.ProductStore
+	variants  types.VariantStore
+	pricer    types.ProductPricer
+	taxes     types.TaxCalculator
+	shipping  types.ShippingCalculator
+	addresses types.AddressStore
+}
+
+func NewService(store types.CartStore, products types.ProductStore, variants types.VariantStore, pricer types.ProductPricer, taxes types.TaxCalculator, shipping types.ShippingCalculator, addresses types.AddressStore) *Service {
+	return &Service{store: store, products: products, variants: variants, pricer: pricer, taxes: taxes, shipping: shipping, addresses: addresses}
+}
+
+// Checkout places an order priced in currency. With payload.FromCart the
+// stored cart is ordered and emptied in the same transaction. Any line whose
+// price moved since it was added is returned, and unless the payload accepts
+// them nothing is ordered and the error is ErrPriceChanged.
+func (s *Service) Checkout(userID int, currency string, payload types.CheckoutPayload) (*types.Order, []types.CartPriceChange, error) {
+	shipTo, billTo, err := s.checkoutAddresses(userID, payload)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var cartID int
+	if payload.FromCart {
+		cart, err := s.store.GetOrCreateCart(userID)
+		if err != nil {
+			return nil, nil, err
+		}
+		cartID = cart.ID
+	}
+
+	var order types.Order
+	var changes []types.CartPriceChange
+	err = s.store.WithinTx(func(tx types.CheckoutTx) error {
+		items := mergeCheckoutItems(payload.Items)
+
+		var stored []types.CartItem
+		if payload.FromCart {
+			var err error
+			stored, err = tx.GetCartItemsForUpdate(cartID)
+			if err != nil {
+				return err
+			}
+			if len(stored) == 0 {
+				return ErrCartEmpty
+			}
+
+			items = make([]types.CheckoutItem, 0, len(stored))
+			for _, item := range stored {
+				items = append(items, types.CheckoutItem{ProductID: item.ProductID, VariantID: item.VariantID, Quantity: item.Quantity})
+			}
+			items = mergeCheckoutItems(items)
+		}
+
+		// lock and price every product before writing anything
+		totals, products, err := s.calculateTotalWithPrices(tx, userID, items, currency, payload.PromotionCode)
+		if err != nil {
+			return err
+		}
+
+		// the customer sees a new price before being charged it
+		if payload.FromCart {
+			changes = priceChanges(stored, products)
+			if len(changes) > 0 && !payload.AcceptPriceChanges {
+				return ErrPriceChanged
+			}
+		}
+
+		address := types.TaxAddress{Country: shipTo.Country, Region: shipTo.Region}
+		if err := s.applyTax(address, items, products, totals); err != nil {
+			return err
+		}
+
+		if err := s.applyShipping(payload.ShippingMethod, address, items, products, totals); err != nil {
+			return err
+		}
+
+		// reduce quantities before creating order ... a product sold in
+		// variants is stocked through them
+		for _, item := range items {
+			if item.VariantID != 0 {
+				err = tx.DecrementVariantQuantity(item.VariantID, item.Quantity)
+			} else {
+				err = tx.DecrementProductQuantity(item.ProductID, item.Quantity)
+			}
+			if err != nil {
+				return err
+			}
+		}
+
+		order = types.Order{
+			UserID:          userID,
+			Currency:        currency,
+			Subtotal:        totals.Subtotal,
+			Total:           totals.Total,
+			DiscountTotal:   totals.Discount,
+			TaxTotal:        totals.Tax,
+			TaxIncluded:     totals.TaxIncluded,
+			ShippingTotal:   totals.Shipping,
+			ShippingMethod:  payload.ShippingMethod,
+			Status:          types.OrderStatusPending,
+			ShippingAddress: shipTo,
+			BillingAddress:  billTo,
+			CreatedAt:       time.Now(),
+		}
+
+		order.ID, err = tx.CreateOrder(order)
+		if err != nil {
+			return err
+		}
+
+		// create order items, each with its tax lines for the invoice
+		for _, item := range items {
+			key := lineKey(item.ProductID, item.VariantID)
+			product := products[key]
+			discount := totals.lineDiscount(key, currency)
+			lineTax := totals.Taxes[key]
+
+			itemID, err := tx.CreateOrderItem(types.OrderItem{
+				OrderID:      order.ID,
+				ProductID:    item.ProductID,
+				VariantID:    item.VariantID,
+				SKU:          product.sku(),
+				ProductName:  product.title(),
+				ProductImage: product.Image,
+				Quantity:     item.Quantity,
+				Price:        product.Price, // from products db
+				Discount:     discount,
+				Tax:          lineTax.Tax,
+				Total:        product.Price.Mul(int64(item.Quantity)).Sub(discount).Add(lineTax.Tax.Sub(lineTax.Included)),
+			})
+			if err != nil {
+				return err
+			}
+
+			for _, tax := range lineTax.Taxes {
+				tax.OrderItemID = itemID
+				if err := tx.CreateOrderItemTax(tax); err != nil {
+					return err
+				}
+			}
+		}
+
+		// counted in the same transaction that locked the promotion, so its
+		// limits hold however many checkouts race for it
+		if totals.Promotion != nil {
+			err := tx.CreateOrderDiscount(types.OrderDiscount{
+				OrderID:      order.ID,
+				PromotionID:  totals.Promotion.ID,
+				Code:         totals.Promotion.Code,
+				Type:         totals.Promotion.Type,
+				Amount:       totals.Discount,
+				FreeShipping: totals.FreeShipping,
+			})
+			if err != nil {
+				return err
+			}
+
+			if err := tx.RecordPromotionRedemption(totals.Promotion.ID, userID, order.ID); err != nil {
+				return err
+			}
+		}
+
+		if payload.FromCart {
+			if err := tx.ClearCart(cartID); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+	if errors.Is(err, ErrPriceChanged) {
+		return nil, changes, err
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return &order, changes, nil
+}
+
+// GetCart re-checks the stored cart against current stock and prices in
+// currency ... a guest without a cart yet gets an empty one
+func (s *Service) GetCart(cart *types.Cart, currency string) (*types.CartView, error) {
+	if cart == nil {
+		return s.viewCart(nil, currency)
+	}
+
+	items, err := s.store.GetCartItems(cart.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	return s.viewCart(items, currency)
+}
+
+// QuoteShipping lists the methods that can ship the lines of cart still
+// available to country, cheapest first
+func (s *Service) QuoteShipping(cart *types.Cart, currency string, country string) ([]types.ShippingQuote, error) {
+	view, err := s.GetCart(cart, currency)
+	if err != nil {
+		return nil, err
+	}
+
+	parcel := types.Parcel{Subtotal: view.Subtotal, Country: strings.ToUpper(country)}
+	for _, line := range view.Items {
+		if !line.Available {
+			continue
+		}
+
+		product, err := s.products.GetProductByID(line.ProductID)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %d", ErrProductNotFound, line.ProductID)
+		}
+		parcel.Weight += shipping.ChargeableWeight(product, line.Quantity)
+	}
+
+	if parcel.Subtotal.IsZero() && parcel.Weight == 0 {
+		return nil, ErrCartEmpty
+	}
+
+	return s.shipping.QuoteShipping(parcel)
+}
+
+// AddItem adds quantity to the line, its price is taken again at the current
+// price in currency
+func (s *Service) AddItem(cart *types.Cart, currency string, payload types.AddCartItemPayload) (*types.CartView, error) {
+	items, err := s.store.GetCartItems(cart.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	quantity := payload.Quantity
+	if existing := findCartItem(items, payload.ProductID, payload.VariantID); existing != nil {
+		quantity += existing.Quantity
+	}
+
+	product, err := s.availableProduct(payload.ProductID, payload.VariantID, quantity)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := s.priceLines([]*lineProduct{product}, currency); err != nil {
+		return nil, err
+	}
+
+	item := types.CartItem{ProductID: payload.ProductID, VariantID: payload.VariantID, Quantity: quantity, Price: product.Price}
+	if err := s.store.SetCartItem(cart.ID, item); err != nil {
+		return nil, err
+	}
+
+	return s.GetCart(cart, currency)
+}
+
+// UpdateItem sets the quantity of a line already in the cart ... the price
+// it was added at is kept so changes are still pointed out
+func (s *Service) UpdateItem(cart *types.Cart, currency string, productID int, variantID int, quantity int) (*types.CartView, error) {
+	items, err := s.store.GetCartItems(cart.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	existing := findCartItem(items, productID, variantID)
+	if existing == nil {
+		return nil, ErrCartItemNotFound
+	}
+
+	if _, err := s.availableProduct(productID, variantID, quantity); err != nil {
+		return nil, err
+	}
+
+	existing.Quantity = quantity
+	if err := s.store.SetCartItem(cart.ID, *existing); err != nil {
+		return nil, err
+	}
+
+	return s.GetCart(cart, currency)
+}
+
+func (s *Service) RemoveItem(cart *types.Cart, currency string, productID int, variantID int) (*types.CartView, error) {
+	removed, err := s.store.RemoveCartItem(cart.ID, productID, variantID)
+	if err != nil {
+		return nil, err
+	}
+
+	if !removed {
+		return nil, ErrCartItemNotFound
+	}
+
+	return s.GetCart(cart, currency)
+}
+
+// MergeGuestCart moves a guest cart into the user's cart:
+//   - products only the guest had move over with the price they were added at
+//   - for products in both the quantities add up and the user's price is kept
+//   - quantities are capped at current stock, but the user's own quantity is
+//     never lowered
+//   - products that are gone or out of stock are dropped
+//
+// a variant counts as a product of its own
+func (s *Service) MergeGuestCart(guest *types.Cart, userID int) error {
+	userCart, err := s.store.GetOrCreateCart(userID)
+	if err != nil {
+		return err
+	}
+
+	guestItems, err := s.store.GetCartItems(guest.ID)
+	if err != nil {
+		return err
+	}
+
+	userItems, err := s.store.GetCartItems(userCart.ID)
+	if err != nil {
+		return err
+	}
+
+	merged := make([]types.CartItem, 0, len(guestItems))
+	for _, item := range guestItems {
+		product, err := s.lookupLine(item.ProductID, item.VariantID)
+		if err != nil {
+			continue
+		}
+
+		existing := findCartItem(userItems, item.ProductID, item.VariantID)
+		if existing == nil {
+			if product.Quantity == 0 {
+				continue
+			}
+			item.Quantity = min(item.Quantity, product.Quantity)
+			merged = append(merged, item)
+			continue
+		}
+
+		quantity := min(existing.Quantity+item.Quantity, product.Quantity)
+		if quantity <= existing.Quantity {
+			continue
+		}
+		existing.Quantity = quantity
+		merged = append(merged, *existing)
+	}
+
+	err = s.store.MergeGuestCart(guest.ID, userCart.ID, merged)
+	if errors.Is(err, ErrCartNotFound) {
+		// merged by a concurrent login
+		return nil
+	}
+
+	return err
+}
+
+// availableProduct fails unless quantity units of the product, or of its
+// variant, are in stock
+func (s *Service) availableProduct(productID int, variantID int, quantity int) (*lineProduct, error) {
+	product, err := s.lookupLine(productID, variantID)
+	if err != nil {
+		return nil, err
+	}
+
+	if product.Quantity < quantity {
+		return nil, fmt.Errorf("%w for product %s", ErrInsufficientStock, product.title())
+	}
+
+	return product, nil
+}
+
+// lookupLine finds what a cart line is for, variantID is 0 for a product
+// sold without variants
+func (s *Service) lookupLine(productID int, variantID int) (*lineProduct, error) {
+	product, err := s.products.GetProductByID(productID)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %d", ErrProductNotFound, productID)
+	}
+
+	_, variants, err := s.variants.GetProductVariants(productID)
+	if err != nil {
+		return nil, err
+	}
+
+	v, err := variant.Find(variants, productID, variantID)
+	if err != nil {
+		return nil, err
+	}
+
+	return newLineProduct(*product, v), nil
+}
+
+// priceLines puts lines in currency, a variant line at its variant's price
+func (s *Service) priceLines(lines []*lineProduct, currency string) error {
+	products := make([]types.Product, 0, len(lines))
+	variants := make([]types.ProductVariant, 0, len(lines))
+	for _, line := range lines {
+		if line.variant != nil {
+			variants = append(variants, *line.variant)
+		} else {
+			products = append(products, line.Product)
+		}
+	}
+
+	if err := s.pricer.PriceProducts(products, currency); err != nil {
+		return err
+	}
+	if len(variants) > 0 {
+		if err := s.pricer.PriceVariants(variants, currency); err != nil {
+			return err
+		}
+	}
+
+	for _, line := range lines {
+		if line.variant != nil {
+			line.Price = variants[0].Price
+			line.Currency = currency
+			variants = variants[1:]
+		} else {
+			line.Product = products[0]
+			products = products[1:]
+		}
+	}
+
+	return nil
+}
+
+// lines whose product is gone or short on stock stay in the view but are
+// left out of the subtotal
+func (s *Service) viewCart(items []types.CartItem, currency string) (*types.CartView, error) {
+	view := &types.CartView{
+		Currency: currency,
+		Items:    []types.CartLine{},
+		Subtotal: types.NewMoney(0, currency),
+	}
+
+	products := make([]*lineProduct, 0, len(items))
+	found := make(map[types.LineKey]*lineProduct)
+	for _, item := range items {
+		product, err := s.lookupLine(item.ProductID, item.VariantID)
+		if err != nil {
+			continue
+		}
+		found[lineKey(item.ProductID, item.VariantID)] = product
+		products = append(products, product)
+	}
+
+	if err := s.priceLines(products, currency); err != nil {
+		return nil, err
+	}
+
+	for _, item := range items {
+		product, ok := found[lineKey(item.ProductID, item.VariantID)]
+		if !ok {
+			view.Items = append(view.Items, types.CartLine{
+				ProductID: item.ProductID,
+				VariantID: item.VariantID,
+				Quantity:  item.Quantity,
+				Price:     types.NewMoney(0, currency),
+				LineTotal: types.NewMoney(0, currency),
+			})
+			view.HasChanges = true
+			continue
+		}
+
+		line := types.CartLine{
+			ProductID: item.ProductID,
+			VariantID: item.VariantID,
+			SKU:       product.sku(),
+			Options:   product.options(),
+			Name:      product.Name,
+			Image:     product.Image,
+			Quantity:  item.Quantity,
+			Price:     product.Price,
+			LineTotal: product.Price.Mul(int64(item.Quantity)),
+			InStock:   product.Quantity,
+			Available: product.Quantity >= item.Quantity,
+		}
+
+		if priceChanged(item, product.Price) {
+			previous := item.Price
+			line.PreviousPrice = &previous
+			view.HasChanges = true
+		}
+
+		if line.Available {
+			view.Subtotal = view.Subtotal.Add(line.LineTotal)
+		} else {
+			view.HasChanges = true
+		}
+
+		view.Items = append(view.Items, line)
+	}
+
+	return view, nil
+}
+
+func findCartItem(items []types.CartItem, productID int, variantID int) *types.CartItem {
+	for i := range items {
+		if items[i].ProductID == productID && items[i].VariantID == variantID {
+			return &items[i]
+		}
+	}
+
+	return nil
+}
+
+// a price added in another currency can't be compared, so it never counts
+// as a change
+func priceChanged(item types.CartItem, current types.Money) bool {
+	return item.Price.Currency == current.Currency && item.Price.Amount != current.Amount
+}
+
+func priceChanges(items []types.CartItem, products map[types.LineKey]*lineProduct) []types.CartPriceChange {
+	changes := []types.CartPriceChange{}
+	for _, item := range items {
+		product := products[lineKey(item.ProductID, item.VariantID)]
+		if priceChanged(item, product.Price) {
+			changes = append(changes, types.CartPriceChange{
+				ProductID:     item.ProductID,
+				VariantID:     item.VariantID,
+				PreviousPrice: item.Price,
+				Price:         product.Price,
+			})
+		}
+	}
+
+	return changes
+}
+
+// checkoutTotals is what an order costs once a promotion and taxes are
+// applied ... Total = Subtotal - Discount + Tax - TaxIncluded + Shipping
+type checkoutTotals struct {
+	Subtotal    types.Money
+	Discount    types.Money
+	Tax         types.Money
+	TaxIncluded types.Money
+	Shipping    types.Money
+	Total       types.Money
+	// set when a promotion code was used
+	Promotion    *types.Promotion
+	ByLine       map[types.LineKey]types.Money
+	FreeShipping bool
+	Taxes        map[types.LineKey]types.LineTax
+}
+
+func (t *checkoutTotals) lineDiscount(key types.LineKey, currency string) types.Money {
+	if discount, ok := t.ByLine[key]; ok {
+		return discount
+	}
+
+	return types.NewMoney(0, currency)
+}
+
+// helper func to get actual prices from db ... rows stay locked until the
+// transaction ends, and so does the promotion behind code
+func (s *Service) calculateTotalWithPrices(tx types.CheckoutTx, userID int, items []types.CheckoutItem, currency string, code string) (*checkoutTotals, map[types.LineKey]*lineProduct, error) {
+	locked := make([]*lineProduct, 0, len(items))
+	variants := make(map[int][]types.ProductVariant)
+	for _, item := range items {
+		product, err := tx.GetProductForUpdate(item.ProductID)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		// lines for several variants of a product share its variants
+		if _, ok := variants[item.ProductID]; !ok {
+			variants[item.ProductID], err = tx.GetProductVariantsForUpdate(item.ProductID)
+			if err != nil {
+				return nil, nil, err
+			}
+		}
+
+		v, err := variant.Find(variants[item.ProductID], item.ProductID, item.VariantID)
+		if err != nil {
+			return nil, nil, err
+		}
+		line := newLineProduct(*product, v)
+
+		// check for sufficient quatity of product
+		if line.Quantity < item.Quantity {
+			return nil, nil, fmt.Errorf("%w for product %s", ErrInsufficientStock, line.title())
+		}
+
+		locked = append(locked, line)
+	}
+
+	// the customer pays in their currency
+	if err := s.priceLines(locked, currency); err != nil {
+		return nil, nil, err
+	}
+
+	total := types.NewMoney(0, currency)
+	products := make(map[types.LineKey]*lineProduct)
+	for i, item := range items {
+		// exact minor units ... no float rounding on the way
+		itemTotal := locked[i].Price.Mul(int64(item.Quantity))
+		total = total.Add(itemTotal)
+		products[lineKey(item.ProductID, item.VariantID)] = locked[i] // keep price and details for order items
+	}
+
+	totals := &checkoutTotals{
+		Subtotal:    total,
+		Discount:    types.NewMoney(0, currency),
+		Tax:         types.NewMoney(0, currency),
+		TaxIncluded: types.NewMoney(0, currency),
+		Shipping:    types.NewMoney(0, currency),
+		Total:       total,
+	}
+
+	if code == "" {
+		return totals, products, nil
+	}
+
+	if err := s.applyPromotion(tx, userID, code, items, products, totals); err != nil {
+		return nil, nil, err
+	}
+
+	return totals, products, nil
+}
+
+func (s *Service) applyPromotion(tx types.CheckoutTx, userID int, code string, items []types.CheckoutItem, products map[types.LineKey]*lineProduct, totals *checkoutTotals) error {
+	promo, err := tx.GetPromotionForUpdate(code)
+	if err != nil {
+		return err
+	}
+
+	used, err := tx.CountPromotionRedemptions(promo.ID, userID)
+	if err != nil {
+		return err
+	}
+
+	if err := promotion.CheckUsage(promo, used); err != nil {
+		return err
+	}
+
+	var categories map[int][]int
+	if len(promo.CategoryIDs) > 0 {
+		ids := make([]int, 0, len(items))
+		for _, item := range items {
+			ids = append(ids, item.ProductID)
+		}
+
+		categories, err = tx.GetProductCategoryIDs(ids)
+		if err != nil {
+			return err
+		}
+	}
+
+	lines := make([]promotion.Line, 0, len(items))
+	for _, item := range items {
+		lines = append(lines, promotion.Line{
+			ProductID:   item.ProductID,
+			VariantID:   item.VariantID,
+			CategoryIDs: categories[item.ProductID],
+			Quantity:    item.Quantity,
+			Price:       products[lineKey(item.ProductID, item.VariantID)].Price,
+		})
+	}
+
+	result, err := promotion.Evaluate(promo, lines, totals.Subtotal.Currency, time.Now())
+	if err != nil {
+		return err
+	}
+
+	totals.Promotion = promo
+	totals.Discount = result.Amount
+	totals.Total = totals.Subtotal.Sub(result.Amount)
+	totals.ByLine = result.ByLine
+	totals.FreeShipping = result.FreeShipping
+
+	return nil
+}
+
+// taxes are worked out on what each line costs after its discount
+func (s *Service) applyTax(address types.TaxAddress, items []types.CheckoutItem, products map[types.LineKey]*lineProduct, totals *checkoutTotals) error {
+	currency := totals.Subtotal.Currency
+
+	lines := make([]types.TaxableLine, 0, len(items))
+	for _, item := range items {
+		key := lineKey(item.ProductID, item.VariantID)
+		product := products[key]
+		lines = append(lines, types.TaxableLine{
+			ProductID: item.ProductID,
+			VariantID: item.VariantID,
+			TaxClass:  product.TaxClass,
+			Amount:    product.Price.Mul(int64(item.Quantity)).Sub(totals.lineDiscount(key, currency)),
+		})
+	}
+
+	taxes, err := s.taxes.CalculateTax(address, lines)
+	if err != nil {
+		return err
+	}
+
+	totals.Taxes = make(map[types.LineKey]types.LineTax, len(taxes))
+	for _, tax := range taxes {
+		totals.Taxes[lineKey(tax.ProductID, tax.VariantID)] = tax
+		totals.Tax = totals.Tax.Add(tax.Tax)
+		totals.TaxIncluded = totals.TaxIncluded.Add(tax.Included)
+	}
+
+	// included tax is already in the subtotal
+	totals.Total = totals.Total.Add(totals.Tax.Sub(totals.TaxIncluded))
+
+	return nil
+}
+
+// shipping is quoted on the subtotal before discounts, the same figure the
+// customer saw on /shipping/quote ... a free shipping promotion waives it
+func (s *Service) applyShipping(method string, address types.TaxAddress, items []types.CheckoutItem, products map[types.LineKey]*lineProduct, totals *checkoutTotals) error {
+	parcel := types.Parcel{Subtotal: totals.Subtotal, Country: address.Country}
+	for _, item := range items {
+		parcel.Weight += shipping.ChargeableWeight(&products[lineKey(item.ProductID, item.VariantID)].Product, item.Quantity)
+	}
+
+	quote, err := s.shipping.QuoteShippingMethod(method, parcel)
+	if err != nil {
+		return err
+	}
+
+	if !totals.FreeShipping {
+		totals.Shipping = quote.Price
+	}
+	totals.Total = totals.Total.Add(totals.Shipping)
+
+	return nil
+}
+
+// checkoutAddresses picks the addresses an order is copied from, the
+// user's default when no shipping address is given and the shipping
+// address when no billing address is
+func (s *Service) checkoutAddresses(userID int, payload types.CheckoutPayload) (types.Address, types.Address, error) {
+	shipTo, err := s.checkoutAddress(userID, payload.ShippingAddressID, payload.ShippingAddress)
+	if err != nil {
+		return types.Address{}, types.Address{}, err
+	}
+
+	if shipTo == nil {
+		saved, err := s.addresses.GetDefaultAddress(userID)
+		if errors.Is(err, address.ErrAddressNotFound) {
+			return types.Address{}, types.Address{}, address.ErrAddressRequired
+		}
+		if err != nil {
+			return types.Address{}, types.Address{}, err
+		}
+		shipTo = &saved.Address
+	}
+
+	billTo, err := s.checkoutAddress(userID, payload.BillingAddressID, payload.BillingAddress)
+	if err != nil {
+		return types.Address{}, types.Address{}, err
+	}
+
+	if billTo == nil {
+		billTo = shipTo
+	}
+
+	return *shipTo, *billTo, nil
+}
+
+// a saved address by ID, or one given inline ... nil for neither
+func (s *Service) checkoutAddress(userID int, id int, inline *types.Address) (*types.Address, error) {
+	if id > 0 {
+		saved, err := s.addresses.GetUserAddress(userID, id)
+		if err != nil {
+			return nil, err
+		}
+		return &saved.Address, nil
+	}
+
+	if inline == nil {
+		return nil, nil
+	}
+
+	normalized := address.Normalize(*inline)
+	if err := address.Validate(normalized); err != nil {
+		return nil, err
+	}
+
+	return &normalized, nil
+}
+
+// folds repeated products into one line and sorts by product ID so
+// concurrent checkouts always lock rows in the same order (no deadlocks)
+// ... each variant of a product is a line of its own
+func mergeCheckoutItems(items []types.CheckoutItem) []types.CheckoutItem {
+	quantities := make(map[types.LineKey]int)
+	for _, item := range items {
+		quantities[lineKey(item.ProductID, item.VariantID)] += item.Quantity
+	}
+
+	merged := make([]types.CheckoutItem, 0, len(quantities))
+	for key, quantity := range quantities {
+		merged = append(merged, types.CheckoutItem{ProductID: key.ProductID, VariantID: key.VariantID, Quantity: quantity})
+	}
+
+	sort.Slice(merged, func(i, j int) bool {
+		if merged[i].ProductID != merged[j].ProductID {
+			return merged[i].ProductID < merged[j].ProductID
+		}
+		return merged[i].VariantID < merged[j].VariantID
+	})
+
+	return merged
+}
+
+func lineKey(productID int, variantID int) types.LineKey {
+	return types.LineKey{ProductID: productID, VariantID: variantID}
+}
+
+// lineProduct is what a line is for, a variant's price, image and stock
+// taking the place of its product's
+type lineProduct struct {
+	types.Product
+	variant *types.ProductVariant
+}
+
+func newLineProduct(product types.Product, v *types.ProductVariant) *lineProduct {
+	if v != nil {
+		product.Price = v.Price
+		product.Image = v.Image
+		product.Quantity = v.Quantity
+	}
+
+	return &lineProduct{Product: product, variant: v}
+}
+
+// the name orders keep, like "T-Shirt (M / Red)"
+func (p *lineProduct) title() string {
+	if p.variant == nil {
+		return p.Name
+	}
+	return fmt.Sprintf("%s (%s)", p.Name, variant.Title(p.variant.Options))
+}
+
+func (p *lineProduct) sku() string {
+	if p.variant == nil {
+		return ""
+	}
+	return p.variant.SKU
+}
+
+func (p *lineProduct) options() []types.VariantOption {
+	if p.variant == nil {
+		return nil
+	}
+	return p.variant.Options
+}
@@ -0,0 +1,644 @@
+package cart
+
+import (
+	"errors"
+	"sync"
+	"testing"
+
+	"github.com/eugenius-watchman/ecom_go_rest_api/cmd/service/address"
+	"github.com/eugenius-watchman/ecom_go_rest_api/cmd/service/promotion"
+	"github.com/eugenius-watchman/ecom_go_rest_api/cmd/service/shipping"
+	"github.com/eugenius-watchman/ecom_go_rest_api/cmd/service/tax"
+	"github.com/eugenius-watchman/ecom_go_rest_api/cmd/service/variant"
+	"github.com/eugenius-watchman/ecom_go_rest_api/types"
+)
+
+// the service side of concurrent checkouts: every buyer either gets an order
+// or ErrInsufficientStock, never an error in between ... the stock itself is
+// guarded by the mock transaction here, the row lock and the quantity >= ?
+// guard in store.go are covered by TestCheckoutTxStock
+func TestCheckoutServiceStopsAtStock(t *testing.T) {
+	const stock = 10
+	const buyers = 50
+
+	store := newMockCartStore(types.Product{ID: 1, Name: "shirt", Price: types.NewMoney(2000, "USD"), Quantity: stock})
+	service := NewService(store, &mockProductStore{store: store}, &mockVariantStore{store: store}, &mockPricer{}, tax.NewCalculator(&mockTaxRateStore{}), newShippingCalculator(), newMockAddressStore())
+
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	succeeded, outOfStock := 0, 0
+
+	for i := 0; i < buyers; i++ {
+		wg.Add(1)
+		go func(userID int) {
+			defer wg.Done()
+
+			_, _, err := service.Checkout(userID, "USD", types.CheckoutPayload{
+				ShippingMethod: "pickup",
+				Items:          []types.CheckoutItem{{ProductID: 1, Quantity: 1}},
+			})
+
+			mu.Lock()
+			defer mu.Unlock()
+			switch {
+			case err == nil:
+				succeeded++
+			case errors.Is(err, ErrInsufficientStock):
+				outOfStock++
+			default:
+				t.Errorf("unexpected error: %v", err)
+			}
+		}(i + 1)
+	}
+	wg.Wait()
+
+	if succeeded != stock {
+		t.Errorf("expected %d successful checkouts, got %d", stock, succeeded)
+	}
+	if outOfStock != buyers-stock {
+		t.Errorf("expected %d out of stock checkouts, got %d", buyers-stock, outOfStock)
+	}
+	if q := store.quantity(1); q != 0 {
+		t.Errorf("expected remaining stock 0, got %d", q)
+	}
+	if n := len(store.orders); n != stock {
+		t.Errorf("expected %d orders, got %d", stock, n)
+	}
+}
+
+func TestCheckoutRollsBackOnFailure(t *testing.T) {
+	store := newMockCartStore(
+		types.Product{ID: 1, Name: "shirt", Price: types.NewMoney(2000, "USD"), Quantity: 5},
+		types.Product{ID: 2, Name: "hat", Price: types.NewMoney(1000, "USD"), Quantity: 1},
+	)
+	service := NewService(store, &mockProductStore{store: store}, &mockVariantStore{store: store}, &mockPricer{}, tax.NewCalculator(&mockTaxRateStore{}), newShippingCalculator(), newMockAddressStore())
+
+	// the hat line fails after the shirt has been priced and locked
+	_, _, err := service.Checkout(1, "USD", types.CheckoutPayload{
+		ShippingMethod: "pickup",
+		Items: []types.CheckoutItem{
+			{ProductID: 1, Quantity: 2},
+			{ProductID: 2, Quantity: 3},
+		},
+	})
+	if !errors.Is(err, ErrInsufficientStock) {
+		t.Fatalf("expected insufficient stock error, got %v", err)
+	}
+
+	if q := store.quantity(1); q != 5 {
+		t.Errorf("expected shirt stock to stay 5, got %d", q)
+	}
+	if n := len(store.orders); n != 0 {
+		t.Errorf("expected no orders, got %d", n)
+	}
+}
+
+func TestCheckoutDeletedProduct(t *testing.T) {
+	store := newMockCartStore(types.Product{ID: 1, Name: "shirt", Price: types.NewMoney(2000, "USD"), Quantity: 5})
+	products := &mockProductStore{store: store}
+	service := NewService(store, products, &mockVariantStore{store: store}, &mockPricer{}, tax.NewCalculator(&mockTaxRateStore{}), newShippingCalculator(), newMockAddressStore())
+
+	payload := types.CheckoutPayload{ShippingMethod: "pickup", Items: []types.CheckoutItem{{ProductID: 1, Quantity: 1}}}
+	products.DeleteProduct(1)
+
+	if _, _, err := service.Checkout(1, "USD", payload); !errors.Is(err, ErrProductNotFound) {
+		t.Fatalf("expected product not found error, got %v", err)
+	}
+	if q := store.quantity(1); q != 5 {
+		t.Errorf("expected shirt stock to stay 5, got %d", q)
+	}
+
+	// a restored product can be bought again
+	products.RestoreProduct(1)
+	if _, _, err := service.Checkout(1, "USD", payload); err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+}
+
+func TestCheckoutMergesRepeatedItems(t *testing.T) {
+	store := newMockCartStore(types.Product{ID: 1, Name: "shirt", Price: types.NewMoney(2000, "USD"), Quantity: 3})
+	service := NewService(store, &mockProductStore{store: store}, &mockVariantStore{store: store}, &mockPricer{}, tax.NewCalculator(&mockTaxRateStore{}), newShippingCalculator(), newMockAddressStore())
+
+	order, _, err := service.Checkout(1, "USD", types.CheckoutPayload{
+		ShippingMethod: "pickup",
+		Items: []types.CheckoutItem{
+			{ProductID: 1, Quantity: 2},
+			{ProductID: 1, Quantity: 1},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if order.Total.Amount != 6000 {
+		t.Errorf("expected total 60, got %v", order.Total)
+	}
+	if n := len(store.orderItems); n != 1 {
+		t.Errorf("expected 1 order item, got %d", n)
+	}
+	if q := store.quantity(1); q != 0 {
+		t.Errorf("expected remaining stock 0, got %d", q)
+	}
+}
+
+func TestCheckoutVariants(t *testing.T) {
+	newStore := func() *mockCartStore {
+		store := newMockCartStore(
+			types.Product{ID: 1, Name: "T-Shirt", Image: "shirt.png", Price: types.NewMoney(2000, "USD"), Quantity: 5},
+			types.Product{ID: 2, Name: "hat", Price: types.NewMoney(1000, "USD"), Quantity: 5},
+		)
+		store.variants[1] = []types.ProductVariant{
+			{ID: 10, ProductID: 1, SKU: "TS-M-RED", Price: types.NewMoney(2000, "USD"), Image: "shirt.png", Quantity: 2,
+				Options: []types.VariantOption{{Name: "Size", Value: "M"}, {Name: "Colour", Value: "Red"}}},
+			{ID: 11, ProductID: 1, SKU: "TS-XL-RED", Price: types.NewMoney(2500, "USD"), PriceOverride: true, Image: "red-xl.png", Quantity: 3,
+				Options: []types.VariantOption{{Name: "Size", Value: "XL"}, {Name: "Colour", Value: "Red"}}},
+		}
+		return store
+	}
+
+	t.Run("should take stock and price from each variant", func(t *testing.T) {
+		store := newStore()
+		service := NewService(store, &mockProductStore{store: store}, &mockVariantStore{store: store}, &mockPricer{}, tax.NewCalculator(&mockTaxRateStore{}), newShippingCalculator(), newMockAddressStore())
+
+		order, _, err := service.Checkout(1, "USD", types.CheckoutPayload{
+			ShippingMethod: "pickup",
+			Items: []types.CheckoutItem{
+				{ProductID: 1, VariantID: 11, Quantity: 1},
+				{ProductID: 1, VariantID: 10, Quantity: 2},
+				{ProductID: 2, Quantity: 1},
+			},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// 2 x 20 + 25 + 10
+		if order.Total.Amount != 7500 {
+			t.Errorf("expected total 75, got %v", order.Total)
+		}
+		if q := store.variantQuantity(1, 10); q != 0 {
+			t.Errorf("expected no M left, got %d", q)
+		}
+		if q := store.variantQuantity(1, 11); q != 2 {
+			t.Errorf("expected 2 XL left, got %d", q)
+		}
+		if q := store.quantity(1); q != 2 {
+			t.Errorf("expected the product total to follow its variants, got %d", q)
+		}
+		if q := store.quantity(2); q != 4 {
+			t.Errorf("expected 4 hats left, got %d", q)
+		}
+
+		if n := len(store.orderItems); n != 3 {
+			t.Fatalf("expected 3 order items, got %d", n)
+		}
+		xl := store.orderItems[1]
+		if xl.VariantID != 11 || xl.SKU != "TS-XL-RED" || xl.ProductName != "T-Shirt (XL / Red)" || xl.ProductImage != "red-xl.png" || xl.Price.Amount != 2500 {
+			t.Errorf("unexpected XL order item %+v", xl)
+		}
+		if hat := store.orderItems[2]; hat.VariantID != 0 || hat.SKU != "" || hat.ProductName != "hat" {
+			t.Errorf("unexpected hat order item %+v", hat)
+		}
+	})
+
+	t.Run("should check stock per variant", func(t *testing.T) {
+		store := newStore()
+		service := NewService(store, &mockProductStore{store: store}, &mockVariantStore{store: store}, &mockPricer{}, tax.NewCalculator(&mockTaxRateStore{}), newShippingCalculator(), newMockAddressStore())
+
+		// 3 in stock across the product, only 2 of them M
+		_, _, err := service.Checkout(1, "USD", types.CheckoutPayload{
+			ShippingMethod: "pickup",
+			Items:          []types.CheckoutItem{{ProductID: 1, VariantID: 10, Quantity: 3}},
+		})
+		if !errors.Is(err, ErrInsufficientStock) {
+			t.Fatalf("expected insufficient stock error, got %v", err)
+		}
+		if q := store.variantQuantity(1, 10); q != 2 {
+			t.Errorf("expected M stock to stay 2, got %d", q)
+		}
+	})
+
+	t.Run("should require a variant of the product", func(t *testing.T) {
+		store := newStore()
+		service := NewService(store, &mockProductStore{store: store}, &mockVariantStore{store: store}, &mockPricer{}, tax.NewCalculator(&mockTaxRateStore{}), newShippingCalculator(), newMockAddressStore())
+
+		cases := map[string]types.CheckoutItem{
+			"no variant":            {ProductID: 1, Quantity: 1},
+			"another product's":     {ProductID: 2, VariantID: 10, Quantity: 1},
+			"a variant that's gone": {ProductID: 1, VariantID: 99, Quantity: 1},
+		}
+		for name, item := range cases {
+			_, _, err := service.Checkout(1, "USD", types.CheckoutPayload{ShippingMethod: "pickup", Items: []types.CheckoutItem{item}})
+			if !errors.Is(err, variant.ErrVariantRequired) && !errors.Is(err, variant.ErrVariantNotFound) {
+				t.Errorf("%s: expected a variant error, got %v", name, err)
+			}
+		}
+		if n := len(store.orders); n != 0 {
+			t.Errorf("expected no orders, got %d", n)
+		}
+	})
+
+	t.Run("should keep each variant on its own cart line", func(t *testing.T) {
+		store := newStore()
+		service := NewService(store, &mockProductStore{store: store}, &mockVariantStore{store: store}, &mockPricer{}, tax.NewCalculator(&mockTaxRateStore{}), newShippingCalculator(), newMockAddressStore())
+		cart, _ := store.GetOrCreateCart(1)
+
+		if _, err := service.AddItem(cart, "EUR", types.AddCartItemPayload{ProductID: 1, VariantID: 10, Quantity: 1}); err != nil {
+			t.Fatal(err)
+		}
+		view, err := service.AddItem(cart, "EUR", types.AddCartItemPayload{ProductID: 1, VariantID: 11, Quantity: 3})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if n := len(view.Items); n != 2 {
+			t.Fatalf("expected 2 lines, got %d", n)
+		}
+		xl := view.Items[1]
+		if xl.VariantID != 11 || xl.SKU != "TS-XL-RED" || len(xl.Options) != 2 || xl.Price != types.NewMoney(1250, "EUR") {
+			t.Errorf("unexpected XL line %+v", xl)
+		}
+
+		if _, err := service.AddItem(cart, "USD", types.AddCartItemPayload{ProductID: 1, VariantID: 11, Quantity: 1}); !errors.Is(err, ErrInsufficientStock) {
+			t.Errorf("expected insufficient stock error, got %v", err)
+		}
+		if _, err := service.AddItem(cart, "USD", types.AddCartItemPayload{ProductID: 1, Quantity: 1}); !errors.Is(err, variant.ErrVariantRequired) {
+			t.Errorf("expected a variant to be required, got %v", err)
+		}
+
+		view, err = service.RemoveItem(cart, "USD", 1, 10)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if n := len(view.Items); n != 1 || view.Items[0].VariantID != 11 {
+			t.Errorf("expected only the XL line left, got %+v", view.Items)
+		}
+	})
+}
+
+func TestCheckoutInAnotherCurrency(t *testing.T) {
+	store := newMockCartStore(types.Product{ID: 1, Name: "shirt", Price: types.NewMoney(1999, "USD"), Quantity: 3})
+	service := NewService(store, &mockProductStore{store: store}, &mockVariantStore{store: store}, &mockPricer{}, tax.NewCalculator(&mockTaxRateStore{}), newShippingCalculator(), newMockAddressStore())
+
+	order, _, err := service.Checkout(1, "EUR", types.CheckoutPayload{
+		ShippingMethod: "pickup",
+		Items:          []types.CheckoutItem{{ProductID: 1, Quantity: 3}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// 19.99 USD is 10.00 EUR at 0.5 (9.995 rounded), the total is per line
+	if order.Currency != "EUR" || order.Total != types.NewMoney(3000, "EUR") {
+		t.Errorf("unexpected order total %v %s", order.Total, order.Currency)
+	}
+	if price := store.orderItems[0].Price; price != types.NewMoney(1000, "EUR") {
+		t.Errorf("expected the item priced in EUR, got %+v", price)
+	}
+}
+
+func TestCheckoutWithPromotion(t *testing.T) {
+	store := newMockCartStore(
+		types.Product{ID: 1, Name: "shirt", Price: types.NewMoney(2000, "USD"), Quantity: 10},
+		types.Product{ID: 2, Name: "hat", Price: types.NewMoney(1000, "USD"), Quantity: 10},
+	)
+	store.promotions["TENOFF"] = &types.Promotion{
+		ID: 1, Code: "TENOFF", Type: types.PromotionPercent, PercentOff: 10, Currency: "USD", Active: true,
+	}
+	service := NewService(store, &mockProductStore{store: store}, &mockVariantStore{store: store}, &mockPricer{}, tax.NewCalculator(&mockTaxRateStore{}), newShippingCalculator(), newMockAddressStore())
+
+	order, _, err := service.Checkout(1, "USD", types.CheckoutPayload{
+		ShippingMethod: "pickup",
+		Items:          []types.CheckoutItem{{ProductID: 1, Quantity: 1}, {ProductID: 2, Quantity: 1}},
+		PromotionCode:  "TENOFF",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if order.Total.Amount != 2700 || order.DiscountTotal.Amount != 300 {
+		t.Errorf("expected 27.00 after 3.00 off, got %v after %v", order.Total, order.DiscountTotal)
+	}
+	if len(store.discounts) != 1 || store.discounts[0].Code != "TENOFF" || store.discounts[0].OrderID != order.ID {
+		t.Errorf("expected the discount line to be recorded, got %+v", store.discounts)
+	}
+
+	// the discount is split by line value
+	for _, item := range store.orderItems {
+		want := map[int]int64{1: 200, 2: 100}[item.ProductID]
+		if item.Discount.Amount != want {
+			t.Errorf("expected product %d discount %d, got %v", item.ProductID, want, item.Discount)
+		}
+	}
+	if store.promotions["TENOFF"].UsageCount != 1 {
+		t.Errorf("expected usage count 1, got %d", store.promotions["TENOFF"].UsageCount)
+	}
+
+	t.Run("should leave nothing behind for an invalid code", func(t *testing.T) {
+		_, _, err := service.Checkout(1, "USD", types.CheckoutPayload{
+			ShippingMethod: "pickup",
+			Items:          []types.CheckoutItem{{ProductID: 1, Quantity: 1}},
+			PromotionCode:  "NOPE",
+		})
+		if !errors.Is(err, promotion.ErrPromotionNotFound) {
+			t.Fatalf("expected promotion not found, got %v", err)
+		}
+		if q := store.quantity(1); q != 9 {
+			t.Errorf("expected stock to be rolled back to 9, got %d", q)
+		}
+	})
+
+	t.Run("should enforce the per-user limit", func(t *testing.T) {
+		limit := 1
+		store.promotions["ONCE"] = &types.Promotion{
+			ID: 2, Code: "ONCE", Type: types.PromotionFixed, AmountOff: types.NewMoney(500, "USD"),
+			Currency: "USD", PerUserLimit: &limit, Active: true,
+		}
+
+		payload := types.CheckoutPayload{
+			ShippingMethod: "pickup",
+			Items:          []types.CheckoutItem{{ProductID: 2, Quantity: 1}},
+			PromotionCode:  "ONCE",
+		}
+		if _, _, err := service.Checkout(1, "USD", payload); err != nil {
+			t.Fatal(err)
+		}
+		if _, _, err := service.Checkout(1, "USD", payload); !errors.Is(err, promotion.ErrPromotionUserLimit) {
+			t.Errorf("expected the per-user limit, got %v", err)
+		}
+		if _, _, err := service.Checkout(2, "USD", payload); err != nil {
+			t.Errorf("expected another user to redeem it, got %v", err)
+		}
+	})
+}
+
+func TestCheckoutWithTax(t *testing.T) {
+	store := newMockCartStore(
+		types.Product{ID: 1, Name: "shirt", Price: types.NewMoney(2000, "USD"), Quantity: 10, TaxClass: types.TaxClassStandard},
+		types.Product{ID: 2, Name: "book", Price: types.NewMoney(1000, "USD"), Quantity: 10, TaxClass: "reduced"},
+	)
+	store.promotions["TENOFF"] = &types.Promotion{
+		ID: 1, Code: "TENOFF", Type: types.PromotionPercent, PercentOff: 10, Currency: "USD", Active: true,
+	}
+	rates := &mockTaxRateStore{rates: map[string][]types.TaxRate{
+		"US": {
+			{Country: "US", Region: "NY", TaxClass: types.TaxClassStandard, Name: "NY sales tax", Rate: 800},
+			{Country: "US", Region: "NY", TaxClass: "reduced", Name: "NY sales tax", Rate: 0},
+		},
+		"DE": {
+			{Country: "DE", TaxClass: types.TaxClassStandard, Name: "VAT", Rate: 1900, Inclusive: true},
+			{Country: "DE", TaxClass: "reduced", Name: "VAT", Rate: 700, Inclusive: true},
+		},
+	}}
+	service := NewService(store, &mockProductStore{store: store}, &mockVariantStore{store: store}, &mockPricer{}, tax.NewCalculator(rates), newShippingCalculator(), newMockAddressStore())
+
+	items := []types.CheckoutItem{{ProductID: 1, Quantity: 1}, {ProductID: 2, Quantity: 1}}
+
+	t.Run("should add exclusive tax on the discounted lines", func(t *testing.T) {
+		order, _, err := service.Checkout(1, "USD", types.CheckoutPayload{
+			ShippingMethod: "pickup", Items: items, PromotionCode: "TENOFF", ShippingAddress: testAddress("US", "NY", "10001"),
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// 30.00 - 3.00 off, 8% of the shirt's 18.00 and nothing on the book
+		if order.Subtotal.Amount != 3000 || order.DiscountTotal.Amount != 300 || order.TaxTotal.Amount != 144 ||
+			order.TaxIncluded.Amount != 0 || order.ShippingTotal.Amount != 0 || order.Total.Amount != 2844 {
+			t.Errorf("unexpected totals %+v", order)
+		}
+		if shipTo := order.ShippingAddress; shipTo.Country != "US" || shipTo.Region != "NY" {
+			t.Errorf("expected the order to be taxed in NY, got %s %s", shipTo.Country, shipTo.Region)
+		}
+
+		items := store.orderItems[len(store.orderItems)-2:]
+		if shirt := items[0]; shirt.Tax.Amount != 144 || shirt.Total.Amount != 1944 {
+			t.Errorf("unexpected shirt line %+v", shirt)
+		}
+		if book := items[1]; book.Tax.Amount != 0 || book.Total.Amount != 900 {
+			t.Errorf("unexpected book line %+v", book)
+		}
+
+		taxes := store.itemTaxes[len(store.itemTaxes)-2:]
+		if taxes[0].OrderItemID != items[0].ID || taxes[0].Taxable.Amount != 1800 || taxes[0].Amount.Amount != 144 {
+			t.Errorf("unexpected shirt tax line %+v", taxes[0])
+		}
+		if taxes[1].OrderItemID != items[1].ID || taxes[1].Rate != 0 {
+			t.Errorf("unexpected book tax line %+v", taxes[1])
+		}
+	})
+
+	t.Run("should leave inclusive tax in the total", func(t *testing.T) {
+		order, _, err := service.Checkout(1, "USD", types.CheckoutPayload{
+			ShippingMethod: "pickup", Items: items, ShippingAddress: testAddress("de", "", "10115"),
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// 20.00 - 20.00/1.19 = 3.19 and 10.00 - 10.00/1.07 = 0.65
+		if order.TaxTotal.Amount != 384 || order.TaxIncluded.Amount != 384 || order.Total.Amount != 3000 {
+			t.Errorf("unexpected totals %+v", order)
+		}
+		if order.ShippingAddress.Country != "DE" {
+			t.Errorf("expected the order to be taxed in DE, got %s", order.ShippingAddress.Country)
+		}
+	})
+
+	t.Run("should tax where the default address is", func(t *testing.T) {
+		order, _, err := service.Checkout(1, "USD", types.CheckoutPayload{ShippingMethod: "pickup", Items: items})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if order.ShippingAddress.Region != "CA" || order.TaxTotal.Amount != 0 || order.Total.Amount != 3000 {
+			t.Errorf("unexpected order %+v", order)
+		}
+	})
+}
+
+func TestCheckoutWithShipping(t *testing.T) {
+	// 600g, but 300x200x100mm bills as 1.2kg
+	store := newMockCartStore(types.Product{
+		ID: 1, Name: "shirt", Price: types.NewMoney(2000, "USD"), Quantity: 10,
+		Weight: 600, Length: 300, Width: 200, Height: 100,
+	})
+	store.promotions["FREESHIP"] = &types.Promotion{
+		ID: 1, Code: "FREESHIP", Type: types.PromotionFreeShipping, Currency: "USD", Active: true,
+	}
+	courier := types.ShippingMethod{
+		Code: "courier", Name: "Courier", Type: types.ShippingWeight,
+		Price: types.NewMoney(500, "USD"), PerKg: types.NewMoney(200, "USD"), FreeOver: types.NewMoney(0, "USD"),
+		Countries: []string{"US"}, Active: true,
+	}
+	service := NewService(store, &mockProductStore{store: store}, &mockVariantStore{store: store}, &mockPricer{}, tax.NewCalculator(&mockTaxRateStore{}), newShippingCalculator(courier), newMockAddressStore())
+
+	checkout := func(code string, payload types.CheckoutPayload) (*types.Order, error) {
+		payload.Items = []types.CheckoutItem{{ProductID: 1, Quantity: 1}}
+		order, _, err := service.Checkout(1, code, payload)
+		return order, err
+	}
+
+	t.Run("should add the chosen method to the total", func(t *testing.T) {
+		order, err := checkout("USD", types.CheckoutPayload{ShippingMethod: "courier", ShippingAddress: testAddress("US", "CA", "94105")})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// 5.00 + 2 started kilograms at 2.00
+		if order.ShippingTotal.Amount != 900 || order.Total.Amount != 2900 || order.ShippingMethod != "courier" {
+			t.Errorf("unexpected order %+v", order)
+		}
+	})
+
+	t.Run("should convert the rates into the order currency", func(t *testing.T) {
+		order, err := checkout("EUR", types.CheckoutPayload{ShippingMethod: "courier", ShippingAddress: testAddress("US", "CA", "94105")})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if order.ShippingTotal != types.NewMoney(450, "EUR") || order.Total.Amount != 1450 {
+			t.Errorf("unexpected order %+v", order)
+		}
+	})
+
+	t.Run("should waive shipping for a free shipping promotion", func(t *testing.T) {
+		order, err := checkout("USD", types.CheckoutPayload{ShippingMethod: "courier", ShippingAddress: testAddress("US", "CA", "94105"), PromotionCode: "FREESHIP"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if order.ShippingTotal.Amount != 0 || order.Total.Amount != 2000 {
+			t.Errorf("unexpected order %+v", order)
+		}
+	})
+
+	t.Run("should refuse a method that doesn't ship there", func(t *testing.T) {
+		before := store.quantity(1)
+		_, err := checkout("USD", types.CheckoutPayload{ShippingMethod: "courier", ShippingAddress: testAddress("DE", "", "10115")})
+		if !errors.Is(err, shipping.ErrMethodUnavailable) {
+			t.Fatalf("expected the method to be unavailable, got %v", err)
+		}
+		if q := store.quantity(1); q != before {
+			t.Errorf("expected stock to be rolled back to %d, got %d", before, q)
+		}
+	})
+
+	t.Run("should refuse an unknown method", func(t *testing.T) {
+		_, err := checkout("USD", types.CheckoutPayload{ShippingMethod: "teleport"})
+		if !errors.Is(err, shipping.ErrMethodNotFound) {
+			t.Errorf("expected the method not to be found, got %v", err)
+		}
+	})
+}
+
+func TestCheckoutAddresses(t *testing.T) {
+	store := newMockCartStore(types.Product{ID: 1, Name: "shirt", Price: types.NewMoney(2000, "USD"), Quantity: 10})
+	addresses := newMockAddressStore()
+	service := NewService(store, &mockProductStore{store: store}, &mockVariantStore{store: store}, &mockPricer{}, tax.NewCalculator(&mockTaxRateStore{}), newShippingCalculator(), addresses)
+
+	checkout := func(userID int, payload types.CheckoutPayload) (*types.Order, error) {
+		payload.ShippingMethod = "pickup"
+		payload.Items = []types.CheckoutItem{{ProductID: 1, Quantity: 1}}
+		order, _, err := service.Checkout(userID, "USD", payload)
+		return order, err
+	}
+
+	t.Run("should copy a saved address onto the order", func(t *testing.T) {
+		order, err := checkout(1, types.CheckoutPayload{ShippingAddressID: 1})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if order.ShippingAddress.PostalCode != "SW1A 1AA" || order.BillingAddress != order.ShippingAddress {
+			t.Errorf("unexpected addresses %+v %+v", order.ShippingAddress, order.BillingAddress)
+		}
+	})
+
+	t.Run("should not use another user's address", func(t *testing.T) {
+		if _, err := checkout(2, types.CheckoutPayload{ShippingAddressID: 1}); !errors.Is(err, address.ErrAddressNotFound) {
+			t.Errorf("expected the address not to be found, got %v", err)
+		}
+	})
+
+	t.Run("should normalize inline addresses", func(t *testing.T) {
+		order, err := checkout(1, types.CheckoutPayload{
+			ShippingAddress: testAddress("gb", "", "sw1a1aa"),
+			BillingAddress:  testAddress("ca", "on", "k1a0b1"),
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if order.ShippingAddress.PostalCode != "SW1A 1AA" || order.ShippingAddress.Country != "GB" {
+			t.Errorf("unexpected shipping address %+v", order.ShippingAddress)
+		}
+		if billTo := order.BillingAddress; billTo.PostalCode != "K1A 0B1" || billTo.Region != "ON" {
+			t.Errorf("unexpected billing address %+v", billTo)
+		}
+	})
+
+	t.Run("should reject an address in the wrong format", func(t *testing.T) {
+		before := len(store.orders)
+		_, err := checkout(1, types.CheckoutPayload{ShippingAddress: testAddress("US", "NY", "ABCDE")})
+		if !errors.Is(err, address.ErrInvalidAddress) {
+			t.Fatalf("expected an invalid address, got %v", err)
+		}
+		if len(store.orders) != before {
+			t.Error("expected no order to be created")
+		}
+	})
+
+	t.Run("should require an address without a default", func(t *testing.T) {
+		addresses.noDefault = true
+		defer func() { addresses.noDefault = false }()
+
+		if _, err := checkout(1, types.CheckoutPayload{}); !errors.Is(err, address.ErrAddressRequired) {
+			t.Errorf("expected an address to be required, got %v", err)
+		}
+	})
+}
+
+func TestPromotionUsageLimitUnderConcurrency(t *testing.T) {
+	const limit = 3
+	const buyers = 20
+
+	store := newMockCartStore(types.Product{ID: 1, Name: "shirt", Price: types.NewMoney(2000, "USD"), Quantity: buyers})
+	usageLimit := limit
+	store.promotions["FIRST3"] = &types.Promotion{
+		ID: 1, Code: "FIRST3", Type: types.PromotionPercent, PercentOff: 50, Currency: "USD",
+		UsageLimit: &usageLimit, Active: true,
+	}
+	service := NewService(store, &mockProductStore{store: store}, &mockVariantStore{store: store}, &mockPricer{}, tax.NewCalculator(&mockTaxRateStore{}), newShippingCalculator(), newMockAddressStore())
+
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	succeeded, usedUp := 0, 0
+
+	for i := 0; i < buyers; i++ {
+		wg.Add(1)
+		go func(userID int) {
+			defer wg.Done()
+
+			_, _, err := service.Checkout(userID, "USD", types.CheckoutPayload{
+				ShippingMethod: "pickup",
+				Items:          []types.CheckoutItem{{ProductID: 1, Quantity: 1}},
+				PromotionCode:  "FIRST3",
+			})
+
+			mu.Lock()
+			defer mu.Unlock()
+			switch {
+			case err == nil:
+				succeeded++
+			case errors.Is(err, promotion.ErrPromotionUsedUp):
+				usedUp++
+			default:
+				t.Errorf("unexpected error: %v", err)
+			}
+		}(i + 1)
+	}
+	wg.Wait()
+
+	if succeeded != limit || usedUp != buyers-limit {
+		t.Errorf("expected %d redemptions and %d refusals, got %d and %d", limit, buyers-limit, succeeded, usedUp)
+	}
+	if got := store.promotions["FIRST3"].UsageCount; got != limit {
+		t.Errorf("expected usage count %d, got %d", limit, got)
+	}
+}
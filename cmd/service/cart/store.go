@@ -3,7 +3,12 @@ package cart
 import (
 	"database/sql"
 	"fmt"
+	"strings"
+	"time"
 
+	"github.com/eugenius-watchman/ecom_go_rest_api/cmd/service/promotion"
+	"github.com/eugenius-watchman/ecom_go_rest_api/cmd/service/variant"
+	"github.com/eugenius-watchman/ecom_go_rest_api/config"
 	"github.com/eugenius-watchman/ecom_go_rest_api/types"
 )
 
@@ -15,17 +20,137 @@ func NewStore(db *sql.DB) *Store {
 	return &Store{db: db}
 }
 
-func (s *Store) CreateOrder(order types.Order) (int, error) {
+func (s *Store) WithinTx(fn func(tx types.CheckoutTx) error) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	// no-op once committed
+	defer tx.Rollback()
+
+	if err := fn(&checkoutTx{tx: tx}); err != nil {
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+
+	return nil
+}
+
+type checkoutTx struct {
+	tx *sql.Tx
+}
+
+// locks the product row until the transaction ends
+func (t *checkoutTx) GetProductForUpdate(id int) (*types.Product, error) {
+	const query = `
+		SELECT id, name, description, image, price, quantity, taxClass, weight, length, width, height, createdAt
+		FROM products WHERE id = ? AND deletedAt IS NULL
+		FOR UPDATE`
+
+	product := types.Product{Price: types.NewMoney(0, config.Envs.DefaultCurrency)}
+	err := t.tx.QueryRow(query, id).Scan(
+		&product.ID,
+		&product.Name,
+		&product.Description,
+		&product.Image,
+		&product.Price,
+		&product.Quantity,
+		&product.TaxClass,
+		&product.Weight,
+		&product.Length,
+		&product.Width,
+		&product.Height,
+		&product.CreatedAt,
+	)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, fmt.Errorf("%w: %d", ErrProductNotFound, id)
+		}
+		return nil, fmt.Errorf("failed to get product: %w", err)
+	}
+
+	return &product, nil
+}
+
+// the quantity guard makes the decrement safe even without the row lock
+func (t *checkoutTx) DecrementProductQuantity(id int, quantity int) error {
+	const query = `
+		UPDATE products SET quantity = quantity - ?
+		WHERE id = ? AND quantity >= ?`
+
+	result, err := t.tx.Exec(query, quantity, id, quantity)
+	if err != nil {
+		return fmt.Errorf("failed to update stock for product %d: %w", id, err)
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to check rows affected: %w", err)
+	}
+
+	if rowsAffected == 0 {
+		return fmt.Errorf("%w for product %d", ErrInsufficientStock, id)
+	}
+
+	return nil
+}
+
+func (t *checkoutTx) GetProductVariantsForUpdate(productID int) ([]types.ProductVariant, error) {
+	return variant.LockProductVariants(t.tx, productID)
+}
+
+// the product's quantity is the sum of its variants', so it goes down too
+func (t *checkoutTx) DecrementVariantQuantity(id int, quantity int) error {
+	const query = `
+		UPDATE product_variants v JOIN products p ON p.id = v.productId
+		SET v.quantity = v.quantity - ?, p.quantity = p.quantity - ?
+		WHERE v.id = ? AND v.quantity >= ?`
+
+	result, err := t.tx.Exec(query, quantity, quantity, id, quantity)
+	if err != nil {
+		return fmt.Errorf("failed to update stock for variant %d: %w", id, err)
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to check rows affected: %w", err)
+	}
+
+	if rowsAffected == 0 {
+		return fmt.Errorf("%w for variant %d", ErrInsufficientStock, id)
+	}
+
+	return nil
+}
+
+func (t *checkoutTx) CreateOrder(order types.Order) (int, error) {
 	const query = `
-		INSERT INTO orders (userId, total, status, address, createdAt)
-		VALUES (?, ?, ?, ?, ?)`
+		INSERT INTO orders (userId, currency, subtotal, total, discountTotal, taxTotal, taxIncluded, shippingTotal,
+			shippingMethod, status,
+			shippingName, shippingLine1, shippingLine2, shippingCity, shippingRegion, shippingPostalCode, shippingCountry, shippingPhone,
+			billingName, billingLine1, billingLine2, billingCity, billingRegion, billingPostalCode, billingCountry, billingPhone,
+			createdAt)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`
+
+	shipTo, billTo := order.ShippingAddress, order.BillingAddress
 
-	result, err := s.db.Exec(
+	result, err := t.tx.Exec(
 		query,
 		order.UserID,
+		order.Currency,
+		order.Subtotal,
 		order.Total,
+		order.DiscountTotal,
+		order.TaxTotal,
+		order.TaxIncluded,
+		order.ShippingTotal,
+		order.ShippingMethod,
 		order.Status,
-		order.Address,
+		shipTo.Name, shipTo.Line1, shipTo.Line2, shipTo.City, shipTo.Region, shipTo.PostalCode, shipTo.Country, shipTo.Phone,
+		billTo.Name, billTo.Line1, billTo.Line2, billTo.City, billTo.Region, billTo.PostalCode, billTo.Country, billTo.Phone,
 		order.CreatedAt,
 	)
 	if err != nil {
@@ -41,84 +166,331 @@ func (s *Store) CreateOrder(order types.Order) (int, error) {
 
 }
 
-func (s *Store) CreateOrderItem(item types.OrderItem) error {
+func (t *checkoutTx) CreateOrderItem(item types.OrderItem) (int, error) {
 	const query = `
-			INSERT INTO order_items (orderId, productId, quantity, price)
-				VALUES (?, ?, ?, ?)`
+			INSERT INTO order_items (orderId, productId, variantId, sku, productName, productImage, quantity, price, discount, tax, total)
+				VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`
 	
-	_, err := s.db.Exec(
+	result, err := t.tx.Exec(
 		query,
 		item.OrderID,
 		item.ProductID,
+		nullableID(item.VariantID),
+		item.SKU,
+		item.ProductName,
+		item.ProductImage,
 		item.Quantity,
 		item.Price,
+		item.Discount,
+		item.Tax,
+		item.Total,
 	)
 	if err != nil {
-		return fmt.Errorf("failed too create order item: %w", err)
+		return 0, fmt.Errorf("failed too create order item: %w", err)
+	}
+
+	id, err := result.LastInsertId()
+	if err != nil {
+		return 0, fmt.Errorf("failed to get order item ID: %w", err)
+	}
+
+	return int(id), nil
+}
+
+func (t *checkoutTx) CreateOrderItemTax(tax types.OrderItemTax) error {
+	const query = `
+		INSERT INTO order_item_taxes (orderItemId, name, rate, inclusive, taxable, amount)
+		VALUES (?, ?, ?, ?, ?, ?)`
+
+	_, err := t.tx.Exec(query, tax.OrderItemID, tax.Name, tax.Rate, tax.Inclusive, tax.Taxable, tax.Amount)
+	if err != nil {
+		return fmt.Errorf("failed to create order item tax: %w", err)
 	}
 
 	return nil
 }
 
-func (s *Store) GetOrderByID(id int) (*types.Order, error) {
+func (s *Store) GetOrCreateCart(userID int) (*types.Cart, error) {
+	// no-op when the user already has a cart
+	if _, err := s.db.Exec("INSERT IGNORE INTO carts (userId) VALUES (?)", userID); err != nil {
+		return nil, fmt.Errorf("failed to create cart: %w", err)
+	}
+
+	var cart types.Cart
+	err := s.db.QueryRow("SELECT id, userId, createdAt, updatedAt FROM carts WHERE userId = ?", userID).
+		Scan(&cart.ID, &cart.UserID, &cart.CreatedAt, &cart.UpdatedAt)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get cart: %w", err)
+	}
+
+	return &cart, nil
+}
+
+func (s *Store) GetCartItems(cartID int) ([]types.CartItem, error) {
 	const query = `
-		SELECT id, userId, total, status, address, createdAt 
-		FROM orders WHERE id = ?`
-
-	row := s.db.QueryRow(query, id)
-
-	var order types.Order
-	err := row.Scan(
-		&order.ID,
-		&order.UserID,
-		&order.Total,
-		&order.Status,
-		&order.Address,
-		&order.CreatedAt,
-	)
+		SELECT cartId, productId, variantId, quantity, currency, price, addedAt
+		FROM cart_items WHERE cartId = ?
+		ORDER BY addedAt, productId, variantId`
+
+	rows, err := s.db.Query(query, cartID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query cart items: %w", err)
+	}
+	defer rows.Close()
+
+	return scanCartItems(rows)
+}
+
+const setCartItemQuery = `
+	INSERT INTO cart_items (cartId, productId, variantId, quantity, price, currency)
+	VALUES (?, ?, ?, ?, ?, ?)
+	ON DUPLICATE KEY UPDATE quantity = VALUES(quantity), price = VALUES(price), currency = VALUES(currency)`
+
+func (s *Store) SetCartItem(cartID int, item types.CartItem) error {
+	_, err := s.db.Exec(setCartItemQuery, cartID, item.ProductID, item.VariantID, item.Quantity, item.Price, item.Price.Currency)
+	if err != nil {
+		return fmt.Errorf("failed to save cart item: %w", err)
+	}
+
+	return nil
+}
+
+func (s *Store) RemoveCartItem(cartID int, productID int, variantID int) (bool, error) {
+	const query = "DELETE FROM cart_items WHERE cartId = ? AND productId = ? AND variantId = ?"
+	result, err := s.db.Exec(query, cartID, productID, variantID)
+	if err != nil {
+		return false, fmt.Errorf("failed to remove cart item: %w", err)
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("failed to check rows affected: %w", err)
+	}
+
+	return rowsAffected > 0, nil
+}
+
+func (s *Store) CreateGuestCart(token string, expiresAt time.Time) (*types.Cart, error) {
+	result, err := s.db.Exec("INSERT INTO carts (guestToken, expiresAt) VALUES (?, ?)", token, expiresAt)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create guest cart: %w", err)
+	}
+
+	cartID, err := result.LastInsertId()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get cart ID: %w", err)
+	}
+
+	now := time.Now()
+	return &types.Cart{ID: int(cartID), ExpiresAt: &expiresAt, CreatedAt: now, UpdatedAt: now}, nil
+}
+
+func (s *Store) GetGuestCart(token string) (*types.Cart, error) {
+	const query = `
+		SELECT id, expiresAt, createdAt, updatedAt
+		FROM carts WHERE guestToken = ? AND userId IS NULL AND expiresAt > ?`
+
+	var cart types.Cart
+	var expiresAt time.Time
+	err := s.db.QueryRow(query, token, time.Now()).Scan(&cart.ID, &expiresAt, &cart.CreatedAt, &cart.UpdatedAt)
 	if err != nil {
 		if err == sql.ErrNoRows {
-			return nil, fmt.Errorf("order not found")
+			return nil, ErrCartNotFound
 		}
-		return nil, fmt.Errorf("failed to get order: %w", err)
+		return nil, fmt.Errorf("failed to get guest cart: %w", err)
 	}
+	cart.ExpiresAt = &expiresAt
 
-	return &order, nil
+	return &cart, nil
+}
+
+func (s *Store) RenewGuestCart(cartID int, expiresAt time.Time) error {
+	_, err := s.db.Exec("UPDATE carts SET expiresAt = ? WHERE id = ? AND userId IS NULL", expiresAt, cartID)
+	if err != nil {
+		return fmt.Errorf("failed to renew guest cart: %w", err)
+	}
+
+	return nil
+}
+
+func (s *Store) MergeGuestCart(guestCartID int, userCartID int, items []types.CartItem) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	// lock the guest cart so two logins with the same cookie merge it once
+	var id int
+	err = tx.QueryRow("SELECT id FROM carts WHERE id = ? AND userId IS NULL FOR UPDATE", guestCartID).Scan(&id)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return ErrCartNotFound
+		}
+		return fmt.Errorf("failed to get guest cart: %w", err)
+	}
+
+	for _, item := range items {
+		_, err := tx.Exec(setCartItemQuery, userCartID, item.ProductID, item.VariantID, item.Quantity, item.Price, item.Price.Currency)
+		if err != nil {
+			return fmt.Errorf("failed to save cart item: %w", err)
+		}
+	}
+
+	// its items go with it
+	if _, err := tx.Exec("DELETE FROM carts WHERE id = ?", guestCartID); err != nil {
+		return fmt.Errorf("failed to delete guest cart: %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+
+	return nil
+}
+
+func (s *Store) DeleteExpiredGuestCarts(before time.Time) (int64, error) {
+	result, err := s.db.Exec("DELETE FROM carts WHERE userId IS NULL AND expiresAt <= ?", before)
+	if err != nil {
+		return 0, fmt.Errorf("failed to delete expired guest carts: %w", err)
+	}
+
+	deleted, err := result.RowsAffected()
+	if err != nil {
+		return 0, fmt.Errorf("failed to check rows affected: %w", err)
+	}
+
+	return deleted, nil
+}
 
+func (t *checkoutTx) GetPromotionForUpdate(code string) (*types.Promotion, error) {
+	return promotion.LockPromotionByCode(t.tx, code)
 }
 
-func (s *Store) GetOrdersByUserID(userID int) ([]types.Order, error) {
+func (t *checkoutTx) CountPromotionRedemptions(promotionID int, userID int) (int, error) {
+	return promotion.CountRedemptions(t.tx, promotionID, userID)
+}
+
+func (t *checkoutTx) RecordPromotionRedemption(promotionID int, userID int, orderID int) error {
+	return promotion.RecordRedemption(t.tx, promotionID, userID, orderID)
+}
+
+func (t *checkoutTx) GetProductCategoryIDs(productIDs []int) (map[int][]int, error) {
+	categories := make(map[int][]int)
+	if len(productIDs) == 0 {
+		return categories, nil
+	}
+
+	args := make([]any, 0, len(productIDs))
+	for _, id := range productIDs {
+		args = append(args, id)
+	}
+
+	// walks up from each linked category to the top level
+	query := fmt.Sprintf(`
+		WITH RECURSIVE linked AS (
+			SELECT pc.productId, c.id AS categoryId, c.parentId
+			FROM product_categories pc
+			JOIN categories c ON c.id = pc.categoryId
+			WHERE pc.productId IN (?%s)
+			UNION ALL
+			SELECT linked.productId, c.id, c.parentId
+			FROM linked
+			JOIN categories c ON c.id = linked.parentId
+		)
+		SELECT DISTINCT productId, categoryId FROM linked`,
+		strings.Repeat(", ?", len(productIDs)-1),
+	)
+
+	rows, err := t.tx.Query(query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query product categories: %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var productID, categoryID int
+		if err := rows.Scan(&productID, &categoryID); err != nil {
+			return nil, fmt.Errorf("failed to scan product category: %w", err)
+		}
+		categories[productID] = append(categories[productID], categoryID)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("rows iteration error: %w", err)
+	}
+
+	return categories, nil
+}
+
+func (t *checkoutTx) CreateOrderDiscount(discount types.OrderDiscount) error {
+	const query = `
+		INSERT INTO order_discounts (orderId, promotionId, code, type, amount, freeShipping)
+		VALUES (?, ?, ?, ?, ?, ?)`
+
+	_, err := t.tx.Exec(query, discount.OrderID, discount.PromotionID, discount.Code, discount.Type,
+		discount.Amount, discount.FreeShipping)
+	if err != nil {
+		return fmt.Errorf("failed to create order discount: %w", err)
+	}
+
+	return nil
+}
+
+func (t *checkoutTx) GetCartItemsForUpdate(cartID int) ([]types.CartItem, error) {
 	const query = `
-		SELECT id, userId, total, status, address, createdAt
-		FROM orders WHERE userId = ?
-		ORDER BY createdAt DESC`
+		SELECT cartId, productId, variantId, quantity, currency, price, addedAt
+		FROM cart_items WHERE cartId = ?
+		ORDER BY productId, variantId
+		FOR UPDATE`
 
-	rows, err := s.db.Query(query, userID)
+	rows, err := t.tx.Query(query, cartID)
 	if err != nil {
-		return nil, fmt.Errorf("failed to query oders: %w", err)
+		return nil, fmt.Errorf("failed to query cart items: %w", err)
 	}
 	defer rows.Close()
 
-	var orders []types.Order
+	return scanCartItems(rows)
+}
+
+func (t *checkoutTx) ClearCart(cartID int) error {
+	if _, err := t.tx.Exec("DELETE FROM cart_items WHERE cartId = ?", cartID); err != nil {
+		return fmt.Errorf("failed to clear cart: %w", err)
+	}
+
+	return nil
+}
+
+// order items keep no variant as NULL, the column references product_variants
+func nullableID(id int) any {
+	if id == 0 {
+		return nil
+	}
+	return id
+}
+
+func scanCartItems(rows *sql.Rows) ([]types.CartItem, error) {
+	items := []types.CartItem{}
 	for rows.Next() {
-		var order types.Order
+		var item types.CartItem
 		err := rows.Scan(
-			&order.ID,
-			&order.UserID,
-			&order.Total,
-			&order.Status,
-			&order.Address,
-			&order.CreatedAt,
+			&item.CartID,
+			&item.ProductID,
+			&item.VariantID,
+			&item.Quantity,
+			&item.Price.Currency, // read before the price so its decimals are known
+			&item.Price,
+			&item.AddedAt,
 		)
 		if err != nil {
-			return nil, fmt.Errorf("failed to scan order: %w", err)
+			return nil, fmt.Errorf("failed to scan cart item: %w", err)
 		}
-		orders = append(orders, order)
+		items = append(items, item)
 	}
 
 	if err := rows.Err(); err != nil {
 		return nil, fmt.Errorf("rows iteration error: %w", err)
 	}
 
-	return orders, nil
-}
\ No newline at end of file
+	return items, nil
+}
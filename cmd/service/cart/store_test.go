@@ -0,0 +1,102 @@
+package cart
+
+import (
+	"database/sql"
+	"errors"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/eugenius-watchman/ecom_go_rest_api/types"
+)
+
+// the SQL side of the oversell guard: the row lock, the conditional UPDATE
+// and what a zero row count turns into
+func TestCheckoutTxStock(t *testing.T) {
+	decrementProduct := regexp.QuoteMeta("UPDATE products SET quantity = quantity - ?") + `\s+` +
+		regexp.QuoteMeta("WHERE id = ? AND quantity >= ?")
+	decrementVariant := regexp.QuoteMeta("WHERE v.id = ? AND v.quantity >= ?")
+
+	t.Run("should lock the product row", func(t *testing.T) {
+		db, mock := newMockDB(t)
+		mock.ExpectBegin()
+		mock.ExpectQuery(`FROM products WHERE id = \? AND deletedAt IS NULL\s+FOR UPDATE`).
+			WithArgs(1).
+			WillReturnRows(sqlmock.NewRows([]string{
+				"id", "name", "description", "image", "price", "quantity", "taxClass", "weight", "length", "width", "height", "createdAt",
+			}).AddRow(1, "shirt", "", "", "20.00", 3, "standard", 0, 0, 0, 0, time.Now()))
+		mock.ExpectCommit()
+
+		err := NewStore(db).WithinTx(func(tx types.CheckoutTx) error {
+			product, err := tx.GetProductForUpdate(1)
+			if err != nil {
+				return err
+			}
+			if product.Quantity != 3 {
+				t.Errorf("expected quantity 3, got %d", product.Quantity)
+			}
+			return nil
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	})
+
+	t.Run("should only decrement while enough is left", func(t *testing.T) {
+		db, mock := newMockDB(t)
+		mock.ExpectBegin()
+		mock.ExpectExec(decrementProduct).WithArgs(2, 1, 2).WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectCommit()
+
+		err := NewStore(db).WithinTx(func(tx types.CheckoutTx) error {
+			return tx.DecrementProductQuantity(1, 2)
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	})
+
+	t.Run("should roll back when the guard matches no row", func(t *testing.T) {
+		db, mock := newMockDB(t)
+		mock.ExpectBegin()
+		mock.ExpectExec(decrementProduct).WithArgs(2, 1, 2).WillReturnResult(sqlmock.NewResult(0, 0))
+		mock.ExpectRollback()
+
+		err := NewStore(db).WithinTx(func(tx types.CheckoutTx) error {
+			return tx.DecrementProductQuantity(1, 2)
+		})
+		if !errors.Is(err, ErrInsufficientStock) {
+			t.Fatalf("expected ErrInsufficientStock, got %v", err)
+		}
+	})
+
+	t.Run("should guard variants the same way", func(t *testing.T) {
+		db, mock := newMockDB(t)
+		mock.ExpectBegin()
+		mock.ExpectExec(decrementVariant).WithArgs(1, 1, 4, 1).WillReturnResult(sqlmock.NewResult(0, 0))
+		mock.ExpectRollback()
+
+		err := NewStore(db).WithinTx(func(tx types.CheckoutTx) error {
+			return tx.DecrementVariantQuantity(4, 1)
+		})
+		if !errors.Is(err, ErrInsufficientStock) {
+			t.Fatalf("expected ErrInsufficientStock, got %v", err)
+		}
+	})
+}
+
+// fails the test if a statement the test expected never ran
+func newMockDB(t *testing.T) (*sql.DB, sqlmock.Sqlmock) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		if err := mock.ExpectationsWereMet(); err != nil {
+			t.Error(err)
+		}
+		db.Close()
+	})
+	return db, mock
+}
@@ -0,0 +1,124 @@
+package category
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"slices"
+	"strings"
+
+	"github.com/eugenius-watchman/ecom_go_rest_api/types"
+)
+
+var (
+	ErrCategoryNotFound    = errors.New("category not found")
+	ErrProductNotFound     = errors.New("product not found")
+	ErrCategoryCycle       = errors.New("a category can't be moved inside itself")
+	ErrCategoryHasChildren = errors.New("category has subcategories, move or delete them first")
+	ErrInvalidSlug         = errors.New("a slug is lowercase letters and digits separated by single hyphens")
+)
+
+// SubtreeQuery selects the IDs of a category and everything under it, for
+// filters that take in subcategories ... its one argument is the category ID
+const SubtreeQuery = `
+	WITH RECURSIVE subtree AS (
+		SELECT id FROM categories WHERE id = ?
+		UNION ALL
+		SELECT c.id FROM categories c JOIN subtree ON c.parentId = subtree.id
+	)
+	SELECT id FROM subtree`
+
+var slugPattern = regexp.MustCompile(`^[a-z0-9]+(-[a-z0-9]+)*$`)
+
+// Slugify makes a slug out of a name, "Shirts & Tops" becomes
+// "shirts-tops" ... letters outside a-z are dropped
+func Slugify(name string) string {
+	var b strings.Builder
+	hyphen := false
+	for _, r := range strings.ToLower(name) {
+		switch {
+		case r >= 'a' && r <= 'z' || r >= '0' && r <= '9':
+			if hyphen && b.Len() > 0 {
+				b.WriteByte('-')
+			}
+			b.WriteRune(r)
+			hyphen = false
+		case r == ' ' || r == '-' || r == '_' || r == '&' || r == '/' || r == '.':
+			hyphen = true
+		}
+	}
+	return b.String()
+}
+
+func ValidateSlug(slug string) error {
+	if !slugPattern.MatchString(slug) {
+		return fmt.Errorf("%w: %q", ErrInvalidSlug, slug)
+	}
+	return nil
+}
+
+// BuildTree nests categories under their parents, each level sorted by
+// name
+func BuildTree(categories []types.Category) []types.CategoryNode {
+	children := map[int][]types.Category{}
+	var roots []types.Category
+	for _, c := range categories {
+		if c.ParentID == nil {
+			roots = append(roots, c)
+			continue
+		}
+		children[*c.ParentID] = append(children[*c.ParentID], c)
+	}
+
+	var build func(level []types.Category) []types.CategoryNode
+	build = func(level []types.Category) []types.CategoryNode {
+		slices.SortFunc(level, func(a, b types.Category) int {
+			if c := strings.Compare(a.Name, b.Name); c != 0 {
+				return c
+			}
+			return a.ID - b.ID
+		})
+
+		nodes := make([]types.CategoryNode, 0, len(level))
+		for _, c := range level {
+			nodes = append(nodes, types.CategoryNode{Category: c, Children: build(children[c.ID])})
+		}
+		return nodes
+	}
+
+	return build(roots)
+}
+
+// createsCycle reports whether putting id under parentID would make it its
+// own ancestor, parents holds every category's parent
+func createsCycle(parents map[int]*int, id int, parentID *int) bool {
+	for p := parentID; p != nil; p = parents[*p] {
+		if *p == id {
+			return true
+		}
+	}
+	return false
+}
+
+// longestPaths drops every path that is the start of another, a product in
+// both Apparel and Apparel > Shirts only needs the second
+func longestPaths(paths [][]types.Category) [][]types.Category {
+	kept := [][]types.Category{}
+	for i, path := range paths {
+		covered := false
+		for j, other := range paths {
+			if i != j && len(other) > len(path) && slices.EqualFunc(path, other[:len(path)], sameCategory) {
+				covered = true
+				break
+			}
+		}
+		if !covered {
+			kept = append(kept, path)
+		}
+	}
+	return kept
+}
+
+func sameCategory(a, b types.Category) bool {
+	return a.ID == b.ID
+}
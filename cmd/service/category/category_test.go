@@ -0,0 +1,140 @@
+package category
+
+import (
+	"errors"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/eugenius-watchman/ecom_go_rest_api/types"
+)
+
+func TestSlugify(t *testing.T) {
+	tests := map[string]string{
+		"Shirts":              "shirts",
+		"Shirts & Tops":       "shirts-tops",
+		"  Men's   Shoes  ":   "mens-shoes",
+		"T-Shirts / Polos":    "t-shirts-polos",
+		"Size 42_EU":          "size-42-eu",
+		"Café Crème":          "caf-crme",
+		"--Already-a-slug--":  "already-a-slug",
+		"!!!":                 "",
+		"Kids 3.5 - 7 years.": "kids-3-5-7-years",
+	}
+	for name, want := range tests {
+		if got := Slugify(name); got != want {
+			t.Errorf("Slugify(%q): expected %q, got %q", name, want, got)
+		}
+	}
+}
+
+func TestValidateSlug(t *testing.T) {
+	for _, slug := range []string{"shirts", "t-shirts", "size-42"} {
+		if err := ValidateSlug(slug); err != nil {
+			t.Errorf("%q: unexpected error %v", slug, err)
+		}
+	}
+	for _, slug := range []string{"", "Shirts", "t--shirts", "-shirts", "shirts-", "shirts tops", "café"} {
+		if err := ValidateSlug(slug); !errors.Is(err, ErrInvalidSlug) {
+			t.Errorf("%q: expected ErrInvalidSlug, got %v", slug, err)
+		}
+	}
+}
+
+func TestBuildTree(t *testing.T) {
+	id := func(i int) *int { return &i }
+
+	categories := []types.Category{
+		{ID: 1, Name: "Shoes"},
+		{ID: 2, Name: "Apparel"},
+		{ID: 3, Name: "Shirts", ParentID: id(2)},
+		{ID: 4, Name: "Flannel", ParentID: id(3)},
+		{ID: 5, Name: "Dresses", ParentID: id(2)},
+		{ID: 6, Name: "Boots", ParentID: id(1)},
+	}
+
+	// names indented by depth, in tree order
+	var lines []string
+	var walk func(nodes []types.CategoryNode, depth int)
+	walk = func(nodes []types.CategoryNode, depth int) {
+		for _, node := range nodes {
+			lines = append(lines, strings.Repeat("  ", depth)+node.Name)
+			walk(node.Children, depth+1)
+		}
+	}
+	walk(BuildTree(categories), 0)
+
+	want := []string{
+		"Apparel",
+		"  Dresses",
+		"  Shirts",
+		"    Flannel",
+		"Shoes",
+		"  Boots",
+	}
+	if !slices.Equal(lines, want) {
+		t.Errorf("expected\n%s\ngot\n%s", strings.Join(want, "\n"), strings.Join(lines, "\n"))
+	}
+
+	t.Run("should give leaves an empty list of children", func(t *testing.T) {
+		tree := BuildTree([]types.Category{{ID: 1, Name: "Shoes"}})
+		if tree[0].Children == nil || len(tree[0].Children) != 0 {
+			t.Errorf("expected no children, got %v", tree[0].Children)
+		}
+		if tree := BuildTree(nil); tree == nil {
+			t.Error("expected an empty tree, got nil")
+		}
+	})
+}
+
+func TestCreatesCycle(t *testing.T) {
+	id := func(i int) *int { return &i }
+
+	// 1 > 2 > 3, and 4 on its own
+	parents := map[int]*int{1: nil, 2: id(1), 3: id(2), 4: nil}
+
+	tests := []struct {
+		name   string
+		id     int
+		parent *int
+		want   bool
+	}{
+		{"to the top level", 3, nil, false},
+		{"under an unrelated category", 1, id(4), false},
+		{"a subtree under its sibling", 4, id(3), false},
+		{"under itself", 2, id(2), true},
+		{"under its child", 2, id(3), true},
+		{"under its grandchild", 1, id(3), true},
+	}
+	for _, tt := range tests {
+		if got := createsCycle(parents, tt.id, tt.parent); got != tt.want {
+			t.Errorf("%s: expected %v, got %v", tt.name, tt.want, got)
+		}
+	}
+}
+
+func TestLongestPaths(t *testing.T) {
+	apparel := types.Category{ID: 1, Name: "Apparel"}
+	shirts := types.Category{ID: 2, Name: "Shirts"}
+	flannel := types.Category{ID: 3, Name: "Flannel"}
+	sale := types.Category{ID: 4, Name: "Sale"}
+
+	paths := longestPaths([][]types.Category{
+		{apparel},
+		{apparel, shirts, flannel},
+		{apparel, shirts},
+		{sale},
+	})
+
+	var got []string
+	for _, path := range paths {
+		var names []string
+		for _, c := range path {
+			names = append(names, c.Name)
+		}
+		got = append(got, strings.Join(names, " > "))
+	}
+	if want := []string{"Apparel > Shirts > Flannel", "Sale"}; !slices.Equal(got, want) {
+		t.Errorf("expected %v, got %v", want, got)
+	}
+}
@@ -0,0 +1,253 @@
+package category
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/eugenius-watchman/ecom_go_rest_api/cmd/service/auth"
+	"github.com/eugenius-watchman/ecom_go_rest_api/types"
+	"github.com/eugenius-watchman/ecom_go_rest_api/utils"
+	"github.com/go-playground/validator/v10"
+	"github.com/gorilla/mux"
+)
+
+type Handler struct {
+	store     types.CategoryStore
+	userStore types.UserStore
+}
+
+func NewHandler(store types.CategoryStore, userStore types.UserStore) *Handler {
+	return &Handler{store: store, userStore: userStore}
+}
+
+func (h *Handler) RegisterRoutes(router *mux.Router) {
+	router.HandleFunc("/categories", h.handleGetCategories).Methods("GET")
+
+	// admin only
+	router.HandleFunc("/categories", auth.WithRole(h.handleCreateCategory, h.userStore, types.RoleAdmin)).Methods("POST")
+	router.HandleFunc("/categories/{id:[0-9]+}", auth.WithRole(h.handleUpdateCategory, h.userStore, types.RoleAdmin)).Methods("PUT")
+	router.HandleFunc("/categories/{id:[0-9]+}", auth.WithRole(h.handleDeleteCategory, h.userStore, types.RoleAdmin)).Methods("DELETE")
+	router.HandleFunc("/categories/{id:[0-9]+}/move", auth.WithRole(h.handleMoveCategory, h.userStore, types.RoleAdmin)).Methods("POST")
+	router.HandleFunc("/products/{id:[0-9]+}/categories", auth.WithRole(h.handleSetProductCategories, h.userStore, types.RoleAdmin)).Methods("PUT")
+}
+
+// the whole taxonomy as a tree
+func (h *Handler) handleGetCategories(w http.ResponseWriter, r *http.Request) {
+	categories, err := h.store.GetCategories()
+	if err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	utils.WriteJSON(w, http.StatusOK, BuildTree(categories))
+}
+
+func (h *Handler) handleCreateCategory(w http.ResponseWriter, r *http.Request) {
+	payload, ok := parseCategory(w, r)
+	if !ok {
+		return
+	}
+
+	if payload.ParentID != nil {
+		if _, err := h.store.GetCategoryByID(*payload.ParentID); err != nil {
+			if errors.Is(err, ErrCategoryNotFound) {
+				utils.WriteError(w, http.StatusBadRequest, fmt.Errorf("parent category %d not found", *payload.ParentID))
+				return
+			}
+			utils.WriteError(w, http.StatusInternalServerError, err)
+			return
+		}
+	}
+
+	// slugs are unique
+	if _, err := h.store.GetCategoryBySlug(payload.Slug); err == nil {
+		utils.WriteError(w, http.StatusConflict, fmt.Errorf("category slug %s already exists", payload.Slug))
+		return
+	}
+
+	id, err := h.store.CreateCategory(types.Category{Name: payload.Name, Slug: payload.Slug, ParentID: payload.ParentID})
+	if err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	created, err := h.store.GetCategoryByID(id)
+	if err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	utils.WriteJSON(w, http.StatusCreated, created)
+}
+
+// renames a category ... its place in the tree only changes through move
+func (h *Handler) handleUpdateCategory(w http.ResponseWriter, r *http.Request) {
+	id, ok := pathID(w, r, "category")
+	if !ok {
+		return
+	}
+
+	payload, ok := parseCategory(w, r)
+	if !ok {
+		return
+	}
+
+	existing, err := h.store.GetCategoryByID(id)
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+
+	if other, err := h.store.GetCategoryBySlug(payload.Slug); err == nil && other.ID != id {
+		utils.WriteError(w, http.StatusConflict, fmt.Errorf("category slug %s already exists", payload.Slug))
+		return
+	}
+
+	existing.Name = payload.Name
+	existing.Slug = payload.Slug
+	if err := h.store.UpdateCategory(*existing); err != nil {
+		writeStoreError(w, err)
+		return
+	}
+
+	utils.WriteJSON(w, http.StatusOK, existing)
+}
+
+func (h *Handler) handleDeleteCategory(w http.ResponseWriter, r *http.Request) {
+	id, ok := pathID(w, r, "category")
+	if !ok {
+		return
+	}
+
+	if err := h.store.DeleteCategory(id); err != nil {
+		writeStoreError(w, err)
+		return
+	}
+
+	utils.WriteJSON(w, http.StatusOK, map[string]string{"message": "category deleted"})
+}
+
+// moves a category and its whole subtree under another parent, or to the
+// top level
+func (h *Handler) handleMoveCategory(w http.ResponseWriter, r *http.Request) {
+	id, ok := pathID(w, r, "category")
+	if !ok {
+		return
+	}
+
+	var payload types.MoveCategoryPayload
+	if err := utils.ParseJSON(r, &payload); err != nil {
+		utils.WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	if err := utils.Validate.Struct(payload); err != nil {
+		errors := err.(validator.ValidationErrors)
+		utils.WriteError(w, http.StatusBadRequest, fmt.Errorf("invalid payload %w", errors))
+		return
+	}
+
+	if err := h.store.MoveCategory(id, payload.ParentID); err != nil {
+		writeStoreError(w, err)
+		return
+	}
+
+	moved, err := h.store.GetCategoryByID(id)
+	if err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	utils.WriteJSON(w, http.StatusOK, moved)
+}
+
+// replaces the categories a product is listed in
+func (h *Handler) handleSetProductCategories(w http.ResponseWriter, r *http.Request) {
+	productID, ok := pathID(w, r, "product")
+	if !ok {
+		return
+	}
+
+	var payload types.ProductCategoriesPayload
+	if err := utils.ParseJSON(r, &payload); err != nil {
+		utils.WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	if err := utils.Validate.Struct(payload); err != nil {
+		errors := err.(validator.ValidationErrors)
+		utils.WriteError(w, http.StatusBadRequest, fmt.Errorf("invalid payload %w", errors))
+		return
+	}
+
+	if err := h.store.SetProductCategories(productID, payload.CategoryIDs); err != nil {
+		// the product is the resource here, a bad category is a bad payload
+		if errors.Is(err, ErrCategoryNotFound) {
+			utils.WriteError(w, http.StatusBadRequest, err)
+			return
+		}
+		writeStoreError(w, err)
+		return
+	}
+
+	breadcrumbs, err := h.store.GetProductBreadcrumbs(productID)
+	if err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	utils.WriteJSON(w, http.StatusOK, map[string]any{
+		"productId":   productID,
+		"breadcrumbs": breadcrumbs,
+	})
+}
+
+func pathID(w http.ResponseWriter, r *http.Request, what string) (int, bool) {
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		utils.WriteError(w, http.StatusBadRequest, fmt.Errorf("invalid %s ID", what))
+		return 0, false
+	}
+
+	return id, true
+}
+
+// parses and validates the payload, filling in the slug when it's left out
+func parseCategory(w http.ResponseWriter, r *http.Request) (types.CategoryPayload, bool) {
+	var payload types.CategoryPayload
+	if err := utils.ParseJSON(r, &payload); err != nil {
+		utils.WriteError(w, http.StatusBadRequest, err)
+		return payload, false
+	}
+
+	if err := utils.Validate.Struct(payload); err != nil {
+		errors := err.(validator.ValidationErrors)
+		utils.WriteError(w, http.StatusBadRequest, fmt.Errorf("invalid payload %w", errors))
+		return payload, false
+	}
+
+	if payload.Slug == "" {
+		payload.Slug = Slugify(payload.Name)
+	}
+	if err := ValidateSlug(payload.Slug); err != nil {
+		utils.WriteError(w, http.StatusBadRequest, err)
+		return payload, false
+	}
+
+	return payload, true
+}
+
+func writeStoreError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, ErrCategoryNotFound):
+		utils.WriteError(w, http.StatusNotFound, err)
+	case errors.Is(err, ErrProductNotFound):
+		utils.WriteError(w, http.StatusNotFound, err)
+	case errors.Is(err, ErrCategoryCycle), errors.Is(err, ErrCategoryHasChildren):
+		utils.WriteError(w, http.StatusConflict, err)
+	default:
+		utils.WriteError(w, http.StatusInternalServerError, err)
+	}
+}
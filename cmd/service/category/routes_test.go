@@ -0,0 +1,289 @@
+package category
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"testing"
+
+	"github.com/eugenius-watchman/ecom_go_rest_api/cmd/service/auth/authtest"
+	"github.com/eugenius-watchman/ecom_go_rest_api/types"
+	"github.com/gorilla/mux"
+)
+
+func TestCategoryServiceHandlers(t *testing.T) {
+	store := &mockCategoryStore{categories: map[int]types.Category{}, products: map[int][]int{1: nil}}
+	handler := NewHandler(store, userStore)
+
+	router := mux.NewRouter()
+	handler.RegisterRoutes(router)
+
+	send := func(method, path string, userID int, payload any) *httptest.ResponseRecorder {
+		return authtest.Serve(t, router, method, path, userID, payload)
+	}
+
+	create := func(payload types.CategoryPayload) types.Category {
+		rr := send(http.MethodPost, "/categories", 1, payload)
+		if rr.Code != http.StatusCreated {
+			t.Fatalf("expected status code %d, got %d: %s", http.StatusCreated, rr.Code, rr.Body)
+		}
+		var c types.Category
+		json.NewDecoder(rr.Body).Decode(&c)
+		return c
+	}
+
+	t.Run("should forbid customers from managing categories", func(t *testing.T) {
+		payload := types.CategoryPayload{Name: "Apparel"}
+		if rr := send(http.MethodPost, "/categories", 0, payload); rr.Code != http.StatusUnauthorized {
+			t.Errorf("expected status code %d, got %d", http.StatusUnauthorized, rr.Code)
+		}
+		if rr := send(http.MethodPost, "/categories", 2, payload); rr.Code != http.StatusForbidden {
+			t.Errorf("expected status code %d, got %d", http.StatusForbidden, rr.Code)
+		}
+		if rr := send(http.MethodPut, "/products/1/categories", 2, types.ProductCategoriesPayload{CategoryIDs: []int{1}}); rr.Code != http.StatusForbidden {
+			t.Errorf("expected status code %d, got %d", http.StatusForbidden, rr.Code)
+		}
+	})
+
+	apparel := create(types.CategoryPayload{Name: "Apparel"})
+	shirts := create(types.CategoryPayload{Name: "Shirts & Tops", ParentID: &apparel.ID})
+	flannel := create(types.CategoryPayload{Name: "Flannel", Slug: "flannel-shirts", ParentID: &shirts.ID})
+	shoes := create(types.CategoryPayload{Name: "Shoes"})
+
+	t.Run("should make the slug from the name", func(t *testing.T) {
+		if shirts.Slug != "shirts-tops" || flannel.Slug != "flannel-shirts" {
+			t.Errorf("unexpected slugs %q and %q", shirts.Slug, flannel.Slug)
+		}
+		if shirts.ParentID == nil || *shirts.ParentID != apparel.ID {
+			t.Errorf("expected parent %d, got %v", apparel.ID, shirts.ParentID)
+		}
+	})
+
+	t.Run("should reject bad categories", func(t *testing.T) {
+		missing := 99
+		tests := map[string]struct {
+			payload types.CategoryPayload
+			code    int
+		}{
+			"no name":         {types.CategoryPayload{}, http.StatusBadRequest},
+			"bad slug":        {types.CategoryPayload{Name: "Hats", Slug: "Hats!"}, http.StatusBadRequest},
+			"no usable slug":  {types.CategoryPayload{Name: "!!!"}, http.StatusBadRequest},
+			"unknown parent":  {types.CategoryPayload{Name: "Hats", ParentID: &missing}, http.StatusBadRequest},
+			"duplicate slug":  {types.CategoryPayload{Name: "Shoes"}, http.StatusConflict},
+			"slug of another": {types.CategoryPayload{Name: "Hats", Slug: "apparel"}, http.StatusConflict},
+		}
+		for name, tt := range tests {
+			if rr := send(http.MethodPost, "/categories", 1, tt.payload); rr.Code != tt.code {
+				t.Errorf("%s: expected status code %d, got %d", name, tt.code, rr.Code)
+			}
+		}
+	})
+
+	t.Run("should return the tree without a token", func(t *testing.T) {
+		rr := send(http.MethodGet, "/categories", 0, nil)
+		if rr.Code != http.StatusOK {
+			t.Fatalf("expected status code %d, got %d", http.StatusOK, rr.Code)
+		}
+
+		var tree []types.CategoryNode
+		json.NewDecoder(rr.Body).Decode(&tree)
+		if len(tree) != 2 || tree[0].Name != "Apparel" || tree[1].Name != "Shoes" {
+			t.Fatalf("unexpected top level %+v", tree)
+		}
+		if got := tree[0].Children; len(got) != 1 || got[0].ID != shirts.ID || len(got[0].Children) != 1 || got[0].Children[0].ID != flannel.ID {
+			t.Errorf("unexpected subtree %+v", got)
+		}
+	})
+
+	t.Run("should rename a category in place", func(t *testing.T) {
+		rr := send(http.MethodPut, fmt.Sprintf("/categories/%d", shirts.ID), 1, types.CategoryPayload{Name: "Shirts"})
+		if rr.Code != http.StatusOK {
+			t.Fatalf("expected status code %d, got %d: %s", http.StatusOK, rr.Code, rr.Body)
+		}
+		got := store.categories[shirts.ID]
+		if got.Name != "Shirts" || got.Slug != "shirts" || got.ParentID == nil || *got.ParentID != apparel.ID {
+			t.Errorf("unexpected category %+v", got)
+		}
+
+		if rr := send(http.MethodPut, fmt.Sprintf("/categories/%d", shirts.ID), 1, types.CategoryPayload{Name: "Shoes"}); rr.Code != http.StatusConflict {
+			t.Errorf("expected status code %d, got %d", http.StatusConflict, rr.Code)
+		}
+		if rr := send(http.MethodPut, "/categories/99", 1, types.CategoryPayload{Name: "Hats"}); rr.Code != http.StatusNotFound {
+			t.Errorf("expected status code %d, got %d", http.StatusNotFound, rr.Code)
+		}
+	})
+
+	t.Run("should move a subtree", func(t *testing.T) {
+		rr := send(http.MethodPost, fmt.Sprintf("/categories/%d/move", shirts.ID), 1, types.MoveCategoryPayload{ParentID: &shoes.ID})
+		if rr.Code != http.StatusOK {
+			t.Fatalf("expected status code %d, got %d: %s", http.StatusOK, rr.Code, rr.Body)
+		}
+		if got := store.categories[shirts.ID].ParentID; got == nil || *got != shoes.ID {
+			t.Errorf("expected parent %d, got %v", shoes.ID, got)
+		}
+		// flannel comes along
+		if got := store.categories[flannel.ID].ParentID; got == nil || *got != shirts.ID {
+			t.Errorf("expected flannel to stay under shirts, got %v", got)
+		}
+
+		rr = send(http.MethodPost, fmt.Sprintf("/categories/%d/move", shirts.ID), 1, types.MoveCategoryPayload{})
+		if rr.Code != http.StatusOK {
+			t.Fatalf("expected status code %d, got %d", http.StatusOK, rr.Code)
+		}
+		if got := store.categories[shirts.ID].ParentID; got != nil {
+			t.Errorf("expected a top level category, got parent %d", *got)
+		}
+	})
+
+	t.Run("should not move a category inside itself", func(t *testing.T) {
+		for _, parent := range []int{shirts.ID, flannel.ID} {
+			rr := send(http.MethodPost, fmt.Sprintf("/categories/%d/move", shirts.ID), 1, types.MoveCategoryPayload{ParentID: &parent})
+			if rr.Code != http.StatusConflict {
+				t.Errorf("under %d: expected status code %d, got %d", parent, http.StatusConflict, rr.Code)
+			}
+		}
+		if rr := send(http.MethodPost, "/categories/99/move", 1, types.MoveCategoryPayload{}); rr.Code != http.StatusNotFound {
+			t.Errorf("expected status code %d, got %d", http.StatusNotFound, rr.Code)
+		}
+	})
+
+	t.Run("should set the categories of a product", func(t *testing.T) {
+		rr := send(http.MethodPut, "/products/1/categories", 1, types.ProductCategoriesPayload{CategoryIDs: []int{flannel.ID, shoes.ID}})
+		if rr.Code != http.StatusOK {
+			t.Fatalf("expected status code %d, got %d: %s", http.StatusOK, rr.Code, rr.Body)
+		}
+		if got := store.products[1]; !slices.Equal(got, []int{flannel.ID, shoes.ID}) {
+			t.Errorf("expected %v, got %v", []int{flannel.ID, shoes.ID}, got)
+		}
+
+		tests := map[string]struct {
+			path    string
+			payload types.ProductCategoriesPayload
+			code    int
+		}{
+			"no categories":    {"/products/1/categories", types.ProductCategoriesPayload{}, http.StatusBadRequest},
+			"unknown category": {"/products/1/categories", types.ProductCategoriesPayload{CategoryIDs: []int{99}}, http.StatusBadRequest},
+			"unknown product":  {"/products/99/categories", types.ProductCategoriesPayload{CategoryIDs: []int{shoes.ID}}, http.StatusNotFound},
+		}
+		for name, tt := range tests {
+			if rr := send(http.MethodPut, tt.path, 1, tt.payload); rr.Code != tt.code {
+				t.Errorf("%s: expected status code %d, got %d", name, tt.code, rr.Code)
+			}
+		}
+	})
+
+	t.Run("should only delete categories without subcategories", func(t *testing.T) {
+		if rr := send(http.MethodDelete, fmt.Sprintf("/categories/%d", shirts.ID), 1, nil); rr.Code != http.StatusConflict {
+			t.Errorf("expected status code %d, got %d", http.StatusConflict, rr.Code)
+		}
+		if rr := send(http.MethodDelete, fmt.Sprintf("/categories/%d", flannel.ID), 1, nil); rr.Code != http.StatusOK {
+			t.Errorf("expected status code %d, got %d", http.StatusOK, rr.Code)
+		}
+		if rr := send(http.MethodDelete, fmt.Sprintf("/categories/%d", flannel.ID), 1, nil); rr.Code != http.StatusNotFound {
+			t.Errorf("expected status code %d, got %d", http.StatusNotFound, rr.Code)
+		}
+	})
+}
+
+// keeps the tree in memory and checks moves like the store
+type mockCategoryStore struct {
+	categories map[int]types.Category
+	// category IDs of each product
+	products map[int][]int
+	nextID   int
+}
+
+func (m *mockCategoryStore) GetCategories() ([]types.Category, error) {
+	categories := []types.Category{}
+	for _, c := range m.categories {
+		categories = append(categories, c)
+	}
+	return categories, nil
+}
+
+func (m *mockCategoryStore) GetCategoryByID(id int) (*types.Category, error) {
+	c, ok := m.categories[id]
+	if !ok {
+		return nil, ErrCategoryNotFound
+	}
+	return &c, nil
+}
+
+func (m *mockCategoryStore) GetCategoryBySlug(slug string) (*types.Category, error) {
+	for _, c := range m.categories {
+		if c.Slug == slug {
+			return &c, nil
+		}
+	}
+	return nil, ErrCategoryNotFound
+}
+
+func (m *mockCategoryStore) CreateCategory(c types.Category) (int, error) {
+	m.nextID++
+	c.ID = m.nextID
+	m.categories[c.ID] = c
+	return c.ID, nil
+}
+
+func (m *mockCategoryStore) UpdateCategory(c types.Category) error {
+	m.categories[c.ID] = c
+	return nil
+}
+
+func (m *mockCategoryStore) MoveCategory(id int, parentID *int) error {
+	c, ok := m.categories[id]
+	if !ok {
+		return ErrCategoryNotFound
+	}
+	if parentID != nil {
+		if _, ok := m.categories[*parentID]; !ok {
+			return ErrCategoryNotFound
+		}
+	}
+
+	parents := map[int]*int{}
+	for _, c := range m.categories {
+		parents[c.ID] = c.ParentID
+	}
+	if createsCycle(parents, id, parentID) {
+		return ErrCategoryCycle
+	}
+
+	c.ParentID = parentID
+	m.categories[id] = c
+	return nil
+}
+
+func (m *mockCategoryStore) DeleteCategory(id int) error {
+	if _, ok := m.categories[id]; !ok {
+		return ErrCategoryNotFound
+	}
+	for _, c := range m.categories {
+		if c.ParentID != nil && *c.ParentID == id {
+			return ErrCategoryHasChildren
+		}
+	}
+	delete(m.categories, id)
+	return nil
+}
+
+func (m *mockCategoryStore) GetProductBreadcrumbs(productID int) ([][]types.Category, error) {
+	return [][]types.Category{}, nil
+}
+
+func (m *mockCategoryStore) SetProductCategories(productID int, categoryIDs []int) error {
+	if _, ok := m.products[productID]; !ok {
+		return ErrProductNotFound
+	}
+	for _, id := range categoryIDs {
+		if _, ok := m.categories[id]; !ok {
+			return ErrCategoryNotFound
+		}
+	}
+	m.products[productID] = categoryIDs
+	return nil
+}
+
+var userStore = &authtest.UserStore{Roles: map[int]string{1: types.RoleAdmin, 2: types.RoleCustomer}}
@@ -0,0 +1,313 @@
+package category
+
+import (
+	"database/sql"
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/eugenius-watchman/ecom_go_rest_api/types"
+)
+
+type Store struct {
+	db *sql.DB
+}
+
+func NewStore(db *sql.DB) *Store {
+	return &Store{db: db}
+}
+
+const selectCategory = "SELECT id, name, slug, parentId, createdAt FROM categories"
+
+func (s *Store) GetCategories() ([]types.Category, error) {
+	rows, err := s.db.Query(selectCategory + " ORDER BY name, id")
+	if err != nil {
+		return nil, fmt.Errorf("failed to query categories: %w", err)
+	}
+	defer rows.Close()
+
+	categories := []types.Category{}
+	for rows.Next() {
+		c, err := scanRowIntoCategory(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan category: %w", err)
+		}
+		categories = append(categories, *c)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("rows iteration error: %w", err)
+	}
+
+	return categories, nil
+}
+
+func (s *Store) GetCategoryByID(id int) (*types.Category, error) {
+	return s.getCategory(selectCategory+" WHERE id = ?", id)
+}
+
+func (s *Store) GetCategoryBySlug(slug string) (*types.Category, error) {
+	return s.getCategory(selectCategory+" WHERE slug = ?", slug)
+}
+
+func (s *Store) CreateCategory(c types.Category) (int, error) {
+	result, err := s.db.Exec("INSERT INTO categories (name, slug, parentId) VALUES (?, ?, ?)", c.Name, c.Slug, c.ParentID)
+	if err != nil {
+		return 0, fmt.Errorf("failed to create category: %w", err)
+	}
+
+	id, err := result.LastInsertId()
+	if err != nil {
+		return 0, fmt.Errorf("failed to get category ID: %w", err)
+	}
+
+	return int(id), nil
+}
+
+func (s *Store) UpdateCategory(c types.Category) error {
+	if _, err := s.db.Exec("UPDATE categories SET name = ?, slug = ? WHERE id = ?", c.Name, c.Slug, c.ID); err != nil {
+		return fmt.Errorf("failed to update category: %w", err)
+	}
+
+	return nil
+}
+
+// the taxonomy is small, so a move locks all of it ... two moves that
+// would make a loop together can't both pass the check
+func (s *Store) MoveCategory(id int, parentID *int) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	rows, err := tx.Query("SELECT id, parentId FROM categories FOR UPDATE")
+	if err != nil {
+		return fmt.Errorf("failed to lock categories: %w", err)
+	}
+	defer rows.Close()
+
+	parents := map[int]*int{}
+	for rows.Next() {
+		var categoryID int
+		var parent sql.NullInt64
+		if err := rows.Scan(&categoryID, &parent); err != nil {
+			return fmt.Errorf("failed to scan category: %w", err)
+		}
+		parents[categoryID] = nil
+		if parent.Valid {
+			p := int(parent.Int64)
+			parents[categoryID] = &p
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("rows iteration error: %w", err)
+	}
+	rows.Close()
+
+	if _, ok := parents[id]; !ok {
+		return ErrCategoryNotFound
+	}
+	if parentID != nil {
+		if _, ok := parents[*parentID]; !ok {
+			return fmt.Errorf("%w: parent %d", ErrCategoryNotFound, *parentID)
+		}
+	}
+	if createsCycle(parents, id, parentID) {
+		return ErrCategoryCycle
+	}
+
+	// the subtree hangs off this one row, so it moves with it
+	if _, err := tx.Exec("UPDATE categories SET parentId = ? WHERE id = ?", parentID, id); err != nil {
+		return fmt.Errorf("failed to move category: %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+
+	return nil
+}
+
+func (s *Store) DeleteCategory(id int) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	if err := lockRow(tx, "SELECT id FROM categories WHERE id = ? FOR UPDATE", id); err != nil {
+		if err == sql.ErrNoRows {
+			return ErrCategoryNotFound
+		}
+		return fmt.Errorf("failed to get category: %w", err)
+	}
+
+	// locks the children too, so none can be moved under it meanwhile
+	var children int
+	if err := tx.QueryRow("SELECT COUNT(*) FROM categories WHERE parentId = ? FOR UPDATE", id).Scan(&children); err != nil {
+		return fmt.Errorf("failed to count subcategories: %w", err)
+	}
+	if children > 0 {
+		return ErrCategoryHasChildren
+	}
+
+	// product and promotion links go with it
+	if _, err := tx.Exec("DELETE FROM categories WHERE id = ?", id); err != nil {
+		return fmt.Errorf("failed to delete category: %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+
+	return nil
+}
+
+// walks up from each of the product's categories to the top level
+func (s *Store) GetProductBreadcrumbs(productID int) ([][]types.Category, error) {
+	const query = `
+		WITH RECURSIVE path AS (
+			SELECT pc.categoryId AS leaf, c.id, c.name, c.slug, c.parentId, c.createdAt, 0 AS depth
+			FROM product_categories pc
+			JOIN categories c ON c.id = pc.categoryId
+			WHERE pc.productId = ?
+			UNION ALL
+			SELECT path.leaf, c.id, c.name, c.slug, c.parentId, c.createdAt, path.depth + 1
+			FROM path
+			JOIN categories c ON c.id = path.parentId
+		)
+		SELECT leaf, id, name, slug, parentId, createdAt FROM path
+		ORDER BY leaf, depth DESC`
+
+	rows, err := s.db.Query(query, productID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query breadcrumbs: %w", err)
+	}
+	defer rows.Close()
+
+	var paths [][]types.Category
+	last := 0
+	for rows.Next() {
+		var leaf int
+		var c types.Category
+		var parent sql.NullInt64
+		if err := rows.Scan(&leaf, &c.ID, &c.Name, &c.Slug, &parent, &c.CreatedAt); err != nil {
+			return nil, fmt.Errorf("failed to scan breadcrumb: %w", err)
+		}
+		if parent.Valid {
+			p := int(parent.Int64)
+			c.ParentID = &p
+		}
+
+		if leaf != last || len(paths) == 0 {
+			paths = append(paths, nil)
+			last = leaf
+		}
+		paths[len(paths)-1] = append(paths[len(paths)-1], c)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("rows iteration error: %w", err)
+	}
+
+	paths = longestPaths(paths)
+	slices.SortFunc(paths, func(a, b []types.Category) int {
+		return strings.Compare(pathNames(a), pathNames(b))
+	})
+
+	return paths, nil
+}
+
+func pathNames(path []types.Category) string {
+	names := make([]string, len(path))
+	for i, c := range path {
+		names[i] = c.Name
+	}
+	return strings.Join(names, "\x00")
+}
+
+func (s *Store) SetProductCategories(productID int, categoryIDs []int) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	if err := lockRow(tx, "SELECT id FROM products WHERE id = ? FOR UPDATE", productID); err != nil {
+		if err == sql.ErrNoRows {
+			return ErrProductNotFound
+		}
+		return fmt.Errorf("failed to get product: %w", err)
+	}
+
+	ids := slices.Compact(slices.Sorted(slices.Values(categoryIDs)))
+	if len(ids) > 0 {
+		args := make([]any, len(ids))
+		for i, id := range ids {
+			args[i] = id
+		}
+
+		// lock them so none is deleted before the links are in
+		query := fmt.Sprintf("SELECT COUNT(*) FROM categories WHERE id IN (?%s) LOCK IN SHARE MODE", strings.Repeat(", ?", len(ids)-1))
+		var found int
+		if err := tx.QueryRow(query, args...).Scan(&found); err != nil {
+			return fmt.Errorf("failed to get categories: %w", err)
+		}
+		if found != len(ids) {
+			return ErrCategoryNotFound
+		}
+	}
+
+	if _, err := tx.Exec("DELETE FROM product_categories WHERE productId = ?", productID); err != nil {
+		return fmt.Errorf("failed to clear product categories: %w", err)
+	}
+
+	for _, id := range ids {
+		if _, err := tx.Exec("INSERT INTO product_categories (productId, categoryId) VALUES (?, ?)", productID, id); err != nil {
+			return fmt.Errorf("failed to add product category: %w", err)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+
+	return nil
+}
+
+func (s *Store) getCategory(query string, args ...any) (*types.Category, error) {
+	c, err := scanRowIntoCategory(s.db.QueryRow(query, args...))
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, ErrCategoryNotFound
+		}
+		return nil, fmt.Errorf("failed to get category: %w", err)
+	}
+
+	return c, nil
+}
+
+func lockRow(tx *sql.Tx, query string, id int) error {
+	var locked int
+	return tx.QueryRow(query, id).Scan(&locked)
+}
+
+// scanner is satisfied by both *sql.Row and *sql.Rows
+type scanner interface {
+	Scan(dest ...any) error
+}
+
+func scanRowIntoCategory(row scanner) (*types.Category, error) {
+	var c types.Category
+	var parent sql.NullInt64
+	if err := row.Scan(&c.ID, &c.Name, &c.Slug, &parent, &c.CreatedAt); err != nil {
+		return nil, err
+	}
+	if parent.Valid {
+		p := int(parent.Int64)
+		c.ParentID = &p
+	}
+
+	return &c, nil
+}
@@ -0,0 +1,226 @@
+package currency
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/eugenius-watchman/ecom_go_rest_api/config"
+	"github.com/eugenius-watchman/ecom_go_rest_api/types"
+)
+
+const (
+	QueryParam = "currency"
+	HeaderKey  = "X-Currency"
+)
+
+// returned for a currency with neither explicit prices nor an exchange rate
+var ErrUnsupportedCurrency = errors.New("unsupported currency")
+
+// FromRequest returns the currency asked for with ?currency= or the
+// X-Currency header, falling back to the default currency
+func FromRequest(r *http.Request) string {
+	currency := r.URL.Query().Get(QueryParam)
+	if currency == "" {
+		currency = r.Header.Get(HeaderKey)
+	}
+
+	if currency == "" {
+		return config.Envs.DefaultCurrency
+	}
+
+	return strings.ToUpper(strings.TrimSpace(currency))
+}
+
+// IsCode reports whether currency looks like an ISO 4217 code
+func IsCode(currency string) bool {
+	if len(currency) != 3 {
+		return false
+	}
+
+	for _, c := range currency {
+		if c < 'A' || c > 'Z' {
+			return false
+		}
+	}
+
+	return true
+}
+
+// Converter implements types.ProductPricer. Product prices are stored in
+// the default currency and optionally per currency in product_prices.
+type Converter struct {
+	products types.ProductStore
+	rates    types.ExchangeRateStore
+}
+
+func NewConverter(products types.ProductStore, rates types.ExchangeRateStore) *Converter {
+	return &Converter{products: products, rates: rates}
+}
+
+func (c *Converter) PriceProducts(products []types.Product, currency string) error {
+	if !IsCode(currency) {
+		return fmt.Errorf("%w: %q", ErrUnsupportedCurrency, currency)
+	}
+
+	if currency == config.Envs.DefaultCurrency {
+		for i := range products {
+			products[i].Price.Currency = currency
+			products[i].Currency = currency
+		}
+		return nil
+	}
+
+	ids := make([]int, 0, len(products))
+	for _, product := range products {
+		ids = append(ids, product.ID)
+	}
+
+	prices, err := c.products.GetProductPrices(ids, currency)
+	if err != nil {
+		return err
+	}
+
+	// the rate is only needed for products without an explicit price
+	var rate *types.ExchangeRate
+	for i := range products {
+		price, ok := prices[products[i].ID]
+		if !ok {
+			if rate == nil {
+				rate, err = c.rates.GetExchangeRate(currency)
+				if err != nil {
+					return err
+				}
+			}
+
+			price, err = Convert(products[i].Price, rate.Rate, currency)
+			if err != nil {
+				return err
+			}
+		}
+
+		products[i].Price = price
+		products[i].Currency = currency
+	}
+
+	return nil
+}
+
+// PriceVariants prices variants without their own price like their
+// product, a variant's own price is converted at the exchange rate
+func (c *Converter) PriceVariants(variants []types.ProductVariant, currency string) error {
+	if !IsCode(currency) {
+		return fmt.Errorf("%w: %q", ErrUnsupportedCurrency, currency)
+	}
+
+	if currency == config.Envs.DefaultCurrency {
+		for i := range variants {
+			variants[i].Price.Currency = currency
+		}
+		return nil
+	}
+
+	var ids []int
+	for _, variant := range variants {
+		if !variant.PriceOverride {
+			ids = append(ids, variant.ProductID)
+		}
+	}
+
+	prices := map[int]types.Money{}
+	if len(ids) > 0 {
+		var err error
+		prices, err = c.products.GetProductPrices(ids, currency)
+		if err != nil {
+			return err
+		}
+	}
+
+	var rate *types.ExchangeRate
+	for i := range variants {
+		price, ok := prices[variants[i].ProductID]
+		if !ok || variants[i].PriceOverride {
+			if rate == nil {
+				var err error
+				rate, err = c.rates.GetExchangeRate(currency)
+				if err != nil {
+					return err
+				}
+			}
+
+			var err error
+			price, err = Convert(variants[i].Price, rate.Rate, currency)
+			if err != nil {
+				return err
+			}
+		}
+
+		variants[i].Price = price
+	}
+
+	return nil
+}
+
+// ConvertAmount puts an amount in the default currency into currency at
+// the current exchange rate
+func (c *Converter) ConvertAmount(amount types.Money, currency string) (types.Money, error) {
+	if amount.Currency == currency {
+		return amount, nil
+	}
+
+	if !IsCode(currency) || amount.Currency != config.Envs.DefaultCurrency {
+		return types.Money{}, fmt.Errorf("%w: %q", ErrUnsupportedCurrency, currency)
+	}
+
+	rate, err := c.rates.GetExchangeRate(currency)
+	if err != nil {
+		return types.Money{}, err
+	}
+
+	return Convert(amount, rate.Rate, currency)
+}
+
+// Convert turns amount into currency at rate (units of currency per unit
+// of amount's currency), rounding half away from zero to the minor unit
+func Convert(amount types.Money, rate string, currency string) (types.Money, error) {
+	numerator, denominator, err := parseRate(rate)
+	if err != nil {
+		return types.Money{}, err
+	}
+
+	// rescale between currencies with a different number of decimals
+	shift := types.MinorUnits(currency) - types.MinorUnits(amount.Currency)
+	for ; shift > 0; shift-- {
+		numerator *= 10
+	}
+	for ; shift < 0; shift++ {
+		denominator *= 10
+	}
+
+	converted := amount.MulRate(numerator, denominator)
+	converted.Currency = currency
+
+	return converted, nil
+}
+
+// parseRate reads a positive decimal exactly as numerator / denominator
+func parseRate(rate string) (int64, int64, error) {
+	whole, fraction, _ := strings.Cut(strings.TrimSpace(rate), ".")
+
+	// DECIMAL(18,8) pads with zeros, they don't change the value
+	fraction = strings.TrimRight(fraction, "0")
+
+	numerator, err := strconv.ParseInt(whole+fraction, 10, 64)
+	if err != nil || numerator <= 0 || strings.HasPrefix(whole, "-") || strings.HasPrefix(whole, "+") {
+		return 0, 0, fmt.Errorf("invalid exchange rate %q", rate)
+	}
+
+	denominator := int64(1)
+	for range fraction {
+		denominator *= 10
+	}
+
+	return numerator, denominator, nil
+}
@@ -0,0 +1,114 @@
+package currency
+
+import (
+	"errors"
+	"fmt"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/eugenius-watchman/ecom_go_rest_api/types"
+)
+
+func TestConvert(t *testing.T) {
+	cases := []struct {
+		amount types.Money
+		rate   string
+		to     string
+		want   types.Money
+	}{
+		{types.NewMoney(1000, "USD"), "0.92150000", "EUR", types.NewMoney(922, "EUR")},  // 9.215
+		{types.NewMoney(1999, "USD"), "0.5", "EUR", types.NewMoney(1000, "EUR")},        // 9.995
+		{types.NewMoney(1999, "USD"), "151.2", "JPY", types.NewMoney(3022, "JPY")},      // 3022.488
+		{types.NewMoney(3022, "JPY"), "0.00661376", "USD", types.NewMoney(1999, "USD")}, // 19.9867
+	}
+	for _, tc := range cases {
+		got, err := Convert(tc.amount, tc.rate, tc.to)
+		if err != nil {
+			t.Errorf("Convert(%v, %s): %v", tc.amount, tc.rate, err)
+			continue
+		}
+		if got != tc.want {
+			t.Errorf("Convert(%v %s, %s) = %v %s, want %v", tc.amount, tc.amount.Currency, tc.rate, got, got.Currency, tc.want)
+		}
+	}
+
+	for _, rate := range []string{"", "0", "-1", "abc", "1.2.3"} {
+		if _, err := Convert(types.NewMoney(100, "USD"), rate, "EUR"); err == nil {
+			t.Errorf("expected rate %q to be rejected", rate)
+		}
+	}
+}
+
+func TestConvertAmount(t *testing.T) {
+	converter := NewConverter(nil, &mockRateStore{rates: map[string]string{"EUR": "0.5"}})
+
+	got, err := converter.ConvertAmount(types.NewMoney(999, "USD"), "EUR")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != types.NewMoney(500, "EUR") {
+		t.Errorf("expected 5.00 EUR, got %v %s", got, got.Currency)
+	}
+
+	if got, _ := converter.ConvertAmount(types.NewMoney(999, "USD"), "USD"); got != types.NewMoney(999, "USD") {
+		t.Errorf("expected the amount back unchanged, got %v", got)
+	}
+
+	if _, err := converter.ConvertAmount(types.NewMoney(999, "USD"), "GBP"); !errors.Is(err, ErrUnsupportedCurrency) {
+		t.Errorf("expected an unsupported currency, got %v", err)
+	}
+}
+
+func TestPriceVariants(t *testing.T) {
+	converter := NewConverter(nil, &mockRateStore{rates: map[string]string{"EUR": "0.5"}})
+
+	variants := []types.ProductVariant{
+		{ID: 1, ProductID: 1, Price: types.NewMoney(1000, "USD"), PriceOverride: true},
+		{ID: 2, ProductID: 1, Price: types.NewMoney(1499, "USD"), PriceOverride: true},
+	}
+	if err := converter.PriceVariants(variants, "EUR"); err != nil {
+		t.Fatal(err)
+	}
+	if variants[0].Price != types.NewMoney(500, "EUR") || variants[1].Price != types.NewMoney(750, "EUR") {
+		t.Errorf("expected 5.00 and 7.50 EUR, got %v and %v", variants[0].Price, variants[1].Price)
+	}
+
+	if err := converter.PriceVariants(variants, "GBP"); !errors.Is(err, ErrUnsupportedCurrency) {
+		t.Errorf("expected an unsupported currency, got %v", err)
+	}
+}
+
+func TestFromRequest(t *testing.T) {
+	req := httptest.NewRequest("GET", "/products?currency=eur", nil)
+	req.Header.Set(HeaderKey, "GBP")
+	if got := FromRequest(req); got != "EUR" {
+		t.Errorf("expected the query parameter to win, got %s", got)
+	}
+
+	req = httptest.NewRequest("GET", "/products", nil)
+	req.Header.Set(HeaderKey, "gbp")
+	if got := FromRequest(req); got != "GBP" {
+		t.Errorf("expected GBP from the header, got %s", got)
+	}
+
+	req = httptest.NewRequest("GET", "/products", nil)
+	if got := FromRequest(req); got != "USD" {
+		t.Errorf("expected the default currency, got %s", got)
+	}
+}
+
+type mockRateStore struct {
+	rates map[string]string
+}
+
+func (m *mockRateStore) GetExchangeRate(code string) (*types.ExchangeRate, error) {
+	rate, ok := m.rates[code]
+	if !ok {
+		return nil, fmt.Errorf("%w: no exchange rate for %s", ErrUnsupportedCurrency, code)
+	}
+	return &types.ExchangeRate{Currency: code, Rate: rate}, nil
+}
+
+func (m *mockRateStore) ReplaceExchangeRates(rates []types.ExchangeRate) error {
+	return nil
+}
@@ -0,0 +1,56 @@
+package currency
+
+import (
+	"database/sql"
+	"fmt"
+
+	"github.com/eugenius-watchman/ecom_go_rest_api/types"
+)
+
+type Store struct {
+	db *sql.DB
+}
+
+func NewStore(db *sql.DB) *Store {
+	return &Store{db: db}
+}
+
+func (s *Store) GetExchangeRate(currency string) (*types.ExchangeRate, error) {
+	var rate types.ExchangeRate
+	err := s.db.QueryRow("SELECT currency, rate, updatedAt FROM exchange_rates WHERE currency = ?", currency).
+		Scan(&rate.Currency, &rate.Rate, &rate.UpdatedAt)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, fmt.Errorf("%w: no exchange rate for %s", ErrUnsupportedCurrency, currency)
+		}
+		return nil, fmt.Errorf("failed to get exchange rate: %w", err)
+	}
+
+	return &rate, nil
+}
+
+func (s *Store) ReplaceExchangeRates(rates []types.ExchangeRate) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	// DELETE rather than TRUNCATE, which would commit implicitly
+	if _, err := tx.Exec("DELETE FROM exchange_rates"); err != nil {
+		return fmt.Errorf("failed to clear exchange rates: %w", err)
+	}
+
+	for _, rate := range rates {
+		_, err := tx.Exec("INSERT INTO exchange_rates (currency, rate) VALUES (?, ?)", rate.Currency, rate.Rate)
+		if err != nil {
+			return fmt.Errorf("failed to insert exchange rate for %s: %w", rate.Currency, err)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+
+	return nil
+}
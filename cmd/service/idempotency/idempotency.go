@@ -0,0 +1,176 @@
+package idempotency
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/eugenius-watchman/ecom_go_rest_api/cmd/service/auth"
+	"github.com/eugenius-watchman/ecom_go_rest_api/cmd/service/currency"
+	"github.com/eugenius-watchman/ecom_go_rest_api/config"
+	"github.com/eugenius-watchman/ecom_go_rest_api/types"
+	"github.com/eugenius-watchman/ecom_go_rest_api/utils"
+)
+
+const (
+	HeaderKey      = "Idempotency-Key"
+	HeaderReplayed = "Idempotent-Replayed"
+
+	maxKeyLength = 255
+)
+
+// WithIdempotencyKey replays the stored response when a request is retried
+// with the same Idempotency-Key. Requests without the header pass straight
+// through. It must run inside auth.WithJWTAuth since keys are per user.
+func WithIdempotencyKey(handlerFunc http.HandlerFunc, store types.IdempotencyStore) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		key := r.Header.Get(HeaderKey)
+		if key == "" {
+			handlerFunc(w, r)
+			return
+		}
+
+		if len(key) > maxKeyLength {
+			utils.WriteError(w, http.StatusBadRequest, fmt.Errorf("idempotency key must be at most %d characters", maxKeyLength))
+			return
+		}
+
+		userID := auth.GetUserIDFromContext(r.Context())
+
+		// read the body for hashing and hand the handler a fresh copy
+		var body []byte
+		if r.Body != nil {
+			var err error
+			body, err = io.ReadAll(r.Body)
+			if err != nil {
+				utils.WriteError(w, http.StatusBadRequest, err)
+				return
+			}
+		}
+		r.Body = io.NopCloser(bytes.NewReader(body))
+
+		ttl := time.Second * time.Duration(config.Envs.IdempotencyKeyTTLInSeconds)
+		existing, reserved, err := store.ReserveIdempotencyKey(types.IdempotencyRecord{
+			UserID:      userID,
+			Key:         key,
+			RequestHash: hashRequest(r, body),
+			ExpiresAt:   time.Now().Add(ttl),
+		})
+		if err != nil {
+			utils.WriteError(w, http.StatusInternalServerError, err)
+			return
+		}
+
+		if !reserved {
+			replay(w, r, body, existing)
+			return
+		}
+
+		// a key left in progress would answer 409 until it expires, so every
+		// way out of the handler either completes or releases it
+		defer func() {
+			if p := recover(); p != nil {
+				log.Println("idempotency: handler panicked:", p)
+				release(store, userID, key)
+				utils.WriteError(w, http.StatusInternalServerError, fmt.Errorf("internal server error"))
+			}
+		}()
+
+		rec := &responseRecorder{ResponseWriter: w, status: http.StatusOK}
+		handlerFunc(rec, r)
+
+		// server errors are not final ... free the key so the client can retry
+		if rec.status >= http.StatusInternalServerError {
+			release(store, userID, key)
+			rec.flush()
+			return
+		}
+
+		if err := store.CompleteIdempotencyKey(userID, key, rec.status, rec.body.Bytes()); err != nil {
+			release(store, userID, key)
+			utils.WriteError(w, http.StatusInternalServerError, fmt.Errorf("failed to store the response: %w", err))
+			return
+		}
+
+		rec.flush()
+	}
+}
+
+func release(store types.IdempotencyStore, userID int, key string) {
+	if err := store.DeleteIdempotencyKey(userID, key); err != nil {
+		log.Println("idempotency:", err)
+	}
+}
+
+func replay(w http.ResponseWriter, r *http.Request, body []byte, existing *types.IdempotencyRecord) {
+	if existing.RequestHash != hashRequest(r, body) {
+		utils.WriteError(w, http.StatusUnprocessableEntity, fmt.Errorf("idempotency key was already used with a different request"))
+		return
+	}
+
+	if existing.ResponseStatus == 0 {
+		utils.WriteError(w, http.StatusConflict, fmt.Errorf("a request with this idempotency key is still in progress"))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set(HeaderReplayed, "true")
+	w.WriteHeader(existing.ResponseStatus)
+	w.Write(existing.ResponseBody)
+}
+
+// hashes method, path, query, the currency asked for and body ... the
+// currency can come from a header and decides what checkout charges. JSON
+// bodies are re-encoded first so whitespace and key order do not count as a
+// different payload
+func hashRequest(r *http.Request, body []byte) string {
+	var decoded any
+	if err := json.Unmarshal(body, &decoded); err == nil {
+		if canonical, err := json.Marshal(decoded); err == nil {
+			body = canonical
+		}
+	}
+
+	h := sha256.New()
+	h.Write([]byte(r.Method + " " + r.URL.Path + "?" + r.URL.RawQuery + "\n"))
+	h.Write([]byte(currency.FromRequest(r) + "\n"))
+	h.Write(body)
+
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// responseRecorder holds the response back until the key is completed, so
+// a failure to store it can still answer 500 ... headers go straight to the
+// real writer
+type responseRecorder struct {
+	http.ResponseWriter
+	status      int
+	wroteHeader bool
+	body        bytes.Buffer
+}
+
+func (rec *responseRecorder) WriteHeader(status int) {
+	if rec.wroteHeader {
+		return
+	}
+	rec.status = status
+	rec.wroteHeader = true
+}
+
+func (rec *responseRecorder) Write(b []byte) (int, error) {
+	if !rec.wroteHeader {
+		rec.WriteHeader(http.StatusOK)
+	}
+	return rec.body.Write(b)
+}
+
+func (rec *responseRecorder) flush() {
+	rec.ResponseWriter.WriteHeader(rec.status)
+	rec.ResponseWriter.Write(rec.body.Bytes())
+}
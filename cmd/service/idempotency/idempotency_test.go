@@ -0,0 +1,214 @@
+package idempotency
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/eugenius-watchman/ecom_go_rest_api/cmd/service/auth"
+	"github.com/eugenius-watchman/ecom_go_rest_api/cmd/service/currency"
+	"github.com/eugenius-watchman/ecom_go_rest_api/types"
+	"github.com/eugenius-watchman/ecom_go_rest_api/utils"
+)
+
+func TestWithIdempotencyKey(t *testing.T) {
+	store := newMockIdempotencyStore()
+
+	calls := 0
+	status := http.StatusCreated
+	panics := false
+	handler := WithIdempotencyKey(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		if panics {
+			panic("checkout blew up")
+		}
+		utils.WriteJSON(w, status, map[string]int{"orderId": calls})
+	}, store)
+
+	sendTo := func(target string, header http.Header, userID int, key, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, target, strings.NewReader(body))
+		for name, values := range header {
+			req.Header[name] = values
+		}
+		if key != "" {
+			req.Header.Set(HeaderKey, key)
+		}
+		req = req.WithContext(context.WithValue(req.Context(), auth.UserKey, userID))
+		rr := httptest.NewRecorder()
+		handler(rr, req)
+		return rr
+	}
+
+	send := func(userID int, key, body string) *httptest.ResponseRecorder {
+		return sendTo("/cart/checkout", nil, userID, key, body)
+	}
+
+	t.Run("should replay the first response for a retried request", func(t *testing.T) {
+		first := send(1, "key-a", `{"items":[{"productId":1,"quantity":1}]}`)
+		if first.Code != http.StatusCreated {
+			t.Fatalf("expected status code %d, got %d", http.StatusCreated, first.Code)
+		}
+
+		// same payload, different formatting
+		retry := send(1, "key-a", `{ "items": [ { "quantity": 1, "productId": 1 } ] }`)
+		if retry.Code != http.StatusCreated {
+			t.Errorf("expected status code %d, got %d", http.StatusCreated, retry.Code)
+		}
+		if retry.Body.String() != first.Body.String() {
+			t.Errorf("expected replayed body %q, got %q", first.Body.String(), retry.Body.String())
+		}
+		if retry.Header().Get(HeaderReplayed) != "true" {
+			t.Error("expected replayed header to be set")
+		}
+		if calls != 1 {
+			t.Errorf("expected handler to run once, ran %d times", calls)
+		}
+	})
+
+	t.Run("should reject a different payload under the same key", func(t *testing.T) {
+		send(1, "key-b", `{"items":[{"productId":1,"quantity":1}]}`)
+
+		rr := send(1, "key-b", `{"items":[{"productId":1,"quantity":2}]}`)
+		if rr.Code != http.StatusUnprocessableEntity {
+			t.Errorf("expected status code %d, got %d", http.StatusUnprocessableEntity, rr.Code)
+		}
+	})
+
+	t.Run("should reject a different query or currency under the same key", func(t *testing.T) {
+		send(1, "key-q", `{}`)
+
+		if rr := sendTo("/cart/checkout?currency=EUR", nil, 1, "key-q", `{}`); rr.Code != http.StatusUnprocessableEntity {
+			t.Errorf("expected status code %d for another query, got %d", http.StatusUnprocessableEntity, rr.Code)
+		}
+
+		header := http.Header{currency.HeaderKey: {"EUR"}}
+		if rr := sendTo("/cart/checkout", header, 1, "key-q", `{}`); rr.Code != http.StatusUnprocessableEntity {
+			t.Errorf("expected status code %d for another currency header, got %d", http.StatusUnprocessableEntity, rr.Code)
+		}
+	})
+
+	t.Run("should scope keys per user", func(t *testing.T) {
+		before := calls
+		send(1, "key-c", `{}`)
+		send(2, "key-c", `{}`)
+		if calls != before+2 {
+			t.Errorf("expected handler to run for both users")
+		}
+	})
+
+	t.Run("should answer 409 while the first request is in progress", func(t *testing.T) {
+		store.ReserveIdempotencyKey(types.IdempotencyRecord{
+			UserID:      1,
+			Key:         "key-d",
+			RequestHash: hashRequest(httptest.NewRequest(http.MethodPost, "/cart/checkout", nil), []byte(`{}`)),
+		})
+
+		rr := send(1, "key-d", `{}`)
+		if rr.Code != http.StatusConflict {
+			t.Errorf("expected status code %d, got %d", http.StatusConflict, rr.Code)
+		}
+	})
+
+	t.Run("should release the key after a server error", func(t *testing.T) {
+		status = http.StatusInternalServerError
+		send(1, "key-e", `{}`)
+		status = http.StatusCreated
+
+		before := calls
+		rr := send(1, "key-e", `{}`)
+		if rr.Code != http.StatusCreated {
+			t.Errorf("expected status code %d, got %d", http.StatusCreated, rr.Code)
+		}
+		if calls != before+1 {
+			t.Error("expected the retry to run the handler")
+		}
+	})
+
+	t.Run("should release the key when the handler panics", func(t *testing.T) {
+		panics = true
+		rr := send(1, "key-f", `{}`)
+		panics = false
+		if rr.Code != http.StatusInternalServerError {
+			t.Errorf("expected status code %d, got %d", http.StatusInternalServerError, rr.Code)
+		}
+
+		if rr := send(1, "key-f", `{}`); rr.Code != http.StatusCreated {
+			t.Errorf("expected the retry to get status code %d, got %d", http.StatusCreated, rr.Code)
+		}
+	})
+
+	t.Run("should answer 500 and release the key when the response can't be stored", func(t *testing.T) {
+		store.completeErr = fmt.Errorf("connection lost")
+		rr := send(1, "key-g", `{}`)
+		store.completeErr = nil
+		if rr.Code != http.StatusInternalServerError {
+			t.Errorf("expected status code %d, got %d", http.StatusInternalServerError, rr.Code)
+		}
+
+		before := calls
+		if rr := send(1, "key-g", `{}`); rr.Code != http.StatusCreated {
+			t.Errorf("expected the retry to get status code %d, got %d", http.StatusCreated, rr.Code)
+		}
+		if calls != before+1 {
+			t.Error("expected the retry to run the handler")
+		}
+	})
+
+	t.Run("should pass through without a key", func(t *testing.T) {
+		before := calls
+		send(1, "", `{}`)
+		send(1, "", `{}`)
+		if calls != before+2 {
+			t.Error("expected handler to run for every request")
+		}
+	})
+}
+
+type mockIdempotencyStore struct {
+	mu      sync.Mutex
+	records map[string]*types.IdempotencyRecord
+	// returned by CompleteIdempotencyKey when set
+	completeErr error
+}
+
+func newMockIdempotencyStore() *mockIdempotencyStore {
+	return &mockIdempotencyStore{records: make(map[string]*types.IdempotencyRecord)}
+}
+
+func recordKey(userID int, key string) string {
+	return fmt.Sprintf("%d/%s", userID, key)
+}
+
+func (m *mockIdempotencyStore) ReserveIdempotencyKey(record types.IdempotencyRecord) (*types.IdempotencyRecord, bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if existing, ok := m.records[recordKey(record.UserID, record.Key)]; ok {
+		copied := *existing
+		return &copied, false, nil
+	}
+	m.records[recordKey(record.UserID, record.Key)] = &record
+	return nil, true, nil
+}
+
+func (m *mockIdempotencyStore) CompleteIdempotencyKey(userID int, key string, status int, body []byte) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.completeErr != nil {
+		return m.completeErr
+	}
+	record := m.records[recordKey(userID, key)]
+	record.ResponseStatus = status
+	record.ResponseBody = body
+	return nil
+}
+
+func (m *mockIdempotencyStore) DeleteIdempotencyKey(userID int, key string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.records, recordKey(userID, key))
+	return nil
+}
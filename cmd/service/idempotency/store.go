@@ -0,0 +1,102 @@
+package idempotency
+
+import (
+	"database/sql"
+	"fmt"
+
+	"github.com/eugenius-watchman/ecom_go_rest_api/types"
+)
+
+type Store struct {
+	db *sql.DB
+}
+
+func NewStore(db *sql.DB) *Store {
+	return &Store{db: db}
+}
+
+func (s *Store) ReserveIdempotencyKey(record types.IdempotencyRecord) (*types.IdempotencyRecord, bool, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return nil, false, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	// an expired key is free to be used again
+	_, err = tx.Exec(
+		"DELETE FROM idempotency_keys WHERE userId = ? AND idempotencyKey = ? AND expiresAt < NOW()",
+		record.UserID, record.Key,
+	)
+	if err != nil {
+		return nil, false, fmt.Errorf("failed to expire idempotency key: %w", err)
+	}
+
+	// the primary key makes sure only one request can claim the key
+	const insert = `
+		INSERT IGNORE INTO idempotency_keys (userId, idempotencyKey, requestHash, expiresAt)
+		VALUES (?, ?, ?, ?)`
+
+	result, err := tx.Exec(insert, record.UserID, record.Key, record.RequestHash, record.ExpiresAt)
+	if err != nil {
+		return nil, false, fmt.Errorf("failed to reserve idempotency key: %w", err)
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return nil, false, fmt.Errorf("failed to check rows affected: %w", err)
+	}
+
+	if rowsAffected == 1 {
+		if err := tx.Commit(); err != nil {
+			return nil, false, fmt.Errorf("failed to commit transaction: %w", err)
+		}
+		return nil, true, nil
+	}
+
+	const query = `
+		SELECT userId, idempotencyKey, requestHash, responseStatus, responseBody, expiresAt, createdAt
+		FROM idempotency_keys WHERE userId = ? AND idempotencyKey = ?`
+
+	var existing types.IdempotencyRecord
+	var status sql.NullInt64
+	err = tx.QueryRow(query, record.UserID, record.Key).Scan(
+		&existing.UserID,
+		&existing.Key,
+		&existing.RequestHash,
+		&status,
+		&existing.ResponseBody,
+		&existing.ExpiresAt,
+		&existing.CreatedAt,
+	)
+	if err != nil {
+		return nil, false, fmt.Errorf("failed to get idempotency key: %w", err)
+	}
+	existing.ResponseStatus = int(status.Int64)
+
+	if err := tx.Commit(); err != nil {
+		return nil, false, fmt.Errorf("failed to commit transaction: %w", err)
+	}
+
+	return &existing, false, nil
+}
+
+func (s *Store) CompleteIdempotencyKey(userID int, key string, status int, body []byte) error {
+	const query = `
+		UPDATE idempotency_keys SET responseStatus = ?, responseBody = ?
+		WHERE userId = ? AND idempotencyKey = ?`
+
+	if _, err := s.db.Exec(query, status, body, userID, key); err != nil {
+		return fmt.Errorf("failed to store idempotent response: %w", err)
+	}
+
+	return nil
+}
+
+func (s *Store) DeleteIdempotencyKey(userID int, key string) error {
+	_, err := s.db.Exec("DELETE FROM idempotency_keys WHERE userId = ? AND idempotencyKey = ?", userID, key)
+	if err != nil {
+		return fmt.Errorf("failed to release idempotency key: %w", err)
+	}
+
+	return nil
+}
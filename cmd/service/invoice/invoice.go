@@ -0,0 +1,388 @@
+package invoice
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/eugenius-watchman/ecom_go_rest_api/config"
+	"github.com/eugenius-watchman/ecom_go_rest_api/types"
+	"github.com/go-pdf/fpdf"
+)
+
+var ErrNotInvoiceable = errors.New("order has not been paid")
+
+// Seller is who the invoice is from
+type Seller struct {
+	Name    string
+	Address []string
+	TaxID   string
+}
+
+func SellerFromConfig() Seller {
+	seller := Seller{Name: config.Envs.ShopName, TaxID: config.Envs.ShopTaxID}
+	for _, line := range strings.Split(config.Envs.ShopAddress, ";") {
+		if line = strings.TrimSpace(line); line != "" {
+			seller.Address = append(seller.Address, line)
+		}
+	}
+
+	return seller
+}
+
+// Invoice is everything printed on one
+type Invoice struct {
+	Seller    Seller
+	Order     types.Order
+	Items     []types.OrderItem
+	Discounts []types.OrderDiscount
+}
+
+// Invoiceable reports whether an order in status has been paid for ...
+// refunded orders keep their invoice, the refund is printed on it
+func Invoiceable(status types.OrderStatus) bool {
+	switch status {
+	case types.OrderStatusPaid, types.OrderStatusShipped, types.OrderStatusDelivered,
+		types.OrderStatusPartiallyRefunded, types.OrderStatusRefunded:
+		return true
+	}
+
+	return false
+}
+
+// FormatNumber writes the nth invoice of year, e.g. INV-2026-000042
+func FormatNumber(year int, n int) string {
+	return fmt.Sprintf("INV-%d-%06d", year, n)
+}
+
+// A4 with 15mm margins leaves 180mm across
+const (
+	pageMargin   = 15.0
+	contentWidth = 180.0
+	lineHeight   = 5.0
+)
+
+// item table columns
+var columns = []struct {
+	title string
+	width float64
+	align string
+}{
+	{"Item", 70, "L"},
+	{"Qty", 15, "R"},
+	{"Unit price", 25, "R"},
+	{"Discount", 22, "R"},
+	{"Tax", 22, "R"},
+	{"Total", 26, "R"},
+}
+
+// Render writes the invoice as a PDF. The output only depends on inv, the
+// document dates are the invoice date, so the same invoice always renders
+// to the same bytes.
+func Render(w io.Writer, inv Invoice) error {
+	if inv.Order.InvoiceNumber == "" || inv.Order.InvoicedAt == nil {
+		return fmt.Errorf("order %d has no invoice number", inv.Order.ID)
+	}
+
+	pdf := fpdf.New("P", "mm", "A4", "")
+	pdf.SetCreationDate(*inv.Order.InvoicedAt)
+	pdf.SetModificationDate(*inv.Order.InvoicedAt)
+	pdf.SetCatalogSort(true)
+	pdf.SetTitle("Invoice "+inv.Order.InvoiceNumber, true)
+	pdf.SetAuthor(inv.Seller.Name, true)
+	pdf.SetMargins(pageMargin, pageMargin, pageMargin)
+	pdf.SetAutoPageBreak(true, pageMargin)
+	pdf.AddPage()
+
+	r := &renderer{
+		pdf:      pdf,
+		tr:       pdf.UnicodeTranslatorFromDescriptor(""), // cp1252 for the core fonts
+		currency: inv.Order.Currency,
+	}
+
+	r.header(inv)
+	r.addresses(inv.Order)
+	r.items(inv.Items)
+	r.totals(inv)
+	r.taxSummary(inv.Items)
+
+	return pdf.Output(w)
+}
+
+type renderer struct {
+	pdf      *fpdf.Fpdf
+	tr       func(string) string
+	currency string
+}
+
+func (r *renderer) text(w float64, s string, align string) {
+	r.pdf.CellFormat(w, lineHeight, r.tr(s), "", 0, align, false, 0, "")
+}
+
+func (r *renderer) line(w float64, s string, align string) {
+	r.pdf.CellFormat(w, lineHeight, r.tr(s), "", 1, align, false, 0, "")
+}
+
+func (r *renderer) money(m types.Money) string {
+	return m.String() + " " + r.currency
+}
+
+// seller on the left, invoice details on the right
+func (r *renderer) header(inv Invoice) {
+	order := inv.Order
+	top := r.pdf.GetY()
+
+	r.pdf.SetFont("Helvetica", "B", 14)
+	r.line(100, inv.Seller.Name, "L")
+	r.pdf.SetFont("Helvetica", "", 9)
+	for _, line := range inv.Seller.Address {
+		r.line(100, line, "L")
+	}
+	if inv.Seller.TaxID != "" {
+		r.line(100, "Tax ID: "+inv.Seller.TaxID, "L")
+	}
+	bottom := r.pdf.GetY()
+
+	r.pdf.SetXY(pageMargin+100, top)
+	r.pdf.SetFont("Helvetica", "B", 18)
+	r.pdf.CellFormat(80, 8, "INVOICE", "", 2, "R", false, 0, "")
+
+	r.pdf.SetFont("Helvetica", "", 9)
+	details := [][2]string{
+		{"Invoice number", order.InvoiceNumber},
+		{"Invoice date", order.InvoicedAt.Format("2 January 2006")},
+		{"Order", "#" + strconv.Itoa(order.ID)},
+		{"Order date", order.CreatedAt.Format("2 January 2006")},
+	}
+	for _, detail := range details {
+		r.pdf.SetX(pageMargin + 100)
+		r.text(40, detail[0], "L")
+		r.line(40, detail[1], "R")
+	}
+
+	r.pdf.SetY(max(bottom, r.pdf.GetY()) + 8)
+}
+
+func (r *renderer) addresses(order types.Order) {
+	top := r.pdf.GetY()
+
+	blocks := []struct {
+		title   string
+		address types.Address
+		x       float64
+	}{
+		{"Bill to", order.BillingAddress, pageMargin},
+		{"Ship to", order.ShippingAddress, pageMargin + contentWidth/2},
+	}
+
+	bottom := top
+	for _, block := range blocks {
+		r.pdf.SetXY(block.x, top)
+		r.pdf.SetFont("Helvetica", "B", 10)
+		r.line(contentWidth/2, block.title, "L")
+
+		r.pdf.SetFont("Helvetica", "", 9)
+		for _, line := range addressLines(block.address) {
+			r.pdf.SetX(block.x)
+			r.line(contentWidth/2, line, "L")
+		}
+		bottom = max(bottom, r.pdf.GetY())
+	}
+
+	r.pdf.SetY(bottom + 8)
+}
+
+func addressLines(address types.Address) []string {
+	lines := []string{address.Name, address.Line1}
+	if address.Line2 != "" {
+		lines = append(lines, address.Line2)
+	}
+
+	city := strings.Join(nonEmpty(address.City, address.Region, address.PostalCode), " ")
+	lines = append(lines, city, address.Country)
+	if address.Phone != "" {
+		lines = append(lines, address.Phone)
+	}
+
+	return nonEmpty(lines...)
+}
+
+func nonEmpty(values ...string) []string {
+	var kept []string
+	for _, v := range values {
+		if v != "" {
+			kept = append(kept, v)
+		}
+	}
+	return kept
+}
+
+// one row per line, with its tax rates underneath
+func (r *renderer) items(items []types.OrderItem) {
+	r.pdf.SetFont("Helvetica", "B", 9)
+	r.pdf.SetFillColor(235, 235, 235)
+	for _, column := range columns {
+		r.pdf.CellFormat(column.width, 7, column.title, "B", 0, column.align, true, 0, "")
+	}
+	r.pdf.Ln(-1)
+
+	for _, item := range items {
+		r.pdf.SetFont("Helvetica", "", 9)
+
+		// long names wrap inside the first column
+		names := r.pdf.SplitText(r.tr(item.ProductName), columns[0].width-2)
+		cells := []string{
+			"",
+			strconv.Itoa(item.Quantity),
+			r.money(item.Price),
+			r.money(item.Discount),
+			r.money(item.Tax),
+			r.money(item.Total),
+		}
+		for i, name := range names {
+			r.pdf.CellFormat(columns[0].width, lineHeight, name, "", 0, "L", false, 0, "")
+			for c, column := range columns[1:] {
+				value := ""
+				if i == 0 {
+					value = cells[c+1]
+				}
+				r.pdf.CellFormat(column.width, lineHeight, value, "", 0, column.align, false, 0, "")
+			}
+			r.pdf.Ln(-1)
+		}
+
+		r.pdf.SetFont("Helvetica", "", 7)
+		for _, tax := range item.Taxes {
+			r.line(columns[0].width, "  "+taxLabel(tax), "L")
+		}
+	}
+
+	r.pdf.Line(pageMargin, r.pdf.GetY()+1, pageMargin+contentWidth, r.pdf.GetY()+1)
+	r.pdf.Ln(4)
+}
+
+func (r *renderer) totals(inv Invoice) {
+	order := inv.Order
+
+	type row struct {
+		label string
+		value string
+		bold  bool
+	}
+	rows := []row{{"Subtotal", r.money(order.Subtotal), false}}
+
+	if !order.DiscountTotal.IsZero() {
+		label := "Discount"
+		if codes := discountCodes(inv.Discounts); codes != "" {
+			label += " (" + codes + ")"
+		}
+		rows = append(rows, row{label, "-" + r.money(order.DiscountTotal), false})
+	}
+
+	shipping := "Shipping"
+	if order.ShippingMethod != "" {
+		shipping += " (" + order.ShippingMethod + ")"
+	}
+	rows = append(rows, row{shipping, r.money(order.ShippingTotal), false})
+
+	// included tax is already in the prices above
+	if added := order.TaxTotal.Sub(order.TaxIncluded); !added.IsZero() {
+		rows = append(rows, row{"Tax", r.money(added), false})
+	}
+
+	rows = append(rows, row{"Total", r.money(order.Total), true})
+
+	if !order.TaxIncluded.IsZero() {
+		rows = append(rows, row{"Includes tax of", r.money(order.TaxIncluded), false})
+	}
+	if !order.RefundedTotal.IsZero() {
+		rows = append(rows, row{"Refunded", "-" + r.money(order.RefundedTotal), false})
+	}
+
+	for _, row := range rows {
+		style := ""
+		if row.bold {
+			style = "B"
+		}
+		r.pdf.SetFont("Helvetica", style, 9)
+		r.pdf.SetX(pageMargin + contentWidth - 90)
+		r.text(60, row.label, "R")
+		r.line(30, row.value, "R")
+	}
+
+	r.pdf.Ln(6)
+}
+
+// taxSummary adds up the tax lines of every item by rate
+func (r *renderer) taxSummary(items []types.OrderItem) {
+	type total struct {
+		tax     types.OrderItemTax
+		taxable types.Money
+		amount  types.Money
+	}
+
+	var order []string
+	totals := map[string]*total{}
+	for _, item := range items {
+		for _, tax := range item.Taxes {
+			key := taxLabel(tax)
+			t, ok := totals[key]
+			if !ok {
+				zero := types.NewMoney(0, r.currency)
+				t = &total{tax: tax, taxable: zero, amount: zero}
+				totals[key] = t
+				order = append(order, key)
+			}
+			t.taxable = t.taxable.Add(tax.Taxable)
+			t.amount = t.amount.Add(tax.Amount)
+		}
+	}
+
+	if len(order) == 0 {
+		return
+	}
+
+	widths := []float64{90, 45, 45}
+	r.pdf.SetFont("Helvetica", "B", 9)
+	for i, title := range []string{"Tax summary", "Taxable", "Tax"} {
+		align := "R"
+		if i == 0 {
+			align = "L"
+		}
+		r.pdf.CellFormat(widths[i], 7, title, "B", 0, align, true, 0, "")
+	}
+	r.pdf.Ln(-1)
+
+	r.pdf.SetFont("Helvetica", "", 9)
+	for _, key := range order {
+		t := totals[key]
+		r.text(widths[0], key, "L")
+		r.text(widths[1], r.money(t.taxable), "R")
+		r.line(widths[2], r.money(t.amount), "R")
+	}
+}
+
+func taxLabel(tax types.OrderItemTax) string {
+	label := tax.Name + " " + formatRate(tax.Rate)
+	if tax.Inclusive {
+		label += " incl."
+	}
+	return label
+}
+
+// basis points as a percentage, 1900 is 19% and 825 is 8.25%
+func formatRate(rate int) string {
+	percent := strconv.FormatFloat(float64(rate)/100, 'f', 2, 64)
+	percent = strings.TrimRight(strings.TrimRight(percent, "0"), ".")
+	return percent + "%"
+}
+
+func discountCodes(discounts []types.OrderDiscount) string {
+	codes := make([]string, 0, len(discounts))
+	for _, discount := range discounts {
+		codes = append(codes, discount.Code)
+	}
+	return strings.Join(codes, ", ")
+}
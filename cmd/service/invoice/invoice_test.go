@@ -0,0 +1,209 @@
+package invoice
+
+import (
+	"bytes"
+	"flag"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/eugenius-watchman/ecom_go_rest_api/types"
+)
+
+// go test ./cmd/service/invoice -update rewrites the golden files
+var update = flag.Bool("update", false, "update golden files")
+
+var testSeller = Seller{
+	Name:    "Ecom Store",
+	Address: []string{"1 Commerce Way", "Dublin D02 X285", "Ireland"},
+	TaxID:   "IE1234567T",
+}
+
+func money(amount int64, currency string) types.Money {
+	return types.NewMoney(amount, currency)
+}
+
+// a US order taxed on top of the prices, with a promotion
+func usdInvoice() Invoice {
+	issued := time.Date(2026, 10, 18, 12, 0, 0, 0, time.UTC)
+	usd := func(amount int64) types.Money { return money(amount, "USD") }
+	address := types.Address{
+		Name: "Ada Lovelace", Line1: "1 Market Street", Line2: "Suite 300",
+		City: "San Francisco", Region: "CA", PostalCode: "94105", Country: "US", Phone: "+1 415 555 0100",
+	}
+
+	return Invoice{
+		Seller: testSeller,
+		Order: types.Order{
+			ID:              42,
+			UserID:          1,
+			Currency:        "USD",
+			Status:          types.OrderStatusPaid,
+			CreatedAt:       time.Date(2026, 10, 17, 9, 30, 0, 0, time.UTC),
+			Subtotal:        usd(6000),
+			DiscountTotal:   usd(600),
+			TaxTotal:        usd(446),
+			TaxIncluded:     usd(0),
+			ShippingTotal:   usd(500),
+			ShippingMethod:  "standard",
+			Total:           usd(6346),
+			RefundedTotal:   usd(0),
+			ShippingAddress: address,
+			BillingAddress:  address,
+			InvoiceNumber:   "INV-2026-000001",
+			InvoicedAt:      &issued,
+		},
+		Items: []types.OrderItem{
+			{
+				ProductName: "Mechanical keyboard with a very long product name that wraps onto a second line",
+				Quantity:    1, Price: usd(4000), Discount: usd(400), Tax: usd(297), Total: usd(3897),
+				Taxes: []types.OrderItemTax{
+					{Name: "CA state tax", Rate: 725, Taxable: usd(3600), Amount: usd(261)},
+					{Name: "SF county tax", Rate: 100, Taxable: usd(3600), Amount: usd(36)},
+				},
+			},
+			{
+				ProductName: "USB cable",
+				Quantity:    2, Price: usd(1000), Discount: usd(200), Tax: usd(149), Total: usd(1949),
+				Taxes: []types.OrderItemTax{
+					{Name: "CA state tax", Rate: 725, Taxable: usd(1800), Amount: usd(131)},
+					{Name: "SF county tax", Rate: 100, Taxable: usd(1800), Amount: usd(18)},
+				},
+			},
+		},
+		Discounts: []types.OrderDiscount{{Code: "AUTUMN10", Type: types.PromotionPercent, Amount: usd(600)}},
+	}
+}
+
+// a German order with VAT in the prices, partly refunded
+func eurInvoice() Invoice {
+	issued := time.Date(2026, 10, 18, 12, 0, 0, 0, time.UTC)
+	eur := func(amount int64) types.Money { return money(amount, "EUR") }
+
+	return Invoice{
+		Seller: testSeller,
+		Order: types.Order{
+			ID:             43,
+			UserID:         2,
+			Currency:       "EUR",
+			Status:         types.OrderStatusPartiallyRefunded,
+			CreatedAt:      time.Date(2026, 10, 16, 14, 0, 0, 0, time.UTC),
+			Subtotal:       eur(11900),
+			DiscountTotal:  eur(0),
+			TaxTotal:       eur(1900),
+			TaxIncluded:    eur(1900),
+			ShippingTotal:  eur(0),
+			ShippingMethod: "pickup",
+			Total:          eur(11900),
+			RefundedTotal:  eur(2000),
+			ShippingAddress: types.Address{
+				Name: "Jürgen Müller", Line1: "Straße des 17. Juni 135", City: "Berlin", PostalCode: "10623", Country: "DE",
+			},
+			BillingAddress: types.Address{
+				Name: "Müller GmbH", Line1: "Friedrichstraße 1", City: "Berlin", PostalCode: "10117", Country: "DE",
+			},
+			InvoiceNumber: "INV-2026-000002",
+			InvoicedAt:    &issued,
+		},
+		Items: []types.OrderItem{
+			{
+				ProductName: "Espresso machine",
+				Quantity:    1, Price: eur(11900), Discount: eur(0), Tax: eur(1900), Total: eur(11900),
+				Taxes: []types.OrderItemTax{
+					{Name: "MwSt", Rate: 1900, Inclusive: true, Taxable: eur(10000), Amount: eur(1900)},
+				},
+			},
+		},
+	}
+}
+
+func TestRender(t *testing.T) {
+	tests := []struct {
+		name    string
+		invoice Invoice
+	}{
+		{"usd_exclusive_tax", usdInvoice()},
+		{"eur_inclusive_vat", eurInvoice()},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got bytes.Buffer
+			if err := Render(&got, tt.invoice); err != nil {
+				t.Fatal(err)
+			}
+
+			golden := filepath.Join("testdata", tt.name+".golden.pdf")
+			if *update {
+				if err := os.WriteFile(golden, got.Bytes(), 0o644); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			want, err := os.ReadFile(golden)
+			if err != nil {
+				t.Fatalf("%v, run with -update to create it", err)
+			}
+			if !bytes.Equal(got.Bytes(), want) {
+				t.Errorf("rendered invoice differs from %s, run with -update if the change is intended", golden)
+			}
+		})
+	}
+
+	t.Run("should render the same bytes twice", func(t *testing.T) {
+		var first, second bytes.Buffer
+		if err := Render(&first, usdInvoice()); err != nil {
+			t.Fatal(err)
+		}
+		if err := Render(&second, usdInvoice()); err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(first.Bytes(), second.Bytes()) {
+			t.Error("expected identical output")
+		}
+	})
+
+	t.Run("should refuse an order without an invoice number", func(t *testing.T) {
+		invoice := usdInvoice()
+		invoice.Order.InvoiceNumber = ""
+		if err := Render(&bytes.Buffer{}, invoice); err == nil {
+			t.Error("expected an error")
+		}
+	})
+}
+
+func TestFormatNumber(t *testing.T) {
+	if got := FormatNumber(2026, 42); got != "INV-2026-000042" {
+		t.Errorf("expected INV-2026-000042, got %s", got)
+	}
+	if got := FormatNumber(2027, 1234567); got != "INV-2027-1234567" {
+		t.Errorf("expected INV-2027-1234567, got %s", got)
+	}
+}
+
+func TestFormatRate(t *testing.T) {
+	tests := map[int]string{1900: "19%", 825: "8.25%", 750: "7.5%", 0: "0%"}
+	for rate, want := range tests {
+		if got := formatRate(rate); got != want {
+			t.Errorf("formatRate(%d): expected %s, got %s", rate, want, got)
+		}
+	}
+}
+
+func TestInvoiceable(t *testing.T) {
+	tests := map[types.OrderStatus]bool{
+		types.OrderStatusPending:           false,
+		types.OrderStatusCancelled:         false,
+		types.OrderStatusPaid:              true,
+		types.OrderStatusShipped:           true,
+		types.OrderStatusDelivered:         true,
+		types.OrderStatusPartiallyRefunded: true,
+		types.OrderStatusRefunded:          true,
+	}
+	for status, want := range tests {
+		if got := Invoiceable(status); got != want {
+			t.Errorf("Invoiceable(%s): expected %v, got %v", status, want, got)
+		}
+	}
+}
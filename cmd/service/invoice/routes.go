@@ -0,0 +1,104 @@
+package invoice
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/eugenius-watchman/ecom_go_rest_api/cmd/service/auth"
+	"github.com/eugenius-watchman/ecom_go_rest_api/types"
+	"github.com/eugenius-watchman/ecom_go_rest_api/utils"
+	"github.com/gorilla/mux"
+)
+
+type Handler struct {
+	store      types.InvoiceStore
+	orderStore types.OrderStore
+	userStore  types.UserStore
+	seller     Seller
+	now        func() time.Time
+}
+
+func NewHandler(store types.InvoiceStore, orderStore types.OrderStore, userStore types.UserStore) *Handler {
+	return &Handler{
+		store:      store,
+		orderStore: orderStore,
+		userStore:  userStore,
+		seller:     SellerFromConfig(),
+		now:        time.Now,
+	}
+}
+
+func (h *Handler) RegisterRoutes(router *mux.Router) {
+	router.HandleFunc("/orders/{id}/invoice.pdf", auth.WithJWTAuth(h.handleGetInvoice, h.userStore)).Methods("GET")
+}
+
+// the first download numbers the invoice, later ones get the same number
+// and date
+func (h *Handler) handleGetInvoice(w http.ResponseWriter, r *http.Request) {
+	orderID, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		utils.WriteError(w, http.StatusBadRequest, fmt.Errorf("invalid order ID"))
+		return
+	}
+
+	order, err := h.orderStore.GetOrderByID(orderID)
+	if err != nil {
+		utils.WriteError(w, http.StatusNotFound, fmt.Errorf("order not found"))
+		return
+	}
+
+	userID := auth.GetUserIDFromContext(r.Context())
+	if order.UserID != userID && !auth.HasRole(r.Context(), types.RoleAdmin, types.RoleStaff) {
+		utils.WriteError(w, http.StatusNotFound, fmt.Errorf("order not found"))
+		return
+	}
+
+	if order.InvoiceNumber == "" {
+		if !Invoiceable(order.Status) {
+			utils.WriteError(w, http.StatusConflict, ErrNotInvoiceable)
+			return
+		}
+
+		number, issuedAt, err := h.store.IssueInvoiceNumber(order.ID, h.now())
+		if err != nil {
+			if errors.Is(err, ErrNotInvoiceable) {
+				utils.WriteError(w, http.StatusConflict, err)
+				return
+			}
+			utils.WriteError(w, http.StatusInternalServerError, err)
+			return
+		}
+		order.InvoiceNumber = number
+		order.InvoicedAt = &issuedAt
+	}
+
+	items, err := h.orderStore.GetOrderItemsByOrderID(order.ID)
+	if err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	discounts, err := h.orderStore.GetOrderDiscountsByOrderID(order.ID)
+	if err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	// render fully before writing so a failure can still be reported
+	var pdf bytes.Buffer
+	invoice := Invoice{Seller: h.seller, Order: *order, Items: items, Discounts: discounts}
+	if err := Render(&pdf, invoice); err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/pdf")
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`inline; filename="%s.pdf"`, order.InvoiceNumber))
+	w.Header().Set("Content-Length", strconv.Itoa(pdf.Len()))
+	w.WriteHeader(http.StatusOK)
+	w.Write(pdf.Bytes())
+}
@@ -0,0 +1,210 @@
+package invoice
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/eugenius-watchman/ecom_go_rest_api/cmd/service/auth/authtest"
+	"github.com/eugenius-watchman/ecom_go_rest_api/types"
+	"github.com/gorilla/mux"
+)
+
+func TestInvoiceServiceHandlers(t *testing.T) {
+	paid := usdInvoice()
+	paid.Order.InvoiceNumber = ""
+	paid.Order.InvoicedAt = nil
+
+	pending := usdInvoice().Order
+	pending.ID = 44
+	pending.Status = types.OrderStatusPending
+	pending.InvoiceNumber = ""
+	pending.InvoicedAt = nil
+
+	orderStore := &mockOrderStore{
+		orders: map[int]*types.Order{paid.Order.ID: &paid.Order, pending.ID: &pending},
+		items:  map[int][]types.OrderItem{paid.Order.ID: paid.Items},
+	}
+	invoiceStore := &mockInvoiceStore{orders: orderStore}
+
+	handler := NewHandler(invoiceStore, orderStore, userStore)
+	handler.seller = testSeller
+	handler.now = func() time.Time { return time.Date(2026, 10, 18, 12, 0, 0, 0, time.UTC) }
+
+	router := mux.NewRouter()
+	handler.RegisterRoutes(router)
+
+	get := func(orderID, userID int) *httptest.ResponseRecorder {
+		req, err := http.NewRequest(http.MethodGet, fmt.Sprintf("/orders/%d/invoice.pdf", orderID), nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		authtest.SignIn(req, userID)
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		return rr
+	}
+
+	t.Run("should fail without a token", func(t *testing.T) {
+		if rr := get(paid.Order.ID, 0); rr.Code != http.StatusUnauthorized {
+			t.Errorf("expected status code %d, got %d", http.StatusUnauthorized, rr.Code)
+		}
+	})
+
+	t.Run("should hide another user's invoice", func(t *testing.T) {
+		if rr := get(paid.Order.ID, 2); rr.Code != http.StatusNotFound {
+			t.Errorf("expected status code %d, got %d", http.StatusNotFound, rr.Code)
+		}
+		if invoiceStore.issued != 0 {
+			t.Errorf("expected no invoice number to be issued, got %d", invoiceStore.issued)
+		}
+	})
+
+	t.Run("should refuse an unpaid order", func(t *testing.T) {
+		if rr := get(pending.ID, 1); rr.Code != http.StatusConflict {
+			t.Errorf("expected status code %d, got %d", http.StatusConflict, rr.Code)
+		}
+	})
+
+	t.Run("should fail for a missing order", func(t *testing.T) {
+		if rr := get(999, 1); rr.Code != http.StatusNotFound {
+			t.Errorf("expected status code %d, got %d", http.StatusNotFound, rr.Code)
+		}
+	})
+
+	t.Run("should number the invoice on the first download", func(t *testing.T) {
+		rr := get(paid.Order.ID, 1)
+		if rr.Code != http.StatusOK {
+			t.Fatalf("expected status code %d, got %d: %s", http.StatusOK, rr.Code, rr.Body)
+		}
+
+		if ct := rr.Header().Get("Content-Type"); ct != "application/pdf" {
+			t.Errorf("expected application/pdf, got %s", ct)
+		}
+		if cd := rr.Header().Get("Content-Disposition"); cd != `inline; filename="INV-2026-000001.pdf"` {
+			t.Errorf("unexpected content disposition %s", cd)
+		}
+		if !bytes.HasPrefix(rr.Body.Bytes(), []byte("%PDF-")) {
+			t.Error("expected a PDF body")
+		}
+
+		// the same invoice as the golden file
+		want, err := renderBytes(usdInvoice())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(rr.Body.Bytes(), want) {
+			t.Error("expected the served invoice to match the rendered one")
+		}
+	})
+
+	t.Run("should reuse the number afterwards", func(t *testing.T) {
+		first := get(paid.Order.ID, 1)
+
+		// a later download on another day keeps the original number and date
+		handler.now = func() time.Time { return time.Date(2027, 1, 2, 0, 0, 0, 0, time.UTC) }
+		second := get(paid.Order.ID, 1)
+
+		if first.Code != http.StatusOK || second.Code != http.StatusOK {
+			t.Fatalf("expected status code %d, got %d and %d", http.StatusOK, first.Code, second.Code)
+		}
+		if !bytes.Equal(first.Body.Bytes(), second.Body.Bytes()) {
+			t.Error("expected the same invoice twice")
+		}
+		if invoiceStore.issued != 1 {
+			t.Errorf("expected one invoice number to be issued, got %d", invoiceStore.issued)
+		}
+	})
+
+	t.Run("should let an admin download any invoice", func(t *testing.T) {
+		if rr := get(paid.Order.ID, 3); rr.Code != http.StatusOK {
+			t.Errorf("expected status code %d, got %d", http.StatusOK, rr.Code)
+		}
+	})
+}
+
+func renderBytes(invoice Invoice) ([]byte, error) {
+	var buf bytes.Buffer
+	err := Render(&buf, invoice)
+	return buf.Bytes(), err
+}
+
+// mockInvoiceStore numbers invoices from one sequence, straight onto the
+// mock orders
+type mockInvoiceStore struct {
+	orders *mockOrderStore
+	last   int
+	issued int
+}
+
+func (m *mockInvoiceStore) IssueInvoiceNumber(orderID int, issuedAt time.Time) (string, time.Time, error) {
+	order, ok := m.orders.orders[orderID]
+	if !ok {
+		return "", time.Time{}, fmt.Errorf("order not found")
+	}
+	if order.InvoiceNumber != "" {
+		return order.InvoiceNumber, *order.InvoicedAt, nil
+	}
+	if !Invoiceable(order.Status) {
+		return "", time.Time{}, ErrNotInvoiceable
+	}
+
+	m.last++
+	m.issued++
+	issuedAt = issuedAt.UTC().Truncate(time.Second)
+	order.InvoiceNumber = FormatNumber(issuedAt.Year(), m.last)
+	order.InvoicedAt = &issuedAt
+
+	return order.InvoiceNumber, issuedAt, nil
+}
+
+type mockOrderStore struct {
+	orders map[int]*types.Order
+	items  map[int][]types.OrderItem
+}
+
+// copies, like the database would
+func (m *mockOrderStore) GetOrderByID(id int) (*types.Order, error) {
+	order, ok := m.orders[id]
+	if !ok {
+		return nil, fmt.Errorf("order not found")
+	}
+	copied := *order
+	return &copied, nil
+}
+
+func (m *mockOrderStore) GetOrdersByUserID(userID int, limit, offset int) ([]types.Order, error) {
+	return nil, nil
+}
+
+func (m *mockOrderStore) CountOrdersByUserID(userID int) (int, error) {
+	return 0, nil
+}
+
+func (m *mockOrderStore) GetOrderItemsByOrderID(orderID int) ([]types.OrderItem, error) {
+	return m.items[orderID], nil
+}
+
+func (m *mockOrderStore) GetOrderDiscountsByOrderID(orderID int) ([]types.OrderDiscount, error) {
+	if orderID == usdInvoice().Order.ID {
+		return usdInvoice().Discounts, nil
+	}
+	return nil, nil
+}
+
+func (m *mockOrderStore) UpdateOrderStatus(orderID int, from, to types.OrderStatus, changedBy int, reason string) error {
+	return nil
+}
+
+func (m *mockOrderStore) GetOrderStatusHistory(orderID int) ([]types.OrderStatusChange, error) {
+	return nil, nil
+}
+
+func (m *mockOrderStore) WithinTx(fn func(tx types.OrderTx) error) error {
+	return nil
+}
+
+var userStore = &authtest.UserStore{Roles: map[int]string{3: types.RoleAdmin}, Default: types.RoleCustomer}
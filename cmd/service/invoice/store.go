@@ -0,0 +1,79 @@
+package invoice
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/eugenius-watchman/ecom_go_rest_api/types"
+)
+
+type Store struct {
+	db *sql.DB
+}
+
+func NewStore(db *sql.DB) *Store {
+	return &Store{db: db}
+}
+
+// numbers come from one counter row per year, locked by the upsert until
+// the order has its number ... a rolled back transaction gives the number
+// back, so none are skipped
+func (s *Store) IssueInvoiceNumber(orderID int, issuedAt time.Time) (string, time.Time, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return "", time.Time{}, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	// lock the order so two downloads can't both number it
+	var (
+		status     types.OrderStatus
+		number     sql.NullString
+		invoicedAt sql.NullTime
+	)
+	err = tx.QueryRow("SELECT status, invoiceNumber, invoicedAt FROM orders WHERE id = ? FOR UPDATE", orderID).
+		Scan(&status, &number, &invoicedAt)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return "", time.Time{}, fmt.Errorf("order not found")
+		}
+		return "", time.Time{}, fmt.Errorf("failed to get order: %w", err)
+	}
+
+	if number.Valid {
+		return number.String, invoicedAt.Time, nil
+	}
+
+	if !Invoiceable(status) {
+		return "", time.Time{}, ErrNotInvoiceable
+	}
+
+	issuedAt = issuedAt.UTC().Truncate(time.Second)
+	year := issuedAt.Year()
+
+	const next = `
+		INSERT INTO invoice_sequences (year, lastNumber) VALUES (?, 1)
+		ON DUPLICATE KEY UPDATE lastNumber = lastNumber + 1`
+
+	if _, err := tx.Exec(next, year); err != nil {
+		return "", time.Time{}, fmt.Errorf("failed to advance invoice sequence: %w", err)
+	}
+
+	var n int
+	if err := tx.QueryRow("SELECT lastNumber FROM invoice_sequences WHERE year = ?", year).Scan(&n); err != nil {
+		return "", time.Time{}, fmt.Errorf("failed to get invoice sequence: %w", err)
+	}
+
+	invoiceNumber := FormatNumber(year, n)
+	_, err = tx.Exec("UPDATE orders SET invoiceNumber = ?, invoicedAt = ? WHERE id = ?", invoiceNumber, issuedAt, orderID)
+	if err != nil {
+		return "", time.Time{}, fmt.Errorf("failed to number invoice: %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return "", time.Time{}, fmt.Errorf("failed to commit transaction: %w", err)
+	}
+
+	return invoiceNumber, issuedAt, nil
+}
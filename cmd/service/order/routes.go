@@ -0,0 +1,274 @@
+package order
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+
+	"github.com/eugenius-watchman/ecom_go_rest_api/cmd/service/auth"
+	"github.com/eugenius-watchman/ecom_go_rest_api/types"
+	"github.com/eugenius-watchman/ecom_go_rest_api/utils"
+	"github.com/go-playground/validator/v10"
+	"github.com/gorilla/mux"
+)
+
+const (
+	defaultPageLimit = 20
+	maxPageLimit     = 100
+)
+
+type Handler struct {
+	store     types.OrderStore
+	refunder  types.OrderRefunder
+	userStore types.UserStore
+}
+
+func NewHandler(store types.OrderStore, refunder types.OrderRefunder, userStore types.UserStore) *Handler {
+	return &Handler{store: store, refunder: refunder, userStore: userStore}
+}
+
+func (h *Handler) RegisterRoutes(router *mux.Router) {
+	router.HandleFunc("/orders", auth.WithJWTAuth(h.handleGetOrders, h.userStore)).Methods("GET")
+	router.HandleFunc("/orders/{id}", auth.WithJWTAuth(h.handleGetOrder, h.userStore)).Methods("GET")
+	router.HandleFunc("/orders/{id}/history", auth.WithJWTAuth(h.handleGetOrderHistory, h.userStore)).Methods("GET")
+	router.HandleFunc("/orders/{id}/cancel", auth.WithJWTAuth(h.handleCancelOrder, h.userStore)).Methods("POST")
+
+	// order administration
+	router.HandleFunc("/orders/{id}/status", auth.WithRole(h.handleUpdateOrderStatus, h.userStore, types.RoleAdmin)).Methods("PATCH")
+}
+
+// lists the authenticated user's own orders, newest first
+func (h *Handler) handleGetOrders(w http.ResponseWriter, r *http.Request) {
+	userID := auth.GetUserIDFromContext(r.Context())
+
+	page, limit, err := parsePagination(r)
+	if err != nil {
+		utils.WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	orders, err := h.store.GetOrdersByUserID(userID, limit, (page-1)*limit)
+	if err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	total, err := h.store.CountOrdersByUserID(userID)
+	if err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	utils.WriteJSON(w, http.StatusOK, types.OrderListResponse{
+		Orders: orders,
+		Page:   page,
+		Limit:  limit,
+		Total:  total,
+	})
+}
+
+func (h *Handler) handleGetOrder(w http.ResponseWriter, r *http.Request) {
+	order, ok := h.getAccessibleOrder(w, r)
+	if !ok {
+		return
+	}
+
+	items, err := h.store.GetOrderItemsByOrderID(order.ID)
+	if err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	discounts, err := h.store.GetOrderDiscountsByOrderID(order.ID)
+	if err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	utils.WriteJSON(w, http.StatusOK, types.OrderDetailResponse{
+		Order:     *order,
+		Items:     items,
+		Discounts: discounts,
+	})
+}
+
+func (h *Handler) handleGetOrderHistory(w http.ResponseWriter, r *http.Request) {
+	order, ok := h.getAccessibleOrder(w, r)
+	if !ok {
+		return
+	}
+
+	history, err := h.store.GetOrderStatusHistory(order.ID)
+	if err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	utils.WriteJSON(w, http.StatusOK, history)
+}
+
+func (h *Handler) handleUpdateOrderStatus(w http.ResponseWriter, r *http.Request) {
+	order, ok := h.getAccessibleOrder(w, r)
+	if !ok {
+		return
+	}
+
+	var payload types.UpdateOrderStatusPayload
+	if err := utils.ParseJSON(r, &payload); err != nil {
+		utils.WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	if err := utils.Validate.Struct(payload); err != nil {
+		errors := err.(validator.ValidationErrors)
+		utils.WriteError(w, http.StatusBadRequest, fmt.Errorf("invalid payload %w", errors))
+		return
+	}
+
+	// cancelling restocks, so it only happens through POST /orders/{id}/cancel
+	// ... the payload already refuses the refund statuses, which need a refund
+	if payload.Status == types.OrderStatusCancelled {
+		utils.WriteError(w, http.StatusConflict,
+			fmt.Errorf("%w: orders are cancelled through POST /orders/{id}/cancel", ErrInvalidTransition))
+		return
+	}
+
+	if !CanTransition(order.Status, payload.Status) {
+		utils.WriteError(w, http.StatusConflict,
+			fmt.Errorf("%w: cannot change order status from %s to %s", ErrInvalidTransition, order.Status, payload.Status))
+		return
+	}
+
+	userID := auth.GetUserIDFromContext(r.Context())
+	err := h.store.UpdateOrderStatus(order.ID, order.Status, payload.Status, userID, payload.Reason)
+	if err != nil {
+		if errors.Is(err, ErrStatusChanged) {
+			utils.WriteError(w, http.StatusConflict, err)
+			return
+		}
+		utils.WriteError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	order.Status = payload.Status
+	utils.WriteJSON(w, http.StatusOK, order)
+}
+
+// owners and admins can cancel while the order is pending or paid, a paid
+// order gets its payment back ... cancelling twice is a no-op
+func (h *Handler) handleCancelOrder(w http.ResponseWriter, r *http.Request) {
+	order, ok := h.getAccessibleOrder(w, r)
+	if !ok {
+		return
+	}
+
+	userID := auth.GetUserIDFromContext(r.Context())
+	if order.UserID != userID && !auth.HasRole(r.Context(), types.RoleAdmin) {
+		utils.WriteError(w, http.StatusForbidden, fmt.Errorf("permission denied"))
+		return
+	}
+
+	// the reason is optional so an empty body is fine
+	var payload types.CancelOrderPayload
+	if err := utils.ParseJSON(r, &payload); err != nil && !errors.Is(err, io.EOF) {
+		utils.WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	if err := utils.Validate.Struct(payload); err != nil {
+		errors := err.(validator.ValidationErrors)
+		utils.WriteError(w, http.StatusBadRequest, fmt.Errorf("invalid payload %w", errors))
+		return
+	}
+
+	if err := h.cancelOrder(order.ID, userID, payload.Reason); err != nil {
+		if errors.Is(err, ErrInvalidTransition) {
+			utils.WriteError(w, http.StatusConflict, err)
+			return
+		}
+		utils.WriteError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	order.Status = types.OrderStatusCancelled
+	utils.WriteJSON(w, http.StatusOK, order)
+}
+
+// restocks, cancels and refunds in one transaction, so a failed refund
+// leaves the order as it was
+func (h *Handler) cancelOrder(orderID int, cancelledBy int, reason string) error {
+	return h.store.WithinTx(func(tx types.OrderTx) error {
+		order, err := tx.GetOrderForUpdate(orderID)
+		if err != nil {
+			return err
+		}
+
+		if order.Status == types.OrderStatusCancelled {
+			return nil
+		}
+
+		if !CanTransition(order.Status, types.OrderStatusCancelled) {
+			return fmt.Errorf("%w: cannot cancel a %s order", ErrInvalidTransition, order.Status)
+		}
+
+		paid := order.Status == types.OrderStatusPaid
+		if err := tx.CancelOrder(order, cancelledBy, reason); err != nil {
+			return err
+		}
+
+		if paid {
+			return h.refunder.RefundCancelledOrder(tx, order, cancelledBy, reason)
+		}
+
+		return nil
+	})
+}
+
+// loads the order from the URL if the caller owns it or is staff ... other
+// people's orders answer 404 so their IDs can't be probed
+func (h *Handler) getAccessibleOrder(w http.ResponseWriter, r *http.Request) (*types.Order, bool) {
+	orderID, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		utils.WriteError(w, http.StatusBadRequest, fmt.Errorf("invalid order ID"))
+		return nil, false
+	}
+
+	order, err := h.store.GetOrderByID(orderID)
+	if err != nil {
+		utils.WriteError(w, http.StatusNotFound, fmt.Errorf("order not found"))
+		return nil, false
+	}
+
+	userID := auth.GetUserIDFromContext(r.Context())
+	if order.UserID != userID && !auth.HasRole(r.Context(), types.RoleAdmin, types.RoleStaff) {
+		utils.WriteError(w, http.StatusNotFound, fmt.Errorf("order not found"))
+		return nil, false
+	}
+
+	return order, true
+}
+
+// reads ?page= and ?limit=, page starts at 1
+func parsePagination(r *http.Request) (int, int, error) {
+	page, limit := 1, defaultPageLimit
+
+	if v := r.URL.Query().Get("page"); v != "" {
+		p, err := strconv.Atoi(v)
+		if err != nil || p < 1 {
+			return 0, 0, fmt.Errorf("invalid page")
+		}
+		page = p
+	}
+
+	if v := r.URL.Query().Get("limit"); v != "" {
+		l, err := strconv.Atoi(v)
+		if err != nil || l < 1 || l > maxPageLimit {
+			return 0, 0, fmt.Errorf("limit must be between 1 and %d", maxPageLimit)
+		}
+		limit = l
+	}
+
+	return page, limit, nil
+}
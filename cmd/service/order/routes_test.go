@@ -0,0 +1,398 @@
+package order
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/eugenius-watchman/ecom_go_rest_api/cmd/service/auth/authtest"
+	"github.com/eugenius-watchman/ecom_go_rest_api/types"
+	"github.com/gorilla/mux"
+)
+
+func TestOrderServiceHandlers(t *testing.T) {
+	store := &mockOrderStore{
+		orders: []types.Order{
+			{ID: 1, UserID: 1, Total: types.NewMoney(2000, "USD"), Status: "pending"},
+			{ID: 2, UserID: 1, Total: types.NewMoney(3000, "USD"), Status: "pending"},
+			{ID: 3, UserID: 1, Total: types.NewMoney(4000, "USD"), Status: "pending"},
+			{ID: 4, UserID: 2, Total: types.NewMoney(5000, "USD"), Status: "pending"},
+		},
+		items: []types.OrderItem{
+			{ID: 1, OrderID: 1, ProductID: 7, ProductName: "shirt", Quantity: 1, Price: types.NewMoney(2000, "USD")},
+		},
+	}
+	handler := NewHandler(store, &mockRefunder{}, userStore)
+
+	router := mux.NewRouter()
+	handler.RegisterRoutes(router)
+
+	get := func(path string, userID int) *httptest.ResponseRecorder {
+		req, err := http.NewRequest(http.MethodGet, path, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		authtest.SignIn(req, userID)
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		return rr
+	}
+
+	t.Run("should page through the user's own orders", func(t *testing.T) {
+		rr := get("/orders?page=2&limit=2", 1)
+		if rr.Code != http.StatusOK {
+			t.Fatalf("expected status code %d, got %d", http.StatusOK, rr.Code)
+		}
+
+		var response types.OrderListResponse
+		json.NewDecoder(rr.Body).Decode(&response)
+		if response.Total != 3 {
+			t.Errorf("expected total 3, got %d", response.Total)
+		}
+		if len(response.Orders) != 1 || response.Orders[0].ID != 3 {
+			t.Errorf("expected only order 3 on page 2, got %+v", response.Orders)
+		}
+	})
+
+	t.Run("should fail for an invalid limit", func(t *testing.T) {
+		if rr := get("/orders?limit=1000", 1); rr.Code != http.StatusBadRequest {
+			t.Errorf("expected status code %d, got %d", http.StatusBadRequest, rr.Code)
+		}
+	})
+
+	t.Run("should return the order with its items", func(t *testing.T) {
+		rr := get("/orders/1", 1)
+		if rr.Code != http.StatusOK {
+			t.Fatalf("expected status code %d, got %d", http.StatusOK, rr.Code)
+		}
+
+		var response types.OrderDetailResponse
+		json.NewDecoder(rr.Body).Decode(&response)
+		if response.ID != 1 || len(response.Items) != 1 || response.Items[0].ProductName != "shirt" {
+			t.Errorf("unexpected order detail %+v", response)
+		}
+	})
+
+	t.Run("should hide other users' orders", func(t *testing.T) {
+		if rr := get("/orders/4", 1); rr.Code != http.StatusNotFound {
+			t.Errorf("expected status code %d, got %d", http.StatusNotFound, rr.Code)
+		}
+	})
+
+	t.Run("should let admins read any order", func(t *testing.T) {
+		if rr := get("/orders/1", 3); rr.Code != http.StatusOK {
+			t.Errorf("expected status code %d, got %d", http.StatusOK, rr.Code)
+		}
+	})
+}
+
+func TestOrderStatusHandlers(t *testing.T) {
+	store := &mockOrderStore{
+		orders: []types.Order{
+			{ID: 1, UserID: 1, Status: types.OrderStatusPending},
+			{ID: 2, UserID: 1, Status: types.OrderStatusShipped},
+		},
+	}
+	handler := NewHandler(store, &mockRefunder{}, userStore)
+
+	router := mux.NewRouter()
+	handler.RegisterRoutes(router)
+
+	patch := func(orderID string, userID int, status types.OrderStatus) *httptest.ResponseRecorder {
+		marshalled, _ := json.Marshal(types.UpdateOrderStatusPayload{Status: status})
+		req, err := http.NewRequest(http.MethodPatch, "/orders/"+orderID+"/status", bytes.NewBuffer(marshalled))
+		if err != nil {
+			t.Fatal(err)
+		}
+		authtest.SignIn(req, userID)
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		return rr
+	}
+
+	t.Run("should forbid customers from changing the status", func(t *testing.T) {
+		if rr := patch("1", 1, types.OrderStatusPaid); rr.Code != http.StatusForbidden {
+			t.Errorf("expected status code %d, got %d", http.StatusForbidden, rr.Code)
+		}
+	})
+
+	t.Run("should apply an allowed transition and record it", func(t *testing.T) {
+		if rr := patch("1", 3, types.OrderStatusPaid); rr.Code != http.StatusOK {
+			t.Fatalf("expected status code %d, got %d", http.StatusOK, rr.Code)
+		}
+
+		if len(store.history) != 1 || store.history[0].ToStatus != types.OrderStatusPaid || *store.history[0].ChangedBy != 3 {
+			t.Errorf("unexpected history %+v", store.history)
+		}
+	})
+
+	t.Run("should reject a transition that skips a step", func(t *testing.T) {
+		if rr := patch("1", 3, types.OrderStatusDelivered); rr.Code != http.StatusConflict {
+			t.Errorf("expected status code %d, got %d", http.StatusConflict, rr.Code)
+		}
+	})
+
+	t.Run("should reject cancelling a shipped order", func(t *testing.T) {
+		if rr := patch("2", 3, types.OrderStatusCancelled); rr.Code != http.StatusConflict {
+			t.Errorf("expected status code %d, got %d", http.StatusConflict, rr.Code)
+		}
+	})
+
+	t.Run("should leave cancelling and refunding to their own routes", func(t *testing.T) {
+		store.orders[0].Status = types.OrderStatusPaid
+		if rr := patch("1", 3, types.OrderStatusCancelled); rr.Code != http.StatusConflict {
+			t.Errorf("expected status code %d, got %d", http.StatusConflict, rr.Code)
+		}
+		for _, status := range []types.OrderStatus{types.OrderStatusPartiallyRefunded, types.OrderStatusRefunded} {
+			if rr := patch("1", 3, status); rr.Code != http.StatusBadRequest {
+				t.Errorf("%s: expected status code %d, got %d", status, http.StatusBadRequest, rr.Code)
+			}
+		}
+		if store.orders[0].Status != types.OrderStatusPaid {
+			t.Errorf("expected the order to stay paid, got %s", store.orders[0].Status)
+		}
+	})
+
+	t.Run("should reject an unknown status", func(t *testing.T) {
+		if rr := patch("2", 3, "lost"); rr.Code != http.StatusBadRequest {
+			t.Errorf("expected status code %d, got %d", http.StatusBadRequest, rr.Code)
+		}
+	})
+}
+
+func TestCanTransition(t *testing.T) {
+	allowed := []struct{ from, to types.OrderStatus }{
+		{types.OrderStatusPending, types.OrderStatusPaid},
+		{types.OrderStatusPending, types.OrderStatusCancelled},
+		{types.OrderStatusPaid, types.OrderStatusShipped},
+		{types.OrderStatusShipped, types.OrderStatusDelivered},
+		{types.OrderStatusDelivered, types.OrderStatusRefunded},
+		{types.OrderStatusPartiallyRefunded, types.OrderStatusShipped},
+		{types.OrderStatusPartiallyRefunded, types.OrderStatusRefunded},
+	}
+	for _, tc := range allowed {
+		if !CanTransition(tc.from, tc.to) {
+			t.Errorf("expected %s -> %s to be allowed", tc.from, tc.to)
+		}
+	}
+
+	rejected := []struct{ from, to types.OrderStatus }{
+		{types.OrderStatusPending, types.OrderStatusPending},
+		{types.OrderStatusPending, types.OrderStatusShipped},
+		{types.OrderStatusDelivered, types.OrderStatusCancelled},
+		{types.OrderStatusCancelled, types.OrderStatusPending},
+		{types.OrderStatusPending, types.OrderStatusRefunded},
+		{types.OrderStatusRefunded, types.OrderStatusPartiallyRefunded},
+	}
+	for _, tc := range rejected {
+		if CanTransition(tc.from, tc.to) {
+			t.Errorf("expected %s -> %s to be rejected", tc.from, tc.to)
+		}
+	}
+}
+
+func TestCancelOrderHandler(t *testing.T) {
+	store := &mockOrderStore{
+		orders: []types.Order{
+			{ID: 1, UserID: 1, Status: types.OrderStatusPending},
+			{ID: 2, UserID: 1, Status: types.OrderStatusShipped},
+			{ID: 3, UserID: 2, Status: types.OrderStatusPaid},
+		},
+		items: []types.OrderItem{
+			{ID: 1, OrderID: 1, ProductID: 7, Quantity: 2},
+			{ID: 2, OrderID: 3, ProductID: 7, Quantity: 1},
+		},
+		stock: map[int]int{7: 0},
+	}
+	refunder := &mockRefunder{}
+	handler := NewHandler(store, refunder, userStore)
+
+	router := mux.NewRouter()
+	handler.RegisterRoutes(router)
+
+	cancel := func(orderID string, userID int) *httptest.ResponseRecorder {
+		marshalled, _ := json.Marshal(types.CancelOrderPayload{Reason: "changed my mind"})
+		req, err := http.NewRequest(http.MethodPost, "/orders/"+orderID+"/cancel", bytes.NewBuffer(marshalled))
+		if err != nil {
+			t.Fatal(err)
+		}
+		authtest.SignIn(req, userID)
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		return rr
+	}
+
+	t.Run("should let the owner cancel a pending order and restock", func(t *testing.T) {
+		if rr := cancel("1", 1); rr.Code != http.StatusOK {
+			t.Fatalf("expected status code %d, got %d", http.StatusOK, rr.Code)
+		}
+		if store.stock[7] != 2 {
+			t.Errorf("expected stock 2, got %d", store.stock[7])
+		}
+		if len(refunder.refunded) != 0 {
+			t.Errorf("expected no refund for a pending order, got %v", refunder.refunded)
+		}
+	})
+
+	t.Run("should treat a second cancellation as a no-op", func(t *testing.T) {
+		if rr := cancel("1", 1); rr.Code != http.StatusOK {
+			t.Fatalf("expected status code %d, got %d", http.StatusOK, rr.Code)
+		}
+		if store.stock[7] != 2 {
+			t.Errorf("expected stock to stay 2, got %d", store.stock[7])
+		}
+	})
+
+	t.Run("should refuse to cancel a shipped order", func(t *testing.T) {
+		if rr := cancel("2", 1); rr.Code != http.StatusConflict {
+			t.Errorf("expected status code %d, got %d", http.StatusConflict, rr.Code)
+		}
+	})
+
+	t.Run("should hide other users' orders", func(t *testing.T) {
+		if rr := cancel("3", 1); rr.Code != http.StatusNotFound {
+			t.Errorf("expected status code %d, got %d", http.StatusNotFound, rr.Code)
+		}
+	})
+
+	t.Run("should let admins cancel any order and refund a paid one", func(t *testing.T) {
+		if rr := cancel("3", 3); rr.Code != http.StatusOK {
+			t.Errorf("expected status code %d, got %d", http.StatusOK, rr.Code)
+		}
+		if store.stock[7] != 3 {
+			t.Errorf("expected stock 3, got %d", store.stock[7])
+		}
+		if len(refunder.refunded) != 1 || refunder.refunded[0] != 3 {
+			t.Errorf("expected order 3 to be refunded, got %v", refunder.refunded)
+		}
+	})
+}
+
+type mockOrderStore struct {
+	orders  []types.Order
+	items   []types.OrderItem
+	history []types.OrderStatusChange
+	stock   map[int]int
+}
+
+func (m *mockOrderStore) GetOrderByID(id int) (*types.Order, error) {
+	for _, o := range m.orders {
+		if o.ID == id {
+			return &o, nil
+		}
+	}
+	return nil, fmt.Errorf("order not found")
+}
+
+func (m *mockOrderStore) GetOrdersByUserID(userID int, limit, offset int) ([]types.Order, error) {
+	orders := []types.Order{}
+	for _, o := range m.orders {
+		if o.UserID == userID {
+			orders = append(orders, o)
+		}
+	}
+	if offset >= len(orders) {
+		return []types.Order{}, nil
+	}
+	return orders[offset:min(offset+limit, len(orders))], nil
+}
+
+func (m *mockOrderStore) CountOrdersByUserID(userID int) (int, error) {
+	count := 0
+	for _, o := range m.orders {
+		if o.UserID == userID {
+			count++
+		}
+	}
+	return count, nil
+}
+
+func (m *mockOrderStore) GetOrderItemsByOrderID(orderID int) ([]types.OrderItem, error) {
+	items := []types.OrderItem{}
+	for _, item := range m.items {
+		if item.OrderID == orderID {
+			items = append(items, item)
+		}
+	}
+	return items, nil
+}
+
+func (m *mockOrderStore) GetOrderDiscountsByOrderID(orderID int) ([]types.OrderDiscount, error) {
+	return []types.OrderDiscount{}, nil
+}
+
+func (m *mockOrderStore) UpdateOrderStatus(orderID int, from, to types.OrderStatus, changedBy int, reason string) error {
+	for i := range m.orders {
+		if m.orders[i].ID != orderID {
+			continue
+		}
+		if m.orders[i].Status != from {
+			return ErrStatusChanged
+		}
+		m.orders[i].Status = to
+		m.history = append(m.history, types.OrderStatusChange{
+			OrderID:    orderID,
+			FromStatus: from,
+			ToStatus:   to,
+			ChangedBy:  &changedBy,
+			Reason:     reason,
+		})
+		return nil
+	}
+	return fmt.Errorf("order not found")
+}
+
+func (m *mockOrderStore) GetOrderStatusHistory(orderID int) ([]types.OrderStatusChange, error) {
+	history := []types.OrderStatusChange{}
+	for _, change := range m.history {
+		if change.OrderID == orderID {
+			history = append(history, change)
+		}
+	}
+	return history, nil
+}
+
+func (m *mockOrderStore) WithinTx(fn func(tx types.OrderTx) error) error {
+	return fn(&mockOrderTx{store: m})
+}
+
+type mockOrderTx struct {
+	store *mockOrderStore
+}
+
+func (t *mockOrderTx) GetOrderForUpdate(id int) (*types.Order, error) {
+	return t.store.GetOrderByID(id)
+}
+
+func (t *mockOrderTx) GetOrderItems(orderID int) ([]types.OrderItem, error) {
+	return t.store.GetOrderItemsByOrderID(orderID)
+}
+
+func (t *mockOrderTx) CancelOrder(order *types.Order, cancelledBy int, reason string) error {
+	m := t.store
+	for _, item := range m.items {
+		if item.OrderID == order.ID {
+			m.stock[item.ProductID] += item.Quantity
+		}
+	}
+	return m.UpdateOrderStatus(order.ID, order.Status, types.OrderStatusCancelled, cancelledBy, reason)
+}
+
+func (t *mockOrderTx) RecordRefund(refund types.Refund) (int, error) {
+	return 0, fmt.Errorf("not implemented")
+}
+
+// remembers which orders were refunded on cancellation
+type mockRefunder struct {
+	refunded []int
+}
+
+func (m *mockRefunder) RefundCancelledOrder(tx types.OrderTx, order *types.Order, refundedBy int, reason string) error {
+	m.refunded = append(m.refunded, order.ID)
+	return nil
+}
+
+var userStore = &authtest.UserStore{Roles: map[int]string{3: types.RoleAdmin}, Default: types.RoleCustomer}
@@ -0,0 +1,43 @@
+package order
+
+import (
+	"errors"
+
+	"github.com/eugenius-watchman/ecom_go_rest_api/types"
+)
+
+var (
+	ErrInvalidTransition = errors.New("invalid order status transition")
+	// the order moved on between reading and updating it
+	ErrStatusChanged = errors.New("order status was changed concurrently")
+)
+
+// allowed status changes ... anything not listed here is rejected
+var transitions = map[types.OrderStatus][]types.OrderStatus{
+	types.OrderStatusPending:   {types.OrderStatusPaid, types.OrderStatusCancelled},
+	types.OrderStatusPaid:      {types.OrderStatusShipped, types.OrderStatusCancelled, types.OrderStatusPartiallyRefunded, types.OrderStatusRefunded},
+	types.OrderStatusShipped:   {types.OrderStatusDelivered, types.OrderStatusPartiallyRefunded, types.OrderStatusRefunded},
+	types.OrderStatusDelivered: {types.OrderStatusPartiallyRefunded, types.OrderStatusRefunded},
+	// the lines that weren't refunded still have to be shipped
+	types.OrderStatusPartiallyRefunded: {types.OrderStatusShipped, types.OrderStatusDelivered, types.OrderStatusRefunded},
+}
+
+// RefundStatus is the status of an order once refunded out of total has
+// been given back
+func RefundStatus(total, refunded types.Money) types.OrderStatus {
+	if refunded.Cmp(total) >= 0 {
+		return types.OrderStatusRefunded
+	}
+
+	return types.OrderStatusPartiallyRefunded
+}
+
+func CanTransition(from, to types.OrderStatus) bool {
+	for _, next := range transitions[from] {
+		if next == to {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,548 @@
+package order
+
+import (
+	"database/sql"
+	"fmt"
+
+	"github.com/eugenius-watchman/ecom_go_rest_api/types"
+)
+
+type Store struct {
+	db *sql.DB
+}
+
+func NewStore(db *sql.DB) *Store {
+	return &Store{db: db}
+}
+
+const selectOrderByID = `
+		SELECT id, userId, currency, subtotal, total, discountTotal, taxTotal, taxIncluded, shippingTotal, shippingMethod,
+			refundedTotal, status, invoiceNumber, invoicedAt,
+			shippingName, shippingLine1, shippingLine2, shippingCity, shippingRegion, shippingPostalCode, shippingCountry, shippingPhone,
+			billingName, billingLine1, billingLine2, billingCity, billingRegion, billingPostalCode, billingCountry, billingPhone,
+			createdAt 
+		FROM orders WHERE id = ?`
+
+func (s *Store) GetOrderByID(id int) (*types.Order, error) {
+	return getOrder(s.db, selectOrderByID, id)
+}
+
+func getOrder(q querier, query string, id int) (*types.Order, error) {
+	order, err := scanRowIntoOrder(q.QueryRow(query, id))
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, fmt.Errorf("order not found")
+		}
+		return nil, fmt.Errorf("failed to get order: %w", err)
+	}
+
+	return order, nil
+}
+
+func (s *Store) GetOrdersByUserID(userID int, limit, offset int) ([]types.Order, error) {
+	const query = `
+		SELECT id, userId, currency, subtotal, total, discountTotal, taxTotal, taxIncluded, shippingTotal, shippingMethod,
+			refundedTotal, status, invoiceNumber, invoicedAt,
+			shippingName, shippingLine1, shippingLine2, shippingCity, shippingRegion, shippingPostalCode, shippingCountry, shippingPhone,
+			billingName, billingLine1, billingLine2, billingCity, billingRegion, billingPostalCode, billingCountry, billingPhone,
+			createdAt
+		FROM orders WHERE userId = ?
+		ORDER BY createdAt DESC, id DESC
+		LIMIT ? OFFSET ?`
+
+	rows, err := s.db.Query(query, userID, limit, offset)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query oders: %w", err)
+	}
+	defer rows.Close()
+
+	orders := []types.Order{}
+	for rows.Next() {
+		order, err := scanRowIntoOrder(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan order: %w", err)
+		}
+		orders = append(orders, *order)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("rows iteration error: %w", err)
+	}
+
+	return orders, nil
+}
+
+func (s *Store) CountOrdersByUserID(userID int) (int, error) {
+	var count int
+	err := s.db.QueryRow("SELECT COUNT(*) FROM orders WHERE userId = ?", userID).Scan(&count)
+	if err != nil {
+		return 0, fmt.Errorf("failed to count orders: %w", err)
+	}
+
+	return count, nil
+}
+
+func (s *Store) GetOrderItemsByOrderID(orderID int) ([]types.OrderItem, error) {
+	return getOrderItems(s.db, orderID)
+}
+
+func getOrderItems(q querier, orderID int) ([]types.OrderItem, error) {
+	const query = `
+		SELECT oi.id, oi.orderId, oi.productId, COALESCE(oi.variantId, 0), oi.sku, oi.productName, oi.productImage,
+			oi.quantity, o.currency, oi.price,
+			oi.discount, oi.tax, oi.total,
+			COALESCE((SELECT SUM(ri.quantity) FROM refund_items ri WHERE ri.orderItemId = oi.id), 0)
+		FROM order_items oi
+		JOIN orders o ON o.id = oi.orderId
+		WHERE oi.orderId = ?
+		ORDER BY oi.id`
+
+	rows, err := q.Query(query, orderID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query order items: %w", err)
+	}
+	defer rows.Close()
+
+	items := []types.OrderItem{}
+	for rows.Next() {
+		var item types.OrderItem
+		var discount, tax, total string
+		err := rows.Scan(
+			&item.ID,
+			&item.OrderID,
+			&item.ProductID,
+			&item.VariantID,
+			&item.SKU,
+			&item.ProductName,
+			&item.ProductImage,
+			&item.Quantity,
+			&item.Price.Currency, // items are priced in the order's currency
+			&item.Price,
+			&discount,
+			&tax,
+			&total,
+			&item.RefundedQuantity,
+		)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan order item: %w", err)
+		}
+		if item.Discount, err = types.ParseMoney(discount, item.Price.Currency); err != nil {
+			return nil, fmt.Errorf("failed to scan order item: %w", err)
+		}
+		if item.Tax, err = types.ParseMoney(tax, item.Price.Currency); err != nil {
+			return nil, fmt.Errorf("failed to scan order item: %w", err)
+		}
+		if item.Total, err = types.ParseMoney(total, item.Price.Currency); err != nil {
+			return nil, fmt.Errorf("failed to scan order item: %w", err)
+		}
+		item.Taxes = []types.OrderItemTax{}
+		items = append(items, item)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("rows iteration error: %w", err)
+	}
+
+	if err := loadOrderItemTaxes(q, orderID, items); err != nil {
+		return nil, err
+	}
+
+	return items, nil
+}
+
+// attaches each item's tax lines, all read in one query
+func loadOrderItemTaxes(q querier, orderID int, items []types.OrderItem) error {
+	const query = `
+		SELECT t.id, t.orderItemId, t.name, t.rate, t.inclusive, o.currency, t.taxable, t.amount
+		FROM order_item_taxes t
+		JOIN order_items oi ON oi.id = t.orderItemId
+		JOIN orders o ON o.id = oi.orderId
+		WHERE oi.orderId = ?
+		ORDER BY t.id`
+
+	rows, err := q.Query(query, orderID)
+	if err != nil {
+		return fmt.Errorf("failed to query order item taxes: %w", err)
+	}
+	defer rows.Close()
+
+	byItem := make(map[int]int, len(items))
+	for i, item := range items {
+		byItem[item.ID] = i
+	}
+
+	for rows.Next() {
+		var tax types.OrderItemTax
+		var currency, taxable, amount string
+		err := rows.Scan(&tax.ID, &tax.OrderItemID, &tax.Name, &tax.Rate, &tax.Inclusive, &currency, &taxable, &amount)
+		if err != nil {
+			return fmt.Errorf("failed to scan order item tax: %w", err)
+		}
+		if tax.Taxable, err = types.ParseMoney(taxable, currency); err != nil {
+			return fmt.Errorf("failed to scan order item tax: %w", err)
+		}
+		if tax.Amount, err = types.ParseMoney(amount, currency); err != nil {
+			return fmt.Errorf("failed to scan order item tax: %w", err)
+		}
+
+		if i, ok := byItem[tax.OrderItemID]; ok {
+			items[i].Taxes = append(items[i].Taxes, tax)
+		}
+	}
+
+	return rows.Err()
+}
+
+func (s *Store) GetOrderDiscountsByOrderID(orderID int) ([]types.OrderDiscount, error) {
+	const query = `
+		SELECT d.id, d.orderId, COALESCE(d.promotionId, 0), d.code, d.type, o.currency, d.amount, d.freeShipping
+		FROM order_discounts d
+		JOIN orders o ON o.id = d.orderId
+		WHERE d.orderId = ?
+		ORDER BY d.id`
+
+	rows, err := s.db.Query(query, orderID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query order discounts: %w", err)
+	}
+	defer rows.Close()
+
+	discounts := []types.OrderDiscount{}
+	for rows.Next() {
+		var discount types.OrderDiscount
+		err := rows.Scan(
+			&discount.ID,
+			&discount.OrderID,
+			&discount.PromotionID, // 0 once the promotion is deleted
+			&discount.Code,
+			&discount.Type,
+			&discount.Amount.Currency,
+			&discount.Amount,
+			&discount.FreeShipping,
+		)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan order discount: %w", err)
+		}
+		discounts = append(discounts, discount)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("rows iteration error: %w", err)
+	}
+
+	return discounts, nil
+}
+
+func (s *Store) UpdateOrderStatus(orderID int, from, to types.OrderStatus, changedBy int, reason string) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	if err := updateOrderStatus(tx, orderID, from, to, changedBy, reason); err != nil {
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+
+	return nil
+}
+
+func (s *Store) GetOrderStatusHistory(orderID int) ([]types.OrderStatusChange, error) {
+	const query = `
+		SELECT id, orderId, fromStatus, toStatus, changedBy, reason, createdAt
+		FROM order_status_history WHERE orderId = ?
+		ORDER BY createdAt, id`
+
+	rows, err := s.db.Query(query, orderID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query order status history: %w", err)
+	}
+	defer rows.Close()
+
+	history := []types.OrderStatusChange{}
+	for rows.Next() {
+		var change types.OrderStatusChange
+		var changedBy sql.NullInt64
+		err := rows.Scan(
+			&change.ID,
+			&change.OrderID,
+			&change.FromStatus,
+			&change.ToStatus,
+			&changedBy,
+			&change.Reason,
+			&change.CreatedAt,
+		)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan order status change: %w", err)
+		}
+		if changedBy.Valid {
+			id := int(changedBy.Int64)
+			change.ChangedBy = &id
+		}
+		history = append(history, change)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("rows iteration error: %w", err)
+	}
+
+	return history, nil
+}
+
+// WithinTx runs fn in a single db transaction, rolled back if fn errors
+func (s *Store) WithinTx(fn func(tx types.OrderTx) error) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	// no-op once committed
+	defer tx.Rollback()
+
+	if err := fn(&orderTx{tx: tx}); err != nil {
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+
+	return nil
+}
+
+type orderTx struct {
+	tx *sql.Tx
+}
+
+// locks the order row until the transaction ends, so refunds of the same
+// order run one after the other
+func (t *orderTx) GetOrderForUpdate(id int) (*types.Order, error) {
+	return getOrder(t.tx, selectOrderByID+" FOR UPDATE", id)
+}
+
+func (t *orderTx) GetOrderItems(orderID int) ([]types.OrderItem, error) {
+	return getOrderItems(t.tx, orderID)
+}
+
+// restocks every item and cancels the order, which the caller has locked
+func (t *orderTx) CancelOrder(order *types.Order, cancelledBy int, reason string) error {
+	// put every unit back on the shelf ... a product sold in variants is
+	// stocked through them, and units of a deleted variant have no shelf
+	const restock = `
+		UPDATE products p
+		JOIN order_items oi ON oi.productId = p.id
+		SET p.quantity = p.quantity + oi.quantity
+		WHERE oi.orderId = ? AND oi.variantId IS NULL AND oi.sku = ''`
+
+	if _, err := t.tx.Exec(restock, order.ID); err != nil {
+		return fmt.Errorf("failed to restock order items: %w", err)
+	}
+
+	const restockVariants = `
+		UPDATE product_variants v
+		JOIN order_items oi ON oi.variantId = v.id
+		SET v.quantity = v.quantity + oi.quantity
+		WHERE oi.orderId = ?`
+
+	if _, err := t.tx.Exec(restockVariants, order.ID); err != nil {
+		return fmt.Errorf("failed to restock order item variants: %w", err)
+	}
+
+	// several variants of a product may be on the order, so its total is
+	// summed again rather than added to once per line
+	const syncProducts = `
+		UPDATE products p
+		SET p.quantity = (SELECT COALESCE(SUM(v.quantity), 0) FROM product_variants v WHERE v.productId = p.id)
+		WHERE p.id IN (SELECT oi.productId FROM order_items oi WHERE oi.orderId = ? AND oi.variantId IS NOT NULL)`
+
+	if _, err := t.tx.Exec(syncProducts, order.ID); err != nil {
+		return fmt.Errorf("failed to update product quantities: %w", err)
+	}
+
+	return updateOrderStatus(t.tx, order.ID, order.Status, types.OrderStatusCancelled, cancelledBy, reason)
+}
+
+func (t *orderTx) RecordRefund(refund types.Refund) (int, error) {
+	order, err := getOrder(t.tx, selectOrderByID+" FOR UPDATE", refund.OrderID)
+	if err != nil {
+		return 0, err
+	}
+
+	const insert = `
+		INSERT INTO refunds (orderId, paymentId, providerRefundId, amount, currency, reason, restock, createdBy)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?)`
+
+	// createdBy 0 is the system, stored as NULL
+	var createdBy sql.NullInt64
+	if refund.CreatedBy > 0 {
+		createdBy = sql.NullInt64{Int64: int64(refund.CreatedBy), Valid: true}
+	}
+
+	result, err := t.tx.Exec(insert, refund.OrderID, refund.PaymentID, refund.ProviderRefundID, refund.Amount.Amount,
+		refund.Currency, refund.Reason, refund.Restock, createdBy)
+	if err != nil {
+		return 0, fmt.Errorf("failed to create refund: %w", err)
+	}
+
+	refundID, err := result.LastInsertId()
+	if err != nil {
+		return 0, fmt.Errorf("failed to get refund ID: %w", err)
+	}
+
+	for _, item := range refund.Items {
+		_, err := t.tx.Exec("INSERT INTO refund_items (refundId, orderItemId, quantity) VALUES (?, ?, ?)",
+			refundID, item.OrderItemID, item.Quantity)
+		if err != nil {
+			return 0, fmt.Errorf("failed to create refund item: %w", err)
+		}
+
+		if !refund.Restock {
+			continue
+		}
+
+		// units of a deleted variant have no shelf to go back to
+		const restock = `
+			UPDATE products p
+			JOIN order_items oi ON oi.productId = p.id
+			SET p.quantity = p.quantity + ?
+			WHERE oi.id = ? AND oi.orderId = ? AND (oi.variantId IS NOT NULL OR oi.sku = '')`
+
+		if _, err := t.tx.Exec(restock, item.Quantity, item.OrderItemID, refund.OrderID); err != nil {
+			return 0, fmt.Errorf("failed to restock refunded item: %w", err)
+		}
+
+		const restockVariant = `
+			UPDATE product_variants v
+			JOIN order_items oi ON oi.variantId = v.id
+			SET v.quantity = v.quantity + ?
+			WHERE oi.id = ? AND oi.orderId = ?`
+
+		if _, err := t.tx.Exec(restockVariant, item.Quantity, item.OrderItemID, refund.OrderID); err != nil {
+			return 0, fmt.Errorf("failed to restock refunded item: %w", err)
+		}
+	}
+
+	_, err = t.tx.Exec("UPDATE orders SET refundedTotal = refundedTotal + ? WHERE id = ?", refund.Amount, refund.OrderID)
+	if err != nil {
+		return 0, fmt.Errorf("failed to update refunded total: %w", err)
+	}
+
+	// a cancelled order stays cancelled, whatever is given back
+	next := RefundStatus(order.Total, order.RefundedTotal.Add(refund.Amount))
+	if next != order.Status && order.Status != types.OrderStatusCancelled {
+		if !CanTransition(order.Status, next) {
+			return 0, fmt.Errorf("%w: cannot refund a %s order", ErrInvalidTransition, order.Status)
+		}
+
+		if err := updateOrderStatus(t.tx, refund.OrderID, order.Status, next, refund.CreatedBy, refund.Reason); err != nil {
+			return 0, err
+		}
+	}
+
+	return int(refundID), nil
+}
+
+// compare-and-set on the status plus the history row, run inside tx
+func updateOrderStatus(tx *sql.Tx, orderID int, from, to types.OrderStatus, changedBy int, reason string) error {
+	result, err := tx.Exec("UPDATE orders SET status = ? WHERE id = ? AND status = ?", to, orderID, from)
+	if err != nil {
+		return fmt.Errorf("failed to update order status: %w", err)
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to check rows affected: %w", err)
+	}
+
+	if rowsAffected == 0 {
+		return ErrStatusChanged
+	}
+
+	const insert = `
+		INSERT INTO order_status_history (orderId, fromStatus, toStatus, changedBy, reason)
+		VALUES (?, ?, ?, ?, ?)`
+
+	// changedBy 0 is the system, stored as NULL
+	var changedByID sql.NullInt64
+	if changedBy > 0 {
+		changedByID = sql.NullInt64{Int64: int64(changedBy), Valid: true}
+	}
+
+	if _, err := tx.Exec(insert, orderID, from, to, changedByID, reason); err != nil {
+		return fmt.Errorf("failed to record order status change: %w", err)
+	}
+
+	return nil
+}
+
+// querier is satisfied by both *sql.DB and *sql.Tx
+type querier interface {
+	QueryRow(query string, args ...any) *sql.Row
+	Query(query string, args ...any) (*sql.Rows, error)
+}
+
+// scanner is satisfied by both *sql.Row and *sql.Rows
+type scanner interface {
+	Scan(dest ...any) error
+}
+
+// amounts are read as text and parsed once the order's currency is known
+func scanRowIntoOrder(row scanner) (*types.Order, error) {
+	var order types.Order
+	var subtotal, total, discountTotal, taxTotal, taxIncluded, shippingTotal, refundedTotal string
+	shipTo, billTo := &order.ShippingAddress, &order.BillingAddress
+	var invoiceNumber sql.NullString
+	var invoicedAt sql.NullTime
+	err := row.Scan(
+		&order.ID,
+		&order.UserID,
+		&order.Currency,
+		&subtotal,
+		&total,
+		&discountTotal,
+		&taxTotal,
+		&taxIncluded,
+		&shippingTotal,
+		&order.ShippingMethod,
+		&refundedTotal,
+		&order.Status,
+		&invoiceNumber,
+		&invoicedAt,
+		&shipTo.Name, &shipTo.Line1, &shipTo.Line2, &shipTo.City, &shipTo.Region, &shipTo.PostalCode, &shipTo.Country, &shipTo.Phone,
+		&billTo.Name, &billTo.Line1, &billTo.Line2, &billTo.City, &billTo.Region, &billTo.PostalCode, &billTo.Country, &billTo.Phone,
+		&order.CreatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	amounts := []struct {
+		dest *types.Money
+		src  string
+	}{
+		{&order.Subtotal, subtotal},
+		{&order.Total, total},
+		{&order.DiscountTotal, discountTotal},
+		{&order.TaxTotal, taxTotal},
+		{&order.TaxIncluded, taxIncluded},
+		{&order.ShippingTotal, shippingTotal},
+		{&order.RefundedTotal, refundedTotal},
+	}
+	for _, amount := range amounts {
+		if *amount.dest, err = types.ParseMoney(amount.src, order.Currency); err != nil {
+			return nil, err
+		}
+	}
+
+	if invoiceNumber.Valid {
+		order.InvoiceNumber = invoiceNumber.String
+	}
+	if invoicedAt.Valid {
+		order.InvoicedAt = &invoicedAt.Time
+	}
+
+	return &order, nil
+}
@@ -0,0 +1,239 @@
+package payment
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/eugenius-watchman/ecom_go_rest_api/types"
+)
+
+// test cards understood by FakeProvider
+const (
+	CardSuccess      = "4242424242424242"
+	CardDeclined     = "4000000000000002"
+	CardThreeDSecure = "4000000000003220" // needs threeDSecure "passed" to go through
+)
+
+// FakeProvider is an in-memory gateway for tests and local development ...
+// outcomes depend only on the card number so runs are deterministic
+type FakeProvider struct {
+	webhookSecret    []byte
+	webhookTolerance time.Duration
+
+	mu       sync.Mutex
+	nextID   int
+	intents  map[string]*types.PaymentIntent
+	cards    map[string]string // card used per intent, like a real gateway keeps it
+	refunded map[string]int64
+}
+
+func NewFakeProvider(webhookSecret string, webhookTolerance time.Duration) *FakeProvider {
+	return &FakeProvider{
+		webhookSecret:    []byte(webhookSecret),
+		webhookTolerance: webhookTolerance,
+		intents:          make(map[string]*types.PaymentIntent),
+		cards:            make(map[string]string),
+		refunded:         make(map[string]int64),
+	}
+}
+
+func (p *FakeProvider) Name() string {
+	return "fake"
+}
+
+func (p *FakeProvider) CreateIntent(orderID int, amount int64, currency string) (*types.PaymentIntent, error) {
+	if amount <= 0 {
+		return nil, fmt.Errorf("amount must be positive")
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.nextID++
+	intent := &types.PaymentIntent{
+		ID:       fmt.Sprintf("pi_fake_%d", p.nextID),
+		Amount:   amount,
+		Currency: currency,
+		Status:   types.PaymentStatusRequiresPaymentMethod,
+	}
+	p.intents[intent.ID] = intent
+
+	copied := *intent
+	return &copied, nil
+}
+
+func (p *FakeProvider) Confirm(intentID string, method types.PaymentMethod) (*types.PaymentIntent, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	intent, ok := p.intents[intentID]
+	if !ok {
+		return nil, fmt.Errorf("payment intent %s not found", intentID)
+	}
+
+	if intent.Status != types.PaymentStatusRequiresPaymentMethod && intent.Status != types.PaymentStatusRequiresAction {
+		return nil, fmt.Errorf("payment intent %s cannot be confirmed in status %s", intentID, intent.Status)
+	}
+
+	// resuming after 3-D Secure reuses the card given first
+	card := method.CardNumber
+	if card == "" {
+		card = p.cards[intentID]
+	}
+	p.cards[intentID] = card
+
+	switch card {
+	case CardSuccess:
+		intent.Status = types.PaymentStatusRequiresCapture
+	case CardThreeDSecure:
+		switch method.ThreeDSecure {
+		case "passed":
+			intent.Status = types.PaymentStatusRequiresCapture
+		case "failed":
+			intent.Status = types.PaymentStatusFailed
+			intent.FailureReason = "authentication_failed"
+		default:
+			intent.Status = types.PaymentStatusRequiresAction
+		}
+	case CardDeclined:
+		intent.Status = types.PaymentStatusFailed
+		intent.FailureReason = "card_declined"
+	default:
+		intent.Status = types.PaymentStatusFailed
+		intent.FailureReason = "invalid_card"
+	}
+
+	copied := *intent
+	return &copied, nil
+}
+
+func (p *FakeProvider) Capture(intentID string) (*types.PaymentIntent, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	intent, ok := p.intents[intentID]
+	if !ok {
+		return nil, fmt.Errorf("payment intent %s not found", intentID)
+	}
+
+	if intent.Status != types.PaymentStatusRequiresCapture {
+		return nil, fmt.Errorf("payment intent %s cannot be captured in status %s", intentID, intent.Status)
+	}
+
+	intent.Status = types.PaymentStatusSucceeded
+
+	copied := *intent
+	return &copied, nil
+}
+
+func (p *FakeProvider) Cancel(intentID string) (*types.PaymentIntent, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	intent, ok := p.intents[intentID]
+	if !ok {
+		return nil, fmt.Errorf("payment intent %s not found", intentID)
+	}
+
+	if intent.Status != types.PaymentStatusRequiresPaymentMethod && intent.Status != types.PaymentStatusRequiresAction {
+		return nil, fmt.Errorf("payment intent %s cannot be cancelled in status %s", intentID, intent.Status)
+	}
+
+	intent.Status = types.PaymentStatusFailed
+	intent.FailureReason = "canceled"
+
+	copied := *intent
+	return &copied, nil
+}
+
+func (p *FakeProvider) Refund(intentID string, amount int64) (*types.PaymentRefund, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	intent, ok := p.intents[intentID]
+	if !ok {
+		return nil, fmt.Errorf("payment intent %s not found", intentID)
+	}
+
+	if intent.Status != types.PaymentStatusSucceeded {
+		return nil, fmt.Errorf("payment intent %s has not been captured", intentID)
+	}
+
+	if amount <= 0 || p.refunded[intentID]+amount > intent.Amount {
+		return nil, fmt.Errorf("refund amount exceeds captured amount")
+	}
+
+	p.refunded[intentID] += amount
+	p.nextID++
+
+	return &types.PaymentRefund{
+		ID:       fmt.Sprintf("re_fake_%d", p.nextID),
+		IntentID: intentID,
+		Amount:   amount,
+	}, nil
+}
+
+// SignWebhookPayload builds the signature header for payload, in the form
+// "t=<unix seconds>,v1=<hex HMAC-SHA256 of "<t>.<payload>">"
+func (p *FakeProvider) SignWebhookPayload(payload []byte, timestamp time.Time) string {
+	t := strconv.FormatInt(timestamp.Unix(), 10)
+	return fmt.Sprintf("t=%s,v1=%s", t, hex.EncodeToString(p.webhookMAC(t, payload)))
+}
+
+// the timestamp is signed too so an old request can't be replayed later
+func (p *FakeProvider) VerifyWebhookSignature(payload []byte, signature string) error {
+	var t, v1 string
+	for _, part := range strings.Split(signature, ",") {
+		key, value, _ := strings.Cut(strings.TrimSpace(part), "=")
+		switch key {
+		case "t":
+			t = value
+		case "v1":
+			v1 = value
+		}
+	}
+
+	timestamp, err := strconv.ParseInt(t, 10, 64)
+	if err != nil {
+		return fmt.Errorf("invalid signature timestamp")
+	}
+
+	expected, err := hex.DecodeString(v1)
+	if err != nil || !hmac.Equal(p.webhookMAC(t, payload), expected) {
+		return fmt.Errorf("invalid signature")
+	}
+
+	age := time.Since(time.Unix(timestamp, 0))
+	if age > p.webhookTolerance || age < -p.webhookTolerance {
+		return fmt.Errorf("signature timestamp outside tolerance")
+	}
+
+	return nil
+}
+
+func (p *FakeProvider) ParseWebhookEvent(payload []byte) (*types.PaymentEvent, error) {
+	var event types.PaymentEvent
+	if err := json.Unmarshal(payload, &event); err != nil {
+		return nil, fmt.Errorf("invalid webhook payload: %w", err)
+	}
+
+	if event.ID == "" || event.IntentID == "" {
+		return nil, fmt.Errorf("invalid webhook payload: missing id or intentId")
+	}
+
+	return &event, nil
+}
+
+func (p *FakeProvider) webhookMAC(timestamp string, payload []byte) []byte {
+	mac := hmac.New(sha256.New, p.webhookSecret)
+	mac.Write([]byte(timestamp + "."))
+	mac.Write(payload)
+	return mac.Sum(nil)
+}
@@ -0,0 +1,93 @@
+package payment
+
+import (
+	"testing"
+	"time"
+
+	"github.com/eugenius-watchman/ecom_go_rest_api/types"
+)
+
+func TestFakeProvider(t *testing.T) {
+	provider := NewFakeProvider("secret", 5*time.Minute)
+
+	t.Run("should decline the decline card", func(t *testing.T) {
+		intent, _ := provider.CreateIntent(1, 500, "USD")
+		intent, err := provider.Confirm(intent.ID, types.PaymentMethod{CardNumber: CardDeclined})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if intent.Status != types.PaymentStatusFailed || intent.FailureReason != "card_declined" {
+			t.Errorf("unexpected intent %+v", intent)
+		}
+	})
+
+	t.Run("should fail 3-D Secure when authentication fails", func(t *testing.T) {
+		intent, _ := provider.CreateIntent(1, 500, "USD")
+		provider.Confirm(intent.ID, types.PaymentMethod{CardNumber: CardThreeDSecure})
+		intent, err := provider.Confirm(intent.ID, types.PaymentMethod{ThreeDSecure: "failed"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if intent.Status != types.PaymentStatusFailed {
+			t.Errorf("expected failed, got %s", intent.Status)
+		}
+	})
+
+	t.Run("should only cancel an intent that can't have been charged", func(t *testing.T) {
+		intent, _ := provider.CreateIntent(1, 500, "USD")
+		provider.Confirm(intent.ID, types.PaymentMethod{CardNumber: CardThreeDSecure})
+		if _, err := provider.Cancel(intent.ID); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := provider.Confirm(intent.ID, types.PaymentMethod{ThreeDSecure: "passed"}); err == nil {
+			t.Error("expected a cancelled intent not to be confirmed")
+		}
+
+		captured, _ := provider.CreateIntent(1, 500, "USD")
+		provider.Confirm(captured.ID, types.PaymentMethod{CardNumber: CardSuccess})
+		provider.Capture(captured.ID)
+		if _, err := provider.Cancel(captured.ID); err == nil {
+			t.Error("expected a captured intent not to be cancelled")
+		}
+	})
+
+	t.Run("should not refund more than was captured", func(t *testing.T) {
+		intent, _ := provider.CreateIntent(1, 500, "USD")
+		provider.Confirm(intent.ID, types.PaymentMethod{CardNumber: CardSuccess})
+		provider.Capture(intent.ID)
+
+		if _, err := provider.Refund(intent.ID, 300); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := provider.Refund(intent.ID, 201); err == nil {
+			t.Error("expected refund over the captured amount to fail")
+		}
+		if _, err := provider.Refund(intent.ID, 200); err != nil {
+			t.Errorf("expected the remaining amount to be refundable: %v", err)
+		}
+	})
+
+	t.Run("should verify webhook signatures", func(t *testing.T) {
+		payload := []byte(`{"id":"evt_1"}`)
+		signature := provider.SignWebhookPayload(payload, time.Now())
+
+		if err := provider.VerifyWebhookSignature(payload, signature); err != nil {
+			t.Errorf("expected signature to verify: %v", err)
+		}
+		if err := provider.VerifyWebhookSignature([]byte(`{"id":"evt_2"}`), signature); err == nil {
+			t.Error("expected a tampered payload to fail")
+		}
+		if err := NewFakeProvider("other", 5*time.Minute).VerifyWebhookSignature(payload, signature); err == nil {
+			t.Error("expected a signature made with another secret to fail")
+		}
+	})
+
+	t.Run("should reject stale webhook signatures", func(t *testing.T) {
+		payload := []byte(`{"id":"evt_1"}`)
+		signature := provider.SignWebhookPayload(payload, time.Now().Add(-10*time.Minute))
+
+		if err := provider.VerifyWebhookSignature(payload, signature); err == nil {
+			t.Error("expected a stale timestamp to fail")
+		}
+	})
+}
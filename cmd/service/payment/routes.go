@@ -0,0 +1,255 @@
+package payment
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+
+	"github.com/eugenius-watchman/ecom_go_rest_api/cmd/service/auth"
+	"github.com/eugenius-watchman/ecom_go_rest_api/types"
+	"github.com/eugenius-watchman/ecom_go_rest_api/utils"
+	"github.com/go-playground/validator/v10"
+	"github.com/gorilla/mux"
+)
+
+const (
+	WebhookSignatureHeader = "X-Payment-Signature"
+
+	maxWebhookBodyBytes = 1 << 20
+)
+
+type Handler struct {
+	service    *Service
+	store      types.PaymentStore
+	orderStore types.OrderStore
+	userStore  types.UserStore
+}
+
+func NewHandler(service *Service, store types.PaymentStore, orderStore types.OrderStore, userStore types.UserStore) *Handler {
+	return &Handler{
+		service:    service,
+		store:      store,
+		orderStore: orderStore,
+		userStore:  userStore,
+	}
+}
+
+func (h *Handler) RegisterRoutes(router *mux.Router) {
+	router.HandleFunc("/orders/{id}/payments", auth.WithJWTAuth(h.handlePayOrder, h.userStore)).Methods("POST")
+	router.HandleFunc("/orders/{id}/payments", auth.WithJWTAuth(h.handleGetPayments, h.userStore)).Methods("GET")
+	router.HandleFunc("/payments/{id}/confirm", auth.WithJWTAuth(h.handleConfirmPayment, h.userStore)).Methods("POST")
+
+	// refunds are issued by customer service
+	router.HandleFunc("/orders/{id}/refunds", auth.WithRole(h.handleRefundOrder, h.userStore, types.RoleAdmin)).Methods("POST")
+
+	// called by the provider, authenticated by signature instead of a JWT
+	router.HandleFunc("/webhooks/payments/{provider}", h.handleWebhook).Methods("POST")
+}
+
+func (h *Handler) handlePayOrder(w http.ResponseWriter, r *http.Request) {
+	orderID, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		utils.WriteError(w, http.StatusBadRequest, fmt.Errorf("invalid order ID"))
+		return
+	}
+
+	order, ok := h.getOwnOrder(w, r, orderID)
+	if !ok {
+		return
+	}
+
+	var payload types.PaymentMethod
+	if err := utils.ParseJSON(r, &payload); err != nil {
+		utils.WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	if err := utils.Validate.Struct(payload); err != nil {
+		errors := err.(validator.ValidationErrors)
+		utils.WriteError(w, http.StatusBadRequest, fmt.Errorf("invalid payload %w", errors))
+		return
+	}
+
+	payment, err := h.service.PayOrder(order, payload)
+	WritePaymentResult(w, payment, err)
+}
+
+func (h *Handler) handleConfirmPayment(w http.ResponseWriter, r *http.Request) {
+	paymentID, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		utils.WriteError(w, http.StatusBadRequest, fmt.Errorf("invalid payment ID"))
+		return
+	}
+
+	payment, err := h.store.GetPaymentByID(paymentID)
+	if err != nil {
+		utils.WriteError(w, http.StatusNotFound, fmt.Errorf("payment not found"))
+		return
+	}
+
+	order, ok := h.getOwnOrder(w, r, payment.OrderID)
+	if !ok {
+		return
+	}
+
+	var payload types.ConfirmPaymentPayload
+	if err := utils.ParseJSON(r, &payload); err != nil {
+		utils.WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	if err := utils.Validate.Struct(payload); err != nil {
+		errors := err.(validator.ValidationErrors)
+		utils.WriteError(w, http.StatusBadRequest, fmt.Errorf("invalid payload %w", errors))
+		return
+	}
+
+	// the provider already holds the card, only the 3-D Secure result is new
+	payment, err = h.service.ConfirmPayment(order, payment, types.PaymentMethod{
+		ThreeDSecure: payload.ThreeDSecure,
+	})
+	WritePaymentResult(w, payment, err)
+}
+
+func (h *Handler) handleGetPayments(w http.ResponseWriter, r *http.Request) {
+	orderID, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		utils.WriteError(w, http.StatusBadRequest, fmt.Errorf("invalid order ID"))
+		return
+	}
+
+	order, err := h.orderStore.GetOrderByID(orderID)
+	if err != nil || (order.UserID != auth.GetUserIDFromContext(r.Context()) &&
+		!auth.HasRole(r.Context(), types.RoleAdmin, types.RoleStaff)) {
+		utils.WriteError(w, http.StatusNotFound, fmt.Errorf("order not found"))
+		return
+	}
+
+	payments, err := h.store.GetPaymentsByOrderID(order.ID)
+	if err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	utils.WriteJSON(w, http.StatusOK, payments)
+}
+
+func (h *Handler) handleRefundOrder(w http.ResponseWriter, r *http.Request) {
+	orderID, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		utils.WriteError(w, http.StatusBadRequest, fmt.Errorf("invalid order ID"))
+		return
+	}
+
+	order, err := h.orderStore.GetOrderByID(orderID)
+	if err != nil {
+		utils.WriteError(w, http.StatusNotFound, fmt.Errorf("order not found"))
+		return
+	}
+
+	// the amount is read in the order's currency, so "1000" is 1000 yen
+	payload := types.CreateRefundPayload{Amount: types.NewMoney(0, order.Currency)}
+	if err := utils.ParseJSON(r, &payload); err != nil {
+		utils.WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	if err := utils.Validate.Struct(payload); err != nil {
+		errors := err.(validator.ValidationErrors)
+		utils.WriteError(w, http.StatusBadRequest, fmt.Errorf("invalid payload %w", errors))
+		return
+	}
+
+	refund, err := h.service.RefundOrder(order, payload, auth.GetUserIDFromContext(r.Context()))
+	switch {
+	case errors.Is(err, ErrInvalidRefund):
+		utils.WriteError(w, http.StatusBadRequest, err)
+	case errors.Is(err, ErrOrderNotRefundable):
+		utils.WriteError(w, http.StatusConflict, err)
+	case err != nil:
+		utils.WriteError(w, http.StatusInternalServerError, err)
+	default:
+		utils.WriteJSON(w, http.StatusCreated, refund)
+	}
+}
+
+func (h *Handler) handleWebhook(w http.ResponseWriter, r *http.Request) {
+	provider := mux.Vars(r)["provider"]
+	if provider != h.service.ProviderName() {
+		utils.WriteError(w, http.StatusNotFound, fmt.Errorf("unknown payment provider"))
+		return
+	}
+
+	body, err := io.ReadAll(io.LimitReader(r.Body, maxWebhookBodyBytes))
+	if err != nil {
+		utils.WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	if err := h.service.provider.VerifyWebhookSignature(body, r.Header.Get(WebhookSignatureHeader)); err != nil {
+		utils.WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	event, err := h.service.provider.ParseWebhookEvent(body)
+	if err != nil {
+		utils.WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	isNew, err := h.store.RecordWebhookEvent(provider, event.ID, event.Type)
+	if err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	if !isNew {
+		utils.WriteJSON(w, http.StatusOK, map[string]any{"received": true, "duplicate": true})
+		return
+	}
+
+	if err := h.service.HandleWebhookEvent(event); err != nil {
+		// forget the event so the provider's retry gets processed
+		if err := h.store.DeleteWebhookEvent(provider, event.ID); err != nil {
+			log.Println("payment webhook:", err)
+		}
+		utils.WriteError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	utils.WriteJSON(w, http.StatusOK, map[string]any{"received": true})
+}
+
+// only the customer who placed the order pays for it
+func (h *Handler) getOwnOrder(w http.ResponseWriter, r *http.Request, orderID int) (*types.Order, bool) {
+	order, err := h.orderStore.GetOrderByID(orderID)
+	if err != nil || order.UserID != auth.GetUserIDFromContext(r.Context()) {
+		utils.WriteError(w, http.StatusNotFound, fmt.Errorf("order not found"))
+		return nil, false
+	}
+
+	return order, true
+}
+
+// WritePaymentResult maps the outcome of PayOrder or ConfirmPayment to a
+// response: 201 paid, 202 waiting on the customer, 402 declined
+func WritePaymentResult(w http.ResponseWriter, payment *types.Payment, err error) {
+	switch {
+	case errors.Is(err, ErrPaymentDeclined):
+		utils.WriteJSON(w, http.StatusPaymentRequired, map[string]any{
+			"error":   err.Error(),
+			"payment": payment,
+		})
+	case errors.Is(err, ErrOrderNotPayable), errors.Is(err, ErrPaymentInProgress):
+		utils.WriteError(w, http.StatusConflict, err)
+	case err != nil:
+		utils.WriteError(w, http.StatusInternalServerError, err)
+	case payment.Status == types.PaymentStatusRequiresAction:
+		utils.WriteJSON(w, http.StatusAccepted, payment)
+	default:
+		utils.WriteJSON(w, http.StatusCreated, payment)
+	}
+}
@@ -0,0 +1,816 @@
+package payment
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/eugenius-watchman/ecom_go_rest_api/cmd/service/auth/authtest"
+	"github.com/eugenius-watchman/ecom_go_rest_api/types"
+	"github.com/gorilla/mux"
+)
+
+func TestPaymentServiceHandlers(t *testing.T) {
+	orderStore := &mockOrderStore{orders: map[int]*types.Order{
+		1: {ID: 1, UserID: 1, Total: types.NewMoney(1999, "USD"), Status: types.OrderStatusPending},
+		2: {ID: 2, UserID: 1, Total: types.NewMoney(500, "USD"), Status: types.OrderStatusPending},
+		3: {ID: 3, UserID: 1, Total: types.NewMoney(500, "USD"), Status: types.OrderStatusPending},
+		4: {ID: 4, UserID: 2, Total: types.NewMoney(500, "USD"), Status: types.OrderStatusPending},
+		5: {ID: 5, UserID: 1, Total: types.NewMoney(500, "USD"), Status: types.OrderStatusPending},
+	}}
+	store := newMockPaymentStore()
+	service := NewService(NewFakeProvider("secret", 5*time.Minute), store, orderStore)
+	handler := NewHandler(service, store, orderStore, userStore)
+
+	router := mux.NewRouter()
+	handler.RegisterRoutes(router)
+
+	post := func(path string, userID int, payload any) *httptest.ResponseRecorder {
+		marshalled, _ := json.Marshal(payload)
+		req, err := http.NewRequest(http.MethodPost, path, bytes.NewBuffer(marshalled))
+		if err != nil {
+			t.Fatal(err)
+		}
+		authtest.SignIn(req, userID)
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		return rr
+	}
+
+	decode := func(rr *httptest.ResponseRecorder) types.Payment {
+		var payment types.Payment
+		json.NewDecoder(rr.Body).Decode(&payment)
+		return payment
+	}
+
+	t.Run("should capture a successful payment and mark the order paid", func(t *testing.T) {
+		rr := post("/orders/1/payments", 1, types.PaymentMethod{CardNumber: CardSuccess})
+		if rr.Code != http.StatusCreated {
+			t.Fatalf("expected status code %d, got %d", http.StatusCreated, rr.Code)
+		}
+
+		payment := decode(rr)
+		if payment.Status != types.PaymentStatusSucceeded || payment.Amount.Amount != 1999 {
+			t.Errorf("unexpected payment %+v", payment)
+		}
+		if orderStore.orders[1].Status != types.OrderStatusPaid {
+			t.Errorf("expected order to be paid, got %s", orderStore.orders[1].Status)
+		}
+	})
+
+	t.Run("should refuse to pay an order twice", func(t *testing.T) {
+		rr := post("/orders/1/payments", 1, types.PaymentMethod{CardNumber: CardSuccess})
+		if rr.Code != http.StatusConflict {
+			t.Errorf("expected status code %d, got %d", http.StatusConflict, rr.Code)
+		}
+	})
+
+	t.Run("should record a declined payment and keep the order pending", func(t *testing.T) {
+		rr := post("/orders/2/payments", 1, types.PaymentMethod{CardNumber: CardDeclined})
+		if rr.Code != http.StatusPaymentRequired {
+			t.Fatalf("expected status code %d, got %d", http.StatusPaymentRequired, rr.Code)
+		}
+		if orderStore.orders[2].Status != types.OrderStatusPending {
+			t.Errorf("expected order to stay pending, got %s", orderStore.orders[2].Status)
+		}
+
+		payments, _ := store.GetPaymentsByOrderID(2)
+		if len(payments) != 1 || payments[0].Status != types.PaymentStatusFailed {
+			t.Errorf("expected one failed payment, got %+v", payments)
+		}
+	})
+
+	t.Run("should wait for 3-D Secure before capturing", func(t *testing.T) {
+		rr := post("/orders/3/payments", 1, types.PaymentMethod{CardNumber: CardThreeDSecure})
+		if rr.Code != http.StatusAccepted {
+			t.Fatalf("expected status code %d, got %d", http.StatusAccepted, rr.Code)
+		}
+
+		payment := decode(rr)
+		if payment.Status != types.PaymentStatusRequiresAction {
+			t.Fatalf("expected requires_action, got %s", payment.Status)
+		}
+
+		rr = post(fmt.Sprintf("/payments/%d/confirm", payment.ID), 1, types.ConfirmPaymentPayload{ThreeDSecure: "passed"})
+		if rr.Code != http.StatusCreated {
+			t.Fatalf("expected status code %d, got %d", http.StatusCreated, rr.Code)
+		}
+		if orderStore.orders[3].Status != types.OrderStatusPaid {
+			t.Errorf("expected order to be paid, got %s", orderStore.orders[3].Status)
+		}
+	})
+
+	t.Run("should replace an abandoned 3-D Secure attempt", func(t *testing.T) {
+		rr := post("/orders/5/payments", 1, types.PaymentMethod{CardNumber: CardThreeDSecure})
+		if rr.Code != http.StatusAccepted {
+			t.Fatalf("expected status code %d, got %d", http.StatusAccepted, rr.Code)
+		}
+		abandoned := decode(rr)
+
+		rr = post("/orders/5/payments", 1, types.PaymentMethod{CardNumber: CardSuccess})
+		if rr.Code != http.StatusCreated {
+			t.Fatalf("expected status code %d, got %d: %s", http.StatusCreated, rr.Code, rr.Body)
+		}
+		if orderStore.orders[5].Status != types.OrderStatusPaid {
+			t.Errorf("expected order to be paid, got %s", orderStore.orders[5].Status)
+		}
+		if store.payments[abandoned.ID].Status != types.PaymentStatusFailed {
+			t.Errorf("expected the abandoned payment to have failed, got %s", store.payments[abandoned.ID].Status)
+		}
+
+		// finishing the old challenge late must not charge again
+		rr = post(fmt.Sprintf("/payments/%d/confirm", abandoned.ID), 1, types.ConfirmPaymentPayload{ThreeDSecure: "passed"})
+		if rr.Code == http.StatusCreated {
+			t.Errorf("expected the abandoned payment not to be confirmed, got %d", rr.Code)
+		}
+	})
+
+	t.Run("should let the customer try again after a decline", func(t *testing.T) {
+		rr := post("/orders/2/payments", 1, types.PaymentMethod{CardNumber: CardSuccess})
+		if rr.Code != http.StatusCreated {
+			t.Fatalf("expected status code %d, got %d", http.StatusCreated, rr.Code)
+		}
+		if orderStore.orders[2].Status != types.OrderStatusPaid {
+			t.Errorf("expected order to be paid, got %s", orderStore.orders[2].Status)
+		}
+	})
+
+	t.Run("should not let users pay for other users' orders", func(t *testing.T) {
+		rr := post("/orders/4/payments", 1, types.PaymentMethod{CardNumber: CardSuccess})
+		if rr.Code != http.StatusNotFound {
+			t.Errorf("expected status code %d, got %d", http.StatusNotFound, rr.Code)
+		}
+	})
+}
+
+func TestPaymentWebhook(t *testing.T) {
+	orderStore := &mockOrderStore{orders: map[int]*types.Order{
+		1: {ID: 1, UserID: 1, Total: types.NewMoney(500, "USD"), Status: types.OrderStatusPending},
+		2: {ID: 2, UserID: 1, Total: types.NewMoney(500, "USD"), Status: types.OrderStatusPending},
+	}}
+	store := newMockPaymentStore()
+	store.CreatePayment(types.Payment{OrderID: 1, Provider: "fake", ProviderIntentID: "pi_fake_1", Amount: types.NewMoney(50000, "USD"), Status: types.PaymentStatusRequiresAction})
+	store.CreatePayment(types.Payment{OrderID: 2, Provider: "fake", ProviderIntentID: "pi_fake_2", Amount: types.NewMoney(50000, "USD"), Status: types.PaymentStatusRequiresAction})
+
+	provider := NewFakeProvider("secret", 5*time.Minute)
+	handler := NewHandler(NewService(provider, store, orderStore), store, orderStore, userStore)
+
+	router := mux.NewRouter()
+	handler.RegisterRoutes(router)
+
+	fixture := func(name string) []byte {
+		payload, err := os.ReadFile("testdata/" + name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return payload
+	}
+
+	deliver := func(path string, payload []byte, signature string) *httptest.ResponseRecorder {
+		req, err := http.NewRequest(http.MethodPost, path, bytes.NewReader(payload))
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set(WebhookSignatureHeader, signature)
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		return rr
+	}
+
+	t.Run("should mark the order paid on payment.succeeded", func(t *testing.T) {
+		payload := fixture("webhook_payment_succeeded.json")
+		rr := deliver("/webhooks/payments/fake", payload, provider.SignWebhookPayload(payload, time.Now()))
+		if rr.Code != http.StatusOK {
+			t.Fatalf("expected status code %d, got %d: %s", http.StatusOK, rr.Code, rr.Body)
+		}
+		if orderStore.orders[1].Status != types.OrderStatusPaid {
+			t.Errorf("expected order to be paid, got %s", orderStore.orders[1].Status)
+		}
+		if store.payments[1].Status != types.PaymentStatusSucceeded {
+			t.Errorf("expected payment to have succeeded, got %s", store.payments[1].Status)
+		}
+	})
+
+	t.Run("should treat a redelivered event as a no-op", func(t *testing.T) {
+		payload := fixture("webhook_payment_succeeded.json")
+		rr := deliver("/webhooks/payments/fake", payload, provider.SignWebhookPayload(payload, time.Now()))
+		if rr.Code != http.StatusOK {
+			t.Fatalf("expected status code %d, got %d", http.StatusOK, rr.Code)
+		}
+
+		var body map[string]any
+		json.NewDecoder(rr.Body).Decode(&body)
+		if body["duplicate"] != true {
+			t.Errorf("expected the event to be reported as a duplicate, got %v", body)
+		}
+		if orderStore.orders[1].Status != types.OrderStatusPaid {
+			t.Errorf("expected order to stay paid, got %s", orderStore.orders[1].Status)
+		}
+	})
+
+	t.Run("should record a failed payment and keep the order pending", func(t *testing.T) {
+		payload := fixture("webhook_payment_failed.json")
+		rr := deliver("/webhooks/payments/fake", payload, provider.SignWebhookPayload(payload, time.Now()))
+		if rr.Code != http.StatusOK {
+			t.Fatalf("expected status code %d, got %d", http.StatusOK, rr.Code)
+		}
+		if store.payments[2].Status != types.PaymentStatusFailed || store.payments[2].FailureReason != "card_declined" {
+			t.Errorf("unexpected payment %+v", store.payments[2])
+		}
+		if orderStore.orders[2].Status != types.OrderStatusPending {
+			t.Errorf("expected order to stay pending, got %s", orderStore.orders[2].Status)
+		}
+	})
+
+	t.Run("should reject an invalid signature", func(t *testing.T) {
+		payload := fixture("webhook_payment_succeeded.json")
+		signature := NewFakeProvider("other", 5*time.Minute).SignWebhookPayload(payload, time.Now())
+		if rr := deliver("/webhooks/payments/fake", payload, signature); rr.Code != http.StatusBadRequest {
+			t.Errorf("expected status code %d, got %d", http.StatusBadRequest, rr.Code)
+		}
+	})
+
+	t.Run("should reject a stale timestamp", func(t *testing.T) {
+		payload := fixture("webhook_payment_succeeded.json")
+		signature := provider.SignWebhookPayload(payload, time.Now().Add(-time.Hour))
+		if rr := deliver("/webhooks/payments/fake", payload, signature); rr.Code != http.StatusBadRequest {
+			t.Errorf("expected status code %d, got %d", http.StatusBadRequest, rr.Code)
+		}
+	})
+
+	t.Run("should 404 for an unknown provider", func(t *testing.T) {
+		payload := fixture("webhook_payment_succeeded.json")
+		rr := deliver("/webhooks/payments/stripe", payload, provider.SignWebhookPayload(payload, time.Now()))
+		if rr.Code != http.StatusNotFound {
+			t.Errorf("expected status code %d, got %d", http.StatusNotFound, rr.Code)
+		}
+	})
+}
+
+// a 3-D Secure payment finished by the customer after the order was cancelled
+func TestPaymentCapturedAfterCancellation(t *testing.T) {
+	orderStore := &mockOrderStore{
+		orders: map[int]*types.Order{
+			1: {ID: 1, UserID: 1, Total: types.NewMoney(2500, "USD"), Status: types.OrderStatusPending},
+		},
+		stock: map[int]int{},
+	}
+	store := newMockPaymentStore()
+	provider := NewFakeProvider("secret", 5*time.Minute)
+	service := NewService(provider, store, orderStore)
+
+	order, _ := orderStore.GetOrderByID(1)
+	payment, err := service.PayOrder(order, types.PaymentMethod{CardNumber: CardThreeDSecure})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	orderStore.orders[1].Status = types.OrderStatusCancelled
+	provider.Confirm(payment.ProviderIntentID, types.PaymentMethod{ThreeDSecure: "passed"})
+	provider.Capture(payment.ProviderIntentID)
+
+	event := &types.PaymentEvent{Type: types.PaymentEventSucceeded, IntentID: payment.ProviderIntentID}
+	for i := 0; i < 2; i++ {
+		if err := service.HandleWebhookEvent(event); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if order := orderStore.orders[1]; order.Status != types.OrderStatusCancelled || order.RefundedTotal.Amount != 2500 {
+		t.Errorf("expected the cancelled order to be refunded once, got %+v", order)
+	}
+	if store.payments[payment.ID].Status != types.PaymentStatusSucceeded {
+		t.Errorf("expected payment to have succeeded, got %s", store.payments[payment.ID].Status)
+	}
+}
+
+func TestRefundOrder(t *testing.T) {
+	orderStore := &mockOrderStore{
+		orders: map[int]*types.Order{
+			1: {ID: 1, UserID: 1, Currency: "USD", Total: types.NewMoney(2500, "USD"), Status: types.OrderStatusPending},
+			2: {ID: 2, UserID: 1, Currency: "USD", Total: types.NewMoney(500, "USD"), Status: types.OrderStatusPending},
+			3: {ID: 3, UserID: 1, Currency: "JPY", Total: types.NewMoney(5000, "JPY"), Status: types.OrderStatusPending},
+		},
+		items: []types.OrderItem{
+			{ID: 1, OrderID: 1, ProductID: 10, Quantity: 2, Price: types.NewMoney(1000, "USD"), Total: types.NewMoney(2000, "USD")},
+			{ID: 2, OrderID: 1, ProductID: 11, Quantity: 1, Price: types.NewMoney(500, "USD"), Total: types.NewMoney(500, "USD")},
+			{ID: 3, OrderID: 2, ProductID: 10, Quantity: 1, Price: types.NewMoney(500, "USD"), Total: types.NewMoney(500, "USD")},
+		},
+		stock: map[int]int{},
+	}
+	store := newMockPaymentStore()
+	service := NewService(NewFakeProvider("secret", 5*time.Minute), store, orderStore)
+	handler := NewHandler(service, store, orderStore, userStore)
+
+	router := mux.NewRouter()
+	handler.RegisterRoutes(router)
+
+	// orders 1 and 3 are paid, order 2 stays pending
+	for _, id := range []int{1, 3} {
+		order, _ := orderStore.GetOrderByID(id)
+		if _, err := service.PayOrder(order, types.PaymentMethod{CardNumber: CardSuccess}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	refund := func(orderID, userID int, payload any) *httptest.ResponseRecorder {
+		marshalled, _ := json.Marshal(payload)
+		req, err := http.NewRequest(http.MethodPost, fmt.Sprintf("/orders/%d/refunds", orderID), bytes.NewBuffer(marshalled))
+		if err != nil {
+			t.Fatal(err)
+		}
+		authtest.SignIn(req, userID)
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		return rr
+	}
+
+	t.Run("should refund a single item and restock it", func(t *testing.T) {
+		rr := refund(1, 3, types.CreateRefundPayload{
+			Items:   []types.RefundItem{{OrderItemID: 1, Quantity: 1}},
+			Restock: true,
+		})
+		if rr.Code != http.StatusCreated {
+			t.Fatalf("expected status code %d, got %d: %s", http.StatusCreated, rr.Code, rr.Body)
+		}
+
+		var created types.Refund
+		json.NewDecoder(rr.Body).Decode(&created)
+		if created.Amount.Amount != 1000 || created.ProviderRefundID == "" {
+			t.Errorf("unexpected refund %+v", created)
+		}
+		if orderStore.orders[1].Status != types.OrderStatusPartiallyRefunded || orderStore.orders[1].RefundedTotal.Amount != 1000 {
+			t.Errorf("unexpected order %+v", orderStore.orders[1])
+		}
+		if orderStore.stock[10] != 1 {
+			t.Errorf("expected 1 unit restocked, got %d", orderStore.stock[10])
+		}
+	})
+
+	t.Run("should not refund more units than are left", func(t *testing.T) {
+		rr := refund(1, 3, types.CreateRefundPayload{
+			Items: []types.RefundItem{{OrderItemID: 1, Quantity: 2}},
+		})
+		if rr.Code != http.StatusBadRequest {
+			t.Errorf("expected status code %d, got %d", http.StatusBadRequest, rr.Code)
+		}
+	})
+
+	t.Run("should not refund items of another order", func(t *testing.T) {
+		rr := refund(1, 3, types.CreateRefundPayload{
+			Items: []types.RefundItem{{OrderItemID: 3, Quantity: 1}},
+		})
+		if rr.Code != http.StatusBadRequest {
+			t.Errorf("expected status code %d, got %d", http.StatusBadRequest, rr.Code)
+		}
+	})
+
+	t.Run("should not refund more than is left of the payment", func(t *testing.T) {
+		rr := refund(1, 3, types.CreateRefundPayload{Amount: types.NewMoney(1501, "USD")})
+		if rr.Code != http.StatusBadRequest {
+			t.Errorf("expected status code %d, got %d", http.StatusBadRequest, rr.Code)
+		}
+	})
+
+	t.Run("should reject items and an amount together", func(t *testing.T) {
+		rr := refund(1, 3, types.CreateRefundPayload{
+			Items:  []types.RefundItem{{OrderItemID: 2, Quantity: 1}},
+			Amount: types.NewMoney(500, "USD"),
+		})
+		if rr.Code != http.StatusBadRequest {
+			t.Errorf("expected status code %d, got %d", http.StatusBadRequest, rr.Code)
+		}
+	})
+
+	t.Run("should mark the order refunded once everything is back", func(t *testing.T) {
+		rr := refund(1, 3, types.CreateRefundPayload{Amount: types.NewMoney(1500, "USD")})
+		if rr.Code != http.StatusCreated {
+			t.Fatalf("expected status code %d, got %d: %s", http.StatusCreated, rr.Code, rr.Body)
+		}
+		if orderStore.orders[1].Status != types.OrderStatusRefunded || orderStore.orders[1].RefundedTotal.Amount != 2500 {
+			t.Errorf("unexpected order %+v", orderStore.orders[1])
+		}
+	})
+
+	t.Run("should read the amount in the order's currency", func(t *testing.T) {
+		rr := refund(3, 3, map[string]string{"amount": "1000"})
+		if rr.Code != http.StatusCreated {
+			t.Fatalf("expected status code %d, got %d: %s", http.StatusCreated, rr.Code, rr.Body)
+		}
+		if got := orderStore.orders[3].RefundedTotal; got.Amount != 1000 || got.Currency != "JPY" {
+			t.Errorf("expected 1000 JPY refunded, got %d %s", got.Amount, got.Currency)
+		}
+	})
+
+	t.Run("should reject decimals a currency doesn't have", func(t *testing.T) {
+		rr := refund(3, 3, map[string]string{"amount": "10.50"})
+		if rr.Code != http.StatusBadRequest {
+			t.Errorf("expected status code %d, got %d", http.StatusBadRequest, rr.Code)
+		}
+	})
+
+	t.Run("should not refund an unpaid order", func(t *testing.T) {
+		rr := refund(2, 3, types.CreateRefundPayload{Amount: types.NewMoney(100, "USD")})
+		if rr.Code != http.StatusConflict {
+			t.Errorf("expected status code %d, got %d", http.StatusConflict, rr.Code)
+		}
+	})
+
+	t.Run("should only let admins refund", func(t *testing.T) {
+		rr := refund(1, 1, types.CreateRefundPayload{Amount: types.NewMoney(100, "USD")})
+		if rr.Code != http.StatusForbidden {
+			t.Errorf("expected status code %d, got %d", http.StatusForbidden, rr.Code)
+		}
+	})
+}
+
+// refunds of the same units racing each other must not both go through
+func TestConcurrentRefunds(t *testing.T) {
+	const refunds = 10
+
+	orderStore := &mockOrderStore{
+		orders: map[int]*types.Order{
+			1: {ID: 1, UserID: 1, Total: types.NewMoney(3000, "USD"), Status: types.OrderStatusPending},
+		},
+		items: []types.OrderItem{
+			{ID: 1, OrderID: 1, ProductID: 10, Quantity: 1, Price: types.NewMoney(1000, "USD"), Total: types.NewMoney(1000, "USD")},
+			{ID: 2, OrderID: 1, ProductID: 11, Quantity: 1, Price: types.NewMoney(2000, "USD"), Total: types.NewMoney(2000, "USD")},
+		},
+		stock: map[int]int{},
+	}
+	service := NewService(NewFakeProvider("secret", 5*time.Minute), newMockPaymentStore(), orderStore)
+
+	order, _ := orderStore.GetOrderByID(1)
+	if _, err := service.PayOrder(order, types.PaymentMethod{CardNumber: CardSuccess}); err != nil {
+		t.Fatal(err)
+	}
+
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	succeeded := 0
+	for i := 0; i < refunds; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			// every request saw the order before any refund
+			_, err := service.RefundOrder(order, types.CreateRefundPayload{
+				Items:   []types.RefundItem{{OrderItemID: 1, Quantity: 1}},
+				Restock: true,
+			}, 3)
+
+			mu.Lock()
+			defer mu.Unlock()
+			switch {
+			case err == nil:
+				succeeded++
+			case !errors.Is(err, ErrInvalidRefund):
+				t.Errorf("unexpected error: %v", err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if succeeded != 1 {
+		t.Errorf("expected 1 refund to go through, got %d", succeeded)
+	}
+	if got := orderStore.orders[1].RefundedTotal.Amount; got != 1000 {
+		t.Errorf("expected 10.00 refunded, got %d", got)
+	}
+	if orderStore.stock[10] != 1 {
+		t.Errorf("expected 1 unit restocked, got %d", orderStore.stock[10])
+	}
+}
+
+func TestRefundDiscountedTaxedItems(t *testing.T) {
+	// 3 units at 10.00 with 1.00 off the line and 10% tax on top
+	orderStore := &mockOrderStore{
+		orders: map[int]*types.Order{
+			1: {ID: 1, UserID: 1, Total: types.NewMoney(3190, "USD"), DiscountTotal: types.NewMoney(100, "USD"), TaxTotal: types.NewMoney(290, "USD"), Status: types.OrderStatusPending},
+		},
+		items: []types.OrderItem{
+			{
+				ID: 1, OrderID: 1, ProductID: 10, Quantity: 3, Price: types.NewMoney(1000, "USD"),
+				Discount: types.NewMoney(100, "USD"), Tax: types.NewMoney(290, "USD"), Total: types.NewMoney(3190, "USD"),
+			},
+		},
+		stock: map[int]int{},
+	}
+	service := NewService(NewFakeProvider("secret", 5*time.Minute), newMockPaymentStore(), orderStore)
+
+	order, _ := orderStore.GetOrderByID(1)
+	if _, err := service.PayOrder(order, types.PaymentMethod{CardNumber: CardSuccess}); err != nil {
+		t.Fatal(err)
+	}
+
+	// each unit gives back its share of what the line cost, and the shares
+	// add up to the whole line
+	for _, want := range []int64{1063, 1064, 1063} {
+		order, _ := orderStore.GetOrderByID(1)
+		refund, err := service.RefundOrder(order, types.CreateRefundPayload{
+			Items: []types.RefundItem{{OrderItemID: 1, Quantity: 1}},
+		}, 3)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if refund.Amount.Amount != want {
+			t.Errorf("expected a refund of %d, got %d", want, refund.Amount.Amount)
+		}
+	}
+
+	if order := orderStore.orders[1]; order.Status != types.OrderStatusRefunded || order.RefundedTotal.Amount != 3190 {
+		t.Errorf("unexpected order %+v", order)
+	}
+}
+
+func TestRefundCancelledOrder(t *testing.T) {
+	orderStore := &mockOrderStore{
+		orders: map[int]*types.Order{
+			1: {ID: 1, UserID: 1, Total: types.NewMoney(2500, "USD"), Status: types.OrderStatusPending},
+			2: {ID: 2, UserID: 1, Total: types.NewMoney(500, "USD"), Status: types.OrderStatusPaid},
+		},
+		items: []types.OrderItem{
+			{ID: 1, OrderID: 1, ProductID: 10, Quantity: 2, Price: types.NewMoney(1250, "USD"), Total: types.NewMoney(2500, "USD")},
+		},
+		stock: map[int]int{},
+	}
+	store := newMockPaymentStore()
+	service := NewService(NewFakeProvider("secret", 5*time.Minute), store, orderStore)
+
+	order, _ := orderStore.GetOrderByID(1)
+	if _, err := service.PayOrder(order, types.PaymentMethod{CardNumber: CardSuccess}); err != nil {
+		t.Fatal(err)
+	}
+
+	cancel := func(orderID int) error {
+		return orderStore.WithinTx(func(tx types.OrderTx) error {
+			order, err := tx.GetOrderForUpdate(orderID)
+			if err != nil {
+				return err
+			}
+			if err := tx.CancelOrder(order, 1, "changed my mind"); err != nil {
+				return err
+			}
+			return service.RefundCancelledOrder(tx, order, 1, "changed my mind")
+		})
+	}
+
+	t.Run("should refund the whole payment and keep the order cancelled", func(t *testing.T) {
+		if err := cancel(1); err != nil {
+			t.Fatal(err)
+		}
+		order := orderStore.orders[1]
+		if order.Status != types.OrderStatusCancelled {
+			t.Errorf("expected status %s, got %s", types.OrderStatusCancelled, order.Status)
+		}
+		if order.RefundedTotal.Amount != 2500 {
+			t.Errorf("expected 25.00 refunded, got %d", order.RefundedTotal.Amount)
+		}
+		if orderStore.stock[10] != 2 {
+			t.Errorf("expected 2 units restocked, got %d", orderStore.stock[10])
+		}
+	})
+
+	t.Run("should cancel an order marked paid without a payment", func(t *testing.T) {
+		if err := cancel(2); err != nil {
+			t.Fatal(err)
+		}
+		if order := orderStore.orders[2]; order.Status != types.OrderStatusCancelled || order.RefundedTotal.Amount != 0 {
+			t.Errorf("unexpected order %+v", order)
+		}
+	})
+}
+
+// a second payment request that starts while the first is talking to the
+// provider must not charge the customer again
+func TestPayOrderStartedTwice(t *testing.T) {
+	orderStore := &mockOrderStore{orders: map[int]*types.Order{
+		1: {ID: 1, UserID: 1, Total: types.NewMoney(1999, "USD"), Status: types.OrderStatusPending},
+	}}
+	store := newMockPaymentStore()
+	provider := &racingProvider{FakeProvider: NewFakeProvider("secret", 5*time.Minute)}
+	service := NewService(provider, store, orderStore)
+
+	// the other request records its attempt between our check and ours
+	provider.beforeIntent = func() {
+		store.CreatePayment(types.Payment{OrderID: 1, Status: types.PaymentStatusRequiresPaymentMethod})
+	}
+
+	order, _ := orderStore.GetOrderByID(1)
+	_, err := service.PayOrder(order, types.PaymentMethod{CardNumber: CardSuccess})
+	if !errors.Is(err, ErrPaymentInProgress) {
+		t.Fatalf("expected ErrPaymentInProgress, got %v", err)
+	}
+	if provider.confirmed != 0 {
+		t.Errorf("expected no payment to be confirmed, got %d", provider.confirmed)
+	}
+}
+
+type racingProvider struct {
+	*FakeProvider
+	beforeIntent func()
+	confirmed    int
+}
+
+func (p *racingProvider) CreateIntent(orderID int, amount int64, currency string) (*types.PaymentIntent, error) {
+	p.beforeIntent()
+	return p.FakeProvider.CreateIntent(orderID, amount, currency)
+}
+
+func (p *racingProvider) Confirm(intentID string, method types.PaymentMethod) (*types.PaymentIntent, error) {
+	p.confirmed++
+	return p.FakeProvider.Confirm(intentID, method)
+}
+
+type mockPaymentStore struct {
+	payments map[int]*types.Payment
+	events   map[string]bool
+}
+
+func newMockPaymentStore() *mockPaymentStore {
+	return &mockPaymentStore{
+		payments: make(map[int]*types.Payment),
+		events:   make(map[string]bool),
+	}
+}
+
+func (m *mockPaymentStore) CreatePayment(payment types.Payment) (int, error) {
+	for _, p := range m.payments {
+		if p.OrderID == payment.OrderID && p.Status != types.PaymentStatusFailed {
+			return 0, ErrPaymentInProgress
+		}
+	}
+	payment.ID = len(m.payments) + 1
+	m.payments[payment.ID] = &payment
+	return payment.ID, nil
+}
+
+func (m *mockPaymentStore) UpdatePayment(payment types.Payment) error {
+	existing, ok := m.payments[payment.ID]
+	if !ok {
+		return fmt.Errorf("payment not found")
+	}
+	existing.Status = payment.Status
+	existing.FailureReason = payment.FailureReason
+	return nil
+}
+
+func (m *mockPaymentStore) GetPaymentByID(id int) (*types.Payment, error) {
+	payment, ok := m.payments[id]
+	if !ok {
+		return nil, fmt.Errorf("payment not found")
+	}
+	copied := *payment
+	return &copied, nil
+}
+
+func (m *mockPaymentStore) GetPaymentsByOrderID(orderID int) ([]types.Payment, error) {
+	payments := []types.Payment{}
+	for id := 1; id <= len(m.payments); id++ {
+		if m.payments[id].OrderID == orderID {
+			payments = append(payments, *m.payments[id])
+		}
+	}
+	return payments, nil
+}
+
+func (m *mockPaymentStore) GetPaymentByProviderIntentID(provider, intentID string) (*types.Payment, error) {
+	for _, payment := range m.payments {
+		if payment.Provider == provider && payment.ProviderIntentID == intentID {
+			copied := *payment
+			return &copied, nil
+		}
+	}
+	return nil, fmt.Errorf("payment not found")
+}
+
+func (m *mockPaymentStore) RecordWebhookEvent(provider, eventID string, eventType types.PaymentEventType) (bool, error) {
+	key := provider + "/" + eventID
+	if m.events[key] {
+		return false, nil
+	}
+	m.events[key] = true
+	return true, nil
+}
+
+func (m *mockPaymentStore) DeleteWebhookEvent(provider, eventID string) error {
+	delete(m.events, provider+"/"+eventID)
+	return nil
+}
+
+type mockOrderStore struct {
+	mu     sync.Mutex
+	orders map[int]*types.Order
+	items  []types.OrderItem
+	stock  map[int]int
+}
+
+func (m *mockOrderStore) GetOrderByID(id int) (*types.Order, error) {
+	order, ok := m.orders[id]
+	if !ok {
+		return nil, fmt.Errorf("order not found")
+	}
+	copied := *order
+	return &copied, nil
+}
+
+func (m *mockOrderStore) GetOrdersByUserID(userID int, limit, offset int) ([]types.Order, error) {
+	return nil, nil
+}
+
+func (m *mockOrderStore) CountOrdersByUserID(userID int) (int, error) {
+	return 0, nil
+}
+
+func (m *mockOrderStore) GetOrderItemsByOrderID(orderID int) ([]types.OrderItem, error) {
+	items := []types.OrderItem{}
+	for _, item := range m.items {
+		if item.OrderID == orderID {
+			items = append(items, item)
+		}
+	}
+	return items, nil
+}
+
+func (m *mockOrderStore) GetOrderDiscountsByOrderID(orderID int) ([]types.OrderDiscount, error) {
+	return []types.OrderDiscount{}, nil
+}
+
+func (m *mockOrderStore) UpdateOrderStatus(orderID int, from, to types.OrderStatus, changedBy int, reason string) error {
+	order, ok := m.orders[orderID]
+	if !ok || order.Status != from {
+		return fmt.Errorf("order status was changed concurrently")
+	}
+	order.Status = to
+	return nil
+}
+
+func (m *mockOrderStore) GetOrderStatusHistory(orderID int) ([]types.OrderStatusChange, error) {
+	return nil, nil
+}
+
+// the order is locked for the whole of fn, like the row lock does
+func (m *mockOrderStore) WithinTx(fn func(tx types.OrderTx) error) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return fn(&mockOrderTx{store: m})
+}
+
+type mockOrderTx struct {
+	store *mockOrderStore
+}
+
+func (t *mockOrderTx) GetOrderForUpdate(id int) (*types.Order, error) {
+	return t.store.GetOrderByID(id)
+}
+
+func (t *mockOrderTx) GetOrderItems(orderID int) ([]types.OrderItem, error) {
+	return t.store.GetOrderItemsByOrderID(orderID)
+}
+
+func (t *mockOrderTx) RecordRefund(refund types.Refund) (int, error) {
+	m := t.store
+	order, ok := m.orders[refund.OrderID]
+	if !ok {
+		return 0, fmt.Errorf("order not found")
+	}
+	for _, refunded := range refund.Items {
+		for i := range m.items {
+			if m.items[i].ID == refunded.OrderItemID {
+				m.items[i].RefundedQuantity += refunded.Quantity
+				if refund.Restock {
+					m.stock[m.items[i].ProductID] += refunded.Quantity
+				}
+			}
+		}
+	}
+	order.RefundedTotal = order.RefundedTotal.Add(refund.Amount)
+	if order.Status == types.OrderStatusCancelled {
+		return 1, nil
+	}
+	order.Status = types.OrderStatusPartiallyRefunded
+	if order.RefundedTotal.Cmp(order.Total) >= 0 {
+		order.Status = types.OrderStatusRefunded
+	}
+	return 1, nil
+}
+
+func (t *mockOrderTx) CancelOrder(order *types.Order, cancelledBy int, reason string) error {
+	m := t.store
+	for _, item := range m.items {
+		if item.OrderID == order.ID {
+			m.stock[item.ProductID] += item.Quantity
+		}
+	}
+	m.orders[order.ID].Status = types.OrderStatusCancelled
+	return nil
+}
+
+var userStore = &authtest.UserStore{Roles: map[int]string{3: types.RoleAdmin}, Default: types.RoleCustomer}
@@ -0,0 +1,411 @@
+package payment
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/eugenius-watchman/ecom_go_rest_api/types"
+)
+
+var (
+	ErrOrderNotPayable    = errors.New("order is not awaiting payment")
+	ErrPaymentDeclined    = errors.New("payment declined")
+	ErrOrderNotRefundable = errors.New("order has no captured payment to refund")
+	ErrInvalidRefund      = errors.New("invalid refund")
+	// returned when the order already has a payment that hasn't failed
+	ErrPaymentInProgress = errors.New("order already has a payment in progress")
+)
+
+// Service drives a payment through the provider and keeps the payments
+// table and the order status in step with it
+type Service struct {
+	provider   types.PaymentProvider
+	store      types.PaymentStore
+	orderStore types.OrderStore
+}
+
+func NewService(provider types.PaymentProvider, store types.PaymentStore, orderStore types.OrderStore) *Service {
+	return &Service{
+		provider:   provider,
+		store:      store,
+		orderStore: orderStore,
+	}
+}
+
+// PayOrder starts a new payment attempt for a pending order. An attempt
+// left waiting on the customer, like an abandoned 3-D Secure challenge, is
+// cancelled and replaced.
+func (s *Service) PayOrder(order *types.Order, method types.PaymentMethod) (*types.Payment, error) {
+	if order.Status != types.OrderStatusPending {
+		return nil, ErrOrderNotPayable
+	}
+
+	// spares the provider a call ... CreatePayment checks again under the
+	// order's lock
+	payments, err := s.store.GetPaymentsByOrderID(order.ID)
+	if err != nil {
+		return nil, err
+	}
+	for i := range payments {
+		if err := s.supersede(&payments[i]); err != nil {
+			return nil, err
+		}
+	}
+
+	// an intent charges nothing until confirmed, so one left behind by a
+	// refused attempt costs the customer nothing
+	intent, err := s.provider.CreateIntent(order.ID, order.Total.Amount, order.Total.Currency)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create payment intent: %w", err)
+	}
+
+	payment := types.Payment{
+		OrderID:          order.ID,
+		Provider:         s.provider.Name(),
+		ProviderIntentID: intent.ID,
+		Amount:           types.NewMoney(intent.Amount, intent.Currency),
+		Currency:         intent.Currency,
+		Status:           intent.Status,
+	}
+
+	payment.ID, err = s.store.CreatePayment(payment)
+	if err != nil {
+		return nil, err
+	}
+
+	return s.confirm(order, &payment, method)
+}
+
+// cancels an attempt that can't have taken money yet ... once the provider
+// has cancelled the intent it can never be charged, so a customer finishing
+// the old challenge late can't pay twice
+func (s *Service) supersede(payment *types.Payment) error {
+	switch payment.Status {
+	case types.PaymentStatusFailed:
+		return nil
+	case types.PaymentStatusRequiresPaymentMethod, types.PaymentStatusRequiresAction:
+	default:
+		return ErrPaymentInProgress
+	}
+
+	// the provider refuses once the intent has moved on, e.g. the customer
+	// just passed 3-D Secure
+	if _, err := s.provider.Cancel(payment.ProviderIntentID); err != nil {
+		return fmt.Errorf("%w: %v", ErrPaymentInProgress, err)
+	}
+
+	payment.Status = types.PaymentStatusFailed
+	payment.FailureReason = "superseded by a new payment attempt"
+	return s.store.UpdatePayment(*payment)
+}
+
+// ConfirmPayment resumes a payment waiting on customer action (3-D Secure)
+func (s *Service) ConfirmPayment(order *types.Order, payment *types.Payment, method types.PaymentMethod) (*types.Payment, error) {
+	if payment.Status != types.PaymentStatusRequiresAction {
+		return nil, fmt.Errorf("payment does not require confirmation")
+	}
+
+	if order.Status != types.OrderStatusPending {
+		return nil, ErrOrderNotPayable
+	}
+
+	return s.confirm(order, payment, method)
+}
+
+func (s *Service) confirm(order *types.Order, payment *types.Payment, method types.PaymentMethod) (*types.Payment, error) {
+	intent, err := s.provider.Confirm(payment.ProviderIntentID, method)
+	if err != nil {
+		// nothing was taken, fail the attempt so the order can be paid again
+		payment.Status = types.PaymentStatusFailed
+		payment.FailureReason = err.Error()
+		if err := s.store.UpdatePayment(*payment); err != nil {
+			return nil, err
+		}
+		return nil, fmt.Errorf("failed to confirm payment: %w", err)
+	}
+
+	if intent.Status == types.PaymentStatusRequiresCapture {
+		captured, err := s.provider.Capture(payment.ProviderIntentID)
+		if err != nil {
+			// the money is held, the attempt stays open for the webhook
+			payment.Status = intent.Status
+			if err := s.store.UpdatePayment(*payment); err != nil {
+				return nil, err
+			}
+			return nil, fmt.Errorf("failed to capture payment: %w", err)
+		}
+		intent = captured
+	}
+
+	payment.Status = intent.Status
+	payment.FailureReason = intent.FailureReason
+	if err := s.store.UpdatePayment(*payment); err != nil {
+		return nil, err
+	}
+
+	switch payment.Status {
+	case types.PaymentStatusFailed:
+		return payment, fmt.Errorf("%w: %s", ErrPaymentDeclined, payment.FailureReason)
+	case types.PaymentStatusSucceeded:
+		// money is captured ... the order is paid
+		err := s.orderStore.UpdateOrderStatus(order.ID, types.OrderStatusPending, types.OrderStatusPaid, 0, "payment captured")
+		if err != nil {
+			return payment, err
+		}
+		order.Status = types.OrderStatusPaid
+	}
+
+	return payment, nil
+}
+
+// RefundOrder gives money back on a paid order, either for some of its
+// items at their purchase price or for a plain amount. The refund can never
+// exceed what was captured minus what was already refunded ... the order
+// stays locked from that check until the refund is recorded, so concurrent
+// refunds see each other.
+func (s *Service) RefundOrder(order *types.Order, payload types.CreateRefundPayload, refundedBy int) (*types.Refund, error) {
+	var refund types.Refund
+	var issued string
+
+	err := s.orderStore.WithinTx(func(tx types.OrderTx) error {
+		locked, err := tx.GetOrderForUpdate(order.ID)
+		if err != nil {
+			return err
+		}
+
+		switch locked.Status {
+		case types.OrderStatusPaid, types.OrderStatusShipped, types.OrderStatusDelivered, types.OrderStatusPartiallyRefunded:
+		default:
+			return ErrOrderNotRefundable
+		}
+
+		payment, err := s.capturedPayment(locked.ID)
+		if err != nil {
+			return err
+		}
+
+		refund = types.Refund{
+			OrderID:   locked.ID,
+			PaymentID: payment.ID,
+			Currency:  payment.Currency,
+			Reason:    payload.Reason,
+			Restock:   payload.Restock,
+			CreatedBy: refundedBy,
+		}
+
+		if len(payload.Items) > 0 {
+			refund.Items, refund.Amount, err = refundItems(tx, locked.ID, payload.Items)
+			if err != nil {
+				return err
+			}
+		} else {
+			if payload.Restock {
+				return fmt.Errorf("%w: restock needs the refunded items", ErrInvalidRefund)
+			}
+			if payload.Amount.Currency != payment.Currency {
+				return fmt.Errorf("%w: amount in %q for a %s payment", ErrInvalidRefund, payload.Amount.Currency, payment.Currency)
+			}
+			refund.Amount = payload.Amount
+		}
+
+		refundable := payment.Amount.Sub(locked.RefundedTotal)
+		if refund.Amount.Cmp(refundable) > 0 {
+			return fmt.Errorf("%w: amount exceeds the %s still refundable", ErrInvalidRefund, refundable)
+		}
+
+		providerRefund, err := s.provider.Refund(payment.ProviderIntentID, refund.Amount.Amount)
+		if err != nil {
+			return fmt.Errorf("failed to refund payment: %w", err)
+		}
+		refund.ProviderRefundID = providerRefund.ID
+		issued = providerRefund.ID
+
+		refund.ID, err = tx.RecordRefund(refund)
+		return err
+	})
+	if err != nil {
+		if issued != "" {
+			// the money has gone back already, make that visible in the logs
+			return nil, fmt.Errorf("refund %s was issued but not recorded: %w", issued, err)
+		}
+		return nil, err
+	}
+
+	return &refund, nil
+}
+
+// RefundCancelledOrder refunds what is left of a paid order's payment as
+// part of cancelling it, inside the cancellation's transaction ... an order
+// marked paid by hand has nothing to refund
+func (s *Service) RefundCancelledOrder(tx types.OrderTx, order *types.Order, refundedBy int, reason string) error {
+	payment, err := s.capturedPayment(order.ID)
+	if err != nil {
+		if errors.Is(err, ErrOrderNotRefundable) {
+			return nil
+		}
+		return err
+	}
+
+	amount := payment.Amount.Sub(order.RefundedTotal)
+	if amount.Amount <= 0 {
+		return nil
+	}
+
+	providerRefund, err := s.provider.Refund(payment.ProviderIntentID, amount.Amount)
+	if err != nil {
+		return fmt.Errorf("failed to refund payment: %w", err)
+	}
+
+	_, err = tx.RecordRefund(types.Refund{
+		OrderID:          order.ID,
+		PaymentID:        payment.ID,
+		ProviderRefundID: providerRefund.ID,
+		Amount:           amount,
+		Currency:         payment.Currency,
+		Reason:           reason,
+		CreatedBy:        refundedBy,
+	})
+	if err != nil {
+		return fmt.Errorf("refund %s was issued but not recorded: %w", providerRefund.ID, err)
+	}
+
+	return nil
+}
+
+// money captured after the order was cancelled goes straight back ... a
+// redelivered event finds nothing left to refund
+func (s *Service) refundLateCapture(orderID int) error {
+	err := s.orderStore.WithinTx(func(tx types.OrderTx) error {
+		order, err := tx.GetOrderForUpdate(orderID)
+		if err != nil {
+			return err
+		}
+
+		if order.Status != types.OrderStatusCancelled {
+			return nil
+		}
+
+		return s.RefundCancelledOrder(tx, order, 0, "payment captured after cancellation (webhook)")
+	})
+	if err != nil {
+		return fmt.Errorf("failed to refund the payment captured for cancelled order %d: %w", orderID, err)
+	}
+
+	return nil
+}
+
+func (s *Service) capturedPayment(orderID int) (*types.Payment, error) {
+	payments, err := s.store.GetPaymentsByOrderID(orderID)
+	if err != nil {
+		return nil, err
+	}
+
+	for i := range payments {
+		if payments[i].Status == types.PaymentStatusSucceeded {
+			return &payments[i], nil
+		}
+	}
+
+	return nil, ErrOrderNotRefundable
+}
+
+// checks the requested quantities against what is left to refund on each
+// order item and prices them ... repeated lines are merged
+func refundItems(tx types.OrderTx, orderID int, requested []types.RefundItem) ([]types.RefundItem, types.Money, error) {
+	orderItems, err := tx.GetOrderItems(orderID)
+	if err != nil {
+		return nil, types.Money{}, err
+	}
+
+	byID := make(map[int]types.OrderItem, len(orderItems))
+	for _, item := range orderItems {
+		byID[item.ID] = item
+	}
+
+	quantities := make(map[int]int)
+	var order []int
+	for _, item := range requested {
+		if _, ok := quantities[item.OrderItemID]; !ok {
+			order = append(order, item.OrderItemID)
+		}
+		quantities[item.OrderItemID] += item.Quantity
+	}
+
+	var amount types.Money
+	items := make([]types.RefundItem, 0, len(order))
+	for _, id := range order {
+		orderItem, ok := byID[id]
+		if !ok {
+			return nil, types.Money{}, fmt.Errorf("%w: order item %d is not part of this order", ErrInvalidRefund, id)
+		}
+
+		quantity := quantities[id]
+		if left := orderItem.Quantity - orderItem.RefundedQuantity; quantity > left {
+			return nil, types.Money{}, fmt.Errorf("%w: only %d of order item %d left to refund", ErrInvalidRefund, left, id)
+		}
+
+		// units give back their share of what the line cost, discount and tax
+		// included, taken cumulatively so partial refunds add up to the line
+		// exactly
+		units := int64(orderItem.Quantity)
+		before := int64(orderItem.RefundedQuantity)
+		after := before + int64(quantity)
+
+		amount = amount.Add(orderItem.Total.MulRate(after, units).Sub(orderItem.Total.MulRate(before, units)))
+		items = append(items, types.RefundItem{OrderItemID: id, Quantity: quantity})
+	}
+
+	return items, amount, nil
+}
+
+func (s *Service) ProviderName() string {
+	return s.provider.Name()
+}
+
+// HandleWebhookEvent applies a verified provider event. Applying the same
+// outcome twice changes nothing so redelivered events are harmless.
+func (s *Service) HandleWebhookEvent(event *types.PaymentEvent) error {
+	payment, err := s.store.GetPaymentByProviderIntentID(s.provider.Name(), event.IntentID)
+	if err != nil {
+		return err
+	}
+
+	switch event.Type {
+	case types.PaymentEventSucceeded:
+		if payment.Status != types.PaymentStatusSucceeded {
+			payment.Status = types.PaymentStatusSucceeded
+			payment.FailureReason = ""
+			if err := s.store.UpdatePayment(*payment); err != nil {
+				return err
+			}
+		}
+
+		order, err := s.orderStore.GetOrderByID(payment.OrderID)
+		if err != nil {
+			return err
+		}
+
+		switch order.Status {
+		case types.OrderStatusPending:
+			return s.orderStore.UpdateOrderStatus(order.ID, types.OrderStatusPending, types.OrderStatusPaid, 0, "payment captured (webhook)")
+		case types.OrderStatusCancelled:
+			return s.refundLateCapture(order.ID)
+		}
+
+		// anything else past pending has already seen this payment
+		return nil
+
+	case types.PaymentEventFailed:
+		// a late failure never undoes a captured payment
+		if payment.Status == types.PaymentStatusSucceeded || payment.Status == types.PaymentStatusFailed {
+			return nil
+		}
+
+		payment.Status = types.PaymentStatusFailed
+		payment.FailureReason = event.FailureReason
+		return s.store.UpdatePayment(*payment)
+	}
+
+	// unknown event types are acknowledged and ignored
+	return nil
+}
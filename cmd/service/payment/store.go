@@ -0,0 +1,205 @@
+package payment
+
+import (
+	"database/sql"
+	"fmt"
+
+	"github.com/eugenius-watchman/ecom_go_rest_api/types"
+)
+
+type Store struct {
+	db *sql.DB
+}
+
+func NewStore(db *sql.DB) *Store {
+	return &Store{db: db}
+}
+
+func (s *Store) CreatePayment(payment types.Payment) (int, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return 0, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	// lock the order so two attempts started together can't both go ahead
+	var status types.OrderStatus
+	err = tx.QueryRow("SELECT status FROM orders WHERE id = ? FOR UPDATE", payment.OrderID).Scan(&status)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return 0, fmt.Errorf("order not found")
+		}
+		return 0, fmt.Errorf("failed to get order: %w", err)
+	}
+
+	if status != types.OrderStatusPending {
+		return 0, ErrOrderNotPayable
+	}
+
+	var open bool
+	const openQuery = `
+		SELECT EXISTS(SELECT 1 FROM payments WHERE orderId = ? AND status <> ? LOCK IN SHARE MODE)`
+
+	if err := tx.QueryRow(openQuery, payment.OrderID, types.PaymentStatusFailed).Scan(&open); err != nil {
+		return 0, fmt.Errorf("failed to check payments: %w", err)
+	}
+	if open {
+		return 0, ErrPaymentInProgress
+	}
+
+	const query = `
+		INSERT INTO payments (orderId, provider, providerIntentId, amount, currency, status, failureReason)
+		VALUES (?, ?, ?, ?, ?, ?, ?)`
+
+	result, err := tx.Exec(
+		query,
+		payment.OrderID,
+		payment.Provider,
+		payment.ProviderIntentID,
+		payment.Amount.Amount,
+		payment.Currency,
+		payment.Status,
+		payment.FailureReason,
+	)
+	if err != nil {
+		return 0, fmt.Errorf("failed to create payment: %w", err)
+	}
+
+	paymentID, err := result.LastInsertId()
+	if err != nil {
+		return 0, fmt.Errorf("failed to get payment ID: %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, fmt.Errorf("failed to commit transaction: %w", err)
+	}
+
+	return int(paymentID), nil
+}
+
+// only status and failure reason change after a payment is created
+func (s *Store) UpdatePayment(payment types.Payment) error {
+	const query = `
+		UPDATE payments SET status = ?, failureReason = ?
+		WHERE id = ?`
+
+	if _, err := s.db.Exec(query, payment.Status, payment.FailureReason, payment.ID); err != nil {
+		return fmt.Errorf("failed to update payment: %w", err)
+	}
+
+	return nil
+}
+
+func (s *Store) GetPaymentByID(id int) (*types.Payment, error) {
+	const query = `
+		SELECT id, orderId, provider, providerIntentId, amount, currency, status, failureReason, createdAt, updatedAt
+		FROM payments WHERE id = ?`
+
+	payment, err := scanRowIntoPayment(s.db.QueryRow(query, id))
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, fmt.Errorf("payment not found")
+		}
+		return nil, fmt.Errorf("failed to get payment: %w", err)
+	}
+
+	return payment, nil
+}
+
+func (s *Store) GetPaymentsByOrderID(orderID int) ([]types.Payment, error) {
+	const query = `
+		SELECT id, orderId, provider, providerIntentId, amount, currency, status, failureReason, createdAt, updatedAt
+		FROM payments WHERE orderId = ?
+		ORDER BY id`
+
+	rows, err := s.db.Query(query, orderID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query payments: %w", err)
+	}
+	defer rows.Close()
+
+	payments := []types.Payment{}
+	for rows.Next() {
+		payment, err := scanRowIntoPayment(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan payment: %w", err)
+		}
+		payments = append(payments, *payment)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("rows iteration error: %w", err)
+	}
+
+	return payments, nil
+}
+
+func (s *Store) GetPaymentByProviderIntentID(provider, intentID string) (*types.Payment, error) {
+	const query = `
+		SELECT id, orderId, provider, providerIntentId, amount, currency, status, failureReason, createdAt, updatedAt
+		FROM payments WHERE provider = ? AND providerIntentId = ?`
+
+	payment, err := scanRowIntoPayment(s.db.QueryRow(query, provider, intentID))
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, fmt.Errorf("payment not found")
+		}
+		return nil, fmt.Errorf("failed to get payment: %w", err)
+	}
+
+	return payment, nil
+}
+
+func (s *Store) RecordWebhookEvent(provider, eventID string, eventType types.PaymentEventType) (bool, error) {
+	const query = `
+		INSERT IGNORE INTO payment_webhook_events (provider, eventId, type)
+		VALUES (?, ?, ?)`
+
+	result, err := s.db.Exec(query, provider, eventID, eventType)
+	if err != nil {
+		return false, fmt.Errorf("failed to record webhook event: %w", err)
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("failed to check rows affected: %w", err)
+	}
+
+	return rowsAffected == 1, nil
+}
+
+func (s *Store) DeleteWebhookEvent(provider, eventID string) error {
+	_, err := s.db.Exec("DELETE FROM payment_webhook_events WHERE provider = ? AND eventId = ?", provider, eventID)
+	if err != nil {
+		return fmt.Errorf("failed to delete webhook event: %w", err)
+	}
+
+	return nil
+}
+
+// scanner is satisfied by both *sql.Row and *sql.Rows
+type scanner interface {
+	Scan(dest ...any) error
+}
+
+func scanRowIntoPayment(row scanner) (*types.Payment, error) {
+	payment := new(types.Payment)
+	err := row.Scan(
+		&payment.ID,
+		&payment.OrderID,
+		&payment.Provider,
+		&payment.ProviderIntentID,
+		&payment.Amount.Amount, // BIGINT minor units
+		&payment.Currency,
+		&payment.Status,
+		&payment.FailureReason,
+		&payment.CreatedAt,
+		&payment.UpdatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+	payment.Amount.Currency = payment.Currency
+
+	return payment, nil
+}
@@ -0,0 +1,183 @@
+package product
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/eugenius-watchman/ecom_go_rest_api/config"
+	"github.com/eugenius-watchman/ecom_go_rest_api/types"
+)
+
+const (
+	defaultPageLimit = 20
+	maxPageLimit     = 100
+)
+
+var ErrInvalidCursor = errors.New("invalid cursor")
+
+// the sorts clients may ask for, anything else is rejected
+var sorts = map[types.ProductSort]bool{
+	types.ProductSortCreatedAt: true,
+	types.ProductSortPrice:     true,
+	types.ProductSortName:      true,
+}
+
+// ParseQuery reads the filters, ?sort=, ?cursor= and ?limit= of a product
+// listing priced in currency. Sorts are ascending, a leading "-" makes them
+// descending, and the newest products come first by default.
+func ParseQuery(values url.Values, currency string) (types.ProductQuery, error) {
+	query := types.ProductQuery{Sort: types.ProductSortCreatedAt, Desc: true, Limit: defaultPageLimit}
+
+	if v := values.Get("sort"); v != "" {
+		sort := types.ProductSort(strings.TrimPrefix(v, "-"))
+		if !sorts[sort] {
+			return query, fmt.Errorf("invalid sort %q", v)
+		}
+		query.Sort = sort
+		query.Desc = strings.HasPrefix(v, "-")
+	}
+
+	if v := values.Get("limit"); v != "" {
+		limit, err := strconv.Atoi(v)
+		if err != nil || limit < 1 || limit > maxPageLimit {
+			return query, fmt.Errorf("limit must be between 1 and %d", maxPageLimit)
+		}
+		query.Limit = limit
+	}
+
+	for _, price := range []struct {
+		param string
+		dest  **types.Money
+	}{{"minPrice", &query.MinPrice}, {"maxPrice", &query.MaxPrice}} {
+		v := values.Get(price.param)
+		if v == "" {
+			continue
+		}
+		amount, err := types.ParseMoney(v, config.Envs.DefaultCurrency)
+		if err != nil || amount.IsNegative() {
+			return query, fmt.Errorf("invalid %s %q", price.param, v)
+		}
+		*price.dest = &amount
+	}
+	if query.MinPrice != nil && query.MaxPrice != nil && query.MinPrice.Cmp(*query.MaxPrice) > 0 {
+		return query, fmt.Errorf("minPrice is more than maxPrice")
+	}
+
+	// the db filters and sorts on the base price, which only matches what
+	// the listing shows in the default currency
+	if currency != config.Envs.DefaultCurrency && (query.MinPrice != nil || query.MaxPrice != nil || query.Sort == types.ProductSortPrice) {
+		return query, fmt.Errorf("price filters and sorting are only available in %s", config.Envs.DefaultCurrency)
+	}
+
+	if v := values.Get("inStock"); v != "" {
+		inStock, err := strconv.ParseBool(v)
+		if err != nil {
+			return query, fmt.Errorf("invalid inStock %q", v)
+		}
+		query.InStock = inStock
+	}
+
+	if v := values.Get("category"); v != "" {
+		id, err := strconv.Atoi(v)
+		if err != nil || id < 1 {
+			return query, fmt.Errorf("invalid category %q", v)
+		}
+		query.CategoryID = id
+	}
+
+	for _, date := range []struct {
+		param string
+		dest  **time.Time
+	}{{"createdAfter", &query.CreatedAfter}, {"createdBefore", &query.CreatedBefore}} {
+		v := values.Get(date.param)
+		if v == "" {
+			continue
+		}
+		t, err := parseDate(v)
+		if err != nil {
+			return query, fmt.Errorf("invalid %s %q", date.param, v)
+		}
+		*date.dest = &t
+	}
+
+	if v := values.Get("cursor"); v != "" {
+		after, err := DecodeCursor(v, query.Sort, query.Desc)
+		if err != nil {
+			return query, err
+		}
+		query.After = after
+	}
+
+	return query, nil
+}
+
+// a timestamp, or a day meaning its midnight in UTC
+func parseDate(v string) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, v); err == nil {
+		return t.UTC(), nil
+	}
+	return time.Parse(time.DateOnly, v)
+}
+
+// what a cursor holds before it's made opaque ... the sort is kept so a
+// cursor can't be replayed against another order
+type cursor struct {
+	Sort  types.ProductSort `json:"s"`
+	Desc  bool              `json:"d,omitempty"`
+	Value string            `json:"v"`
+	ID    int               `json:"id"`
+}
+
+// EncodeCursor points after product in the order given by sort and desc
+func EncodeCursor(product types.Product, sort types.ProductSort, desc bool) string {
+	c := cursor{Sort: sort, Desc: desc, ID: product.ID}
+	switch sort {
+	case types.ProductSortCreatedAt:
+		c.Value = product.CreatedAt.UTC().Format(time.RFC3339Nano)
+	case types.ProductSortPrice:
+		c.Value = product.Price.String()
+	case types.ProductSortName:
+		c.Value = product.Name
+	}
+
+	marshalled, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(marshalled)
+}
+
+// DecodeCursor reads a cursor made by EncodeCursor for the same sort
+func DecodeCursor(s string, sort types.ProductSort, desc bool) (*types.ProductCursor, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, ErrInvalidCursor
+	}
+
+	var c cursor
+	if err := json.Unmarshal(raw, &c); err != nil || c.ID < 1 {
+		return nil, ErrInvalidCursor
+	}
+	if c.Sort != sort || c.Desc != desc {
+		return nil, fmt.Errorf("%w: it was made for another sort", ErrInvalidCursor)
+	}
+
+	after := &types.ProductCursor{ID: c.ID}
+	switch sort {
+	case types.ProductSortCreatedAt:
+		if after.CreatedAt, err = time.Parse(time.RFC3339Nano, c.Value); err != nil {
+			return nil, ErrInvalidCursor
+		}
+	case types.ProductSortPrice:
+		if after.Price, err = types.ParseMoney(c.Value, config.Envs.DefaultCurrency); err != nil {
+			return nil, ErrInvalidCursor
+		}
+	case types.ProductSortName:
+		after.Name = c.Value
+	}
+
+	return after, nil
+}
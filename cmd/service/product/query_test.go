@@ -0,0 +1,123 @@
+package product
+
+import (
+	"errors"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/eugenius-watchman/ecom_go_rest_api/config"
+	"github.com/eugenius-watchman/ecom_go_rest_api/types"
+)
+
+func TestParseQuery(t *testing.T) {
+	t.Run("should default to the newest first", func(t *testing.T) {
+		query, err := ParseQuery(url.Values{}, config.Envs.DefaultCurrency)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if query.Sort != types.ProductSortCreatedAt || !query.Desc || query.Limit != defaultPageLimit {
+			t.Errorf("unexpected query %+v", query)
+		}
+	})
+
+	t.Run("should read every filter", func(t *testing.T) {
+		query, err := ParseQuery(url.Values{
+			"sort":          {"-price"},
+			"limit":         {"50"},
+			"minPrice":      {"10"},
+			"maxPrice":      {"99.99"},
+			"inStock":       {"1"},
+			"category":      {"3"},
+			"createdAfter":  {"2026-10-01"},
+			"createdBefore": {"2026-10-18T12:00:00+02:00"},
+		}, config.Envs.DefaultCurrency)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if query.Sort != types.ProductSortPrice || !query.Desc || query.Limit != 50 {
+			t.Errorf("unexpected sort %+v", query)
+		}
+		if query.MinPrice.Amount != 1000 || query.MaxPrice.Amount != 9999 {
+			t.Errorf("unexpected prices %v and %v", query.MinPrice, query.MaxPrice)
+		}
+		if !query.InStock || query.CategoryID != 3 {
+			t.Errorf("unexpected filters %+v", query)
+		}
+		if !query.CreatedAfter.Equal(time.Date(2026, 10, 1, 0, 0, 0, 0, time.UTC)) ||
+			!query.CreatedBefore.Equal(time.Date(2026, 10, 18, 10, 0, 0, 0, time.UTC)) {
+			t.Errorf("unexpected dates %v and %v", query.CreatedAfter, query.CreatedBefore)
+		}
+	})
+
+	t.Run("should only filter and sort by price in the default currency", func(t *testing.T) {
+		for _, values := range []url.Values{
+			{"minPrice": {"10"}},
+			{"maxPrice": {"10"}},
+			{"sort": {"-price"}},
+		} {
+			if _, err := ParseQuery(values, "EUR"); err == nil {
+				t.Errorf("%v: expected an error in EUR", values)
+			}
+		}
+
+		if _, err := ParseQuery(url.Values{"sort": {"name"}}, "EUR"); err != nil {
+			t.Errorf("expected other sorts to work in EUR: %v", err)
+		}
+	})
+}
+
+func TestCursor(t *testing.T) {
+	product := types.Product{
+		ID:        42,
+		Name:      "shirt",
+		Price:     types.NewMoney(1999, "USD"),
+		CreatedAt: time.Date(2026, 10, 18, 9, 30, 15, 0, time.UTC),
+	}
+
+	t.Run("should round trip for every sort", func(t *testing.T) {
+		for sort := range sorts {
+			after, err := DecodeCursor(EncodeCursor(product, sort, true), sort, true)
+			if err != nil {
+				t.Fatalf("%s: %v", sort, err)
+			}
+			if after.ID != 42 {
+				t.Errorf("%s: expected ID 42, got %d", sort, after.ID)
+			}
+
+			switch sort {
+			case types.ProductSortCreatedAt:
+				if !after.CreatedAt.Equal(product.CreatedAt) {
+					t.Errorf("expected %v, got %v", product.CreatedAt, after.CreatedAt)
+				}
+			case types.ProductSortPrice:
+				if after.Price.Cmp(product.Price) != 0 {
+					t.Errorf("expected %v, got %v", product.Price, after.Price)
+				}
+			case types.ProductSortName:
+				if after.Name != "shirt" {
+					t.Errorf("expected shirt, got %s", after.Name)
+				}
+			}
+		}
+	})
+
+	t.Run("should reject a cursor made for another order", func(t *testing.T) {
+		cursor := EncodeCursor(product, types.ProductSortPrice, false)
+		if _, err := DecodeCursor(cursor, types.ProductSortPrice, true); !errors.Is(err, ErrInvalidCursor) {
+			t.Errorf("expected ErrInvalidCursor, got %v", err)
+		}
+		if _, err := DecodeCursor(cursor, types.ProductSortName, false); !errors.Is(err, ErrInvalidCursor) {
+			t.Errorf("expected ErrInvalidCursor, got %v", err)
+		}
+	})
+
+	t.Run("should reject garbage", func(t *testing.T) {
+		for _, cursor := range []string{"", "!!!", "e30", "eyJzIjoicHJpY2UiLCJ2IjoieCIsImlkIjoxfQ"} {
+			if _, err := DecodeCursor(cursor, types.ProductSortPrice, false); !errors.Is(err, ErrInvalidCursor) {
+				t.Errorf("%q: expected ErrInvalidCursor, got %v", cursor, err)
+			}
+		}
+	})
+}
@@ -1,10 +1,16 @@
 package product
 
 import (
+	"errors"
 	"fmt"
 	"net/http"
 	"strconv"
+	"strings"
 
+	"github.com/eugenius-watchman/ecom_go_rest_api/cmd/service/auth"
+	"github.com/eugenius-watchman/ecom_go_rest_api/cmd/service/category"
+	"github.com/eugenius-watchman/ecom_go_rest_api/cmd/service/currency"
+	"github.com/eugenius-watchman/ecom_go_rest_api/config"
 	"github.com/eugenius-watchman/ecom_go_rest_api/types"
 	"github.com/eugenius-watchman/ecom_go_rest_api/utils"
 	"github.com/go-playground/validator/v10"
@@ -12,30 +18,174 @@ import (
 )
 
 type Handler struct {
-	store types.ProductStore
+	store         types.ProductStore
+	categoryStore types.CategoryStore
+	variantStore  types.VariantStore
+	userStore     types.UserStore
+	pricer        types.ProductPricer
 }
 
-func NewHandler(store types.ProductStore) *Handler {
-	return &Handler{store: store}
+func NewHandler(store types.ProductStore, categoryStore types.CategoryStore, variantStore types.VariantStore, userStore types.UserStore, pricer types.ProductPricer) *Handler {
+	return &Handler{store: store, categoryStore: categoryStore, variantStore: variantStore, userStore: userStore, pricer: pricer}
 }
 
 func (h *Handler) RegisterRoutes(router *mux.Router) {
 	router.HandleFunc("/products", h.handleGetProducts).Methods("GET")      
-	router.HandleFunc("/products", h.handleCreateProduct).Methods("POST")
-	router.HandleFunc("/products/{id}", h.handleUpdateProduct).Methods("PUT") 
+	router.HandleFunc("/products/{id:[0-9]+}", h.handleGetProduct).Methods("GET")
+	router.HandleFunc("/categories/{slug}/products", h.handleGetCategoryProducts).Methods("GET")
+
+	// admin only
+	router.HandleFunc("/products", auth.WithRole(h.handleCreateProduct, h.userStore, types.RoleAdmin)).Methods("POST")
+	router.HandleFunc("/products/{id}", auth.WithRole(h.handleUpdateProduct, h.userStore, types.RoleAdmin)).Methods("PUT") 
+	router.HandleFunc("/products/{id}/prices/{currency}", auth.WithRole(h.handleSetProductPrice, h.userStore, types.RoleAdmin)).Methods("PUT")
+	router.HandleFunc("/products/{id:[0-9]+}", auth.WithRole(h.handleDeleteProduct, h.userStore, types.RoleAdmin)).Methods("DELETE")
+	router.HandleFunc("/products/{id:[0-9]+}/restore", auth.WithRole(h.handleRestoreProduct, h.userStore, types.RoleAdmin)).Methods("POST")
 }
 
 func (h *Handler) handleGetProducts(w http.ResponseWriter, r *http.Request) {
-	products, err := h.store.GetProducts()
+	query, err := ParseQuery(r.URL.Query(), currency.FromRequest(r))
 	if err != nil {
+		utils.WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	h.writeProducts(w, r, query)
+}
+
+// the products in a category and all its subcategories, filtered and
+// paged like /products
+func (h *Handler) handleGetCategoryProducts(w http.ResponseWriter, r *http.Request) {
+	c, err := h.categoryStore.GetCategoryBySlug(mux.Vars(r)["slug"])
+	if err != nil {
+		if errors.Is(err, category.ErrCategoryNotFound) {
+			utils.WriteError(w, http.StatusNotFound, err)
+			return
+		}
 		utils.WriteError(w, http.StatusInternalServerError, err)
 		return
 	}
-	utils.WriteJSON(w, http.StatusOK, products)
+
+	query, err := ParseQuery(r.URL.Query(), currency.FromRequest(r))
+	if err != nil {
+		utils.WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+	// the path picks the category
+	query.CategoryID = c.ID
+
+	h.writeProducts(w, r, query)
+}
+
+// one page of products, the next page's cursor is in the body and in a
+// Link header
+func (h *Handler) writeProducts(w http.ResponseWriter, r *http.Request, query types.ProductQuery) {
+	includeTotal := false
+	if v := r.URL.Query().Get("total"); v != "" {
+		var err error
+		if includeTotal, err = strconv.ParseBool(v); err != nil {
+			utils.WriteError(w, http.StatusBadRequest, fmt.Errorf("invalid total %q", v))
+			return
+		}
+	}
+
+	// one more than asked for tells whether there's a next page
+	limit := query.Limit
+	query.Limit++
+	products, err := h.store.GetProducts(query)
+	if err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	response := types.ProductListResponse{Limit: limit}
+	if len(products) > limit {
+		products = products[:limit]
+		// before repricing, the cursor holds the stored price
+		response.NextCursor = EncodeCursor(products[limit-1], query.Sort, query.Desc)
+	}
+
+	if includeTotal {
+		total, err := h.store.CountProducts(query)
+		if err != nil {
+			utils.WriteError(w, http.StatusInternalServerError, err)
+			return
+		}
+		response.Total = &total
+	}
+
+	// prices in the currency the client asked for
+	if err := h.pricer.PriceProducts(products, currency.FromRequest(r)); err != nil {
+		if errors.Is(err, currency.ErrUnsupportedCurrency) {
+			utils.WriteError(w, http.StatusBadRequest, err)
+			return
+		}
+		utils.WriteError(w, http.StatusInternalServerError, err)
+		return
+	}
+	response.Products = products
+
+	if response.NextCursor != "" {
+		next := *r.URL
+		values := next.Query()
+		values.Set("cursor", response.NextCursor)
+		next.RawQuery = values.Encode()
+		w.Header().Set("Link", fmt.Sprintf(`<%s>; rel="next"`, next.RequestURI()))
+	}
+
+	utils.WriteJSON(w, http.StatusOK, response)
+}
+
+// one product with the category paths leading to it
+func (h *Handler) handleGetProduct(w http.ResponseWriter, r *http.Request) {
+	productID, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		utils.WriteError(w, http.StatusBadRequest, fmt.Errorf("invalid product ID"))
+		return
+	}
+
+	product, err := h.store.GetProductByID(productID)
+	if err != nil {
+		utils.WriteError(w, http.StatusNotFound, fmt.Errorf("product not found"))
+		return
+	}
+
+	// the variant matrix, each variant priced like the product
+	options, variants, err := h.variantStore.GetProductVariants(productID)
+	if err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	products := []types.Product{*product}
+	err = h.pricer.PriceProducts(products, currency.FromRequest(r))
+	if err == nil {
+		err = h.pricer.PriceVariants(variants, currency.FromRequest(r))
+	}
+	if err != nil {
+		if errors.Is(err, currency.ErrUnsupportedCurrency) {
+			utils.WriteError(w, http.StatusBadRequest, err)
+			return
+		}
+		utils.WriteError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	breadcrumbs, err := h.categoryStore.GetProductBreadcrumbs(productID)
+	if err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	utils.WriteJSON(w, http.StatusOK, types.ProductDetailResponse{
+		Product:     products[0],
+		Breadcrumbs: breadcrumbs,
+		Options:     options,
+		Variants:    variants,
+	})
 }
 
 func (h *Handler) handleCreateProduct(w http.ResponseWriter, r *http.Request) {
-	var payload types.CreateProductPayload
+	payload := types.CreateProductPayload{Price: types.NewMoney(0, config.Envs.DefaultCurrency)}
 	
 	// Parse JSON payload
 	if err := utils.ParseJSON(r, &payload); err != nil {
@@ -50,6 +200,11 @@ func (h *Handler) handleCreateProduct(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	
+	// taxed at the standard rate unless told otherwise
+	if payload.TaxClass == "" {
+		payload.TaxClass = types.TaxClassStandard
+	}
+
 	// Create product in database
 	err := h.store.CreateProduct(types.Product{
 		Name:        payload.Name,
@@ -57,6 +212,11 @@ func (h *Handler) handleCreateProduct(w http.ResponseWriter, r *http.Request) {
 		Image:       payload.Image,
 		Price:       payload.Price,
 		Quantity:    payload.Quantity,
+		TaxClass:    payload.TaxClass,
+		Weight:      payload.Weight,
+		Length:      payload.Length,
+		Width:       payload.Width,
+		Height:      payload.Height,
 	})
 	
 	if err != nil {
@@ -78,7 +238,7 @@ func (h *Handler) handleUpdateProduct(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	var payload types.UpdateProductPayload
+	payload := types.UpdateProductPayload{Price: types.NewMoney(0, config.Envs.DefaultCurrency)}
 
 	// parse payload
 	if err := utils.ParseJSON(r, &payload); err != nil {
@@ -111,12 +271,27 @@ func (h *Handler) handleUpdateProduct(w http.ResponseWriter, r *http.Request) {
 	if payload.Image != "" {
 		updatedProduct.Image = payload.Image
 	}
-	if payload.Price > 0 {
-		updatedProduct.Price = payload.Price
+	if payload.Price.Amount > 0 {
+		updatedProduct.Price.Amount = payload.Price.Amount
 	}
 	if payload.Quantity >= 0 {
 		updatedProduct.Quantity = payload.Quantity
 	}
+	if payload.TaxClass != "" {
+		updatedProduct.TaxClass = payload.TaxClass
+	}
+	if payload.Weight != nil {
+		updatedProduct.Weight = *payload.Weight
+	}
+	if payload.Length != nil {
+		updatedProduct.Length = *payload.Length
+	}
+	if payload.Width != nil {
+		updatedProduct.Width = *payload.Width
+	}
+	if payload.Height != nil {
+		updatedProduct.Height = *payload.Height
+	}
 
 	// update product in db
 	err = h.store.UpdateProduct(productID, updatedProduct)
@@ -128,4 +303,100 @@ func (h *Handler) handleUpdateProduct(w http.ResponseWriter, r *http.Request) {
 	utils.WriteJSON(w, http.StatusOK, map[string]string{
 		"message": "Product updated successfully",
 	})
-}
\ No newline at end of file
+}
+
+// soft delete ... the product leaves the catalog and carts can't check it
+// out, orders keep pointing at it
+func (h *Handler) handleDeleteProduct(w http.ResponseWriter, r *http.Request) {
+	productID, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		utils.WriteError(w, http.StatusBadRequest, fmt.Errorf("invalid product ID"))
+		return
+	}
+
+	if err := h.store.DeleteProduct(productID); err != nil {
+		if errors.Is(err, ErrProductNotFound) {
+			utils.WriteError(w, http.StatusNotFound, err)
+			return
+		}
+		utils.WriteError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	utils.WriteJSON(w, http.StatusOK, map[string]string{
+		"message": "Product deleted successfully",
+	})
+}
+
+func (h *Handler) handleRestoreProduct(w http.ResponseWriter, r *http.Request) {
+	productID, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		utils.WriteError(w, http.StatusBadRequest, fmt.Errorf("invalid product ID"))
+		return
+	}
+
+	if err := h.store.RestoreProduct(productID); err != nil {
+		switch {
+		case errors.Is(err, ErrProductNotFound):
+			utils.WriteError(w, http.StatusNotFound, err)
+		case errors.Is(err, ErrProductNotDeleted):
+			utils.WriteError(w, http.StatusConflict, err)
+		default:
+			utils.WriteError(w, http.StatusInternalServerError, err)
+		}
+		return
+	}
+
+	product, err := h.store.GetProductByID(productID)
+	if err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	utils.WriteJSON(w, http.StatusOK, product)
+}
+
+// sets the explicit price of a product in a currency other than the
+// default one, which is the product's own price
+func (h *Handler) handleSetProductPrice(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	productID, err := strconv.Atoi(vars["id"])
+	if err != nil {
+		utils.WriteError(w, http.StatusBadRequest, fmt.Errorf("invalid product ID"))
+		return
+	}
+
+	code := strings.ToUpper(vars["currency"])
+	if !currency.IsCode(code) || code == config.Envs.DefaultCurrency {
+		utils.WriteError(w, http.StatusBadRequest, fmt.Errorf("invalid currency %q", vars["currency"]))
+		return
+	}
+
+	payload := types.SetProductPricePayload{Price: types.NewMoney(0, code)}
+	if err := utils.ParseJSON(r, &payload); err != nil {
+		utils.WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	if err := utils.Validate.Struct(payload); err != nil {
+		errors := err.(validator.ValidationErrors)
+		utils.WriteError(w, http.StatusBadRequest, fmt.Errorf("invalid payload %w", errors))
+		return
+	}
+
+	if _, err := h.store.GetProductByID(productID); err != nil {
+		utils.WriteError(w, http.StatusNotFound, fmt.Errorf("product not found"))
+		return
+	}
+
+	if err := h.store.SetProductPrice(productID, payload.Price); err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	utils.WriteJSON(w, http.StatusOK, map[string]any{
+		"productId": productID,
+		"currency":  code,
+		"price":     payload.Price,
+	})
+}
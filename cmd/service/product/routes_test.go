@@ -0,0 +1,838 @@
+package product
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/eugenius-watchman/ecom_go_rest_api/cmd/service/auth/authtest"
+	"github.com/eugenius-watchman/ecom_go_rest_api/cmd/service/category"
+	"github.com/eugenius-watchman/ecom_go_rest_api/cmd/service/currency"
+	"github.com/eugenius-watchman/ecom_go_rest_api/types"
+	"github.com/gorilla/mux"
+)
+
+func TestProductServiceHandlers(t *testing.T) {
+	productStore := &mockProductStore{prices: map[int]types.Money{}}
+	pricer := currency.NewConverter(productStore, &mockRateStore{})
+	handler := NewHandler(productStore, &mockCategoryStore{}, &mockVariantStore{}, userStore, pricer)
+
+	router := mux.NewRouter()
+	handler.RegisterRoutes(router)
+
+	send := func(method, path string, userID int, payload any) *httptest.ResponseRecorder {
+		return authtest.Serve(t, router, method, path, userID, payload)
+	}
+
+	payload := types.CreateProductPayload{
+		Name:        "shirt",
+		Description: "cotton shirt",
+		Image:       "https://example.com/shirt.png",
+		Price:       types.NewMoney(2000, "USD"),
+		Quantity:    5,
+	}
+
+	t.Run("should list products without a token", func(t *testing.T) {
+		if rr := send(http.MethodGet, "/products", 0, nil); rr.Code != http.StatusOK {
+			t.Errorf("expected status code %d, got %d", http.StatusOK, rr.Code)
+		}
+	})
+
+	t.Run("should fail to create a product without a token", func(t *testing.T) {
+		if rr := send(http.MethodPost, "/products", 0, payload); rr.Code != http.StatusUnauthorized {
+			t.Errorf("expected status code %d, got %d", http.StatusUnauthorized, rr.Code)
+		}
+	})
+
+	t.Run("should forbid customers from creating products", func(t *testing.T) {
+		if rr := send(http.MethodPost, "/products", 2, payload); rr.Code != http.StatusForbidden {
+			t.Errorf("expected status code %d, got %d", http.StatusForbidden, rr.Code)
+		}
+	})
+
+	t.Run("should let admins create products", func(t *testing.T) {
+		if rr := send(http.MethodPost, "/products", 1, payload); rr.Code != http.StatusCreated {
+			t.Errorf("expected status code %d, got %d", http.StatusCreated, rr.Code)
+		}
+	})
+
+	t.Run("should reject a negative weight", func(t *testing.T) {
+		invalid := payload
+		invalid.Weight = -1
+		if rr := send(http.MethodPost, "/products", 1, invalid); rr.Code != http.StatusBadRequest {
+			t.Errorf("expected status code %d, got %d", http.StatusBadRequest, rr.Code)
+		}
+	})
+
+	t.Run("should forbid customers from updating products", func(t *testing.T) {
+		update := types.UpdateProductPayload{Name: "hacked"}
+		if rr := send(http.MethodPut, "/products/1", 2, update); rr.Code != http.StatusForbidden {
+			t.Errorf("expected status code %d, got %d", http.StatusForbidden, rr.Code)
+		}
+	})
+
+	t.Run("should let admins update products", func(t *testing.T) {
+		update := types.UpdateProductPayload{Name: "linen shirt", Quantity: 3}
+		if rr := send(http.MethodPut, "/products/1", 1, update); rr.Code != http.StatusOK {
+			t.Errorf("expected status code %d, got %d", http.StatusOK, rr.Code)
+		}
+	})
+
+	t.Run("should let admins set a price in another currency", func(t *testing.T) {
+		price := types.SetProductPricePayload{Price: types.NewMoney(1850, "EUR")}
+		if rr := send(http.MethodPut, "/products/1/prices/eur", 1, price); rr.Code != http.StatusOK {
+			t.Fatalf("expected status code %d, got %d", http.StatusOK, rr.Code)
+		}
+		if got := productStore.prices[1]; got != types.NewMoney(1850, "EUR") {
+			t.Errorf("expected 18.50 EUR, got %+v", got)
+		}
+	})
+
+	t.Run("should not set an explicit price in the default currency", func(t *testing.T) {
+		price := types.SetProductPricePayload{Price: types.NewMoney(1850, "USD")}
+		if rr := send(http.MethodPut, "/products/1/prices/USD", 1, price); rr.Code != http.StatusBadRequest {
+			t.Errorf("expected status code %d, got %d", http.StatusBadRequest, rr.Code)
+		}
+	})
+
+	t.Run("should forbid customers from deleting and restoring products", func(t *testing.T) {
+		if rr := send(http.MethodDelete, "/products/1", 2, nil); rr.Code != http.StatusForbidden {
+			t.Errorf("expected status code %d, got %d", http.StatusForbidden, rr.Code)
+		}
+		if rr := send(http.MethodPost, "/products/1/restore", 2, nil); rr.Code != http.StatusForbidden {
+			t.Errorf("expected status code %d, got %d", http.StatusForbidden, rr.Code)
+		}
+	})
+
+	t.Run("should hide a deleted product until it's restored", func(t *testing.T) {
+		if rr := send(http.MethodDelete, "/products/1", 1, nil); rr.Code != http.StatusOK {
+			t.Fatalf("expected status code %d, got %d", http.StatusOK, rr.Code)
+		}
+
+		if rr := send(http.MethodGet, "/products/1", 0, nil); rr.Code != http.StatusNotFound {
+			t.Errorf("expected status code %d, got %d", http.StatusNotFound, rr.Code)
+		}
+		var list types.ProductListResponse
+		json.NewDecoder(send(http.MethodGet, "/products", 0, nil).Body).Decode(&list)
+		if len(list.Products) != 1 || list.Products[0].ID != 2 {
+			t.Errorf("expected only the hat to be listed, got %+v", list.Products)
+		}
+		if rr := send(http.MethodPut, "/products/1", 1, types.UpdateProductPayload{Name: "linen shirt"}); rr.Code != http.StatusNotFound {
+			t.Errorf("expected status code %d, got %d", http.StatusNotFound, rr.Code)
+		}
+		if rr := send(http.MethodDelete, "/products/1", 1, nil); rr.Code != http.StatusNotFound {
+			t.Errorf("expected status code %d, got %d", http.StatusNotFound, rr.Code)
+		}
+
+		rr := send(http.MethodPost, "/products/1/restore", 1, nil)
+		if rr.Code != http.StatusOK {
+			t.Fatalf("expected status code %d, got %d", http.StatusOK, rr.Code)
+		}
+		var restored types.Product
+		json.NewDecoder(rr.Body).Decode(&restored)
+		if restored.ID != 1 {
+			t.Errorf("expected product 1, got %+v", restored)
+		}
+		if rr := send(http.MethodGet, "/products/1", 0, nil); rr.Code != http.StatusOK {
+			t.Errorf("expected status code %d, got %d", http.StatusOK, rr.Code)
+		}
+	})
+
+	t.Run("should not restore a product that isn't deleted", func(t *testing.T) {
+		if rr := send(http.MethodPost, "/products/1/restore", 1, nil); rr.Code != http.StatusConflict {
+			t.Errorf("expected status code %d, got %d", http.StatusConflict, rr.Code)
+		}
+		if rr := send(http.MethodPost, "/products/99/restore", 1, nil); rr.Code != http.StatusNotFound {
+			t.Errorf("expected status code %d, got %d", http.StatusNotFound, rr.Code)
+		}
+	})
+}
+
+func TestGetProductsInCurrency(t *testing.T) {
+	productStore := &mockProductStore{prices: map[int]types.Money{1: types.NewMoney(1850, "EUR")}}
+	handler := NewHandler(productStore, &mockCategoryStore{}, &mockVariantStore{}, userStore, currency.NewConverter(productStore, &mockRateStore{}))
+
+	router := mux.NewRouter()
+	handler.RegisterRoutes(router)
+
+	list := func(path string) (*httptest.ResponseRecorder, []types.Product) {
+		req, _ := http.NewRequest(http.MethodGet, path, nil)
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+
+		var response types.ProductListResponse
+		json.NewDecoder(rr.Body).Decode(&response)
+		return rr, response.Products
+	}
+
+	t.Run("should use the default currency", func(t *testing.T) {
+		_, products := list("/products")
+		if products[0].Currency != "USD" || products[0].Price.Amount != 2000 {
+			t.Errorf("unexpected product %+v", products[0])
+		}
+	})
+
+	t.Run("should prefer the explicit price and convert the rest", func(t *testing.T) {
+		rr, products := list("/products?currency=EUR")
+		if rr.Code != http.StatusOK {
+			t.Fatalf("expected status code %d, got %d", http.StatusOK, rr.Code)
+		}
+		if products[0].Currency != "EUR" || products[0].Price.Amount != 1850 {
+			t.Errorf("expected the explicit price, got %+v", products[0])
+		}
+		// 15.00 USD at 0.9215
+		if products[1].Price.Amount != 1382 {
+			t.Errorf("expected the converted price 13.82, got %v", products[1].Price)
+		}
+	})
+
+	t.Run("should read the currency header", func(t *testing.T) {
+		// 20.00 USD at 151.2, yen have no decimals
+		req, _ := http.NewRequest(http.MethodGet, "/products", nil)
+		req.Header.Set(currency.HeaderKey, "jpy")
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+
+		var response struct {
+			Products []map[string]any `json:"products"`
+		}
+		json.NewDecoder(rr.Body).Decode(&response)
+		products := response.Products
+		if products[0]["currency"] != "JPY" || products[0]["price"] != "3024" {
+			t.Errorf("unexpected product %v", products[0])
+		}
+	})
+
+	t.Run("should reject a currency without rate", func(t *testing.T) {
+		if rr, _ := list("/products?currency=CHF"); rr.Code != http.StatusBadRequest {
+			t.Errorf("expected status code %d, got %d", http.StatusBadRequest, rr.Code)
+		}
+	})
+}
+
+func TestGetProductsPaginated(t *testing.T) {
+	day := func(d int) time.Time { return time.Date(2026, 10, d, 0, 0, 0, 0, time.UTC) }
+	usd := func(amount int64) types.Money { return types.NewMoney(amount, "USD") }
+
+	productStore := &mockProductStore{
+		prices: map[int]types.Money{},
+		products: []types.Product{
+			{ID: 1, Name: "apron", Price: usd(1500), Quantity: 3, CreatedAt: day(1)},
+			{ID: 2, Name: "boots", Price: usd(9000), Quantity: 0, CreatedAt: day(2)},
+			{ID: 3, Name: "cap", Price: usd(1500), Quantity: 8, CreatedAt: day(3)},
+			{ID: 4, Name: "dress", Price: usd(4500), Quantity: 1, CreatedAt: day(3)},
+			{ID: 5, Name: "earrings", Price: usd(2500), Quantity: 6, CreatedAt: day(5)},
+		},
+		categories: map[int][]int{1: {7}, 3: {7}, 5: {8}},
+	}
+	handler := NewHandler(productStore, &mockCategoryStore{}, &mockVariantStore{}, userStore, currency.NewConverter(productStore, &mockRateStore{}))
+
+	router := mux.NewRouter()
+	handler.RegisterRoutes(router)
+
+	list := func(path string) (*httptest.ResponseRecorder, types.ProductListResponse) {
+		req, _ := http.NewRequest(http.MethodGet, path, nil)
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+
+		var response types.ProductListResponse
+		json.NewDecoder(rr.Body).Decode(&response)
+		return rr, response
+	}
+
+	ids := func(products []types.Product) []int {
+		ids := []int{}
+		for _, p := range products {
+			ids = append(ids, p.ID)
+		}
+		return ids
+	}
+
+	// follows the next cursors to the last page
+	walk := func(path string) []int {
+		var seen []int
+		for range 10 {
+			rr, response := list(path)
+			if rr.Code != http.StatusOK {
+				t.Fatalf("expected status code %d, got %d: %s", http.StatusOK, rr.Code, rr.Body)
+			}
+			seen = append(seen, ids(response.Products)...)
+			if response.NextCursor == "" {
+				if link := rr.Header().Get("Link"); link != "" {
+					t.Errorf("expected no Link header on the last page, got %s", link)
+				}
+				return seen
+			}
+			sep := "?"
+			if strings.Contains(path, "?") {
+				sep = "&"
+			}
+			path = strings.Split(path, "cursor=")[0]
+			path = strings.TrimSuffix(strings.TrimSuffix(path, "&"), "?")
+			path += sep + "cursor=" + response.NextCursor
+		}
+		t.Fatal("too many pages")
+		return nil
+	}
+
+	t.Run("should list the newest first by default", func(t *testing.T) {
+		rr, response := list("/products?limit=2")
+		if rr.Code != http.StatusOK {
+			t.Fatalf("expected status code %d, got %d", http.StatusOK, rr.Code)
+		}
+		if got := ids(response.Products); !slices.Equal(got, []int{5, 4}) {
+			t.Errorf("expected [5 4], got %v", got)
+		}
+		if response.Limit != 2 || response.NextCursor == "" || response.Total != nil {
+			t.Errorf("unexpected response %+v", response)
+		}
+
+		want := fmt.Sprintf(`</products?cursor=%s&limit=2>; rel="next"`, response.NextCursor)
+		if link := rr.Header().Get("Link"); link != want {
+			t.Errorf("expected Link %s, got %s", want, link)
+		}
+	})
+
+	t.Run("should page through every product once", func(t *testing.T) {
+		tests := map[string][]int{
+			"/products?limit=2":             {5, 4, 3, 2, 1},
+			"/products?limit=2&sort=price":  {1, 3, 5, 4, 2},
+			"/products?limit=1&sort=-price": {2, 4, 5, 3, 1},
+			"/products?limit=3&sort=name":   {1, 2, 3, 4, 5},
+		}
+		for path, want := range tests {
+			if got := walk(path); !slices.Equal(got, want) {
+				t.Errorf("%s: expected %v, got %v", path, want, got)
+			}
+		}
+	})
+
+	t.Run("should not shift pages when products are added", func(t *testing.T) {
+		_, first := list("/products?limit=2&sort=price")
+
+		productStore.products = append(productStore.products,
+			types.Product{ID: 6, Name: "fan", Price: usd(1000), Quantity: 1, CreatedAt: day(6)})
+		defer func() { productStore.products = productStore.products[:5] }()
+
+		_, second := list("/products?limit=2&sort=price&cursor=" + first.NextCursor)
+		if got := ids(second.Products); !slices.Equal(got, []int{5, 4}) {
+			t.Errorf("expected [5 4], got %v", got)
+		}
+	})
+
+	t.Run("should filter", func(t *testing.T) {
+		tests := map[string][]int{
+			"/products?inStock=true":                                     {5, 4, 3, 1},
+			"/products?minPrice=20&maxPrice=45.00":                       {5, 4},
+			"/products?category=7":                                       {3, 1},
+			"/products?createdAfter=2026-10-02&createdBefore=2026-10-05": {4, 3, 2},
+			"/products?createdAfter=2026-10-03T00:00:00Z&inStock=true":   {5, 4, 3},
+		}
+		for path, want := range tests {
+			if got := walk(path + "&limit=2"); !slices.Equal(got, want) {
+				t.Errorf("%s: expected %v, got %v", path, want, got)
+			}
+		}
+	})
+
+	t.Run("should count the matching products when asked", func(t *testing.T) {
+		_, response := list("/products?limit=1&inStock=true&total=true")
+		if response.Total == nil || *response.Total != 4 {
+			t.Errorf("expected a total of 4, got %v", response.Total)
+		}
+	})
+
+	t.Run("should reject bad parameters", func(t *testing.T) {
+		_, page := list("/products?limit=2&sort=price")
+
+		paths := []string{
+			"/products?sort=description",
+			"/products?limit=0",
+			"/products?limit=101",
+			"/products?minPrice=abc",
+			"/products?minPrice=-1",
+			"/products?minPrice=50&maxPrice=10",
+			"/products?minPrice=10&currency=EUR",
+			"/products?sort=price&currency=EUR",
+			"/products?inStock=maybe",
+			"/products?category=x",
+			"/products?createdAfter=yesterday",
+			"/products?total=maybe",
+			"/products?cursor=not-a-cursor",
+			// a cursor only works with the sort it was made for
+			"/products?sort=name&cursor=" + page.NextCursor,
+		}
+		for _, path := range paths {
+			if rr, _ := list(path); rr.Code != http.StatusBadRequest {
+				t.Errorf("%s: expected status code %d, got %d", path, http.StatusBadRequest, rr.Code)
+			}
+		}
+	})
+}
+
+func TestGetProduct(t *testing.T) {
+	apparel := types.Category{ID: 1, Name: "Apparel", Slug: "apparel"}
+	shirts := types.Category{ID: 2, Name: "Shirts", Slug: "shirts", ParentID: &apparel.ID}
+	sale := types.Category{ID: 3, Name: "Sale", Slug: "sale"}
+
+	productStore := &mockProductStore{prices: map[int]types.Money{1: types.NewMoney(1850, "EUR")}}
+	categoryStore := &mockCategoryStore{
+		categories:  []types.Category{apparel, shirts, sale},
+		breadcrumbs: map[int][][]types.Category{1: {{apparel, shirts}, {sale}}},
+	}
+	variantStore := &mockVariantStore{
+		options: map[int][]types.ProductOption{1: {{Name: "Size", Values: []string{"M", "XL"}}}},
+		variants: map[int][]types.ProductVariant{1: {
+			{ID: 10, ProductID: 1, SKU: "SHIRT-M", Price: types.NewMoney(2000, "USD"), Quantity: 3, Options: []types.VariantOption{{Name: "Size", Value: "M"}}},
+			{ID: 11, ProductID: 1, SKU: "SHIRT-XL", Price: types.NewMoney(2500, "USD"), PriceOverride: true, Quantity: 2, Options: []types.VariantOption{{Name: "Size", Value: "XL"}}},
+		}},
+	}
+	handler := NewHandler(productStore, categoryStore, variantStore, userStore, currency.NewConverter(productStore, &mockRateStore{}))
+
+	router := mux.NewRouter()
+	handler.RegisterRoutes(router)
+
+	get := func(path string) (*httptest.ResponseRecorder, types.ProductDetailResponse) {
+		req, _ := http.NewRequest(http.MethodGet, path, nil)
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+
+		var response types.ProductDetailResponse
+		json.NewDecoder(rr.Body).Decode(&response)
+		return rr, response
+	}
+
+	t.Run("should return the product with its breadcrumbs", func(t *testing.T) {
+		rr, response := get("/products/1")
+		if rr.Code != http.StatusOK {
+			t.Fatalf("expected status code %d, got %d", http.StatusOK, rr.Code)
+		}
+		if response.ID != 1 || response.Name != "shirt" {
+			t.Errorf("unexpected product %+v", response.Product)
+		}
+
+		var paths []string
+		for _, path := range response.Breadcrumbs {
+			var names []string
+			for _, c := range path {
+				names = append(names, c.Name)
+			}
+			paths = append(paths, strings.Join(names, " > "))
+		}
+		if want := []string{"Apparel > Shirts", "Sale"}; !slices.Equal(paths, want) {
+			t.Errorf("expected breadcrumbs %v, got %v", want, paths)
+		}
+	})
+
+	t.Run("should price the product in the requested currency", func(t *testing.T) {
+		_, response := get("/products/1?currency=EUR")
+		if response.Currency != "EUR" || response.Price.Amount != 1850 {
+			t.Errorf("expected the explicit price 18.50 EUR, got %+v", response.Product)
+		}
+	})
+
+	t.Run("should return the variant matrix", func(t *testing.T) {
+		_, response := get("/products/1?currency=EUR")
+		if len(response.Options) != 1 || !slices.Equal(response.Options[0].Values, []string{"M", "XL"}) {
+			t.Errorf("unexpected options %+v", response.Options)
+		}
+		if len(response.Variants) != 2 {
+			t.Fatalf("expected 2 variants, got %+v", response.Variants)
+		}
+
+		// M sells at the product's explicit price, XL's own price is converted
+		if m := response.Variants[0]; m.SKU != "SHIRT-M" || m.Price.Amount != 1850 {
+			t.Errorf("unexpected M variant %+v", m)
+		}
+		if xl := response.Variants[1]; xl.SKU != "SHIRT-XL" || xl.Price.Amount != 2304 || xl.Options[0].Value != "XL" {
+			t.Errorf("unexpected XL variant %+v", xl)
+		}
+	})
+
+	t.Run("should return an empty matrix for a product without variants", func(t *testing.T) {
+		options, variants := variantStore.options, variantStore.variants
+		variantStore.options, variantStore.variants = nil, nil
+		defer func() { variantStore.options, variantStore.variants = options, variants }()
+
+		_, response := get("/products/1")
+		if response.Options == nil || len(response.Options) != 0 || response.Variants == nil || len(response.Variants) != 0 {
+			t.Errorf("expected empty options and variants, got %+v and %+v", response.Options, response.Variants)
+		}
+	})
+
+	t.Run("should return no breadcrumbs for an uncategorised product", func(t *testing.T) {
+		categoryStore.breadcrumbs = map[int][][]types.Category{}
+		defer func() { categoryStore.breadcrumbs = map[int][][]types.Category{1: {{apparel, shirts}, {sale}}} }()
+
+		// an empty array rather than null
+		_, response := get("/products/1")
+		if response.Breadcrumbs == nil || len(response.Breadcrumbs) != 0 {
+			t.Errorf("expected empty breadcrumbs, got %v", response.Breadcrumbs)
+		}
+	})
+
+	t.Run("should 404 an unknown product", func(t *testing.T) {
+		if rr, _ := get("/products/99"); rr.Code != http.StatusNotFound {
+			t.Errorf("expected status code %d, got %d", http.StatusNotFound, rr.Code)
+		}
+	})
+}
+
+func TestGetCategoryProducts(t *testing.T) {
+	usd := func(amount int64) types.Money { return types.NewMoney(amount, "USD") }
+	day := func(d int) time.Time { return time.Date(2026, 10, d, 0, 0, 0, 0, time.UTC) }
+
+	// apparel > shirts > flannel, and shoes on its own
+	productStore := &mockProductStore{
+		prices: map[int]types.Money{},
+		products: []types.Product{
+			{ID: 1, Name: "apron", Price: usd(1500), Quantity: 3, CreatedAt: day(1)},
+			{ID: 2, Name: "oxford", Price: usd(4000), Quantity: 0, CreatedAt: day(2)},
+			{ID: 3, Name: "lumberjack", Price: usd(5500), Quantity: 2, CreatedAt: day(3)},
+			{ID: 4, Name: "boots", Price: usd(9000), Quantity: 1, CreatedAt: day(4)},
+		},
+		categories: map[int][]int{1: {1}, 2: {2}, 3: {3}, 4: {4}},
+		parents:    map[int]int{2: 1, 3: 2},
+	}
+	categoryStore := &mockCategoryStore{categories: []types.Category{
+		{ID: 1, Name: "Apparel", Slug: "apparel"},
+		{ID: 2, Name: "Shirts", Slug: "shirts"},
+		{ID: 3, Name: "Flannel", Slug: "flannel"},
+		{ID: 4, Name: "Shoes", Slug: "shoes"},
+	}}
+	handler := NewHandler(productStore, categoryStore, &mockVariantStore{}, userStore, currency.NewConverter(productStore, &mockRateStore{}))
+
+	router := mux.NewRouter()
+	handler.RegisterRoutes(router)
+
+	list := func(path string) (*httptest.ResponseRecorder, []int) {
+		req, _ := http.NewRequest(http.MethodGet, path, nil)
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+
+		var response types.ProductListResponse
+		json.NewDecoder(rr.Body).Decode(&response)
+		ids := []int{}
+		for _, p := range response.Products {
+			ids = append(ids, p.ID)
+		}
+		return rr, ids
+	}
+
+	t.Run("should include the products of every subcategory", func(t *testing.T) {
+		tests := map[string][]int{
+			"/categories/apparel/products": {3, 2, 1},
+			"/categories/shirts/products":  {3, 2},
+			"/categories/flannel/products": {3},
+			"/categories/shoes/products":   {4},
+		}
+		for path, want := range tests {
+			rr, got := list(path)
+			if rr.Code != http.StatusOK {
+				t.Fatalf("%s: expected status code %d, got %d", path, http.StatusOK, rr.Code)
+			}
+			if !slices.Equal(got, want) {
+				t.Errorf("%s: expected %v, got %v", path, want, got)
+			}
+		}
+	})
+
+	t.Run("should filter and sort like the product list", func(t *testing.T) {
+		if _, got := list("/categories/apparel/products?inStock=true&sort=price"); !slices.Equal(got, []int{1, 3}) {
+			t.Errorf("expected [1 3], got %v", got)
+		}
+	})
+
+	t.Run("should ignore a category parameter", func(t *testing.T) {
+		if _, got := list("/categories/shoes/products?category=1"); !slices.Equal(got, []int{4}) {
+			t.Errorf("expected [4], got %v", got)
+		}
+	})
+
+	t.Run("should 404 an unknown slug", func(t *testing.T) {
+		if rr, _ := list("/categories/hats/products"); rr.Code != http.StatusNotFound {
+			t.Errorf("expected status code %d, got %d", http.StatusNotFound, rr.Code)
+		}
+	})
+}
+
+type mockProductStore struct {
+	prices map[int]types.Money // explicit EUR prices
+	// a shirt and an older hat when nil
+	products []types.Product
+	// category IDs of each product
+	categories map[int][]int
+	// parent of each subcategory
+	parents map[int]int
+	// soft deleted products
+	deleted map[int]bool
+}
+
+func (m *mockProductStore) catalog() []types.Product {
+	if m.products != nil {
+		return m.products
+	}
+	return []types.Product{
+		{ID: 1, Name: "shirt", Price: types.NewMoney(2000, "USD"), Currency: "USD", Quantity: 5, CreatedAt: time.Date(2026, 10, 2, 0, 0, 0, 0, time.UTC)},
+		{ID: 2, Name: "hat", Price: types.NewMoney(1500, "USD"), Currency: "USD", Quantity: 2, CreatedAt: time.Date(2026, 10, 1, 0, 0, 0, 0, time.UTC)},
+	}
+}
+
+// filters, sorts and pages like the SQL does
+func (m *mockProductStore) GetProducts(query types.ProductQuery) ([]types.Product, error) {
+	// compares a with b by the sort column, then ID
+	compare := func(a, b types.Product) int {
+		c := 0
+		switch query.Sort {
+		case types.ProductSortCreatedAt:
+			c = a.CreatedAt.Compare(b.CreatedAt)
+		case types.ProductSortPrice:
+			c = a.Price.Cmp(b.Price)
+		case types.ProductSortName:
+			c = strings.Compare(a.Name, b.Name)
+		}
+		if c == 0 {
+			c = a.ID - b.ID
+		}
+		if query.Desc {
+			c = -c
+		}
+		return c
+	}
+
+	var after *types.Product
+	if query.After != nil {
+		after = &types.Product{
+			ID: query.After.ID, CreatedAt: query.After.CreatedAt, Price: query.After.Price, Name: query.After.Name,
+		}
+	}
+
+	products := []types.Product{}
+	for _, p := range m.matching(query) {
+		if after == nil || compare(p, *after) > 0 {
+			products = append(products, p)
+		}
+	}
+	slices.SortFunc(products, compare)
+
+	if len(products) > query.Limit {
+		products = products[:query.Limit]
+	}
+	return products, nil
+}
+
+func (m *mockProductStore) CountProducts(query types.ProductQuery) (int, error) {
+	return len(m.matching(query)), nil
+}
+
+func (m *mockProductStore) matching(query types.ProductQuery) []types.Product {
+	var products []types.Product
+	for _, p := range m.catalog() {
+		switch {
+		case query.MinPrice != nil && p.Price.Cmp(*query.MinPrice) < 0,
+			query.MaxPrice != nil && p.Price.Cmp(*query.MaxPrice) > 0,
+			query.InStock && p.Quantity == 0,
+			query.CategoryID != 0 && !m.inCategory(p.ID, query.CategoryID),
+			query.CreatedAfter != nil && p.CreatedAt.Before(*query.CreatedAfter),
+			query.CreatedBefore != nil && !p.CreatedAt.Before(*query.CreatedBefore),
+			m.deleted[p.ID]:
+			continue
+		}
+		products = append(products, p)
+	}
+	return products
+}
+
+// whether the product is in the category or one under it
+func (m *mockProductStore) inCategory(productID int, categoryID int) bool {
+	for _, id := range m.categories[productID] {
+		for ok := true; ok; id, ok = m.parents[id] {
+			if id == categoryID {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func (m *mockProductStore) GetProductByID(id int) (*types.Product, error) {
+	if id != 1 || m.deleted[id] {
+		return nil, fmt.Errorf("product not found")
+	}
+	return &types.Product{ID: id, Name: "shirt", Price: types.NewMoney(2000, "USD"), Quantity: 5}, nil
+}
+
+func (m *mockProductStore) CreateProduct(types.Product) error {
+	return nil
+}
+
+func (m *mockProductStore) UpdateProduct(id int, product types.Product) error {
+	return nil
+}
+
+func (m *mockProductStore) ProductExists(id int) (bool, error) {
+	return id == 1, nil
+}
+
+func (m *mockProductStore) UpdateProductQuantity(id int, newQuantity int) error {
+	return nil
+}
+
+func (m *mockProductStore) GetProductPrices(ids []int, code string) (map[int]types.Money, error) {
+	prices := make(map[int]types.Money)
+	for _, id := range ids {
+		if price, ok := m.prices[id]; ok && price.Currency == code {
+			prices[id] = price
+		}
+	}
+	return prices, nil
+}
+
+func (m *mockProductStore) SetProductPrice(productID int, price types.Money) error {
+	m.prices[productID] = price
+	return nil
+}
+
+func (m *mockProductStore) DeleteProduct(id int) error {
+	if !m.exists(id) || m.deleted[id] {
+		return ErrProductNotFound
+	}
+	if m.deleted == nil {
+		m.deleted = map[int]bool{}
+	}
+	m.deleted[id] = true
+	return nil
+}
+
+func (m *mockProductStore) RestoreProduct(id int) error {
+	if !m.exists(id) {
+		return ErrProductNotFound
+	}
+	if !m.deleted[id] {
+		return ErrProductNotDeleted
+	}
+	delete(m.deleted, id)
+	return nil
+}
+
+// deleted or not
+func (m *mockProductStore) exists(id int) bool {
+	for _, p := range m.catalog() {
+		if p.ID == id {
+			return true
+		}
+	}
+	return false
+}
+
+type mockRateStore struct{}
+
+func (m *mockRateStore) GetExchangeRate(code string) (*types.ExchangeRate, error) {
+	rates := map[string]string{"EUR": "0.92150000", "JPY": "151.20000000"}
+	rate, ok := rates[code]
+	if !ok {
+		return nil, currency.ErrUnsupportedCurrency
+	}
+	return &types.ExchangeRate{Currency: code, Rate: rate}, nil
+}
+
+func (m *mockRateStore) ReplaceExchangeRates(rates []types.ExchangeRate) error {
+	return nil
+}
+
+type mockCategoryStore struct {
+	categories []types.Category
+	// paths to the categories of each product
+	breadcrumbs map[int][][]types.Category
+}
+
+func (m *mockCategoryStore) GetCategories() ([]types.Category, error) {
+	return m.categories, nil
+}
+
+func (m *mockCategoryStore) GetCategoryByID(id int) (*types.Category, error) {
+	for _, c := range m.categories {
+		if c.ID == id {
+			return &c, nil
+		}
+	}
+	return nil, category.ErrCategoryNotFound
+}
+
+func (m *mockCategoryStore) GetCategoryBySlug(slug string) (*types.Category, error) {
+	for _, c := range m.categories {
+		if c.Slug == slug {
+			return &c, nil
+		}
+	}
+	return nil, category.ErrCategoryNotFound
+}
+
+func (m *mockCategoryStore) CreateCategory(types.Category) (int, error) {
+	return 0, nil
+}
+
+func (m *mockCategoryStore) UpdateCategory(types.Category) error {
+	return nil
+}
+
+func (m *mockCategoryStore) MoveCategory(id int, parentID *int) error {
+	return nil
+}
+
+func (m *mockCategoryStore) DeleteCategory(id int) error {
+	return nil
+}
+
+func (m *mockCategoryStore) GetProductBreadcrumbs(productID int) ([][]types.Category, error) {
+	if paths, ok := m.breadcrumbs[productID]; ok {
+		return paths, nil
+	}
+	return [][]types.Category{}, nil
+}
+
+func (m *mockCategoryStore) SetProductCategories(productID int, categoryIDs []int) error {
+	return nil
+}
+
+type mockVariantStore struct {
+	options  map[int][]types.ProductOption
+	variants map[int][]types.ProductVariant
+}
+
+func (m *mockVariantStore) GetProductVariants(productID int) ([]types.ProductOption, []types.ProductVariant, error) {
+	options := append([]types.ProductOption{}, m.options[productID]...)
+	variants := append([]types.ProductVariant{}, m.variants[productID]...)
+	return options, variants, nil
+}
+
+func (m *mockVariantStore) GetVariantByID(id int) (*types.ProductVariant, error) {
+	return nil, fmt.Errorf("variant not found")
+}
+
+func (m *mockVariantStore) GetVariantBySKU(sku string) (*types.ProductVariant, error) {
+	return nil, fmt.Errorf("variant not found")
+}
+
+func (m *mockVariantStore) SetProductOptions(productID int, options []types.ProductOption) error {
+	return nil
+}
+
+func (m *mockVariantStore) CreateVariant(types.ProductVariant) (int, error) {
+	return 0, nil
+}
+
+func (m *mockVariantStore) UpdateVariant(types.ProductVariant) error {
+	return nil
+}
+
+func (m *mockVariantStore) DeleteVariant(id int) error {
+	return nil
+}
+
+var userStore = &authtest.UserStore{Roles: map[int]string{1: types.RoleAdmin, 2: types.RoleCustomer}}
@@ -2,12 +2,22 @@ package product
 
 import (
 	"database/sql"
+	"errors"
 	"fmt"
+	"strings"
 	"time"
 
+	"github.com/eugenius-watchman/ecom_go_rest_api/cmd/service/category"
+	"github.com/eugenius-watchman/ecom_go_rest_api/config"
 	"github.com/eugenius-watchman/ecom_go_rest_api/types"
 )
 
+var (
+	ErrProductNotFound = errors.New("product not found")
+	// returned when restoring a product that isn't deleted
+	ErrProductNotDeleted = errors.New("product is not deleted")
+)
+
 type Store struct {
 	db *sql.DB
 }
@@ -27,19 +37,54 @@ func (s *Store) ProductExists(id int) (bool, error) {
 	return exists, nil
 }
 
-func (s *Store) GetProducts() ([]types.Product, error) {
-	const query = `
-			SELECT id, name, description, image, price, quantity, createdAt
-			FROM products
-			ORDER BY createdAt DESC`
+// the only columns ever put in ORDER BY
+var sortColumns = map[types.ProductSort]string{
+	types.ProductSortCreatedAt: "createdAt",
+	types.ProductSortPrice:     "price",
+	types.ProductSortName:      "name",
+}
+
+// keyset pagination ... a page starts after the cursor's sort value and ID,
+// so products added meanwhile never shift the pages
+func (s *Store) GetProducts(query types.ProductQuery) ([]types.Product, error) {
+	where, args := productFilters(query)
+
+	column, ok := sortColumns[query.Sort]
+	if !ok {
+		return nil, fmt.Errorf("cannot sort products by %q", query.Sort)
+	}
+	direction, op := "ASC", ">"
+	if query.Desc {
+		direction, op = "DESC", "<"
+	}
+
+	if query.After != nil {
+		var value any
+		switch query.Sort {
+		case types.ProductSortCreatedAt:
+			value = query.After.CreatedAt
+		case types.ProductSortPrice:
+			value = query.After.Price
+		case types.ProductSortName:
+			value = query.After.Name
+		}
+		where = append(where, fmt.Sprintf("(%[1]s %[2]s ? OR (%[1]s = ? AND id %[2]s ?))", column, op))
+		args = append(args, value, value, query.After.ID)
+	}
 
-	rows, err := s.db.Query(query)
+	q := `
+			SELECT id, name, description, image, price, quantity, taxClass, weight, length, width, height, createdAt
+			FROM products` + whereClause(where) +
+		fmt.Sprintf(" ORDER BY %[1]s %[2]s, id %[2]s LIMIT ?", column, direction)
+	args = append(args, query.Limit)
+
+	rows, err := s.db.Query(q, args...)
 	if err != nil {
 		return nil, fmt.Errorf("failed to query products: %w", err)
 	}
 	defer rows.Close()
 
-	var products []types.Product
+	products := []types.Product{}
 	for rows.Next() {
 		p, err := scanRowsIntoProducts(rows)
 		if err != nil {
@@ -57,17 +102,68 @@ func (s *Store) GetProducts() ([]types.Product, error) {
 	return products, nil
 }
 
+func (s *Store) CountProducts(query types.ProductQuery) (int, error) {
+	where, args := productFilters(query)
+
+	var count int
+	if err := s.db.QueryRow("SELECT COUNT(*) FROM products"+whereClause(where), args...).Scan(&count); err != nil {
+		return 0, fmt.Errorf("failed to count products: %w", err)
+	}
+
+	return count, nil
+}
+
+// the conditions of query's filters, without the cursor
+func productFilters(query types.ProductQuery) ([]string, []any) {
+	// deleted products are never listed
+	where := []string{"deletedAt IS NULL"}
+	var args []any
+
+	if query.MinPrice != nil {
+		where = append(where, "price >= ?")
+		args = append(args, *query.MinPrice)
+	}
+	if query.MaxPrice != nil {
+		where = append(where, "price <= ?")
+		args = append(args, *query.MaxPrice)
+	}
+	if query.InStock {
+		where = append(where, "quantity > 0")
+	}
+	if query.CategoryID != 0 {
+		where = append(where, "EXISTS (SELECT 1 FROM product_categories pc WHERE pc.productId = products.id AND pc.categoryId IN ("+category.SubtreeQuery+"))")
+		args = append(args, query.CategoryID)
+	}
+	if query.CreatedAfter != nil {
+		where = append(where, "createdAt >= ?")
+		args = append(args, *query.CreatedAfter)
+	}
+	if query.CreatedBefore != nil {
+		where = append(where, "createdAt < ?")
+		args = append(args, *query.CreatedBefore)
+	}
+
+	return where, args
+}
+
+func whereClause(where []string) string {
+	if len(where) == 0 {
+		return ""
+	}
+	return " WHERE " + strings.Join(where, " AND ")
+}
+
 func (s *Store) GetProductByID(id int) (*types.Product, error) {
 	const query = `
-		SELECT id, name, description, image, price, quantity, createdAt 
-		FROM products WHERE id = ?`
+		SELECT id, name, description, image, price, quantity, taxClass, weight, length, width, height, createdAt 
+		FROM products WHERE id = ? AND deletedAt IS NULL`
 
 	row := s.db.QueryRow(query, id)
 
 	product, err := scanRowIntoProduct(row)
 	if err != nil {
 		if err == sql.ErrNoRows {
-			return nil, fmt.Errorf("product not found")
+			return nil, ErrProductNotFound
 		}
 		return nil, fmt.Errorf("failed to get product: %w", err)
 	}
@@ -77,8 +173,8 @@ func (s *Store) GetProductByID(id int) (*types.Product, error) {
 
 func (s *Store) CreateProduct(product types.Product) error {
 	const query = `
-			INSERT INTO products (name, description, image, price, quantity)
-				VALUES (?, ?, ?, ?, ?)`
+			INSERT INTO products (name, description, image, price, quantity, taxClass, weight, length, width, height)
+				VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`
 
 	_, err := s.db.Exec(
 		query,
@@ -87,6 +183,11 @@ func (s *Store) CreateProduct(product types.Product) error {
 		product.Image,
 		product.Price,
 		product.Quantity,
+		product.TaxClass,
+		product.Weight,
+		product.Length,
+		product.Width,
+		product.Height,
 	)
 	return err
 }
@@ -108,7 +209,8 @@ func (s *Store) UpdateProductQuantity(id int, newQuantity int) error {
 func (s *Store) UpdateProduct(id int, product types.Product) error {
 	const query = `
 			UPDATE products
-			SET name = ?, description = ?, image = ?, price = ?, quantity = ?
+			SET name = ?, description = ?, image = ?, price = ?, quantity = ?, taxClass = ?,
+				weight = ?, length = ?, width = ?, height = ?
 			WHERE id = ?`
 
 	result, err := s.db.Exec(
@@ -118,6 +220,11 @@ func (s *Store) UpdateProduct(id int, product types.Product) error {
 		product.Image,
 		product.Price,
 		product.Quantity,
+		product.TaxClass,
+		product.Weight,
+		product.Length,
+		product.Width,
+		product.Height,
 		id,
 	)
 	if err != nil {
@@ -137,8 +244,96 @@ func (s *Store) UpdateProduct(id int, product types.Product) error {
 	return nil
 }
 
+func (s *Store) GetProductPrices(ids []int, currency string) (map[int]types.Money, error) {
+	prices := make(map[int]types.Money)
+	if len(ids) == 0 {
+		return prices, nil
+	}
+
+	args := []any{currency}
+	for _, id := range ids {
+		args = append(args, id)
+	}
+
+	query := fmt.Sprintf(
+		"SELECT productId, price FROM product_prices WHERE currency = ? AND productId IN (?%s)",
+		strings.Repeat(", ?", len(ids)-1),
+	)
+
+	rows, err := s.db.Query(query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query product prices: %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var productID int
+		price := types.NewMoney(0, currency)
+		if err := rows.Scan(&productID, &price); err != nil {
+			return nil, fmt.Errorf("failed to scan product price: %w", err)
+		}
+		prices[productID] = price
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("rows iteration error: %w", err)
+	}
+
+	return prices, nil
+}
+
+func (s *Store) SetProductPrice(productID int, price types.Money) error {
+	const query = `
+		INSERT INTO product_prices (productId, currency, price)
+		VALUES (?, ?, ?)
+		ON DUPLICATE KEY UPDATE price = VALUES(price)`
+
+	if _, err := s.db.Exec(query, productID, price.Currency, price); err != nil {
+		return fmt.Errorf("failed to set product price: %w", err)
+	}
+
+	return nil
+}
+
+func (s *Store) DeleteProduct(id int) error {
+	result, err := s.db.Exec("UPDATE products SET deletedAt = NOW() WHERE id = ? AND deletedAt IS NULL", id)
+	if err != nil {
+		return fmt.Errorf("failed to delete product: %w", err)
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to check rows affected: %w", err)
+	}
+	if rowsAffected == 0 {
+		return ErrProductNotFound
+	}
+
+	return nil
+}
+
+func (s *Store) RestoreProduct(id int) error {
+	var deleted bool
+	err := s.db.QueryRow("SELECT deletedAt IS NOT NULL FROM products WHERE id = ?", id).Scan(&deleted)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return ErrProductNotFound
+		}
+		return fmt.Errorf("failed to get product: %w", err)
+	}
+	if !deleted {
+		return ErrProductNotDeleted
+	}
+
+	if _, err := s.db.Exec("UPDATE products SET deletedAt = NULL WHERE id = ?", id); err != nil {
+		return fmt.Errorf("failed to restore product: %w", err)
+	}
+
+	return nil
+}
+
 func scanRowIntoProduct(row *sql.Row) (*types.Product, error) {
-	product := new(types.Product)
+	product := newProduct()
 	err := row.Scan(
 		&product.ID,
 		&product.Name,
@@ -146,6 +341,11 @@ func scanRowIntoProduct(row *sql.Row) (*types.Product, error) {
 		&product.Image,
 		&product.Price,
 		&product.Quantity,
+		&product.TaxClass,
+		&product.Weight,
+		&product.Length,
+		&product.Width,
+		&product.Height,
 		&product.CreatedAt,
 	)
 	if err != nil {
@@ -155,7 +355,7 @@ func scanRowIntoProduct(row *sql.Row) (*types.Product, error) {
 }
 
 func scanRowsIntoProducts(rows *sql.Rows) (*types.Product, error) {
-	product := new(types.Product)
+	product := newProduct()
 
 	err := rows.Scan(
 		&product.ID,
@@ -164,6 +364,11 @@ func scanRowsIntoProducts(rows *sql.Rows) (*types.Product, error) {
 		&product.Image,
 		&product.Price,
 		&product.Quantity,
+		&product.TaxClass,
+		&product.Weight,
+		&product.Length,
+		&product.Width,
+		&product.Height,
 		&product.CreatedAt,
 	)
 	if err != nil {
@@ -172,3 +377,12 @@ func scanRowsIntoProducts(rows *sql.Rows) (*types.Product, error) {
 
 	return product, nil
 }
+
+// prices are stored in the shop's currency, set before scanning so the
+// DECIMAL is read with the right number of decimals
+func newProduct() *types.Product {
+	return &types.Product{
+		Price:    types.NewMoney(0, config.Envs.DefaultCurrency),
+		Currency: config.Envs.DefaultCurrency,
+	}
+}
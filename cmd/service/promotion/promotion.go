@@ -0,0 +1,258 @@
+package promotion
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/eugenius-watchman/ecom_go_rest_api/cmd/service/currency"
+	"github.com/eugenius-watchman/ecom_go_rest_api/config"
+	"github.com/eugenius-watchman/ecom_go_rest_api/types"
+)
+
+// every reason a code can't be used wraps ErrInvalidPromotion
+var (
+	ErrInvalidPromotion   = errors.New("invalid promotion code")
+	ErrPromotionNotFound  = fmt.Errorf("%w: not found", ErrInvalidPromotion)
+	ErrPromotionNotActive = fmt.Errorf("%w: not active", ErrInvalidPromotion)
+	ErrPromotionUsedUp    = fmt.Errorf("%w: usage limit reached", ErrInvalidPromotion)
+	ErrPromotionUserLimit = fmt.Errorf("%w: already used the maximum number of times", ErrInvalidPromotion)
+	ErrMinimumSpend       = fmt.Errorf("%w: minimum spend not met", ErrInvalidPromotion)
+	ErrNotApplicable      = fmt.Errorf("%w: nothing in the order qualifies", ErrInvalidPromotion)
+)
+
+var ErrInvalidPayload = errors.New("invalid promotion")
+
+// Line is an order line as the rules see it, Price is the unit price
+type Line struct {
+	ProductID int
+	// 0 for a product sold without variants
+	VariantID   int
+	CategoryIDs []int
+	Quantity    int
+	Price       types.Money
+}
+
+func (l Line) key() types.LineKey {
+	return types.LineKey{ProductID: l.ProductID, VariantID: l.VariantID}
+}
+
+// Result is what a promotion takes off an order
+type Result struct {
+	Amount types.Money
+	// Amount split across the eligible lines
+	ByLine       map[types.LineKey]types.Money
+	FreeShipping bool
+}
+
+// CheckUsage fails once the promotion is used up, overall or by a user who
+// has already redeemed it usedByUser times
+func CheckUsage(p *types.Promotion, usedByUser int) error {
+	if p.UsageLimit != nil && p.UsageCount >= *p.UsageLimit {
+		return ErrPromotionUsedUp
+	}
+
+	if p.PerUserLimit != nil && usedByUser >= *p.PerUserLimit {
+		return ErrPromotionUserLimit
+	}
+
+	return nil
+}
+
+// Evaluate works out the discount p gives lines of an order priced in
+// currency ... the discount never exceeds what the eligible lines cost
+func Evaluate(p *types.Promotion, lines []Line, currency string, now time.Time) (*Result, error) {
+	if !p.Active || (p.StartsAt != nil && now.Before(*p.StartsAt)) || (p.EndsAt != nil && !now.Before(*p.EndsAt)) {
+		return nil, ErrPromotionNotActive
+	}
+
+	subtotal := types.NewMoney(0, currency)
+	for _, line := range lines {
+		subtotal = subtotal.Add(line.Price.Mul(int64(line.Quantity)))
+	}
+
+	// amounts only mean something in the promotion's own currency
+	if p.Type == types.PromotionFixed || !p.MinSpend.IsZero() {
+		if p.Currency != currency {
+			return nil, fmt.Errorf("%w: only valid for orders in %s", ErrNotApplicable, p.Currency)
+		}
+
+		if subtotal.Cmp(p.MinSpend) < 0 {
+			return nil, fmt.Errorf("%w: spend at least %s %s", ErrMinimumSpend, p.MinSpend, p.Currency)
+		}
+	}
+
+	eligible := eligibleLines(p, lines)
+	if len(eligible) == 0 {
+		return nil, ErrNotApplicable
+	}
+
+	eligibleTotal := types.NewMoney(0, currency)
+	for _, line := range eligible {
+		eligibleTotal = eligibleTotal.Add(line.Price.Mul(int64(line.Quantity)))
+	}
+
+	result := &Result{
+		Amount: types.NewMoney(0, currency),
+		ByLine: make(map[types.LineKey]types.Money),
+	}
+
+	switch p.Type {
+	case types.PromotionPercent:
+		result.Amount = eligibleTotal.Percent(int64(p.PercentOff) * 100)
+		allocate(result, eligible)
+	case types.PromotionFixed:
+		result.Amount = p.AmountOff.Min(eligibleTotal)
+		allocate(result, eligible)
+	case types.PromotionFreeShipping:
+		result.FreeShipping = true
+	case types.PromotionBuyXGetY:
+		freeCheapestUnits(p, eligible, result)
+		if result.Amount.IsZero() {
+			return nil, fmt.Errorf("%w: buy %d to get %d free", ErrNotApplicable, p.BuyQuantity, p.GetQuantity)
+		}
+	default:
+		return nil, fmt.Errorf("unknown promotion type %q", p.Type)
+	}
+
+	return result, nil
+}
+
+// FromPayload builds a promotion from the admin payload, parsing its
+// amounts in the payload's currency (the shop's currency by default)
+func FromPayload(payload types.PromotionPayload) (types.Promotion, error) {
+	code := strings.ToUpper(payload.Currency)
+	if code == "" {
+		code = config.Envs.DefaultCurrency
+	}
+	if !currency.IsCode(code) {
+		return types.Promotion{}, fmt.Errorf("%w: unknown currency %q", ErrInvalidPayload, payload.Currency)
+	}
+
+	p := types.Promotion{
+		Code:         strings.ToUpper(strings.TrimSpace(payload.Code)),
+		Description:  payload.Description,
+		Type:         payload.Type,
+		PercentOff:   payload.PercentOff,
+		AmountOff:    types.NewMoney(0, code),
+		Currency:     code,
+		MinSpend:     types.NewMoney(0, code),
+		BuyQuantity:  payload.BuyQuantity,
+		GetQuantity:  payload.GetQuantity,
+		StartsAt:     payload.StartsAt,
+		EndsAt:       payload.EndsAt,
+		UsageLimit:   payload.UsageLimit,
+		PerUserLimit: payload.PerUserLimit,
+		ProductIDs:   payload.ProductIDs,
+		CategoryIDs:  payload.CategoryIDs,
+		Active:       payload.Active == nil || *payload.Active,
+	}
+
+	if payload.AmountOff != "" {
+		amount, err := types.ParseMoney(payload.AmountOff, code)
+		if err != nil || amount.IsNegative() || amount.IsZero() {
+			return types.Promotion{}, fmt.Errorf("%w: amountOff must be a positive amount in %s", ErrInvalidPayload, code)
+		}
+		p.AmountOff = amount
+	}
+
+	if payload.MinSpend != "" {
+		amount, err := types.ParseMoney(payload.MinSpend, code)
+		if err != nil || amount.IsNegative() {
+			return types.Promotion{}, fmt.Errorf("%w: minSpend must be an amount in %s", ErrInvalidPayload, code)
+		}
+		p.MinSpend = amount
+	}
+
+	if p.StartsAt != nil && p.EndsAt != nil && !p.EndsAt.After(*p.StartsAt) {
+		return types.Promotion{}, fmt.Errorf("%w: endsAt must be after startsAt", ErrInvalidPayload)
+	}
+
+	if p.ProductIDs == nil {
+		p.ProductIDs = []int{}
+	}
+	if p.CategoryIDs == nil {
+		p.CategoryIDs = []int{}
+	}
+
+	return p, nil
+}
+
+// lines in scope ... a line qualifies through its product or any of its
+// categories
+func eligibleLines(p *types.Promotion, lines []Line) []Line {
+	if len(p.ProductIDs) == 0 && len(p.CategoryIDs) == 0 {
+		return lines
+	}
+
+	products := make(map[int]bool, len(p.ProductIDs))
+	for _, id := range p.ProductIDs {
+		products[id] = true
+	}
+	categories := make(map[int]bool, len(p.CategoryIDs))
+	for _, id := range p.CategoryIDs {
+		categories[id] = true
+	}
+
+	var eligible []Line
+	for _, line := range lines {
+		if products[line.ProductID] {
+			eligible = append(eligible, line)
+			continue
+		}
+		for _, id := range line.CategoryIDs {
+			if categories[id] {
+				eligible = append(eligible, line)
+				break
+			}
+		}
+	}
+
+	return eligible
+}
+
+// splits result.Amount over lines by what each costs
+func allocate(result *Result, lines []Line) {
+	weights := make([]int64, len(lines))
+	for i, line := range lines {
+		weights[i] = line.Price.Mul(int64(line.Quantity)).Amount
+	}
+
+	for i, share := range result.Amount.Allocate(weights) {
+		result.ByLine[lines[i].key()] = share
+	}
+}
+
+// every BuyQuantity+GetQuantity eligible units earn GetQuantity free ones,
+// and the free ones are always the cheapest
+func freeCheapestUnits(p *types.Promotion, lines []Line, result *Result) {
+	if p.BuyQuantity < 1 || p.GetQuantity < 1 {
+		return
+	}
+
+	units := 0
+	for _, line := range lines {
+		units += line.Quantity
+	}
+
+	free := units / (p.BuyQuantity + p.GetQuantity) * p.GetQuantity
+
+	cheapest := append([]Line(nil), lines...)
+	sort.SliceStable(cheapest, func(i, j int) bool {
+		return cheapest[i].Price.Cmp(cheapest[j].Price) < 0
+	})
+
+	for _, line := range cheapest {
+		if free == 0 {
+			break
+		}
+
+		n := min(free, line.Quantity)
+		share := line.Price.Mul(int64(n))
+		result.ByLine[line.key()] = share
+		result.Amount = result.Amount.Add(share)
+		free -= n
+	}
+}
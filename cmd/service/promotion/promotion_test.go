@@ -0,0 +1,195 @@
+package promotion
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/eugenius-watchman/ecom_go_rest_api/types"
+)
+
+func TestEvaluate(t *testing.T) {
+	now := time.Date(2026, 10, 18, 12, 0, 0, 0, time.UTC)
+	yesterday := now.Add(-24 * time.Hour)
+	tomorrow := now.Add(24 * time.Hour)
+
+	usd := func(cents int64) types.Money { return types.NewMoney(cents, "USD") }
+
+	// shirt 20.00 x2 in category 7, hat 10.00 x1, socks 5.00 x3
+	lines := []Line{
+		{ProductID: 1, CategoryIDs: []int{7}, Quantity: 2, Price: usd(2000)},
+		{ProductID: 2, Quantity: 1, Price: usd(1000)},
+		{ProductID: 3, Quantity: 3, Price: usd(500)},
+	}
+
+	tests := []struct {
+		name      string
+		promotion types.Promotion
+		currency  string
+		amount    int64
+		byProduct map[int]int64
+		err       error
+	}{
+		{
+			name:      "percent off the whole order",
+			promotion: types.Promotion{Type: types.PromotionPercent, PercentOff: 10},
+			amount:    650,
+			byProduct: map[int]int64{1: 400, 2: 100, 3: 150},
+		},
+		{
+			name:      "percent off a category",
+			promotion: types.Promotion{Type: types.PromotionPercent, PercentOff: 25, CategoryIDs: []int{7}},
+			amount:    1000,
+			byProduct: map[int]int64{1: 1000},
+		},
+		{
+			name:      "fixed amount capped at the eligible lines",
+			promotion: types.Promotion{Type: types.PromotionFixed, AmountOff: usd(5000), ProductIDs: []int{2}},
+			amount:    1000,
+			byProduct: map[int]int64{2: 1000},
+		},
+		{
+			name:      "fixed amount only in its own currency",
+			promotion: types.Promotion{Type: types.PromotionFixed, AmountOff: usd(500)},
+			currency:  "EUR",
+			err:       ErrNotApplicable,
+		},
+		{
+			name:      "minimum spend met",
+			promotion: types.Promotion{Type: types.PromotionPercent, PercentOff: 50, MinSpend: usd(6500)},
+			amount:    3250,
+		},
+		{
+			name:      "minimum spend not met",
+			promotion: types.Promotion{Type: types.PromotionPercent, PercentOff: 50, MinSpend: usd(6501)},
+			err:       ErrMinimumSpend,
+		},
+		{
+			name:      "buy 2 get 1 frees the cheapest units",
+			promotion: types.Promotion{Type: types.PromotionBuyXGetY, BuyQuantity: 2, GetQuantity: 1},
+			amount:    1000,
+			byProduct: map[int]int64{3: 1000},
+		},
+		{
+			name:      "buy x get y needs enough eligible units",
+			promotion: types.Promotion{Type: types.PromotionBuyXGetY, BuyQuantity: 2, GetQuantity: 1, ProductIDs: []int{1}},
+			err:       ErrNotApplicable,
+		},
+		{
+			name:      "free shipping takes nothing off the items",
+			promotion: types.Promotion{Type: types.PromotionFreeShipping},
+			amount:    0,
+		},
+		{
+			name:      "out of scope",
+			promotion: types.Promotion{Type: types.PromotionPercent, PercentOff: 10, ProductIDs: []int{99}},
+			err:       ErrNotApplicable,
+		},
+		{
+			name:      "not started yet",
+			promotion: types.Promotion{Type: types.PromotionPercent, PercentOff: 10, StartsAt: &tomorrow},
+			err:       ErrPromotionNotActive,
+		},
+		{
+			name:      "ended",
+			promotion: types.Promotion{Type: types.PromotionPercent, PercentOff: 10, EndsAt: &yesterday},
+			err:       ErrPromotionNotActive,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := tt.promotion
+			p.Active = true
+			p.Currency = "USD"
+			if p.MinSpend.Currency == "" {
+				p.MinSpend = usd(0)
+			}
+
+			code := tt.currency
+			orderLines := lines
+			if code == "" {
+				code = "USD"
+			} else {
+				orderLines = nil
+				for _, line := range lines {
+					line.Price = types.NewMoney(line.Price.Amount, code)
+					orderLines = append(orderLines, line)
+				}
+			}
+
+			result, err := Evaluate(&p, orderLines, code, now)
+			if tt.err != nil {
+				if !errors.Is(err, tt.err) {
+					t.Fatalf("expected %v, got %v", tt.err, err)
+				}
+				if !errors.Is(err, ErrInvalidPromotion) {
+					t.Errorf("expected %v to be an invalid promotion", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if result.Amount.Amount != tt.amount {
+				t.Errorf("expected discount %d, got %v", tt.amount, result.Amount)
+			}
+			for productID, want := range tt.byProduct {
+				if got := result.ByLine[types.LineKey{ProductID: productID}].Amount; got != want {
+					t.Errorf("expected product %d discount %d, got %d", productID, want, got)
+				}
+			}
+			if result.FreeShipping != (p.Type == types.PromotionFreeShipping) {
+				t.Errorf("unexpected free shipping %v", result.FreeShipping)
+			}
+		})
+	}
+}
+
+func TestCheckUsage(t *testing.T) {
+	two := 2
+	p := &types.Promotion{UsageLimit: &two, PerUserLimit: &two}
+
+	if err := CheckUsage(p, 1); err != nil {
+		t.Errorf("expected the promotion to be usable, got %v", err)
+	}
+	if err := CheckUsage(p, 2); !errors.Is(err, ErrPromotionUserLimit) {
+		t.Errorf("expected the per-user limit, got %v", err)
+	}
+
+	p.UsageCount = 2
+	if err := CheckUsage(p, 0); !errors.Is(err, ErrPromotionUsedUp) {
+		t.Errorf("expected the global limit, got %v", err)
+	}
+}
+
+func TestFromPayload(t *testing.T) {
+	t.Run("should parse amounts in the payload currency", func(t *testing.T) {
+		p, err := FromPayload(types.PromotionPayload{
+			Code: " welcome5 ", Type: types.PromotionFixed, AmountOff: "500", Currency: "jpy",
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if p.Code != "WELCOME5" || p.AmountOff != types.NewMoney(500, "JPY") || !p.Active {
+			t.Errorf("unexpected promotion %+v", p)
+		}
+	})
+
+	t.Run("should reject a non positive amount", func(t *testing.T) {
+		_, err := FromPayload(types.PromotionPayload{Code: "X", Type: types.PromotionFixed, AmountOff: "0"})
+		if !errors.Is(err, ErrInvalidPayload) {
+			t.Errorf("expected an invalid payload, got %v", err)
+		}
+	})
+
+	t.Run("should reject an end before the start", func(t *testing.T) {
+		start := time.Now()
+		end := start.Add(-time.Hour)
+		_, err := FromPayload(types.PromotionPayload{Code: "X", Type: types.PromotionFreeShipping, StartsAt: &start, EndsAt: &end})
+		if !errors.Is(err, ErrInvalidPayload) {
+			t.Errorf("expected an invalid payload, got %v", err)
+		}
+	})
+}
@@ -0,0 +1,171 @@
+package promotion
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/eugenius-watchman/ecom_go_rest_api/cmd/service/auth"
+	"github.com/eugenius-watchman/ecom_go_rest_api/types"
+	"github.com/eugenius-watchman/ecom_go_rest_api/utils"
+	"github.com/go-playground/validator/v10"
+	"github.com/gorilla/mux"
+)
+
+type Handler struct {
+	store     types.PromotionStore
+	userStore types.UserStore
+}
+
+func NewHandler(store types.PromotionStore, userStore types.UserStore) *Handler {
+	return &Handler{store: store, userStore: userStore}
+}
+
+func (h *Handler) RegisterRoutes(router *mux.Router) {
+	// admin only ... customers just send a code at checkout
+	router.HandleFunc("/promotions", auth.WithRole(h.handleGetPromotions, h.userStore, types.RoleAdmin)).Methods("GET")
+	router.HandleFunc("/promotions", auth.WithRole(h.handleCreatePromotion, h.userStore, types.RoleAdmin)).Methods("POST")
+	router.HandleFunc("/promotions/{id}", auth.WithRole(h.handleGetPromotion, h.userStore, types.RoleAdmin)).Methods("GET")
+	router.HandleFunc("/promotions/{id}", auth.WithRole(h.handleUpdatePromotion, h.userStore, types.RoleAdmin)).Methods("PUT")
+	router.HandleFunc("/promotions/{id}", auth.WithRole(h.handleDeletePromotion, h.userStore, types.RoleAdmin)).Methods("DELETE")
+}
+
+func (h *Handler) handleGetPromotions(w http.ResponseWriter, r *http.Request) {
+	promotions, err := h.store.GetPromotions()
+	if err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	utils.WriteJSON(w, http.StatusOK, promotions)
+}
+
+func (h *Handler) handleGetPromotion(w http.ResponseWriter, r *http.Request) {
+	id, ok := promotionID(w, r)
+	if !ok {
+		return
+	}
+
+	p, err := h.store.GetPromotionByID(id)
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+
+	utils.WriteJSON(w, http.StatusOK, p)
+}
+
+func (h *Handler) handleCreatePromotion(w http.ResponseWriter, r *http.Request) {
+	p, ok := parsePromotion(w, r)
+	if !ok {
+		return
+	}
+
+	// codes are unique
+	if _, err := h.store.GetPromotionByCode(p.Code); err == nil {
+		utils.WriteError(w, http.StatusConflict, fmt.Errorf("promotion code %s already exists", p.Code))
+		return
+	}
+
+	id, err := h.store.CreatePromotion(p)
+	if err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	created, err := h.store.GetPromotionByID(id)
+	if err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	utils.WriteJSON(w, http.StatusCreated, created)
+}
+
+func (h *Handler) handleUpdatePromotion(w http.ResponseWriter, r *http.Request) {
+	id, ok := promotionID(w, r)
+	if !ok {
+		return
+	}
+
+	p, ok := parsePromotion(w, r)
+	if !ok {
+		return
+	}
+	p.ID = id
+
+	if existing, err := h.store.GetPromotionByCode(p.Code); err == nil && existing.ID != id {
+		utils.WriteError(w, http.StatusConflict, fmt.Errorf("promotion code %s already exists", p.Code))
+		return
+	}
+
+	if err := h.store.UpdatePromotion(p); err != nil {
+		writeStoreError(w, err)
+		return
+	}
+
+	updated, err := h.store.GetPromotionByID(id)
+	if err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	utils.WriteJSON(w, http.StatusOK, updated)
+}
+
+func (h *Handler) handleDeletePromotion(w http.ResponseWriter, r *http.Request) {
+	id, ok := promotionID(w, r)
+	if !ok {
+		return
+	}
+
+	if err := h.store.DeletePromotion(id); err != nil {
+		writeStoreError(w, err)
+		return
+	}
+
+	utils.WriteJSON(w, http.StatusOK, map[string]string{"message": "promotion deleted"})
+}
+
+func promotionID(w http.ResponseWriter, r *http.Request) (int, bool) {
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		utils.WriteError(w, http.StatusBadRequest, fmt.Errorf("invalid promotion ID"))
+		return 0, false
+	}
+
+	return id, true
+}
+
+// parses and validates the payload, writing the error response on failure
+func parsePromotion(w http.ResponseWriter, r *http.Request) (types.Promotion, bool) {
+	var payload types.PromotionPayload
+	if err := utils.ParseJSON(r, &payload); err != nil {
+		utils.WriteError(w, http.StatusBadRequest, err)
+		return types.Promotion{}, false
+	}
+
+	if err := utils.Validate.Struct(payload); err != nil {
+		errors := err.(validator.ValidationErrors)
+		utils.WriteError(w, http.StatusBadRequest, fmt.Errorf("invalid payload %w", errors))
+		return types.Promotion{}, false
+	}
+
+	p, err := FromPayload(payload)
+	if err != nil {
+		utils.WriteError(w, http.StatusBadRequest, err)
+		return types.Promotion{}, false
+	}
+
+	return p, true
+}
+
+func writeStoreError(w http.ResponseWriter, err error) {
+	if errors.Is(err, ErrPromotionNotFound) {
+		utils.WriteError(w, http.StatusNotFound, fmt.Errorf("promotion not found"))
+		return
+	}
+
+	utils.WriteError(w, http.StatusInternalServerError, err)
+}
@@ -0,0 +1,161 @@
+package promotion
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/eugenius-watchman/ecom_go_rest_api/cmd/service/auth/authtest"
+	"github.com/eugenius-watchman/ecom_go_rest_api/types"
+	"github.com/gorilla/mux"
+)
+
+func TestPromotionServiceHandlers(t *testing.T) {
+	store := &mockPromotionStore{promotions: map[int]types.Promotion{}}
+	handler := NewHandler(store, userStore)
+
+	router := mux.NewRouter()
+	handler.RegisterRoutes(router)
+
+	send := func(method, path string, userID int, payload any) *httptest.ResponseRecorder {
+		return authtest.Serve(t, router, method, path, userID, payload)
+	}
+
+	payload := types.PromotionPayload{
+		Code:       "welcome10",
+		Type:       types.PromotionPercent,
+		PercentOff: 10,
+	}
+
+	t.Run("should forbid customers from managing promotions", func(t *testing.T) {
+		if rr := send(http.MethodGet, "/promotions", 2, nil); rr.Code != http.StatusForbidden {
+			t.Errorf("expected status code %d, got %d", http.StatusForbidden, rr.Code)
+		}
+		if rr := send(http.MethodPost, "/promotions", 2, payload); rr.Code != http.StatusForbidden {
+			t.Errorf("expected status code %d, got %d", http.StatusForbidden, rr.Code)
+		}
+	})
+
+	t.Run("should let admins create promotions", func(t *testing.T) {
+		rr := send(http.MethodPost, "/promotions", 1, payload)
+		if rr.Code != http.StatusCreated {
+			t.Fatalf("expected status code %d, got %d: %s", http.StatusCreated, rr.Code, rr.Body)
+		}
+
+		var created types.Promotion
+		json.NewDecoder(rr.Body).Decode(&created)
+		if created.Code != "WELCOME10" || !created.Active {
+			t.Errorf("unexpected promotion %+v", created)
+		}
+	})
+
+	t.Run("should reject a duplicate code", func(t *testing.T) {
+		if rr := send(http.MethodPost, "/promotions", 1, payload); rr.Code != http.StatusConflict {
+			t.Errorf("expected status code %d, got %d", http.StatusConflict, rr.Code)
+		}
+	})
+
+	t.Run("should reject a percent promotion without a percentage", func(t *testing.T) {
+		invalid := types.PromotionPayload{Code: "NOTHING", Type: types.PromotionPercent}
+		if rr := send(http.MethodPost, "/promotions", 1, invalid); rr.Code != http.StatusBadRequest {
+			t.Errorf("expected status code %d, got %d", http.StatusBadRequest, rr.Code)
+		}
+	})
+
+	t.Run("should reject an invalid amount", func(t *testing.T) {
+		invalid := types.PromotionPayload{Code: "FIVE", Type: types.PromotionFixed, AmountOff: "five"}
+		if rr := send(http.MethodPost, "/promotions", 1, invalid); rr.Code != http.StatusBadRequest {
+			t.Errorf("expected status code %d, got %d", http.StatusBadRequest, rr.Code)
+		}
+	})
+
+	t.Run("should update a promotion", func(t *testing.T) {
+		update := payload
+		update.PercentOff = 15
+		rr := send(http.MethodPut, "/promotions/1", 1, update)
+		if rr.Code != http.StatusOK {
+			t.Fatalf("expected status code %d, got %d: %s", http.StatusOK, rr.Code, rr.Body)
+		}
+		if got := store.promotions[1].PercentOff; got != 15 {
+			t.Errorf("expected 15 percent off, got %d", got)
+		}
+	})
+
+	t.Run("should 404 on a missing promotion", func(t *testing.T) {
+		if rr := send(http.MethodGet, "/promotions/42", 1, nil); rr.Code != http.StatusNotFound {
+			t.Errorf("expected status code %d, got %d", http.StatusNotFound, rr.Code)
+		}
+		missing := types.PromotionPayload{Code: "MISSING", Type: types.PromotionFreeShipping}
+		if rr := send(http.MethodPut, "/promotions/42", 1, missing); rr.Code != http.StatusNotFound {
+			t.Errorf("expected status code %d, got %d", http.StatusNotFound, rr.Code)
+		}
+		if rr := send(http.MethodDelete, "/promotions/42", 1, nil); rr.Code != http.StatusNotFound {
+			t.Errorf("expected status code %d, got %d", http.StatusNotFound, rr.Code)
+		}
+	})
+
+	t.Run("should delete a promotion", func(t *testing.T) {
+		if rr := send(http.MethodDelete, "/promotions/1", 1, nil); rr.Code != http.StatusOK {
+			t.Errorf("expected status code %d, got %d", http.StatusOK, rr.Code)
+		}
+		if len(store.promotions) != 0 {
+			t.Error("expected the promotion to be deleted")
+		}
+	})
+}
+
+type mockPromotionStore struct {
+	promotions map[int]types.Promotion
+	nextID     int
+}
+
+func (m *mockPromotionStore) GetPromotions() ([]types.Promotion, error) {
+	promotions := []types.Promotion{}
+	for _, p := range m.promotions {
+		promotions = append(promotions, p)
+	}
+	return promotions, nil
+}
+
+func (m *mockPromotionStore) GetPromotionByID(id int) (*types.Promotion, error) {
+	p, ok := m.promotions[id]
+	if !ok {
+		return nil, ErrPromotionNotFound
+	}
+	return &p, nil
+}
+
+func (m *mockPromotionStore) GetPromotionByCode(code string) (*types.Promotion, error) {
+	for _, p := range m.promotions {
+		if p.Code == code {
+			return &p, nil
+		}
+	}
+	return nil, ErrPromotionNotFound
+}
+
+func (m *mockPromotionStore) CreatePromotion(p types.Promotion) (int, error) {
+	m.nextID++
+	p.ID = m.nextID
+	m.promotions[p.ID] = p
+	return p.ID, nil
+}
+
+func (m *mockPromotionStore) UpdatePromotion(p types.Promotion) error {
+	if _, ok := m.promotions[p.ID]; !ok {
+		return ErrPromotionNotFound
+	}
+	m.promotions[p.ID] = p
+	return nil
+}
+
+func (m *mockPromotionStore) DeletePromotion(id int) error {
+	if _, ok := m.promotions[id]; !ok {
+		return ErrPromotionNotFound
+	}
+	delete(m.promotions, id)
+	return nil
+}
+
+var userStore = &authtest.UserStore{Roles: map[int]string{1: types.RoleAdmin, 2: types.RoleCustomer}}
@@ -0,0 +1,322 @@
+package promotion
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+
+	"github.com/eugenius-watchman/ecom_go_rest_api/types"
+)
+
+type Store struct {
+	db *sql.DB
+}
+
+func NewStore(db *sql.DB) *Store {
+	return &Store{db: db}
+}
+
+const selectPromotion = `
+	SELECT id, code, description, type, percentOff, currency, amountOff, minSpend, buyQuantity, getQuantity,
+		startsAt, endsAt, usageLimit, perUserLimit, usageCount, active, createdAt
+	FROM promotions`
+
+func (s *Store) GetPromotions() ([]types.Promotion, error) {
+	rows, err := s.db.Query(selectPromotion + " ORDER BY createdAt DESC, id DESC")
+	if err != nil {
+		return nil, fmt.Errorf("failed to query promotions: %w", err)
+	}
+	defer rows.Close()
+
+	promotions := []types.Promotion{}
+	for rows.Next() {
+		p, err := scanRowIntoPromotion(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan promotion: %w", err)
+		}
+		promotions = append(promotions, *p)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("rows iteration error: %w", err)
+	}
+
+	for i := range promotions {
+		if err := loadScopes(s.db, &promotions[i]); err != nil {
+			return nil, err
+		}
+	}
+
+	return promotions, nil
+}
+
+func (s *Store) GetPromotionByID(id int) (*types.Promotion, error) {
+	return getPromotion(s.db, selectPromotion+" WHERE id = ?", id)
+}
+
+func (s *Store) GetPromotionByCode(code string) (*types.Promotion, error) {
+	return getPromotion(s.db, selectPromotion+" WHERE code = ?", code)
+}
+
+func (s *Store) CreatePromotion(p types.Promotion) (int, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return 0, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	const query = `
+		INSERT INTO promotions (code, description, type, percentOff, currency, amountOff, minSpend, buyQuantity,
+			getQuantity, startsAt, endsAt, usageLimit, perUserLimit, active)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`
+
+	result, err := tx.Exec(query, p.Code, p.Description, p.Type, p.PercentOff, p.Currency, p.AmountOff, p.MinSpend,
+		p.BuyQuantity, p.GetQuantity, p.StartsAt, p.EndsAt, p.UsageLimit, p.PerUserLimit, p.Active)
+	if err != nil {
+		return 0, fmt.Errorf("failed to create promotion: %w", err)
+	}
+
+	id, err := result.LastInsertId()
+	if err != nil {
+		return 0, fmt.Errorf("failed to get promotion ID: %w", err)
+	}
+
+	if err := replaceScopes(tx, int(id), p); err != nil {
+		return 0, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, fmt.Errorf("failed to commit transaction: %w", err)
+	}
+
+	return int(id), nil
+}
+
+// UpdatePromotion replaces everything but the usage count
+func (s *Store) UpdatePromotion(p types.Promotion) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	var id int
+	if err := tx.QueryRow("SELECT id FROM promotions WHERE id = ? FOR UPDATE", p.ID).Scan(&id); err != nil {
+		if err == sql.ErrNoRows {
+			return ErrPromotionNotFound
+		}
+		return fmt.Errorf("failed to get promotion: %w", err)
+	}
+
+	const query = `
+		UPDATE promotions
+		SET code = ?, description = ?, type = ?, percentOff = ?, currency = ?, amountOff = ?, minSpend = ?,
+			buyQuantity = ?, getQuantity = ?, startsAt = ?, endsAt = ?, usageLimit = ?, perUserLimit = ?, active = ?
+		WHERE id = ?`
+
+	_, err = tx.Exec(query, p.Code, p.Description, p.Type, p.PercentOff, p.Currency, p.AmountOff, p.MinSpend,
+		p.BuyQuantity, p.GetQuantity, p.StartsAt, p.EndsAt, p.UsageLimit, p.PerUserLimit, p.Active, p.ID)
+	if err != nil {
+		return fmt.Errorf("failed to update promotion: %w", err)
+	}
+
+	if err := replaceScopes(tx, p.ID, p); err != nil {
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+
+	return nil
+}
+
+// orders keep their discount lines, only the link to the promotion goes
+func (s *Store) DeletePromotion(id int) error {
+	result, err := s.db.Exec("DELETE FROM promotions WHERE id = ?", id)
+	if err != nil {
+		return fmt.Errorf("failed to delete promotion: %w", err)
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to check rows affected: %w", err)
+	}
+
+	if rowsAffected == 0 {
+		return ErrPromotionNotFound
+	}
+
+	return nil
+}
+
+// LockPromotionByCode reads the promotion inside tx and locks it until the
+// transaction ends, so usage limits hold under concurrent checkouts
+func LockPromotionByCode(tx *sql.Tx, code string) (*types.Promotion, error) {
+	return getPromotion(tx, selectPromotion+" WHERE code = ? FOR UPDATE", strings.ToUpper(code))
+}
+
+// RecordRedemption counts one more use of the promotion, run inside the
+// checkout transaction that placed orderID
+func RecordRedemption(tx *sql.Tx, promotionID int, userID int, orderID int) error {
+	if _, err := tx.Exec("UPDATE promotions SET usageCount = usageCount + 1 WHERE id = ?", promotionID); err != nil {
+		return fmt.Errorf("failed to update promotion usage: %w", err)
+	}
+
+	const insert = `INSERT INTO promotion_redemptions (promotionId, userId, orderId) VALUES (?, ?, ?)`
+	if _, err := tx.Exec(insert, promotionID, userID, orderID); err != nil {
+		return fmt.Errorf("failed to record promotion redemption: %w", err)
+	}
+
+	return nil
+}
+
+func CountRedemptions(tx *sql.Tx, promotionID int, userID int) (int, error) {
+	var count int
+	const query = `SELECT COUNT(*) FROM promotion_redemptions WHERE promotionId = ? AND userId = ?`
+	if err := tx.QueryRow(query, promotionID, userID).Scan(&count); err != nil {
+		return 0, fmt.Errorf("failed to count promotion redemptions: %w", err)
+	}
+
+	return count, nil
+}
+
+// querier is satisfied by both *sql.DB and *sql.Tx
+type querier interface {
+	QueryRow(query string, args ...any) *sql.Row
+	Query(query string, args ...any) (*sql.Rows, error)
+}
+
+func getPromotion(q querier, query string, args ...any) (*types.Promotion, error) {
+	p, err := scanRowIntoPromotion(q.QueryRow(query, args...))
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, ErrPromotionNotFound
+		}
+		return nil, fmt.Errorf("failed to get promotion: %w", err)
+	}
+
+	if err := loadScopes(q, p); err != nil {
+		return nil, err
+	}
+
+	return p, nil
+}
+
+func loadScopes(q querier, p *types.Promotion) error {
+	var err error
+	p.ProductIDs, err = queryIDs(q, "SELECT productId FROM promotion_products WHERE promotionId = ? ORDER BY productId", p.ID)
+	if err != nil {
+		return fmt.Errorf("failed to get promotion products: %w", err)
+	}
+
+	p.CategoryIDs, err = queryIDs(q, "SELECT categoryId FROM promotion_categories WHERE promotionId = ? ORDER BY categoryId", p.ID)
+	if err != nil {
+		return fmt.Errorf("failed to get promotion categories: %w", err)
+	}
+
+	return nil
+}
+
+func queryIDs(q querier, query string, args ...any) ([]int, error) {
+	rows, err := q.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	ids := []int{}
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+
+	return ids, rows.Err()
+}
+
+func replaceScopes(tx *sql.Tx, promotionID int, p types.Promotion) error {
+	if _, err := tx.Exec("DELETE FROM promotion_products WHERE promotionId = ?", promotionID); err != nil {
+		return fmt.Errorf("failed to clear promotion products: %w", err)
+	}
+	if _, err := tx.Exec("DELETE FROM promotion_categories WHERE promotionId = ?", promotionID); err != nil {
+		return fmt.Errorf("failed to clear promotion categories: %w", err)
+	}
+
+	for _, id := range p.ProductIDs {
+		_, err := tx.Exec("INSERT IGNORE INTO promotion_products (promotionId, productId) VALUES (?, ?)", promotionID, id)
+		if err != nil {
+			return fmt.Errorf("failed to add promotion product: %w", err)
+		}
+	}
+
+	for _, id := range p.CategoryIDs {
+		_, err := tx.Exec("INSERT IGNORE INTO promotion_categories (promotionId, categoryId) VALUES (?, ?)", promotionID, id)
+		if err != nil {
+			return fmt.Errorf("failed to add promotion category: %w", err)
+		}
+	}
+
+	return nil
+}
+
+// scanner is satisfied by both *sql.Row and *sql.Rows
+type scanner interface {
+	Scan(dest ...any) error
+}
+
+// amounts are read as text and parsed once the currency is known
+func scanRowIntoPromotion(row scanner) (*types.Promotion, error) {
+	var p types.Promotion
+	var amountOff, minSpend string
+	var startsAt, endsAt sql.NullTime
+	var usageLimit, perUserLimit sql.NullInt64
+	err := row.Scan(
+		&p.ID,
+		&p.Code,
+		&p.Description,
+		&p.Type,
+		&p.PercentOff,
+		&p.Currency,
+		&amountOff,
+		&minSpend,
+		&p.BuyQuantity,
+		&p.GetQuantity,
+		&startsAt,
+		&endsAt,
+		&usageLimit,
+		&perUserLimit,
+		&p.UsageCount,
+		&p.Active,
+		&p.CreatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	if p.AmountOff, err = types.ParseMoney(amountOff, p.Currency); err != nil {
+		return nil, err
+	}
+	if p.MinSpend, err = types.ParseMoney(minSpend, p.Currency); err != nil {
+		return nil, err
+	}
+
+	if startsAt.Valid {
+		p.StartsAt = &startsAt.Time
+	}
+	if endsAt.Valid {
+		p.EndsAt = &endsAt.Time
+	}
+	if usageLimit.Valid {
+		limit := int(usageLimit.Int64)
+		p.UsageLimit = &limit
+	}
+	if perUserLimit.Valid {
+		limit := int(perUserLimit.Int64)
+		p.PerUserLimit = &limit
+	}
+
+	return &p, nil
+}
@@ -0,0 +1,272 @@
+package search
+
+import (
+	"math"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/eugenius-watchman/ecom_go_rest_api/types"
+)
+
+// words in the name count for more than words in the description
+const (
+	nameWeight        = 2.0
+	descriptionWeight = 1.0
+	// a word found by its prefix ranks below the whole word
+	prefixPenalty = 0.8
+)
+
+// MemoryIndex is an inverted index held in memory. It searches like the
+// MySQL one without a database, for tests and small catalogs.
+type MemoryIndex struct {
+	mu         sync.RWMutex
+	products   map[int]types.Product
+	categories map[int][]types.Category
+	// word -> product ID -> weight of the word in the product
+	postings map[string]map[int]float64
+	// every indexed word, sorted for prefix lookups
+	vocabulary []string
+	// bigram -> products with a word in their name that has it, for the
+	// typo fallback
+	grams map[string]map[int]bool
+}
+
+func NewMemoryIndex() *MemoryIndex {
+	return &MemoryIndex{
+		products:   map[int]types.Product{},
+		categories: map[int][]types.Category{},
+		postings:   map[string]map[int]float64{},
+		grams:      map[string]map[int]bool{},
+	}
+}
+
+// Add indexes product in categories, replacing it if it's already there
+func (m *MemoryIndex) Add(product types.Product, categories ...types.Category) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.remove(product.ID)
+	m.products[product.ID] = product
+	m.categories[product.ID] = categories
+
+	weights := map[string]float64{}
+	for _, word := range Terms(product.Name) {
+		weights[word] += nameWeight
+		for gram := range bigrams(word) {
+			if m.grams[gram] == nil {
+				m.grams[gram] = map[int]bool{}
+			}
+			m.grams[gram][product.ID] = true
+		}
+	}
+	for _, word := range words(product.Description) {
+		weights[word] += descriptionWeight
+	}
+
+	for word, weight := range weights {
+		if m.postings[word] == nil {
+			m.postings[word] = map[int]float64{}
+			i := sort.SearchStrings(m.vocabulary, word)
+			m.vocabulary = slices.Insert(m.vocabulary, i, word)
+		}
+		m.postings[word][product.ID] = weight
+	}
+}
+
+func (m *MemoryIndex) Remove(productID int) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.remove(productID)
+}
+
+func (m *MemoryIndex) remove(productID int) {
+	if _, ok := m.products[productID]; !ok {
+		return
+	}
+
+	for word, products := range m.postings {
+		delete(products, productID)
+		if len(products) == 0 {
+			delete(m.postings, word)
+			i := sort.SearchStrings(m.vocabulary, word)
+			m.vocabulary = slices.Delete(m.vocabulary, i, i+1)
+		}
+	}
+	for gram, products := range m.grams {
+		delete(products, productID)
+		if len(products) == 0 {
+			delete(m.grams, gram)
+		}
+	}
+	delete(m.products, productID)
+	delete(m.categories, productID)
+}
+
+// every word of text, repeats included ... unlike Terms, which is for
+// queries
+func words(text string) []string {
+	return strings.FieldsFunc(strings.ToLower(text), isSeparator)
+}
+
+func (m *MemoryIndex) Search(query types.SearchQuery) (*types.SearchResult, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	terms := Terms(query.Text)
+	if len(terms) == 0 {
+		return emptyResult(), nil
+	}
+
+	fuzzy := false
+	scores := m.exact(terms, query)
+	if len(scores) == 0 {
+		fuzzy = true
+		scores = m.fuzzy(terms, query)
+	}
+
+	ids := make([]int, 0, len(scores))
+	for id := range scores {
+		ids = append(ids, id)
+	}
+	// best first, the oldest ID breaks ties like the SQL does
+	slices.SortFunc(ids, func(a, b int) int {
+		if scores[a] != scores[b] {
+			if scores[a] > scores[b] {
+				return -1
+			}
+			return 1
+		}
+		return a - b
+	})
+
+	result := emptyResult()
+	result.Total = len(ids)
+	result.Fuzzy = fuzzy && len(ids) > 0
+	result.Facets = m.facets(ids)
+
+	end := min(query.Offset+query.Limit, len(ids))
+	for _, id := range ids[min(query.Offset, end):end] {
+		product := m.products[id]
+		result.Hits = append(result.Hits, types.SearchHit{
+			Product: product,
+			Score:   scores[id],
+			Snippet: Snippet(product, terms, fuzzy),
+		})
+	}
+
+	return result, nil
+}
+
+// exact scores the products with a word starting with every term, by how
+// often and where the words appear and how rare they are
+func (m *MemoryIndex) exact(terms []string, query types.SearchQuery) map[int]float64 {
+	var scores map[int]float64
+	for _, term := range terms {
+		found := map[int]float64{}
+		for i := sort.SearchStrings(m.vocabulary, term); i < len(m.vocabulary) && strings.HasPrefix(m.vocabulary[i], term); i++ {
+			word := m.vocabulary[i]
+			idf := math.Log(1 + float64(len(m.products))/float64(len(m.postings[word])))
+			if word != term {
+				idf *= prefixPenalty
+			}
+			for id, weight := range m.postings[word] {
+				found[id] = max(found[id], weight*idf)
+			}
+		}
+
+		if scores == nil {
+			scores = found
+			continue
+		}
+		// every term must match
+		for id := range scores {
+			if _, ok := found[id]; !ok {
+				delete(scores, id)
+				continue
+			}
+			scores[id] += found[id]
+		}
+	}
+
+	return m.filter(scores, query)
+}
+
+// fuzzy scores the products whose name has a bigram in common with a term
+// by how alike the words are
+func (m *MemoryIndex) fuzzy(terms []string, query types.SearchQuery) map[int]float64 {
+	candidates := map[int]bool{}
+	for _, term := range terms {
+		for gram := range bigrams(term) {
+			for id := range m.grams[gram] {
+				candidates[id] = true
+			}
+		}
+	}
+
+	scores := map[int]float64{}
+	for id := range candidates {
+		if score := FuzzyScore(terms, m.products[id].Name); score > 0 {
+			scores[id] = score
+		}
+	}
+
+	return m.filter(scores, query)
+}
+
+func (m *MemoryIndex) filter(scores map[int]float64, query types.SearchQuery) map[int]float64 {
+	for id := range scores {
+		product := m.products[id]
+		if query.InStock && product.Quantity <= 0 {
+			delete(scores, id)
+			continue
+		}
+		if query.CategoryID != 0 && !slices.ContainsFunc(m.categories[id], func(c types.Category) bool {
+			return c.ID == query.CategoryID
+		}) {
+			delete(scores, id)
+		}
+	}
+	return scores
+}
+
+// facets counts every hit, not just the page
+func (m *MemoryIndex) facets(ids []int) types.SearchFacets {
+	facets := types.SearchFacets{Categories: []types.CategoryFacet{}}
+
+	bounds := priceBounds()
+	buckets := map[int]int{}
+	categories := map[int]*types.CategoryFacet{}
+	for _, id := range ids {
+		product := m.products[id]
+		if product.Quantity > 0 {
+			facets.InStock++
+		}
+		buckets[priceBucket(product.Price, bounds)]++
+
+		for _, category := range m.categories[id] {
+			facet, ok := categories[category.ID]
+			if !ok {
+				facet = &types.CategoryFacet{ID: category.ID, Name: category.Name, Slug: category.Slug}
+				categories[category.ID] = facet
+			}
+			facet.Count++
+		}
+	}
+
+	for _, facet := range categories {
+		facets.Categories = append(facets.Categories, *facet)
+	}
+	// most hits first, like the SQL
+	slices.SortFunc(facets.Categories, func(a, b types.CategoryFacet) int {
+		if a.Count != b.Count {
+			return b.Count - a.Count
+		}
+		return strings.Compare(a.Name, b.Name)
+	})
+	facets.PriceRanges = priceRanges(buckets, bounds)
+
+	return facets
+}
@@ -0,0 +1,143 @@
+package search
+
+import (
+	"slices"
+	"testing"
+
+	"github.com/eugenius-watchman/ecom_go_rest_api/types"
+)
+
+var (
+	apparel = types.Category{ID: 1, Name: "Apparel", Slug: "apparel"}
+	office  = types.Category{ID: 2, Name: "Office", Slug: "office"}
+)
+
+func usd(amount int64) types.Money {
+	return types.NewMoney(amount, "USD")
+}
+
+func newTestIndex() *MemoryIndex {
+	index := NewMemoryIndex()
+	index.Add(types.Product{ID: 1, Name: "Cotton shirt", Description: "A soft shirt for every day.", Price: usd(2000), Quantity: 5}, apparel)
+	index.Add(types.Product{ID: 2, Name: "Linen shirt", Description: "Cool linen, made for summer.", Price: usd(4500), Quantity: 0}, apparel)
+	index.Add(types.Product{ID: 3, Name: "Shirt hanger", Description: "Keeps a shirt or a cotton dress in shape.", Price: usd(500), Quantity: 40}, apparel, office)
+	index.Add(types.Product{ID: 4, Name: "Mechanical keyboard", Description: "Loud switches, aluminium case.", Price: usd(12000), Quantity: 3}, office)
+	index.Add(types.Product{ID: 5, Name: "Desk lamp", Description: "Warm light for the keyboard at night.", Price: usd(3000), Quantity: 7}, office)
+	return index
+}
+
+func search(t *testing.T, index *MemoryIndex, query types.SearchQuery) *types.SearchResult {
+	t.Helper()
+	if query.Limit == 0 {
+		query.Limit = 20
+	}
+	result, err := index.Search(query)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return result
+}
+
+func hitIDs(result *types.SearchResult) []int {
+	ids := []int{}
+	for _, hit := range result.Hits {
+		ids = append(ids, hit.Product.ID)
+	}
+	return ids
+}
+
+func TestMemoryIndex(t *testing.T) {
+	index := newTestIndex()
+
+	t.Run("should rank names above descriptions", func(t *testing.T) {
+		result := search(t, index, types.SearchQuery{Text: "keyboard"})
+		if got := hitIDs(result); !slices.Equal(got, []int{4, 5}) {
+			t.Errorf("expected [4 5], got %v", got)
+		}
+		if result.Fuzzy || result.Total != 2 {
+			t.Errorf("unexpected result %+v", result)
+		}
+		if result.Hits[0].Score <= result.Hits[1].Score {
+			t.Errorf("expected descending scores, got %v and %v", result.Hits[0].Score, result.Hits[1].Score)
+		}
+	})
+
+	t.Run("should match every word by prefix", func(t *testing.T) {
+		if got := hitIDs(search(t, index, types.SearchQuery{Text: "cott shi"})); !slices.Equal(got, []int{1, 3}) {
+			t.Errorf("expected [1 3], got %v", got)
+		}
+		if got := hitIDs(search(t, index, types.SearchQuery{Text: "shirt summer"})); !slices.Equal(got, []int{2}) {
+			t.Errorf("expected [2], got %v", got)
+		}
+	})
+
+	t.Run("should fall back to close spellings", func(t *testing.T) {
+		result := search(t, index, types.SearchQuery{Text: "keybaord"})
+		if !result.Fuzzy || !slices.Equal(hitIDs(result), []int{4}) {
+			t.Errorf("expected a fuzzy hit on 4, got %v fuzzy %v", hitIDs(result), result.Fuzzy)
+		}
+		if result.Hits[0].Snippet != "Loud switches, aluminium case." {
+			t.Errorf("unexpected snippet %q", result.Hits[0].Snippet)
+		}
+
+		if result := search(t, index, types.SearchQuery{Text: "xylophone"}); result.Total != 0 || result.Fuzzy {
+			t.Errorf("expected nothing, got %+v", result)
+		}
+	})
+
+	t.Run("should count facets over every hit", func(t *testing.T) {
+		result := search(t, index, types.SearchQuery{Text: "shirt", Limit: 1})
+		if len(result.Hits) != 1 || result.Total != 3 || result.Facets.InStock != 2 {
+			t.Fatalf("unexpected result %+v", result)
+		}
+
+		want := []types.CategoryFacet{
+			{ID: 1, Name: "Apparel", Slug: "apparel", Count: 3},
+			{ID: 2, Name: "Office", Slug: "office", Count: 1},
+		}
+		if !slices.Equal(result.Facets.Categories, want) {
+			t.Errorf("expected %v, got %v", want, result.Facets.Categories)
+		}
+
+		// 5.00, 20.00 and 45.00
+		ranges := result.Facets.PriceRanges
+		if len(ranges) != 2 || ranges[0].Count != 2 || ranges[0].Max.Amount != 2500 ||
+			ranges[1].Count != 1 || ranges[1].Min.Amount != 2500 || ranges[1].Max.Amount != 5000 {
+			t.Errorf("unexpected price ranges %+v", ranges)
+		}
+	})
+
+	t.Run("should filter and page", func(t *testing.T) {
+		if got := hitIDs(search(t, index, types.SearchQuery{Text: "shirt", InStock: true})); !slices.Equal(got, []int{1, 3}) {
+			t.Errorf("expected [1 3], got %v", got)
+		}
+		if got := hitIDs(search(t, index, types.SearchQuery{Text: "shirt", CategoryID: 2})); !slices.Equal(got, []int{3}) {
+			t.Errorf("expected [3], got %v", got)
+		}
+
+		all := hitIDs(search(t, index, types.SearchQuery{Text: "shirt"}))
+		second := hitIDs(search(t, index, types.SearchQuery{Text: "shirt", Limit: 2, Offset: 2}))
+		if !slices.Equal(second, all[2:]) {
+			t.Errorf("expected %v, got %v", all[2:], second)
+		}
+		if got := search(t, index, types.SearchQuery{Text: "shirt", Offset: 10}); len(got.Hits) != 0 || got.Total != 3 {
+			t.Errorf("expected an empty page of 3, got %+v", got)
+		}
+	})
+
+	t.Run("should forget removed and replaced products", func(t *testing.T) {
+		index := newTestIndex()
+		index.Remove(4)
+		index.Add(types.Product{ID: 5, Name: "Floor lamp", Price: usd(9000), Quantity: 1}, office)
+
+		if result := search(t, index, types.SearchQuery{Text: "keyboard"}); result.Total != 0 {
+			t.Errorf("expected no hits, got %v", hitIDs(result))
+		}
+		if got := hitIDs(search(t, index, types.SearchQuery{Text: "floor"})); !slices.Equal(got, []int{5}) {
+			t.Errorf("expected [5], got %v", got)
+		}
+		if slices.Contains(index.vocabulary, "keyboard") || slices.Contains(index.vocabulary, "desk") {
+			t.Errorf("expected removed words to leave the vocabulary, got %v", index.vocabulary)
+		}
+	})
+}
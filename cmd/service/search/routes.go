@@ -0,0 +1,125 @@
+package search
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/eugenius-watchman/ecom_go_rest_api/cmd/service/currency"
+	"github.com/eugenius-watchman/ecom_go_rest_api/types"
+	"github.com/eugenius-watchman/ecom_go_rest_api/utils"
+	"github.com/gorilla/mux"
+)
+
+const (
+	defaultPageLimit = 20
+	maxPageLimit     = 50
+	// relevance drops off long before this
+	maxOffset   = 1000
+	maxQueryLen = 200
+)
+
+type Handler struct {
+	index  types.SearchIndex
+	pricer types.ProductPricer
+}
+
+func NewHandler(index types.SearchIndex, pricer types.ProductPricer) *Handler {
+	return &Handler{index: index, pricer: pricer}
+}
+
+func (h *Handler) RegisterRoutes(router *mux.Router) {
+	router.HandleFunc("/products/search", h.handleSearch).Methods("GET")
+}
+
+// ?q= with optional ?category=, ?inStock=, ?limit= and ?offset= ... price
+// facets stay in the default currency, the hits are priced in the
+// customer's
+func (h *Handler) handleSearch(w http.ResponseWriter, r *http.Request) {
+	query, err := parseSearchQuery(r)
+	if err != nil {
+		utils.WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	result, err := h.index.Search(query)
+	if err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	products := make([]types.Product, len(result.Hits))
+	for i, hit := range result.Hits {
+		products[i] = hit.Product
+	}
+	if err := h.pricer.PriceProducts(products, currency.FromRequest(r)); err != nil {
+		if errors.Is(err, currency.ErrUnsupportedCurrency) {
+			utils.WriteError(w, http.StatusBadRequest, err)
+			return
+		}
+		utils.WriteError(w, http.StatusInternalServerError, err)
+		return
+	}
+	for i := range result.Hits {
+		result.Hits[i].Product = products[i]
+	}
+
+	utils.WriteJSON(w, http.StatusOK, types.SearchResponse{
+		Query:        query.Text,
+		SearchResult: *result,
+		Limit:        query.Limit,
+		Offset:       query.Offset,
+	})
+}
+
+func parseSearchQuery(r *http.Request) (types.SearchQuery, error) {
+	values := r.URL.Query()
+	query := types.SearchQuery{Text: strings.TrimSpace(values.Get("q")), Limit: defaultPageLimit}
+
+	if query.Text == "" {
+		return query, fmt.Errorf("q is required")
+	}
+	if utf8.RuneCountInString(query.Text) > maxQueryLen {
+		return query, fmt.Errorf("q is longer than %d characters", maxQueryLen)
+	}
+	if len(Terms(query.Text)) == 0 {
+		return query, fmt.Errorf("q has no words to search for")
+	}
+
+	if v := values.Get("limit"); v != "" {
+		limit, err := strconv.Atoi(v)
+		if err != nil || limit < 1 || limit > maxPageLimit {
+			return query, fmt.Errorf("limit must be between 1 and %d", maxPageLimit)
+		}
+		query.Limit = limit
+	}
+
+	if v := values.Get("offset"); v != "" {
+		offset, err := strconv.Atoi(v)
+		if err != nil || offset < 0 || offset > maxOffset {
+			return query, fmt.Errorf("offset must be between 0 and %d", maxOffset)
+		}
+		query.Offset = offset
+	}
+
+	if v := values.Get("category"); v != "" {
+		id, err := strconv.Atoi(v)
+		if err != nil || id < 1 {
+			return query, fmt.Errorf("invalid category %q", v)
+		}
+		query.CategoryID = id
+	}
+
+	if v := values.Get("inStock"); v != "" {
+		inStock, err := strconv.ParseBool(v)
+		if err != nil {
+			return query, fmt.Errorf("invalid inStock %q", v)
+		}
+		query.InStock = inStock
+	}
+
+	return query, nil
+}
@@ -0,0 +1,129 @@
+package search
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/eugenius-watchman/ecom_go_rest_api/cmd/service/currency"
+	"github.com/eugenius-watchman/ecom_go_rest_api/types"
+	"github.com/gorilla/mux"
+)
+
+func TestSearchServiceHandlers(t *testing.T) {
+	handler := NewHandler(newTestIndex(), &mockPricer{})
+
+	router := mux.NewRouter()
+	handler.RegisterRoutes(router)
+
+	get := func(path string) (*httptest.ResponseRecorder, types.SearchResponse) {
+		req, err := http.NewRequest(http.MethodGet, path, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+
+		var response types.SearchResponse
+		json.NewDecoder(rr.Body).Decode(&response)
+		return rr, response
+	}
+
+	t.Run("should search without a token", func(t *testing.T) {
+		rr, response := get("/products/search?q=" + url.QueryEscape("  cotton SHIRT "))
+		if rr.Code != http.StatusOK {
+			t.Fatalf("expected status code %d, got %d", http.StatusOK, rr.Code)
+		}
+
+		if response.Query != "cotton SHIRT" || response.Total != 2 || response.Limit != defaultPageLimit || response.Offset != 0 {
+			t.Errorf("unexpected response %+v", response)
+		}
+		if hit := response.Hits[0]; hit.Product.ID != 1 || hit.Snippet != "A soft <mark>shirt</mark> for every day." {
+			t.Errorf("unexpected hit %+v", hit)
+		}
+		if len(response.Facets.Categories) == 0 || len(response.Facets.PriceRanges) == 0 {
+			t.Errorf("expected facets, got %+v", response.Facets)
+		}
+	})
+
+	t.Run("should page and filter", func(t *testing.T) {
+		_, response := get("/products/search?q=shirt&limit=1&offset=1&inStock=true&category=1")
+		if response.Total != 2 || len(response.Hits) != 1 || response.Hits[0].Product.ID != 3 {
+			t.Errorf("unexpected response %+v", response)
+		}
+	})
+
+	t.Run("should say when the hits are close spellings", func(t *testing.T) {
+		_, response := get("/products/search?q=keybaord")
+		if !response.Fuzzy || response.Total != 1 {
+			t.Errorf("unexpected response %+v", response)
+		}
+	})
+
+	t.Run("should price the hits in the customer's currency", func(t *testing.T) {
+		rr, response := get("/products/search?q=lamp&currency=EUR")
+		if rr.Code != http.StatusOK {
+			t.Fatalf("expected status code %d, got %d", http.StatusOK, rr.Code)
+		}
+		if p := response.Hits[0].Product; p.Currency != "EUR" || p.Price.Amount != 1500 {
+			t.Errorf("unexpected price %v %s", p.Price, p.Currency)
+		}
+
+		if rr, _ := get("/products/search?q=lamp&currency=CHF"); rr.Code != http.StatusBadRequest {
+			t.Errorf("expected status code %d, got %d", http.StatusBadRequest, rr.Code)
+		}
+	})
+
+	t.Run("should reject bad parameters", func(t *testing.T) {
+		paths := []string{
+			"/products/search",
+			"/products/search?q=%20%20",
+			"/products/search?q=" + url.QueryEscape("+-*"),
+			"/products/search?q=" + strings.Repeat("a", maxQueryLen+1),
+			"/products/search?q=shirt&limit=0",
+			"/products/search?q=shirt&limit=51",
+			"/products/search?q=shirt&offset=-1",
+			"/products/search?q=shirt&offset=1001",
+			"/products/search?q=shirt&category=x",
+			"/products/search?q=shirt&inStock=maybe",
+		}
+		for _, path := range paths {
+			if rr, _ := get(path); rr.Code != http.StatusBadRequest {
+				t.Errorf("%s: expected status code %d, got %d", path, http.StatusBadRequest, rr.Code)
+			}
+		}
+	})
+}
+
+// mockPricer halves prices in EUR and knows no other currency
+type mockPricer struct{}
+
+func (m *mockPricer) PriceProducts(products []types.Product, code string) error {
+	if code == "" || code == "USD" {
+		return nil
+	}
+	if code != "EUR" {
+		return currency.ErrUnsupportedCurrency
+	}
+	for i := range products {
+		products[i].Price = types.NewMoney(products[i].Price.Amount/2, "EUR")
+		products[i].Currency = "EUR"
+	}
+	return nil
+}
+
+func (m *mockPricer) PriceVariants(variants []types.ProductVariant, code string) error {
+	if code == "" || code == "USD" {
+		return nil
+	}
+	if code != "EUR" {
+		return currency.ErrUnsupportedCurrency
+	}
+	for i := range variants {
+		variants[i].Price = types.NewMoney(variants[i].Price.Amount/2, "EUR")
+	}
+	return nil
+}
@@ -0,0 +1,238 @@
+package search
+
+import (
+	"html"
+	"strings"
+	"unicode"
+
+	"github.com/eugenius-watchman/ecom_go_rest_api/config"
+	"github.com/eugenius-watchman/ecom_go_rest_api/types"
+)
+
+const (
+	// a search uses at most this many words
+	maxTerms = 8
+	// how alike two words must be for the typo fallback, 0 to 1
+	fuzzyThreshold = 0.3
+	// roughly how many characters of the description a snippet shows
+	snippetLength = 160
+)
+
+// where the price facet splits, in whole units of the default currency
+var priceSteps = []int64{25, 50, 100, 250}
+
+// Terms splits text into the lowercase words a search looks for, each once
+func Terms(text string) []string {
+	var terms []string
+	seen := map[string]bool{}
+	for _, word := range strings.FieldsFunc(strings.ToLower(text), isSeparator) {
+		if seen[word] {
+			continue
+		}
+		seen[word] = true
+		terms = append(terms, word)
+		if len(terms) == maxTerms {
+			break
+		}
+	}
+	return terms
+}
+
+func isSeparator(r rune) bool {
+	return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+}
+
+// bigrams of a word padded with spaces, so the first and last letters
+// count for more ... the same size as MySQL's ngram parser uses
+func bigrams(word string) map[string]bool {
+	runes := []rune(" " + word + " ")
+	grams := make(map[string]bool, len(runes))
+	for i := 0; i+2 <= len(runes); i++ {
+		grams[string(runes[i:i+2])] = true
+	}
+	return grams
+}
+
+// similarity is the Jaccard index of the words' bigrams, 1 when they're
+// the same word
+func similarity(a, b string) float64 {
+	if a == b {
+		return 1
+	}
+
+	ga, gb := bigrams(a), bigrams(b)
+	shared := 0
+	for gram := range ga {
+		if gb[gram] {
+			shared++
+		}
+	}
+	return float64(shared) / float64(len(ga)+len(gb)-shared)
+}
+
+// FuzzyScore is how well text matches terms spelled wrong, the mean of each
+// term's best similarity to a word of text ... 0 unless every term is close
+// to some word
+func FuzzyScore(terms []string, text string) float64 {
+	words := Terms(text)
+	if len(terms) == 0 || len(words) == 0 {
+		return 0
+	}
+
+	total := 0.0
+	for _, term := range terms {
+		best := 0.0
+		for _, word := range words {
+			best = max(best, similarity(term, word))
+		}
+		if best < fuzzyThreshold {
+			return 0
+		}
+		total += best
+	}
+	return total / float64(len(terms))
+}
+
+// matches reports whether word is one the search found, a word starting
+// with a term, or close to one when fuzzy
+func matches(word string, terms []string, fuzzy bool) bool {
+	for _, term := range terms {
+		if strings.HasPrefix(word, term) || fuzzy && similarity(term, word) >= fuzzyThreshold {
+			return true
+		}
+	}
+	return false
+}
+
+// Snippet is the part of the description around the first word the search
+// found, HTML escaped with the found words in <mark>. Products found by
+// their name alone get the start of the description.
+func Snippet(product types.Product, terms []string, fuzzy bool) string {
+	text := product.Description
+	if text == "" {
+		text = product.Name
+	}
+
+	// byte offsets of every word
+	type span struct{ start, end int }
+	var spans []span
+	start := -1
+	for i, r := range text {
+		if isSeparator(r) {
+			if start >= 0 {
+				spans = append(spans, span{start, i})
+				start = -1
+			}
+		} else if start < 0 {
+			start = i
+		}
+	}
+	if start >= 0 {
+		spans = append(spans, span{start, len(text)})
+	}
+
+	found := make([]bool, len(spans))
+	first := -1
+	for i, s := range spans {
+		if matches(strings.ToLower(text[s.start:s.end]), terms, fuzzy) {
+			found[i] = true
+			if first < 0 {
+				first = i
+			}
+		}
+	}
+
+	// a window of words, starting a few words before the first match
+	from := 0
+	if first > 0 {
+		from = first
+		for from > 0 && spans[first].start-spans[from-1].start < snippetLength/3 {
+			from--
+		}
+	}
+	to := from
+	for to < len(spans) && spans[to].end-spans[from].start <= snippetLength {
+		to++
+	}
+	if to == from && to < len(spans) {
+		to++
+	}
+
+	var b strings.Builder
+	if from > 0 {
+		b.WriteString("…")
+	}
+	pos := 0
+	if from > 0 {
+		pos = spans[from].start
+	}
+	for i := from; i < to; i++ {
+		s := spans[i]
+		b.WriteString(html.EscapeString(text[pos:s.start]))
+		word := html.EscapeString(text[s.start:s.end])
+		if found[i] {
+			word = "<mark>" + word + "</mark>"
+		}
+		b.WriteString(word)
+		pos = s.end
+	}
+	if to < len(spans) {
+		b.WriteString("…")
+	} else {
+		b.WriteString(html.EscapeString(text[pos:]))
+	}
+
+	return b.String()
+}
+
+// priceBounds are where the price facet splits, in the default currency
+func priceBounds() []types.Money {
+	bounds := make([]types.Money, len(priceSteps))
+	unit := int64(1)
+	for range types.MinorUnits(config.Envs.DefaultCurrency) {
+		unit *= 10
+	}
+	for i, step := range priceSteps {
+		bounds[i] = types.NewMoney(step*unit, config.Envs.DefaultCurrency)
+	}
+	return bounds
+}
+
+// priceBucket is the index of the price range price falls in
+func priceBucket(price types.Money, bounds []types.Money) int {
+	for i, bound := range bounds {
+		if price.Cmp(bound) < 0 {
+			return i
+		}
+	}
+	return len(bounds)
+}
+
+// priceRanges turns the number of products in each bucket into the facet,
+// leaving out empty ranges
+func priceRanges(counts map[int]int, bounds []types.Money) []types.PriceRangeFacet {
+	ranges := []types.PriceRangeFacet{}
+	for i := 0; i <= len(bounds); i++ {
+		if counts[i] == 0 {
+			continue
+		}
+
+		facet := types.PriceRangeFacet{Min: types.NewMoney(0, config.Envs.DefaultCurrency), Count: counts[i]}
+		if i > 0 {
+			facet.Min = bounds[i-1]
+		}
+		if i < len(bounds) {
+			upper := bounds[i]
+			facet.Max = &upper
+		}
+		ranges = append(ranges, facet)
+	}
+	return ranges
+}
+
+func emptyResult() *types.SearchResult {
+	return &types.SearchResult{
+		Hits:   []types.SearchHit{},
+		Facets: types.SearchFacets{Categories: []types.CategoryFacet{}, PriceRanges: []types.PriceRangeFacet{}},
+	}
+}
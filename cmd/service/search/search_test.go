@@ -0,0 +1,112 @@
+package search
+
+import (
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/eugenius-watchman/ecom_go_rest_api/types"
+)
+
+func TestTerms(t *testing.T) {
+	tests := map[string][]string{
+		"Red  Shirt":                {"red", "shirt"},
+		"+red -shirt* \"cotton\"":   {"red", "shirt", "cotton"},
+		"shirt SHIRT shirts":        {"shirt", "shirts"},
+		"Café crème":                {"café", "crème"},
+		"  ** ":                     nil,
+		"a b c d e f g h i j k":     {"a", "b", "c", "d", "e", "f", "g", "h"},
+		"usb-c 65w (charger) @home": {"usb", "c", "65w", "charger", "home"},
+	}
+
+	for text, want := range tests {
+		if got := Terms(text); !slices.Equal(got, want) {
+			t.Errorf("Terms(%q): expected %v, got %v", text, want, got)
+		}
+	}
+}
+
+func TestFuzzyScore(t *testing.T) {
+	tests := []struct {
+		terms []string
+		text  string
+		match bool
+	}{
+		{[]string{"keybaord"}, "Mechanical Keyboard", true},
+		{[]string{"sheos"}, "Running shoes", true},
+		{[]string{"shirt"}, "Running shoes", false},
+		{[]string{"keybaord", "wireles"}, "Wireless keyboard", true},
+		// every term must be close to something
+		{[]string{"keybaord", "banana"}, "Wireless keyboard", false},
+		{[]string{"keyboard"}, "", false},
+	}
+
+	for _, tt := range tests {
+		if got := FuzzyScore(tt.terms, tt.text) > 0; got != tt.match {
+			t.Errorf("FuzzyScore(%v, %q): expected a match %v", tt.terms, tt.text, tt.match)
+		}
+	}
+
+	if exact, typo := FuzzyScore([]string{"keyboard"}, "keyboard"), FuzzyScore([]string{"keybaord"}, "keyboard"); exact != 1 || typo >= exact {
+		t.Errorf("expected the exact word to score 1 and more than a typo, got %v and %v", exact, typo)
+	}
+}
+
+func TestBooleanQuery(t *testing.T) {
+	if got := booleanQuery(Terms("red +shirt*")); got != "+red* +shirt*" {
+		t.Errorf("expected +red* +shirt*, got %s", got)
+	}
+}
+
+func TestSnippet(t *testing.T) {
+	t.Run("should mark the words found by prefix", func(t *testing.T) {
+		product := types.Product{Name: "shirt", Description: "A soft cotton shirt, washable."}
+		want := "A soft <mark>cotton</mark> shirt, <mark>washable</mark>."
+		if got := Snippet(product, []string{"cot", "wash"}, false); got != want {
+			t.Errorf("expected %q, got %q", want, got)
+		}
+	})
+
+	t.Run("should escape HTML", func(t *testing.T) {
+		product := types.Product{Name: "tag", Description: `<b>bold</b> & "quoted" tag`}
+		want := "&lt;b&gt;<mark>bold</mark>&lt;/b&gt; &amp; &#34;quoted&#34; tag"
+		if got := Snippet(product, []string{"bold"}, false); got != want {
+			t.Errorf("expected %q, got %q", want, got)
+		}
+	})
+
+	t.Run("should cut around the first match", func(t *testing.T) {
+		product := types.Product{
+			Name: "lamp",
+			Description: "This lamp has a long story that starts in a small workshop by the sea where every " +
+				"piece is made by hand from recycled brass and glass, and it ends on your desk where its " +
+				"warm light makes the evenings better, with a dimmer switch built into the base.",
+		}
+		got := Snippet(product, []string{"dimmer"}, false)
+		if !strings.HasPrefix(got, "…") || !strings.Contains(got, "<mark>dimmer</mark>") {
+			t.Errorf("unexpected snippet %q", got)
+		}
+		if len(got) > snippetLength+60 {
+			t.Errorf("expected a short snippet, got %d bytes", len(got))
+		}
+
+		got = Snippet(product, []string{"lamp"}, false)
+		if !strings.HasPrefix(got, "This <mark>lamp</mark>") || !strings.HasSuffix(got, "…") {
+			t.Errorf("unexpected snippet %q", got)
+		}
+	})
+
+	t.Run("should mark close spellings when fuzzy", func(t *testing.T) {
+		product := types.Product{Name: "Mechanical keyboard", Description: "A loud keyboard."}
+		if got := Snippet(product, []string{"keybaord"}, true); got != "A loud <mark>keyboard</mark>." {
+			t.Errorf("unexpected snippet %q", got)
+		}
+	})
+
+	t.Run("should fall back to the name", func(t *testing.T) {
+		product := types.Product{Name: "Desk & chair"}
+		if got := Snippet(product, []string{"desk"}, false); got != "<mark>Desk</mark> &amp; chair" {
+			t.Errorf("unexpected snippet %q", got)
+		}
+	})
+}
@@ -0,0 +1,273 @@
+package search
+
+import (
+	"database/sql"
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/eugenius-watchman/ecom_go_rest_api/cmd/service/category"
+	"github.com/eugenius-watchman/ecom_go_rest_api/config"
+	"github.com/eugenius-watchman/ecom_go_rest_api/types"
+)
+
+// how many products the typo fallback looks at
+const fuzzyCandidates = 200
+
+// Store searches the FULLTEXT indexes on products
+type Store struct {
+	db *sql.DB
+}
+
+func NewStore(db *sql.DB) *Store {
+	return &Store{db: db}
+}
+
+// match is how one search picks and ranks products
+type match struct {
+	where     string
+	whereArgs []any
+	score     string
+	scoreArgs []any
+	order     string
+	orderArgs []any
+}
+
+// words are matched by prefix, all of them must be found ... when none
+// is, the names' bigrams find close spellings
+func (s *Store) Search(query types.SearchQuery) (*types.SearchResult, error) {
+	terms := Terms(query.Text)
+	if len(terms) == 0 {
+		return emptyResult(), nil
+	}
+
+	against := booleanQuery(terms)
+	result, err := s.search(query, terms, false, match{
+		where:     "MATCH(p.name, p.description) AGAINST (? IN BOOLEAN MODE)",
+		whereArgs: []any{against},
+		score:     "MATCH(p.name, p.description) AGAINST (? IN BOOLEAN MODE)",
+		scoreArgs: []any{against},
+		order:     "score DESC, p.id",
+	})
+	if err != nil || result.Total > 0 {
+		return result, err
+	}
+
+	ids, scores, err := s.fuzzyMatches(terms)
+	if err != nil || len(ids) == 0 {
+		return result, err
+	}
+
+	placeholders := "?" + strings.Repeat(", ?", len(ids)-1)
+	args := make([]any, len(ids))
+	for i, id := range ids {
+		args[i] = id
+	}
+
+	result, err = s.search(query, terms, true, match{
+		where:     "p.id IN (" + placeholders + ")",
+		whereArgs: args,
+		score:     "0",
+		order:     "FIELD(p.id, " + placeholders + ")",
+		orderArgs: args,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	result.Fuzzy = result.Total > 0
+	for i := range result.Hits {
+		result.Hits[i].Score = scores[result.Hits[i].Product.ID]
+	}
+
+	return result, nil
+}
+
+// every term is required and matches words it starts ... anything MySQL
+// would read as an operator was split off by Terms
+func booleanQuery(terms []string) string {
+	required := make([]string, len(terms))
+	for i, term := range terms {
+		required[i] = "+" + term + "*"
+	}
+	return strings.Join(required, " ")
+}
+
+// fuzzyMatches returns the IDs of the products whose name is close to the
+// terms, best first, and their scores
+func (s *Store) fuzzyMatches(terms []string) ([]int, map[int]float64, error) {
+	const query = `
+		SELECT id, name FROM products
+		WHERE MATCH(name) AGAINST (? IN NATURAL LANGUAGE MODE) AND deletedAt IS NULL
+		LIMIT ?`
+
+	rows, err := s.db.Query(query, strings.Join(terms, " "), fuzzyCandidates)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to query similar products: %w", err)
+	}
+	defer rows.Close()
+
+	var ids []int
+	scores := map[int]float64{}
+	for rows.Next() {
+		var id int
+		var name string
+		if err := rows.Scan(&id, &name); err != nil {
+			return nil, nil, fmt.Errorf("failed to scan similar product: %w", err)
+		}
+		if score := FuzzyScore(terms, name); score > 0 {
+			ids = append(ids, id)
+			scores[id] = score
+		}
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, nil, fmt.Errorf("rows iteration error: %w", err)
+	}
+
+	slices.SortFunc(ids, func(a, b int) int {
+		if scores[a] != scores[b] {
+			if scores[a] > scores[b] {
+				return -1
+			}
+			return 1
+		}
+		return a - b
+	})
+
+	return ids, scores, nil
+}
+
+func (s *Store) search(query types.SearchQuery, terms []string, fuzzy bool, m match) (*types.SearchResult, error) {
+	where := []string{m.where, "p.deletedAt IS NULL"}
+	args := slices.Clone(m.whereArgs)
+	if query.InStock {
+		where = append(where, "p.quantity > 0")
+	}
+	if query.CategoryID != 0 {
+		where = append(where, "EXISTS (SELECT 1 FROM product_categories pcf WHERE pcf.productId = p.id AND pcf.categoryId IN ("+category.SubtreeQuery+"))")
+		args = append(args, query.CategoryID)
+	}
+	filter := " WHERE " + strings.Join(where, " AND ")
+
+	result := emptyResult()
+
+	err := s.db.QueryRow("SELECT COUNT(*), COALESCE(SUM(p.quantity > 0), 0) FROM products p"+filter, args...).
+		Scan(&result.Total, &result.Facets.InStock)
+	if err != nil {
+		return nil, fmt.Errorf("failed to count search results: %w", err)
+	}
+	if result.Total == 0 {
+		return result, nil
+	}
+
+	q := `
+		SELECT p.id, p.name, p.description, p.image, p.price, p.quantity, p.taxClass,
+			p.weight, p.length, p.width, p.height, p.createdAt, ` + m.score + ` AS score
+		FROM products p` + filter + `
+		ORDER BY ` + m.order + `
+		LIMIT ? OFFSET ?`
+
+	pageArgs := append(append(append(slices.Clone(m.scoreArgs), args...), m.orderArgs...), query.Limit, query.Offset)
+	rows, err := s.db.Query(q, pageArgs...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to search products: %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		hit := types.SearchHit{Product: types.Product{
+			Price:    types.NewMoney(0, config.Envs.DefaultCurrency),
+			Currency: config.Envs.DefaultCurrency,
+		}}
+		p := &hit.Product
+		err := rows.Scan(&p.ID, &p.Name, &p.Description, &p.Image, &p.Price, &p.Quantity, &p.TaxClass,
+			&p.Weight, &p.Length, &p.Width, &p.Height, &p.CreatedAt, &hit.Score)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan search result: %w", err)
+		}
+		hit.Snippet = Snippet(hit.Product, terms, fuzzy)
+		result.Hits = append(result.Hits, hit)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("rows iteration error: %w", err)
+	}
+
+	if result.Facets.Categories, err = s.categoryFacets(filter, args); err != nil {
+		return nil, err
+	}
+	if result.Facets.PriceRanges, err = s.priceFacets(filter, args); err != nil {
+		return nil, err
+	}
+
+	return result, nil
+}
+
+func (s *Store) categoryFacets(filter string, args []any) ([]types.CategoryFacet, error) {
+	q := `
+		SELECT c.id, c.name, c.slug, COUNT(*)
+		FROM products p
+		JOIN product_categories pc ON pc.productId = p.id
+		JOIN categories c ON c.id = pc.categoryId` + filter + `
+		GROUP BY c.id, c.name, c.slug
+		ORDER BY COUNT(*) DESC, c.name`
+
+	rows, err := s.db.Query(q, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to count categories: %w", err)
+	}
+	defer rows.Close()
+
+	facets := []types.CategoryFacet{}
+	for rows.Next() {
+		var facet types.CategoryFacet
+		if err := rows.Scan(&facet.ID, &facet.Name, &facet.Slug, &facet.Count); err != nil {
+			return nil, fmt.Errorf("failed to scan category count: %w", err)
+		}
+		facets = append(facets, facet)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("rows iteration error: %w", err)
+	}
+
+	return facets, nil
+}
+
+func (s *Store) priceFacets(filter string, args []any) ([]types.PriceRangeFacet, error) {
+	bounds := priceBounds()
+
+	// the index of the first bound the price is under
+	var bucket strings.Builder
+	bucket.WriteString("CASE")
+	var bucketArgs []any
+	for i, bound := range bounds {
+		fmt.Fprintf(&bucket, " WHEN p.price < ? THEN %d", i)
+		bucketArgs = append(bucketArgs, bound)
+	}
+	fmt.Fprintf(&bucket, " ELSE %d END", len(bounds))
+
+	q := "SELECT " + bucket.String() + " AS bucket, COUNT(*) FROM products p" + filter + " GROUP BY bucket"
+
+	rows, err := s.db.Query(q, append(bucketArgs, args...)...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to count price ranges: %w", err)
+	}
+	defer rows.Close()
+
+	counts := map[int]int{}
+	for rows.Next() {
+		var i, count int
+		if err := rows.Scan(&i, &count); err != nil {
+			return nil, fmt.Errorf("failed to scan price range count: %w", err)
+		}
+		counts[i] = count
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("rows iteration error: %w", err)
+	}
+
+	return priceRanges(counts, bounds), nil
+}
@@ -0,0 +1,171 @@
+package shipping
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/eugenius-watchman/ecom_go_rest_api/cmd/service/auth"
+	"github.com/eugenius-watchman/ecom_go_rest_api/types"
+	"github.com/eugenius-watchman/ecom_go_rest_api/utils"
+	"github.com/go-playground/validator/v10"
+	"github.com/gorilla/mux"
+)
+
+type Handler struct {
+	store     types.ShippingStore
+	userStore types.UserStore
+}
+
+func NewHandler(store types.ShippingStore, userStore types.UserStore) *Handler {
+	return &Handler{store: store, userStore: userStore}
+}
+
+func (h *Handler) RegisterRoutes(router *mux.Router) {
+	// admin only ... customers see methods through GET /shipping/quote
+	router.HandleFunc("/shipping/methods", auth.WithRole(h.handleGetMethods, h.userStore, types.RoleAdmin)).Methods("GET")
+	router.HandleFunc("/shipping/methods", auth.WithRole(h.handleCreateMethod, h.userStore, types.RoleAdmin)).Methods("POST")
+	router.HandleFunc("/shipping/methods/{id}", auth.WithRole(h.handleGetMethod, h.userStore, types.RoleAdmin)).Methods("GET")
+	router.HandleFunc("/shipping/methods/{id}", auth.WithRole(h.handleUpdateMethod, h.userStore, types.RoleAdmin)).Methods("PUT")
+	router.HandleFunc("/shipping/methods/{id}", auth.WithRole(h.handleDeleteMethod, h.userStore, types.RoleAdmin)).Methods("DELETE")
+}
+
+func (h *Handler) handleGetMethods(w http.ResponseWriter, r *http.Request) {
+	methods, err := h.store.GetShippingMethods()
+	if err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	utils.WriteJSON(w, http.StatusOK, methods)
+}
+
+func (h *Handler) handleGetMethod(w http.ResponseWriter, r *http.Request) {
+	id, ok := methodID(w, r)
+	if !ok {
+		return
+	}
+
+	method, err := h.store.GetShippingMethodByID(id)
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+
+	utils.WriteJSON(w, http.StatusOK, method)
+}
+
+func (h *Handler) handleCreateMethod(w http.ResponseWriter, r *http.Request) {
+	method, ok := parseMethod(w, r)
+	if !ok {
+		return
+	}
+
+	// codes are unique
+	if _, err := h.store.GetShippingMethodByCode(method.Code); err == nil {
+		utils.WriteError(w, http.StatusConflict, fmt.Errorf("shipping method %s already exists", method.Code))
+		return
+	}
+
+	id, err := h.store.CreateShippingMethod(method)
+	if err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	created, err := h.store.GetShippingMethodByID(id)
+	if err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	utils.WriteJSON(w, http.StatusCreated, created)
+}
+
+func (h *Handler) handleUpdateMethod(w http.ResponseWriter, r *http.Request) {
+	id, ok := methodID(w, r)
+	if !ok {
+		return
+	}
+
+	method, ok := parseMethod(w, r)
+	if !ok {
+		return
+	}
+	method.ID = id
+
+	if existing, err := h.store.GetShippingMethodByCode(method.Code); err == nil && existing.ID != id {
+		utils.WriteError(w, http.StatusConflict, fmt.Errorf("shipping method %s already exists", method.Code))
+		return
+	}
+
+	if err := h.store.UpdateShippingMethod(method); err != nil {
+		writeStoreError(w, err)
+		return
+	}
+
+	updated, err := h.store.GetShippingMethodByID(id)
+	if err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	utils.WriteJSON(w, http.StatusOK, updated)
+}
+
+func (h *Handler) handleDeleteMethod(w http.ResponseWriter, r *http.Request) {
+	id, ok := methodID(w, r)
+	if !ok {
+		return
+	}
+
+	if err := h.store.DeleteShippingMethod(id); err != nil {
+		writeStoreError(w, err)
+		return
+	}
+
+	utils.WriteJSON(w, http.StatusOK, map[string]string{"message": "shipping method deleted"})
+}
+
+func methodID(w http.ResponseWriter, r *http.Request) (int, bool) {
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		utils.WriteError(w, http.StatusBadRequest, fmt.Errorf("invalid shipping method ID"))
+		return 0, false
+	}
+
+	return id, true
+}
+
+// parses and validates the payload, writing the error response on failure
+func parseMethod(w http.ResponseWriter, r *http.Request) (types.ShippingMethod, bool) {
+	var payload types.ShippingMethodPayload
+	if err := utils.ParseJSON(r, &payload); err != nil {
+		utils.WriteError(w, http.StatusBadRequest, err)
+		return types.ShippingMethod{}, false
+	}
+
+	if err := utils.Validate.Struct(payload); err != nil {
+		errors := err.(validator.ValidationErrors)
+		utils.WriteError(w, http.StatusBadRequest, fmt.Errorf("invalid payload %w", errors))
+		return types.ShippingMethod{}, false
+	}
+
+	method, err := FromPayload(payload)
+	if err != nil {
+		utils.WriteError(w, http.StatusBadRequest, err)
+		return types.ShippingMethod{}, false
+	}
+
+	return method, true
+}
+
+func writeStoreError(w http.ResponseWriter, err error) {
+	if errors.Is(err, ErrMethodNotFound) {
+		utils.WriteError(w, http.StatusNotFound, err)
+		return
+	}
+
+	utils.WriteError(w, http.StatusInternalServerError, err)
+}
@@ -0,0 +1,177 @@
+package shipping
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"testing"
+
+	"github.com/eugenius-watchman/ecom_go_rest_api/cmd/service/auth/authtest"
+	"github.com/eugenius-watchman/ecom_go_rest_api/types"
+	"github.com/gorilla/mux"
+)
+
+func TestShippingServiceHandlers(t *testing.T) {
+	store := newMockShippingStore()
+	handler := NewHandler(store, userStore)
+
+	router := mux.NewRouter()
+	handler.RegisterRoutes(router)
+
+	send := func(method, path string, userID int, payload any) *httptest.ResponseRecorder {
+		return authtest.Serve(t, router, method, path, userID, payload)
+	}
+
+	payload := types.ShippingMethodPayload{
+		Code:      "courier",
+		Name:      "Courier",
+		Type:      types.ShippingWeight,
+		Price:     "4.99",
+		PerKg:     "1.50",
+		Countries: []string{"US"},
+	}
+
+	t.Run("should forbid customers from managing shipping methods", func(t *testing.T) {
+		if rr := send(http.MethodGet, "/shipping/methods", 2, nil); rr.Code != http.StatusForbidden {
+			t.Errorf("expected status code %d, got %d", http.StatusForbidden, rr.Code)
+		}
+		if rr := send(http.MethodPost, "/shipping/methods", 0, payload); rr.Code != http.StatusUnauthorized {
+			t.Errorf("expected status code %d, got %d", http.StatusUnauthorized, rr.Code)
+		}
+	})
+
+	t.Run("should let admins create shipping methods", func(t *testing.T) {
+		rr := send(http.MethodPost, "/shipping/methods", 1, payload)
+		if rr.Code != http.StatusCreated {
+			t.Fatalf("expected status code %d, got %d: %s", http.StatusCreated, rr.Code, rr.Body)
+		}
+
+		var created types.ShippingMethod
+		json.NewDecoder(rr.Body).Decode(&created)
+		if created.Code != "courier" || created.PerKg.Amount != 150 || !created.Active {
+			t.Errorf("unexpected shipping method %+v", created)
+		}
+	})
+
+	t.Run("should reject a duplicate code", func(t *testing.T) {
+		if rr := send(http.MethodPost, "/shipping/methods", 1, payload); rr.Code != http.StatusConflict {
+			t.Errorf("expected status code %d, got %d", http.StatusConflict, rr.Code)
+		}
+	})
+
+	t.Run("should reject a weight method without a per kg rate", func(t *testing.T) {
+		invalid := types.ShippingMethodPayload{Code: "heavy", Name: "Heavy", Type: types.ShippingWeight, Price: "1"}
+		if rr := send(http.MethodPost, "/shipping/methods", 1, invalid); rr.Code != http.StatusBadRequest {
+			t.Errorf("expected status code %d, got %d", http.StatusBadRequest, rr.Code)
+		}
+	})
+
+	t.Run("should reject an invalid country", func(t *testing.T) {
+		invalid := payload
+		invalid.Code = "elsewhere"
+		invalid.Countries = []string{"XX"}
+		if rr := send(http.MethodPost, "/shipping/methods", 1, invalid); rr.Code != http.StatusBadRequest {
+			t.Errorf("expected status code %d, got %d", http.StatusBadRequest, rr.Code)
+		}
+	})
+
+	t.Run("should update a shipping method", func(t *testing.T) {
+		update := payload
+		update.Price = "5.99"
+		rr := send(http.MethodPut, "/shipping/methods/1", 1, update)
+		if rr.Code != http.StatusOK {
+			t.Fatalf("expected status code %d, got %d: %s", http.StatusOK, rr.Code, rr.Body)
+		}
+		if got := store.methods[1].Price.Amount; got != 599 {
+			t.Errorf("expected price 599, got %d", got)
+		}
+	})
+
+	t.Run("should 404 on a missing shipping method", func(t *testing.T) {
+		if rr := send(http.MethodGet, "/shipping/methods/42", 1, nil); rr.Code != http.StatusNotFound {
+			t.Errorf("expected status code %d, got %d", http.StatusNotFound, rr.Code)
+		}
+		missing := payload
+		missing.Code = "missing"
+		if rr := send(http.MethodPut, "/shipping/methods/42", 1, missing); rr.Code != http.StatusNotFound {
+			t.Errorf("expected status code %d, got %d", http.StatusNotFound, rr.Code)
+		}
+		if rr := send(http.MethodDelete, "/shipping/methods/42", 1, nil); rr.Code != http.StatusNotFound {
+			t.Errorf("expected status code %d, got %d", http.StatusNotFound, rr.Code)
+		}
+	})
+
+	t.Run("should delete a shipping method", func(t *testing.T) {
+		if rr := send(http.MethodDelete, "/shipping/methods/1", 1, nil); rr.Code != http.StatusOK {
+			t.Errorf("expected status code %d, got %d", http.StatusOK, rr.Code)
+		}
+		if len(store.methods) != 0 {
+			t.Error("expected the shipping method to be deleted")
+		}
+	})
+}
+
+type mockShippingStore struct {
+	methods map[int]types.ShippingMethod
+	nextID  int
+}
+
+func newMockShippingStore(methods ...types.ShippingMethod) *mockShippingStore {
+	m := &mockShippingStore{methods: map[int]types.ShippingMethod{}}
+	for _, method := range methods {
+		m.CreateShippingMethod(method)
+	}
+	return m
+}
+
+func (m *mockShippingStore) GetShippingMethods() ([]types.ShippingMethod, error) {
+	methods := []types.ShippingMethod{}
+	for _, method := range m.methods {
+		methods = append(methods, method)
+	}
+	sort.Slice(methods, func(i, j int) bool { return methods[i].ID < methods[j].ID })
+	return methods, nil
+}
+
+func (m *mockShippingStore) GetShippingMethodByID(id int) (*types.ShippingMethod, error) {
+	method, ok := m.methods[id]
+	if !ok {
+		return nil, ErrMethodNotFound
+	}
+	return &method, nil
+}
+
+func (m *mockShippingStore) GetShippingMethodByCode(code string) (*types.ShippingMethod, error) {
+	for _, method := range m.methods {
+		if method.Code == code {
+			return &method, nil
+		}
+	}
+	return nil, ErrMethodNotFound
+}
+
+func (m *mockShippingStore) CreateShippingMethod(method types.ShippingMethod) (int, error) {
+	m.nextID++
+	method.ID = m.nextID
+	m.methods[method.ID] = method
+	return method.ID, nil
+}
+
+func (m *mockShippingStore) UpdateShippingMethod(method types.ShippingMethod) error {
+	if _, ok := m.methods[method.ID]; !ok {
+		return ErrMethodNotFound
+	}
+	m.methods[method.ID] = method
+	return nil
+}
+
+func (m *mockShippingStore) DeleteShippingMethod(id int) error {
+	if _, ok := m.methods[id]; !ok {
+		return ErrMethodNotFound
+	}
+	delete(m.methods, id)
+	return nil
+}
+
+var userStore = &authtest.UserStore{Roles: map[int]string{1: types.RoleAdmin, 2: types.RoleCustomer}}
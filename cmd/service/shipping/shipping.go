@@ -0,0 +1,178 @@
+package shipping
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"sort"
+	"strings"
+
+	"github.com/eugenius-watchman/ecom_go_rest_api/config"
+	"github.com/eugenius-watchman/ecom_go_rest_api/types"
+)
+
+var (
+	ErrMethodNotFound    = errors.New("shipping method not found")
+	ErrMethodUnavailable = errors.New("shipping method not available for this order")
+	ErrInvalidPayload    = errors.New("invalid shipping method")
+)
+
+// carriers charge bulky parcels by volume ... cm³ per kg, which is also
+// mm³ per gram
+const volumetricDivisor = 5000
+
+// Calculator implements types.ShippingCalculator from the shipping methods
+// in the store
+type Calculator struct {
+	store     types.ShippingStore
+	converter types.CurrencyConverter
+}
+
+func NewCalculator(store types.ShippingStore, converter types.CurrencyConverter) *Calculator {
+	return &Calculator{store: store, converter: converter}
+}
+
+func (c *Calculator) QuoteShipping(parcel types.Parcel) ([]types.ShippingQuote, error) {
+	methods, err := c.store.GetShippingMethods()
+	if err != nil {
+		return nil, err
+	}
+
+	quotes := []types.ShippingQuote{}
+	for i := range methods {
+		if !methods[i].Active {
+			continue
+		}
+
+		quote, err := c.quote(&methods[i], parcel)
+		if errors.Is(err, ErrMethodUnavailable) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		quotes = append(quotes, *quote)
+	}
+
+	sort.SliceStable(quotes, func(i, j int) bool {
+		return quotes[i].Price.Cmp(quotes[j].Price) < 0
+	})
+
+	return quotes, nil
+}
+
+func (c *Calculator) QuoteShippingMethod(code string, parcel types.Parcel) (*types.ShippingQuote, error) {
+	method, err := c.store.GetShippingMethodByCode(code)
+	if err != nil {
+		return nil, err
+	}
+
+	return c.quote(method, parcel)
+}
+
+func (c *Calculator) quote(method *types.ShippingMethod, parcel types.Parcel) (*types.ShippingQuote, error) {
+	if !method.Active {
+		return nil, ErrMethodNotFound
+	}
+
+	if !Ships(method, parcel) {
+		return nil, fmt.Errorf("%w: %s", ErrMethodUnavailable, method.Code)
+	}
+
+	currency := parcel.Subtotal.Currency
+	convert := func(amount types.Money) (types.Money, error) {
+		return c.converter.ConvertAmount(amount, currency)
+	}
+
+	price, err := convert(method.Price)
+	if err != nil {
+		return nil, err
+	}
+
+	switch method.Type {
+	case types.ShippingWeight:
+		perKg, err := convert(method.PerKg)
+		if err != nil {
+			return nil, err
+		}
+		price = price.Add(perKg.Mul(int64(startedKilograms(parcel.Weight))))
+	case types.ShippingFreeOver:
+		threshold, err := convert(method.FreeOver)
+		if err != nil {
+			return nil, err
+		}
+		if parcel.Subtotal.Cmp(threshold) >= 0 {
+			price = types.NewMoney(0, currency)
+		}
+	}
+
+	return &types.ShippingQuote{Method: method.Code, Name: method.Name, Price: price}, nil
+}
+
+// Ships reports whether method can take the parcel at all
+func Ships(method *types.ShippingMethod, parcel types.Parcel) bool {
+	if method.MaxWeight > 0 && parcel.Weight > method.MaxWeight {
+		return false
+	}
+
+	return len(method.Countries) == 0 || slices.Contains(method.Countries, strings.ToUpper(parcel.Country))
+}
+
+// ChargeableWeight is what quantity units of product weigh for shipping,
+// the heavier of their actual and volumetric weight
+func ChargeableWeight(product *types.Product, quantity int) int {
+	volumetric := product.Length * product.Width * product.Height / volumetricDivisor
+
+	return max(product.Weight, volumetric) * quantity
+}
+
+// FromPayload builds a method from the admin payload, amounts are read in
+// the default currency
+func FromPayload(payload types.ShippingMethodPayload) (types.ShippingMethod, error) {
+	code := config.Envs.DefaultCurrency
+
+	method := types.ShippingMethod{
+		Code:      strings.ToLower(strings.TrimSpace(payload.Code)),
+		Name:      payload.Name,
+		Type:      payload.Type,
+		Price:     types.NewMoney(0, code),
+		PerKg:     types.NewMoney(0, code),
+		FreeOver:  types.NewMoney(0, code),
+		MaxWeight: payload.MaxWeight,
+		Countries: []string{},
+		Active:    payload.Active == nil || *payload.Active,
+	}
+
+	amounts := []struct {
+		name string
+		src  string
+		dest *types.Money
+	}{
+		{"price", payload.Price, &method.Price},
+		{"perKg", payload.PerKg, &method.PerKg},
+		{"freeOver", payload.FreeOver, &method.FreeOver},
+	}
+	for _, amount := range amounts {
+		if amount.src == "" {
+			continue
+		}
+		parsed, err := types.ParseMoney(amount.src, code)
+		if err != nil || parsed.IsNegative() {
+			return types.ShippingMethod{}, fmt.Errorf("%w: %s must be an amount in %s", ErrInvalidPayload, amount.name, code)
+		}
+		*amount.dest = parsed
+	}
+
+	for _, country := range payload.Countries {
+		country = strings.ToUpper(country)
+		if !slices.Contains(method.Countries, country) {
+			method.Countries = append(method.Countries, country)
+		}
+	}
+
+	return method, nil
+}
+
+func startedKilograms(grams int) int {
+	return (grams + 999) / 1000
+}
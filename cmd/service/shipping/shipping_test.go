@@ -0,0 +1,143 @@
+package shipping
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/eugenius-watchman/ecom_go_rest_api/cmd/service/currency"
+	"github.com/eugenius-watchman/ecom_go_rest_api/types"
+)
+
+func TestQuoteShipping(t *testing.T) {
+	usd := func(cents int64) types.Money { return types.NewMoney(cents, "USD") }
+
+	store := newMockShippingStore(
+		types.ShippingMethod{Code: "flat", Name: "Flat", Type: types.ShippingFlat, Price: usd(700), PerKg: usd(0), FreeOver: usd(0), Active: true},
+		types.ShippingMethod{Code: "weight", Name: "By weight", Type: types.ShippingWeight, Price: usd(200), PerKg: usd(150), FreeOver: usd(0), MaxWeight: 10000, Active: true},
+		types.ShippingMethod{Code: "free", Name: "Free over 50", Type: types.ShippingFreeOver, Price: usd(900), PerKg: usd(0), FreeOver: usd(5000), Countries: []string{"US", "CA"}, Active: true},
+		types.ShippingMethod{Code: "off", Name: "Retired", Type: types.ShippingFlat, Price: usd(100), PerKg: usd(0), FreeOver: usd(0)},
+	)
+	calculator := NewCalculator(store, &mockConverter{})
+
+	tests := []struct {
+		name   string
+		parcel types.Parcel
+		want   map[string]int64
+	}{
+		{
+			name:   "weight rates charge every started kilogram",
+			parcel: types.Parcel{Weight: 2100, Subtotal: usd(1000), Country: "us"},
+			want:   map[string]int64{"weight": 650, "flat": 700, "free": 900},
+		},
+		{
+			name:   "free over the threshold",
+			parcel: types.Parcel{Weight: 500, Subtotal: usd(5000), Country: "CA"},
+			want:   map[string]int64{"free": 0, "weight": 350, "flat": 700},
+		},
+		{
+			name:   "methods limited to other countries are left out",
+			parcel: types.Parcel{Weight: 500, Subtotal: usd(5000), Country: "DE"},
+			want:   map[string]int64{"weight": 350, "flat": 700},
+		},
+		{
+			name:   "methods under the weight are left out",
+			parcel: types.Parcel{Weight: 10001, Subtotal: usd(1000), Country: "DE"},
+			want:   map[string]int64{"flat": 700},
+		},
+		{
+			name:   "rates are converted into the parcel currency",
+			parcel: types.Parcel{Weight: 1000, Subtotal: types.NewMoney(1000, "EUR"), Country: "DE"},
+			want:   map[string]int64{"weight": 175, "flat": 350},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			quotes, err := calculator.QuoteShipping(tt.parcel)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if len(quotes) != len(tt.want) {
+				t.Fatalf("expected %d quotes, got %+v", len(tt.want), quotes)
+			}
+			for i, quote := range quotes {
+				if want, ok := tt.want[quote.Method]; !ok || quote.Price.Amount != want {
+					t.Errorf("unexpected quote %+v", quote)
+				}
+				if quote.Price.Currency != tt.parcel.Subtotal.Currency {
+					t.Errorf("expected %s, got %+v", tt.parcel.Subtotal.Currency, quote)
+				}
+				if i > 0 && quotes[i-1].Price.Cmp(quote.Price) > 0 {
+					t.Errorf("expected cheapest first, got %+v", quotes)
+				}
+			}
+		})
+	}
+
+	t.Run("should refuse a chosen method that can't ship the parcel", func(t *testing.T) {
+		_, err := calculator.QuoteShippingMethod("free", types.Parcel{Subtotal: usd(1000), Country: "DE"})
+		if !errors.Is(err, ErrMethodUnavailable) {
+			t.Errorf("expected the method to be unavailable, got %v", err)
+		}
+	})
+
+	t.Run("should not quote an inactive method", func(t *testing.T) {
+		_, err := calculator.QuoteShippingMethod("off", types.Parcel{Subtotal: usd(1000), Country: "US"})
+		if !errors.Is(err, ErrMethodNotFound) {
+			t.Errorf("expected the method not to be found, got %v", err)
+		}
+	})
+}
+
+func TestChargeableWeight(t *testing.T) {
+	dense := &types.Product{Weight: 2000, Length: 100, Width: 100, Height: 100}
+	if got := ChargeableWeight(dense, 2); got != 4000 {
+		t.Errorf("expected 4000g, got %d", got)
+	}
+
+	// 400 x 300 x 250mm is 6kg by volume
+	bulky := &types.Product{Weight: 800, Length: 400, Width: 300, Height: 250}
+	if got := ChargeableWeight(bulky, 1); got != 6000 {
+		t.Errorf("expected 6000g, got %d", got)
+	}
+}
+
+func TestFromPayload(t *testing.T) {
+	t.Run("should normalise the code and countries", func(t *testing.T) {
+		inactive := false
+		method, err := FromPayload(types.ShippingMethodPayload{
+			Code: " Express ", Name: "Express", Type: types.ShippingFlat, Price: "12.50",
+			Countries: []string{"us", "US", "ca"}, Active: &inactive,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if method.Code != "express" || method.Price.Amount != 1250 || method.Active {
+			t.Errorf("unexpected method %+v", method)
+		}
+		if len(method.Countries) != 2 || method.Countries[0] != "US" || method.Countries[1] != "CA" {
+			t.Errorf("unexpected countries %v", method.Countries)
+		}
+	})
+
+	t.Run("should reject a negative amount", func(t *testing.T) {
+		_, err := FromPayload(types.ShippingMethodPayload{Code: "x", Name: "X", Type: types.ShippingWeight, Price: "1", PerKg: "-1"})
+		if !errors.Is(err, ErrInvalidPayload) {
+			t.Errorf("expected an invalid payload, got %v", err)
+		}
+	})
+}
+
+// mockConverter converts USD to EUR at 0.5
+type mockConverter struct{}
+
+func (m *mockConverter) ConvertAmount(amount types.Money, code string) (types.Money, error) {
+	switch code {
+	case amount.Currency:
+		return amount, nil
+	case "EUR":
+		return currency.Convert(amount, "0.5", code)
+	}
+	return types.Money{}, currency.ErrUnsupportedCurrency
+}
@@ -0,0 +1,231 @@
+package shipping
+
+import (
+	"database/sql"
+	"fmt"
+
+	"github.com/eugenius-watchman/ecom_go_rest_api/config"
+	"github.com/eugenius-watchman/ecom_go_rest_api/types"
+)
+
+type Store struct {
+	db *sql.DB
+}
+
+func NewStore(db *sql.DB) *Store {
+	return &Store{db: db}
+}
+
+const selectShippingMethod = `
+	SELECT id, code, name, type, price, perKg, freeOver, maxWeight, active, createdAt
+	FROM shipping_methods`
+
+func (s *Store) GetShippingMethods() ([]types.ShippingMethod, error) {
+	rows, err := s.db.Query(selectShippingMethod + " ORDER BY id")
+	if err != nil {
+		return nil, fmt.Errorf("failed to query shipping methods: %w", err)
+	}
+	defer rows.Close()
+
+	methods := []types.ShippingMethod{}
+	for rows.Next() {
+		method, err := scanRowIntoShippingMethod(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan shipping method: %w", err)
+		}
+		methods = append(methods, *method)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("rows iteration error: %w", err)
+	}
+
+	for i := range methods {
+		if methods[i].Countries, err = s.getCountries(methods[i].ID); err != nil {
+			return nil, err
+		}
+	}
+
+	return methods, nil
+}
+
+func (s *Store) GetShippingMethodByID(id int) (*types.ShippingMethod, error) {
+	return s.getShippingMethod(selectShippingMethod+" WHERE id = ?", id)
+}
+
+func (s *Store) GetShippingMethodByCode(code string) (*types.ShippingMethod, error) {
+	return s.getShippingMethod(selectShippingMethod+" WHERE code = ?", code)
+}
+
+func (s *Store) CreateShippingMethod(method types.ShippingMethod) (int, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return 0, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	const query = `
+		INSERT INTO shipping_methods (code, name, type, price, perKg, freeOver, maxWeight, active)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?)`
+
+	result, err := tx.Exec(query, method.Code, method.Name, method.Type, method.Price, method.PerKg,
+		method.FreeOver, method.MaxWeight, method.Active)
+	if err != nil {
+		return 0, fmt.Errorf("failed to create shipping method: %w", err)
+	}
+
+	id, err := result.LastInsertId()
+	if err != nil {
+		return 0, fmt.Errorf("failed to get shipping method ID: %w", err)
+	}
+
+	if err := replaceCountries(tx, int(id), method.Countries); err != nil {
+		return 0, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, fmt.Errorf("failed to commit transaction: %w", err)
+	}
+
+	return int(id), nil
+}
+
+func (s *Store) UpdateShippingMethod(method types.ShippingMethod) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	var id int
+	if err := tx.QueryRow("SELECT id FROM shipping_methods WHERE id = ? FOR UPDATE", method.ID).Scan(&id); err != nil {
+		if err == sql.ErrNoRows {
+			return ErrMethodNotFound
+		}
+		return fmt.Errorf("failed to get shipping method: %w", err)
+	}
+
+	const query = `
+		UPDATE shipping_methods
+		SET code = ?, name = ?, type = ?, price = ?, perKg = ?, freeOver = ?, maxWeight = ?, active = ?
+		WHERE id = ?`
+
+	_, err = tx.Exec(query, method.Code, method.Name, method.Type, method.Price, method.PerKg,
+		method.FreeOver, method.MaxWeight, method.Active, method.ID)
+	if err != nil {
+		return fmt.Errorf("failed to update shipping method: %w", err)
+	}
+
+	if err := replaceCountries(tx, method.ID, method.Countries); err != nil {
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+
+	return nil
+}
+
+// orders keep the code they were shipped with
+func (s *Store) DeleteShippingMethod(id int) error {
+	result, err := s.db.Exec("DELETE FROM shipping_methods WHERE id = ?", id)
+	if err != nil {
+		return fmt.Errorf("failed to delete shipping method: %w", err)
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to check rows affected: %w", err)
+	}
+
+	if rowsAffected == 0 {
+		return ErrMethodNotFound
+	}
+
+	return nil
+}
+
+func (s *Store) getShippingMethod(query string, args ...any) (*types.ShippingMethod, error) {
+	method, err := scanRowIntoShippingMethod(s.db.QueryRow(query, args...))
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, ErrMethodNotFound
+		}
+		return nil, fmt.Errorf("failed to get shipping method: %w", err)
+	}
+
+	if method.Countries, err = s.getCountries(method.ID); err != nil {
+		return nil, err
+	}
+
+	return method, nil
+}
+
+func (s *Store) getCountries(methodID int) ([]string, error) {
+	rows, err := s.db.Query("SELECT country FROM shipping_method_countries WHERE shippingMethodId = ? ORDER BY country", methodID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query shipping method countries: %w", err)
+	}
+	defer rows.Close()
+
+	countries := []string{}
+	for rows.Next() {
+		var country string
+		if err := rows.Scan(&country); err != nil {
+			return nil, fmt.Errorf("failed to scan shipping method country: %w", err)
+		}
+		countries = append(countries, country)
+	}
+
+	return countries, rows.Err()
+}
+
+func replaceCountries(tx *sql.Tx, methodID int, countries []string) error {
+	if _, err := tx.Exec("DELETE FROM shipping_method_countries WHERE shippingMethodId = ?", methodID); err != nil {
+		return fmt.Errorf("failed to clear shipping method countries: %w", err)
+	}
+
+	for _, country := range countries {
+		_, err := tx.Exec("INSERT IGNORE INTO shipping_method_countries (shippingMethodId, country) VALUES (?, ?)", methodID, country)
+		if err != nil {
+			return fmt.Errorf("failed to add shipping method country: %w", err)
+		}
+	}
+
+	return nil
+}
+
+// scanner is satisfied by both *sql.Row and *sql.Rows
+type scanner interface {
+	Scan(dest ...any) error
+}
+
+// amounts are in the default currency, set before scanning so the DECIMAL
+// is read with the right number of decimals
+func scanRowIntoShippingMethod(row scanner) (*types.ShippingMethod, error) {
+	code := config.Envs.DefaultCurrency
+	method := types.ShippingMethod{
+		Price:    types.NewMoney(0, code),
+		PerKg:    types.NewMoney(0, code),
+		FreeOver: types.NewMoney(0, code),
+	}
+
+	err := row.Scan(
+		&method.ID,
+		&method.Code,
+		&method.Name,
+		&method.Type,
+		&method.Price,
+		&method.PerKg,
+		&method.FreeOver,
+		&method.MaxWeight,
+		&method.Active,
+		&method.CreatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	return &method, nil
+}
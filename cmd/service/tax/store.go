@@ -0,0 +1,53 @@
+package tax
+
+import (
+	"database/sql"
+	"fmt"
+
+	"github.com/eugenius-watchman/ecom_go_rest_api/types"
+)
+
+type Store struct {
+	db *sql.DB
+}
+
+func NewStore(db *sql.DB) *Store {
+	return &Store{db: db}
+}
+
+func (s *Store) GetTaxRates(country string) ([]types.TaxRate, error) {
+	const query = `
+		SELECT id, country, region, taxClass, name, rate, inclusive
+		FROM tax_rates WHERE country = ?
+		ORDER BY region, id`
+
+	rows, err := s.db.Query(query, country)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query tax rates: %w", err)
+	}
+	defer rows.Close()
+
+	rates := []types.TaxRate{}
+	for rows.Next() {
+		var rate types.TaxRate
+		err := rows.Scan(
+			&rate.ID,
+			&rate.Country,
+			&rate.Region,
+			&rate.TaxClass,
+			&rate.Name,
+			&rate.Rate,
+			&rate.Inclusive,
+		)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan tax rate: %w", err)
+		}
+		rates = append(rates, rate)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("rows iteration error: %w", err)
+	}
+
+	return rates, nil
+}
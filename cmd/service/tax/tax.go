@@ -0,0 +1,123 @@
+package tax
+
+import (
+	"strings"
+
+	"github.com/eugenius-watchman/ecom_go_rest_api/types"
+)
+
+// Calculator implements types.TaxCalculator from the tax_rates table. A
+// line pays every rate of its class for the country, plus the rates of the
+// region if it has any. A class without rates of its own in the country is
+// taxed like TaxClassStandard, so a reduced class only needs rows where it
+// actually differs.
+type Calculator struct {
+	rates types.TaxRateStore
+}
+
+func NewCalculator(rates types.TaxRateStore) *Calculator {
+	return &Calculator{rates: rates}
+}
+
+func (c *Calculator) CalculateTax(address types.TaxAddress, lines []types.TaxableLine) ([]types.LineTax, error) {
+	rates, err := c.rates.GetTaxRates(strings.ToUpper(address.Country))
+	if err != nil {
+		return nil, err
+	}
+
+	taxes := make([]types.LineTax, 0, len(lines))
+	for _, line := range lines {
+		taxes = append(taxes, Apply(line, Lookup(rates, address.Region, line.TaxClass)))
+	}
+
+	return taxes, nil
+}
+
+// Lookup picks the rates of one country that apply to a line of class in
+// region
+func Lookup(rates []types.TaxRate, region string, class string) []types.TaxRate {
+	if class == "" {
+		class = types.TaxClassStandard
+	}
+
+	hasClass := false
+	for _, rate := range rates {
+		if rate.TaxClass == class {
+			hasClass = true
+			break
+		}
+	}
+	if !hasClass {
+		class = types.TaxClassStandard
+	}
+
+	var matched []types.TaxRate
+	for _, rate := range rates {
+		if rate.TaxClass != class {
+			continue
+		}
+		if rate.Region == "" || strings.EqualFold(rate.Region, region) {
+			matched = append(matched, rate)
+		}
+	}
+
+	return matched
+}
+
+// Apply taxes line at rates. Inclusive rates are taken out of the line's
+// amount together and split between them by rate, exclusive rates are
+// charged on what's left.
+func Apply(line types.TaxableLine, rates []types.TaxRate) types.LineTax {
+	currency := line.Amount.Currency
+	result := types.LineTax{
+		ProductID: line.ProductID,
+		VariantID: line.VariantID,
+		Tax:       types.NewMoney(0, currency),
+		Included:  types.NewMoney(0, currency),
+		Taxes:     []types.OrderItemTax{},
+	}
+
+	var inclusive []types.TaxRate
+	var weights []int64
+	var inclusiveRate int64
+	for _, rate := range rates {
+		if rate.Inclusive {
+			inclusive = append(inclusive, rate)
+			weights = append(weights, int64(rate.Rate))
+			inclusiveRate += int64(rate.Rate)
+		}
+	}
+
+	net := line.Amount
+	if inclusiveRate > 0 {
+		net = line.Amount.MulRate(10000, 10000+inclusiveRate)
+		result.Included = line.Amount.Sub(net)
+
+		for i, amount := range result.Included.Allocate(weights) {
+			result.Taxes = append(result.Taxes, orderItemTax(inclusive[i], net, amount))
+		}
+	}
+
+	result.Tax = result.Included
+	for _, rate := range rates {
+		if rate.Inclusive {
+			continue
+		}
+
+		amount := net.Percent(int64(rate.Rate))
+		result.Tax = result.Tax.Add(amount)
+		result.Taxes = append(result.Taxes, orderItemTax(rate, net, amount))
+	}
+
+	return result
+}
+
+func orderItemTax(rate types.TaxRate, taxable types.Money, amount types.Money) types.OrderItemTax {
+	return types.OrderItemTax{
+		Name:      rate.Name,
+		Rate:      rate.Rate,
+		Inclusive: rate.Inclusive,
+		Taxable:   taxable,
+		Amount:    amount,
+	}
+}
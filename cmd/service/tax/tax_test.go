@@ -0,0 +1,164 @@
+package tax
+
+import (
+	"testing"
+
+	"github.com/eugenius-watchman/ecom_go_rest_api/types"
+)
+
+func TestCalculateTax(t *testing.T) {
+	store := &mockTaxRateStore{rates: map[string][]types.TaxRate{
+		"US": {
+			{Country: "US", Region: "CA", TaxClass: types.TaxClassStandard, Name: "CA sales tax", Rate: 725},
+			{Country: "US", Region: "CA", TaxClass: types.TaxClassStandard, Name: "LA county", Rate: 225},
+			{Country: "US", Region: "NY", TaxClass: types.TaxClassStandard, Name: "NY sales tax", Rate: 400},
+		},
+		"CA": {
+			{Country: "CA", TaxClass: types.TaxClassStandard, Name: "GST", Rate: 500},
+			{Country: "CA", Region: "QC", TaxClass: types.TaxClassStandard, Name: "QST", Rate: 998},
+		},
+		"DE": {
+			{Country: "DE", TaxClass: types.TaxClassStandard, Name: "VAT", Rate: 1900, Inclusive: true},
+			{Country: "DE", TaxClass: "reduced", Name: "VAT", Rate: 700, Inclusive: true},
+		},
+	}}
+	calculator := NewCalculator(store)
+
+	tests := []struct {
+		name     string
+		address  types.TaxAddress
+		class    string
+		amount   int64
+		tax      int64
+		included int64
+		lines    []int64 // amount of each tax line
+	}{
+		{
+			name:    "exclusive rates of a region add up",
+			address: types.TaxAddress{Country: "US", Region: "CA"},
+			amount:  10000,
+			tax:     950,
+			lines:   []int64{725, 225},
+		},
+		{
+			name:    "regions are matched regardless of case",
+			address: types.TaxAddress{Country: "us", Region: "ny"},
+			amount:  10000,
+			tax:     400,
+			lines:   []int64{400},
+		},
+		{
+			name:    "a region without rates pays nothing",
+			address: types.TaxAddress{Country: "US", Region: "OR"},
+			amount:  10000,
+		},
+		{
+			name:    "country rates apply in every region",
+			address: types.TaxAddress{Country: "CA", Region: "ON"},
+			amount:  10000,
+			tax:     500,
+			lines:   []int64{500},
+		},
+		{
+			name:    "country and region rates stack",
+			address: types.TaxAddress{Country: "CA", Region: "QC"},
+			amount:  10000,
+			tax:     1498,
+			lines:   []int64{500, 998},
+		},
+		{
+			name:     "inclusive rates come out of the price",
+			address:  types.TaxAddress{Country: "DE"},
+			amount:   11900,
+			tax:      1900,
+			included: 1900,
+			lines:    []int64{1900},
+		},
+		{
+			name:     "the product's class picks the rate",
+			address:  types.TaxAddress{Country: "DE"},
+			class:    "reduced",
+			amount:   10700,
+			tax:      700,
+			included: 700,
+			lines:    []int64{700},
+		},
+		{
+			name:     "a class without rates of its own is taxed as standard",
+			address:  types.TaxAddress{Country: "DE"},
+			class:    "books",
+			amount:   11900,
+			tax:      1900,
+			included: 1900,
+			lines:    []int64{1900},
+		},
+		{
+			name:    "exclusive tax is rounded half away from zero",
+			address: types.TaxAddress{Country: "US", Region: "NY"},
+			amount:  1999,
+			tax:     80,
+			lines:   []int64{80},
+		},
+		{
+			name:    "an unknown country pays nothing",
+			address: types.TaxAddress{Country: "FR"},
+			amount:  10000,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			taxes, err := calculator.CalculateTax(tt.address, []types.TaxableLine{
+				{ProductID: 1, TaxClass: tt.class, Amount: types.NewMoney(tt.amount, "USD")},
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(taxes) != 1 {
+				t.Fatalf("expected 1 line, got %d", len(taxes))
+			}
+
+			got := taxes[0]
+			if got.Tax.Amount != tt.tax || got.Included.Amount != tt.included {
+				t.Errorf("expected tax %d (%d included), got %v (%v included)", tt.tax, tt.included, got.Tax, got.Included)
+			}
+			if len(got.Taxes) != len(tt.lines) {
+				t.Fatalf("expected %d tax lines, got %+v", len(tt.lines), got.Taxes)
+			}
+			for i, amount := range tt.lines {
+				if got.Taxes[i].Amount.Amount != amount {
+					t.Errorf("expected tax line %d to be %d, got %v", i, amount, got.Taxes[i].Amount)
+				}
+				if taxable := got.Taxes[i].Taxable.Amount; taxable != tt.amount-tt.included {
+					t.Errorf("expected %d taxable, got %d", tt.amount-tt.included, taxable)
+				}
+			}
+		})
+	}
+}
+
+func TestApplySplitsInclusiveRates(t *testing.T) {
+	// two inclusive rates share the tax in the price without losing a cent
+	rates := []types.TaxRate{
+		{Name: "federal", Rate: 500, Inclusive: true},
+		{Name: "state", Rate: 1000, Inclusive: true},
+	}
+
+	got := Apply(types.TaxableLine{ProductID: 1, Amount: types.NewMoney(1000, "USD")}, rates)
+	// 1000 - 1000/1.15 = 130.43 ... 130
+	if got.Included.Amount != 130 || got.Tax.Amount != 130 {
+		t.Fatalf("expected 130 included, got %+v", got)
+	}
+	// split 1:2, the odd cent goes to the first rate
+	if got.Taxes[0].Amount.Amount != 44 || got.Taxes[1].Amount.Amount != 86 {
+		t.Errorf("expected 44 and 86, got %+v", got.Taxes)
+	}
+}
+
+type mockTaxRateStore struct {
+	rates map[string][]types.TaxRate
+}
+
+func (m *mockTaxRateStore) GetTaxRates(country string) ([]types.TaxRate, error) {
+	return m.rates[country], nil
+}
@@ -2,8 +2,12 @@ package user
 
 import (
 	//"encoding/json"
+	"errors"
 	"fmt"
+	"log"
 	"net/http"
+	"strconv"
+	"time"
 
 	"github.com/eugenius-watchman/ecom_go_rest_api/cmd/service/auth"
 	"github.com/eugenius-watchman/ecom_go_rest_api/config"
@@ -14,16 +18,24 @@ import (
 )
 
 type Handler struct {
-	store types.UserStore
+	store      types.UserStore
+	tokenStore types.RefreshTokenStore
+	carts      types.GuestCartMerger
 }
 // interface for mocking 
-func NewHandler(store types.UserStore) *Handler {
-	return &Handler{store: store}
+func NewHandler(store types.UserStore, tokenStore types.RefreshTokenStore, carts types.GuestCartMerger) *Handler {
+	return &Handler{store: store, tokenStore: tokenStore, carts: carts}
 }
 
 func (h *Handler) RegisterRoutes(router *mux.Router) {
 	router.HandleFunc("/login", h.handleLogin).Methods("POST")
 	router.HandleFunc("/register", h.handleRegister).Methods("POST")
+	router.HandleFunc("/refresh", h.handleRefresh).Methods("POST")
+	router.HandleFunc("/logout", h.handleLogout).Methods("POST")
+
+	// user administration
+	router.HandleFunc("/users/{id}", auth.WithRole(h.handleGetUser, h.store, types.RoleAdmin)).Methods("GET")
+	router.HandleFunc("/users/{id}/role", auth.WithRole(h.handleUpdateUserRole, h.store, types.RoleAdmin)).Methods("PATCH")
 }
 
 // metheod for the handler
@@ -32,6 +44,7 @@ func (h *Handler) handleLogin(w http.ResponseWriter, r *http.Request) {
 	var payload types.LoginUserPayload
 	if err := utils.ParseJSON(r, &payload); err != nil {
 		utils.WriteError(w, http.StatusBadRequest, err)
+		return
 	}
 
 	// validate the payload
@@ -52,15 +65,150 @@ func (h *Handler) handleLogin(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// every login starts a new refresh token family
+	familyID, err := auth.NewTokenFamilyID()
+	if err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	refreshToken, err := auth.NewRefreshToken()
+	if err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	err = h.tokenStore.CreateRefreshToken(newRefreshToken(u.ID, familyID, refreshToken))
+	if err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	// what they picked as a guest comes with them ... a failed merge
+	// shouldn't stop the login
+	if err := h.carts.MergeGuestCart(w, r, u.ID); err != nil {
+		log.Println("guest cart:", err)
+	}
+
+	h.writeTokens(w, u, refreshToken)
+}
+
+func (h *Handler) handleRefresh(w http.ResponseWriter, r *http.Request) {
+	var payload types.RefreshTokenPayload
+	if err := utils.ParseJSON(r, &payload); err != nil {
+		utils.WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	if err := utils.Validate.Struct(payload); err != nil {
+		errors := err.(validator.ValidationErrors)
+		utils.WriteError(w, http.StatusBadRequest, fmt.Errorf("invalid payload %w", errors))
+		return
+	}
+
+	current, err := h.tokenStore.GetRefreshTokenByHash(auth.HashRefreshToken(payload.RefreshToken))
+	if err != nil {
+		utils.WriteError(w, http.StatusUnauthorized, fmt.Errorf("invalid refresh token"))
+		return
+	}
+
+	if current.RevokedAt != nil || time.Now().After(current.ExpiresAt) {
+		utils.WriteError(w, http.StatusUnauthorized, fmt.Errorf("invalid refresh token"))
+		return
+	}
+
+	// a rotated token coming back means it leaked ... kill the whole family
+	if current.RotatedAt != nil {
+		h.revokeFamily(w, current.FamilyID)
+		return
+	}
+
+	refreshToken, err := auth.NewRefreshToken()
+	if err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	// reload the user so the new access token carries the current role
+	u, err := h.store.GetUserByID(current.UserID)
+	if err != nil {
+		utils.WriteError(w, http.StatusUnauthorized, fmt.Errorf("invalid refresh token"))
+		return
+	}
+
+	err = h.tokenStore.RotateRefreshToken(current.ID, newRefreshToken(current.UserID, current.FamilyID, refreshToken))
+	if err != nil {
+		// lost a race against another rotation of the same token
+		if errors.Is(err, ErrRefreshTokenReused) {
+			h.revokeFamily(w, current.FamilyID)
+			return
+		}
+		utils.WriteError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	h.writeTokens(w, u, refreshToken)
+}
+
+func (h *Handler) handleLogout(w http.ResponseWriter, r *http.Request) {
+	var payload types.RefreshTokenPayload
+	if err := utils.ParseJSON(r, &payload); err != nil {
+		utils.WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	if err := utils.Validate.Struct(payload); err != nil {
+		errors := err.(validator.ValidationErrors)
+		utils.WriteError(w, http.StatusBadRequest, fmt.Errorf("invalid payload %w", errors))
+		return
+	}
+
+	// unknown tokens are not an error ... the session is gone either way
+	current, err := h.tokenStore.GetRefreshTokenByHash(auth.HashRefreshToken(payload.RefreshToken))
+	if err == nil {
+		if err := h.tokenStore.RevokeRefreshTokenFamily(current.FamilyID); err != nil {
+			utils.WriteError(w, http.StatusInternalServerError, err)
+			return
+		}
+	}
+
+	utils.WriteJSON(w, http.StatusOK, map[string]string{"message": "Logged out successfully"})
+}
+
+// writes a fresh access token alongside the given refresh token
+func (h *Handler) writeTokens(w http.ResponseWriter, u *types.User, refreshToken string) {
 	secret := []byte(config.Envs.JWTSecret)
-	token, err := auth.CreateJWT(secret, u.ID)
+	token, err := auth.CreateJWT(secret, u.ID, u.Role)
 	if err != nil {
 		utils.WriteError(w, http.StatusInternalServerError, err)
 		return
 	}
 
-	utils.WriteJSON(w, http.StatusOK, map[string]string{"token": token})
+	utils.WriteJSON(w, http.StatusOK, map[string]any{
+		"token":        token,
+		"refreshToken": refreshToken,
+		"expiresIn":    config.Envs.JWTExpirationInSeconds,
+	})
+}
 
+func (h *Handler) revokeFamily(w http.ResponseWriter, familyID string) {
+	if err := h.tokenStore.RevokeRefreshTokenFamily(familyID); err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	utils.WriteError(w, http.StatusUnauthorized, fmt.Errorf("refresh token reuse detected, session revoked"))
+}
+
+func newRefreshToken(userID int, familyID, token string) types.RefreshToken {
+	expiration := time.Second * time.Duration(config.Envs.RefreshTokenExpirationInSeconds)
+
+	return types.RefreshToken{
+		UserID:    userID,
+		FamilyID:  familyID,
+		TokenHash: auth.HashRefreshToken(token),
+		ExpiresAt: time.Now().Add(expiration),
+	}
 }
 
 func (h *Handler) handleRegister(w http.ResponseWriter, r *http.Request) {
@@ -97,6 +245,7 @@ func (h *Handler) handleRegister(w http.ResponseWriter, r *http.Request) {
 		LastName:  payload.LastName,
 		Email:     payload.Email,
 		Password:  hashedPassword,
+		Role:      types.RoleCustomer,
 	})
 	if err != nil {
 		utils.WriteError(w, http.StatusInternalServerError, err)
@@ -105,3 +254,59 @@ func (h *Handler) handleRegister(w http.ResponseWriter, r *http.Request) {
 	utils.WriteJSON(w, http.StatusCreated, nil)
 
 }
+
+func (h *Handler) handleGetUser(w http.ResponseWriter, r *http.Request) {
+	userID, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		utils.WriteError(w, http.StatusBadRequest, fmt.Errorf("invalid user ID"))
+		return
+	}
+
+	u, err := h.store.GetUserByID(userID)
+	if err != nil {
+		utils.WriteError(w, http.StatusNotFound, fmt.Errorf("user not found"))
+		return
+	}
+
+	utils.WriteJSON(w, http.StatusOK, u)
+}
+
+func (h *Handler) handleUpdateUserRole(w http.ResponseWriter, r *http.Request) {
+	userID, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		utils.WriteError(w, http.StatusBadRequest, fmt.Errorf("invalid user ID"))
+		return
+	}
+
+	var payload types.UpdateUserRolePayload
+	if err := utils.ParseJSON(r, &payload); err != nil {
+		utils.WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	if err := utils.Validate.Struct(payload); err != nil {
+		errors := err.(validator.ValidationErrors)
+		utils.WriteError(w, http.StatusBadRequest, fmt.Errorf("invalid payload %w", errors))
+		return
+	}
+
+	// admins demoting themselves could lock everyone out
+	if userID == auth.GetUserIDFromContext(r.Context()) {
+		utils.WriteError(w, http.StatusBadRequest, fmt.Errorf("cannot change your own role"))
+		return
+	}
+
+	if _, err := h.store.GetUserByID(userID); err != nil {
+		utils.WriteError(w, http.StatusNotFound, fmt.Errorf("user not found"))
+		return
+	}
+
+	if err := h.store.UpdateUserRole(userID, payload.Role); err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	utils.WriteJSON(w, http.StatusOK, map[string]string{
+		"message": "User role updated successfully",
+	})
+}
@@ -7,14 +7,17 @@ import (
 	"net/http"
 	"net/http/httptest"
 	"testing"
+	"time"
 
+	"github.com/eugenius-watchman/ecom_go_rest_api/cmd/service/auth"
+	"github.com/eugenius-watchman/ecom_go_rest_api/config"
 	"github.com/eugenius-watchman/ecom_go_rest_api/types"
 	"github.com/gorilla/mux"
 )
 
 func TestUserServiceHandlers(t *testing.T) {
 	userStore := &mockUserStore{}
-	handler := NewHandler(userStore)
+	handler := NewHandler(userStore, newMockTokenStore(), &mockCartMerger{})
 
 	// first test ...inside the main test function
 	t.Run("should fail if the user payload is invalid", func(t *testing.T) {
@@ -68,16 +71,291 @@ func TestUserServiceHandlers(t *testing.T) {
 
 }
 
+func TestLoginMergesGuestCart(t *testing.T) {
+	password, err := auth.HashedPassword("secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	carts := &mockCartMerger{}
+	handler := NewHandler(&loginUserStore{user: &types.User{ID: 2, Email: "ekg@email.org", Password: password}}, newMockTokenStore(), carts)
+
+	router := mux.NewRouter()
+	handler.RegisterRoutes(router)
+
+	login := func(password string) *httptest.ResponseRecorder {
+		marshalled, _ := json.Marshal(types.LoginUserPayload{Email: "ekg@email.org", Password: password})
+		req, err := http.NewRequest(http.MethodPost, "/login", bytes.NewBuffer(marshalled))
+		if err != nil {
+			t.Fatal(err)
+		}
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		return rr
+	}
+
+	t.Run("should not merge on a failed login", func(t *testing.T) {
+		if rr := login("wrong"); rr.Code != http.StatusBadRequest {
+			t.Fatalf("expected status code %d, got %d", http.StatusBadRequest, rr.Code)
+		}
+		if len(carts.merged) != 0 {
+			t.Errorf("expected no merge, got %v", carts.merged)
+		}
+	})
+
+	t.Run("should merge the guest cart into the user's cart", func(t *testing.T) {
+		if rr := login("secret"); rr.Code != http.StatusOK {
+			t.Fatalf("expected status code %d, got %d", http.StatusOK, rr.Code)
+		}
+		if len(carts.merged) != 1 || carts.merged[0] != 2 {
+			t.Errorf("expected a merge for user 2, got %v", carts.merged)
+		}
+	})
+
+	t.Run("should still log in when the merge fails", func(t *testing.T) {
+		carts.err = fmt.Errorf("db down")
+		if rr := login("secret"); rr.Code != http.StatusOK {
+			t.Errorf("expected status code %d, got %d", http.StatusOK, rr.Code)
+		}
+	})
+}
+
+func TestRefreshTokenHandlers(t *testing.T) {
+	tokenStore := newMockTokenStore()
+	handler := NewHandler(&mockUserStore{}, tokenStore, &mockCartMerger{})
+
+	router := mux.NewRouter()
+	router.HandleFunc("/refresh", handler.handleRefresh)
+	router.HandleFunc("/logout", handler.handleLogout)
+
+	post := func(path, refreshToken string) *httptest.ResponseRecorder {
+		marshalled, _ := json.Marshal(types.RefreshTokenPayload{RefreshToken: refreshToken})
+		req, err := http.NewRequest(http.MethodPost, path, bytes.NewBuffer(marshalled))
+		if err != nil {
+			t.Fatal(err)
+		}
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		return rr
+	}
+
+	// simulates a login for user 1
+	login := func(familyID string) string {
+		token, _ := auth.NewRefreshToken()
+		tokenStore.CreateRefreshToken(newRefreshToken(1, familyID, token))
+		return token
+	}
+
+	rotated := func(rr *httptest.ResponseRecorder) string {
+		var body map[string]any
+		json.NewDecoder(rr.Body).Decode(&body)
+		token, _ := body["refreshToken"].(string)
+		return token
+	}
+
+	t.Run("should rotate a valid refresh token", func(t *testing.T) {
+		original := login("family-a")
+
+		rr := post("/refresh", original)
+		if rr.Code != http.StatusOK {
+			t.Fatalf("expected status code %d, got %d", http.StatusOK, rr.Code)
+		}
+
+		next := rotated(rr)
+		if next == "" || next == original {
+			t.Fatal("expected a new refresh token")
+		}
+
+		if rr := post("/refresh", next); rr.Code != http.StatusOK {
+			t.Errorf("expected status code %d, got %d", http.StatusOK, rr.Code)
+		}
+	})
+
+	t.Run("should revoke the family when a rotated token is reused", func(t *testing.T) {
+		original := login("family-b")
+
+		rr := post("/refresh", original)
+		next := rotated(rr)
+
+		if rr := post("/refresh", original); rr.Code != http.StatusUnauthorized {
+			t.Errorf("expected status code %d, got %d", http.StatusUnauthorized, rr.Code)
+		}
+
+		// the legitimate successor is dead too
+		if rr := post("/refresh", next); rr.Code != http.StatusUnauthorized {
+			t.Errorf("expected status code %d, got %d", http.StatusUnauthorized, rr.Code)
+		}
+	})
+
+	t.Run("should fail for an unknown refresh token", func(t *testing.T) {
+		if rr := post("/refresh", "nope"); rr.Code != http.StatusUnauthorized {
+			t.Errorf("expected status code %d, got %d", http.StatusUnauthorized, rr.Code)
+		}
+	})
+
+	t.Run("should fail for an expired refresh token", func(t *testing.T) {
+		token, _ := auth.NewRefreshToken()
+		expired := newRefreshToken(1, "family-c", token)
+		expired.ExpiresAt = time.Now().Add(-time.Minute)
+		tokenStore.CreateRefreshToken(expired)
+
+		if rr := post("/refresh", token); rr.Code != http.StatusUnauthorized {
+			t.Errorf("expected status code %d, got %d", http.StatusUnauthorized, rr.Code)
+		}
+	})
+
+	t.Run("should revoke the session on logout", func(t *testing.T) {
+		token := login("family-d")
+
+		if rr := post("/logout", token); rr.Code != http.StatusOK {
+			t.Fatalf("expected status code %d, got %d", http.StatusOK, rr.Code)
+		}
+
+		if rr := post("/refresh", token); rr.Code != http.StatusUnauthorized {
+			t.Errorf("expected status code %d, got %d", http.StatusUnauthorized, rr.Code)
+		}
+	})
+}
+
+func TestUserAdminHandlers(t *testing.T) {
+	userStore := &mockUserStore{}
+	handler := NewHandler(userStore, newMockTokenStore(), &mockCartMerger{})
+
+	router := mux.NewRouter()
+	handler.RegisterRoutes(router)
+
+	patchRole := func(userID int, target string, role string) *httptest.ResponseRecorder {
+		marshalled, _ := json.Marshal(types.UpdateUserRolePayload{Role: role})
+		req, err := http.NewRequest(http.MethodPatch, "/users/"+target+"/role", bytes.NewBuffer(marshalled))
+		if err != nil {
+			t.Fatal(err)
+		}
+		token, _ := auth.CreateJWT([]byte(config.Envs.JWTSecret), userID, "")
+		req.Header.Set("Authorization", "Bearer "+token)
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		return rr
+	}
+
+	t.Run("should let an admin change a role", func(t *testing.T) {
+		if rr := patchRole(1, "2", types.RoleStaff); rr.Code != http.StatusOK {
+			t.Errorf("expected status code %d, got %d", http.StatusOK, rr.Code)
+		}
+	})
+
+	t.Run("should forbid customers from changing roles", func(t *testing.T) {
+		if rr := patchRole(2, "2", types.RoleAdmin); rr.Code != http.StatusForbidden {
+			t.Errorf("expected status code %d, got %d", http.StatusForbidden, rr.Code)
+		}
+	})
+
+	t.Run("should fail for an unknown role", func(t *testing.T) {
+		if rr := patchRole(1, "2", "owner"); rr.Code != http.StatusBadRequest {
+			t.Errorf("expected status code %d, got %d", http.StatusBadRequest, rr.Code)
+		}
+	})
+
+	t.Run("should fail for an unknown user", func(t *testing.T) {
+		if rr := patchRole(1, "99", types.RoleStaff); rr.Code != http.StatusNotFound {
+			t.Errorf("expected status code %d, got %d", http.StatusNotFound, rr.Code)
+		}
+	})
+}
+
 type mockUserStore struct{}
 
 func (m *mockUserStore) GetUserByEmail(email string) (*types.User, error) {
 	return nil, fmt.Errorf("user not found")
 }
 
+// user 1 is an admin, user 2 a customer
 func (m *mockUserStore) GetUserByID(id int) (*types.User, error) {
-	return nil, nil
+	switch id {
+	case 1:
+		return &types.User{ID: id, Role: types.RoleAdmin}, nil
+	case 2:
+		return &types.User{ID: id, Role: types.RoleCustomer}, nil
+	}
+	return nil, fmt.Errorf("user not found")
 }
 
 func (m *mockUserStore) CreateUser(types.User) error {
 	return nil
 }
+
+func (m *mockUserStore) UpdateUserRole(id int, role string) error {
+	return nil
+}
+
+type mockTokenStore struct {
+	tokens map[string]*types.RefreshToken
+	nextID int
+}
+
+func newMockTokenStore() *mockTokenStore {
+	return &mockTokenStore{tokens: make(map[string]*types.RefreshToken)}
+}
+
+func (m *mockTokenStore) CreateRefreshToken(token types.RefreshToken) error {
+	m.nextID++
+	token.ID = m.nextID
+	m.tokens[token.TokenHash] = &token
+	return nil
+}
+
+func (m *mockTokenStore) GetRefreshTokenByHash(hash string) (*types.RefreshToken, error) {
+	token, ok := m.tokens[hash]
+	if !ok {
+		return nil, fmt.Errorf("refresh token not found")
+	}
+	copied := *token
+	return &copied, nil
+}
+
+func (m *mockTokenStore) RotateRefreshToken(id int, next types.RefreshToken) error {
+	for _, token := range m.tokens {
+		if token.ID != id {
+			continue
+		}
+		if token.RotatedAt != nil || token.RevokedAt != nil {
+			return ErrRefreshTokenReused
+		}
+		now := time.Now()
+		token.RotatedAt = &now
+		return m.CreateRefreshToken(next)
+	}
+	return fmt.Errorf("refresh token not found")
+}
+
+func (m *mockTokenStore) RevokeRefreshTokenFamily(familyID string) error {
+	now := time.Now()
+	for _, token := range m.tokens {
+		if token.FamilyID == familyID && token.RevokedAt == nil {
+			token.RevokedAt = &now
+		}
+	}
+	return nil
+}
+
+// loginUserStore finds a single user by email
+type loginUserStore struct {
+	mockUserStore
+	user *types.User
+}
+
+func (m *loginUserStore) GetUserByEmail(email string) (*types.User, error) {
+	if email != m.user.Email {
+		return nil, fmt.Errorf("user not found")
+	}
+	return m.user, nil
+}
+
+// records who got a guest cart merged ... carts have their own tests
+type mockCartMerger struct {
+	merged []int
+	err    error
+}
+
+func (m *mockCartMerger) MergeGuestCart(w http.ResponseWriter, r *http.Request, userID int) error {
+	m.merged = append(m.merged, userID)
+	return m.err
+}
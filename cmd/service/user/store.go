@@ -17,7 +17,7 @@ func NewStore(db *sql.DB) *Store {
 }
 
 func (s *Store) GetUserByEmail(email string) (*types.User, error) {
-	rows, err := s.db.Query("SELECT * FROM users WHERE email = ?", email)
+	rows, err := s.db.Query("SELECT id, firstName, lastName, email, password, role, createdAt FROM users WHERE email = ?", email)
 	if err != nil {
 		return nil, err
 	}
@@ -48,6 +48,7 @@ func scanRowsIntoUsers(rows *sql.Rows) (*types.User, error) {
 		&user.LastName,
 		&user.Email,
 		&user.Password,
+		&user.Role,
 		&user.CreatedAt,
 	)
 
@@ -61,12 +62,13 @@ func scanRowsIntoUsers(rows *sql.Rows) (*types.User, error) {
 func (s *Store) GetUserByID(id int) (*types.User, error) {
 	var user types.User
 
-	err := s.db.QueryRow("SELECT id, firstName, lastName, email, password, createdAt FROM users WHERE id = ?", id).Scan(
+	err := s.db.QueryRow("SELECT id, firstName, lastName, email, password, role, createdAt FROM users WHERE id = ?", id).Scan(
 		&user.ID,
 		&user.FirstName,
 		&user.LastName, 
         &user.Email,
         &user.Password,
+        &user.Role,
         &user.CreatedAt,
 	)
 	if err != nil {
@@ -87,4 +89,14 @@ func (s *Store) CreateUser(user types.User) error {
 	}
 
 	return nil
-}
\ No newline at end of file
+}
+
+// rows affected is 0 when the role is unchanged, callers check the user exists
+func (s *Store) UpdateUserRole(id int, role string) error {
+	_, err := s.db.Exec("UPDATE users SET role = ? WHERE id = ?", role, id)
+	if err != nil {
+		return fmt.Errorf("failed to update user role: %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,122 @@
+package user
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+
+	"github.com/eugenius-watchman/ecom_go_rest_api/types"
+)
+
+// returned when a refresh token is presented after it was already rotated
+var ErrRefreshTokenReused = errors.New("refresh token already used")
+
+type TokenStore struct {
+	db *sql.DB
+}
+
+func NewTokenStore(db *sql.DB) *TokenStore {
+	return &TokenStore{db: db}
+}
+
+func (s *TokenStore) CreateRefreshToken(token types.RefreshToken) error {
+	return createRefreshToken(s.db, token)
+}
+
+func (s *TokenStore) GetRefreshTokenByHash(hash string) (*types.RefreshToken, error) {
+	const query = `
+		SELECT id, userId, familyId, tokenHash, expiresAt, rotatedAt, revokedAt, createdAt
+		FROM refresh_tokens WHERE tokenHash = ?`
+
+	var token types.RefreshToken
+	var rotatedAt, revokedAt sql.NullTime
+	err := s.db.QueryRow(query, hash).Scan(
+		&token.ID,
+		&token.UserID,
+		&token.FamilyID,
+		&token.TokenHash,
+		&token.ExpiresAt,
+		&rotatedAt,
+		&revokedAt,
+		&token.CreatedAt,
+	)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, fmt.Errorf("refresh token not found")
+		}
+		return nil, fmt.Errorf("failed to get refresh token: %w", err)
+	}
+
+	if rotatedAt.Valid {
+		token.RotatedAt = &rotatedAt.Time
+	}
+	if revokedAt.Valid {
+		token.RevokedAt = &revokedAt.Time
+	}
+
+	return &token, nil
+}
+
+// RotateRefreshToken marks token id as used and stores its successor in one
+// transaction ... only one of two concurrent rotations can win
+func (s *TokenStore) RotateRefreshToken(id int, next types.RefreshToken) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	const query = `
+		UPDATE refresh_tokens SET rotatedAt = NOW()
+		WHERE id = ? AND rotatedAt IS NULL AND revokedAt IS NULL`
+
+	result, err := tx.Exec(query, id)
+	if err != nil {
+		return fmt.Errorf("failed to rotate refresh token: %w", err)
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to check rows affected: %w", err)
+	}
+
+	if rowsAffected == 0 {
+		return ErrRefreshTokenReused
+	}
+
+	if err := createRefreshToken(tx, next); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func (s *TokenStore) RevokeRefreshTokenFamily(familyID string) error {
+	const query = `
+		UPDATE refresh_tokens SET revokedAt = NOW()
+		WHERE familyId = ? AND revokedAt IS NULL`
+
+	if _, err := s.db.Exec(query, familyID); err != nil {
+		return fmt.Errorf("failed to revoke refresh tokens: %w", err)
+	}
+
+	return nil
+}
+
+// execer is satisfied by both *sql.DB and *sql.Tx
+type execer interface {
+	Exec(query string, args ...any) (sql.Result, error)
+}
+
+func createRefreshToken(db execer, token types.RefreshToken) error {
+	const query = `
+		INSERT INTO refresh_tokens (userId, familyId, tokenHash, expiresAt)
+		VALUES (?, ?, ?, ?)`
+
+	_, err := db.Exec(query, token.UserID, token.FamilyID, token.TokenHash, token.ExpiresAt)
+	if err != nil {
+		return fmt.Errorf("failed to create refresh token: %w", err)
+	}
+
+	return nil
+}
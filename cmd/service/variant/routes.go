@@ -0,0 +1,233 @@
+package variant
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+
+	"github.com/eugenius-watchman/ecom_go_rest_api/cmd/service/auth"
+	"github.com/eugenius-watchman/ecom_go_rest_api/config"
+	"github.com/eugenius-watchman/ecom_go_rest_api/types"
+	"github.com/eugenius-watchman/ecom_go_rest_api/utils"
+	"github.com/go-playground/validator/v10"
+	"github.com/gorilla/mux"
+)
+
+type Handler struct {
+	store     types.VariantStore
+	userStore types.UserStore
+}
+
+func NewHandler(store types.VariantStore, userStore types.UserStore) *Handler {
+	return &Handler{store: store, userStore: userStore}
+}
+
+// customers see the variants on GET /products/{id}
+func (h *Handler) RegisterRoutes(router *mux.Router) {
+	// admin only
+	router.HandleFunc("/products/{id:[0-9]+}/options", auth.WithRole(h.handleSetOptions, h.userStore, types.RoleAdmin)).Methods("PUT")
+	router.HandleFunc("/products/{id:[0-9]+}/variants", auth.WithRole(h.handleCreateVariant, h.userStore, types.RoleAdmin)).Methods("POST")
+	router.HandleFunc("/products/{id:[0-9]+}/variants/{variantId:[0-9]+}", auth.WithRole(h.handleUpdateVariant, h.userStore, types.RoleAdmin)).Methods("PUT")
+	router.HandleFunc("/products/{id:[0-9]+}/variants/{variantId:[0-9]+}", auth.WithRole(h.handleDeleteVariant, h.userStore, types.RoleAdmin)).Methods("DELETE")
+}
+
+// replaces the product's options ... values in use by a variant can't be
+// removed
+func (h *Handler) handleSetOptions(w http.ResponseWriter, r *http.Request) {
+	productID, ok := pathID(w, r, "id", "product")
+	if !ok {
+		return
+	}
+
+	var payload types.ProductOptionsPayload
+	if err := utils.ParseJSON(r, &payload); err != nil {
+		utils.WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	if err := utils.Validate.Struct(payload); err != nil {
+		errors := err.(validator.ValidationErrors)
+		utils.WriteError(w, http.StatusBadRequest, fmt.Errorf("invalid payload %w", errors))
+		return
+	}
+
+	if err := h.store.SetProductOptions(productID, payload.Options); err != nil {
+		writeStoreError(w, err)
+		return
+	}
+
+	h.writeMatrix(w, productID)
+}
+
+func (h *Handler) handleCreateVariant(w http.ResponseWriter, r *http.Request) {
+	productID, ok := pathID(w, r, "id", "product")
+	if !ok {
+		return
+	}
+
+	v, ok := parseVariant(w, r)
+	if !ok {
+		return
+	}
+	v.ProductID = productID
+
+	// SKUs are unique across the catalog
+	if _, err := h.store.GetVariantBySKU(v.SKU); err == nil {
+		utils.WriteError(w, http.StatusConflict, fmt.Errorf("variant SKU %s already exists", v.SKU))
+		return
+	}
+
+	id, err := h.store.CreateVariant(v)
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+
+	created, err := h.store.GetVariantByID(id)
+	if err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	utils.WriteJSON(w, http.StatusCreated, created)
+}
+
+// replaces the variant, its stock included
+func (h *Handler) handleUpdateVariant(w http.ResponseWriter, r *http.Request) {
+	existing, ok := h.pathVariant(w, r)
+	if !ok {
+		return
+	}
+
+	v, ok := parseVariant(w, r)
+	if !ok {
+		return
+	}
+	v.ID = existing.ID
+	v.ProductID = existing.ProductID
+
+	if other, err := h.store.GetVariantBySKU(v.SKU); err == nil && other.ID != v.ID {
+		utils.WriteError(w, http.StatusConflict, fmt.Errorf("variant SKU %s already exists", v.SKU))
+		return
+	}
+
+	if err := h.store.UpdateVariant(v); err != nil {
+		writeStoreError(w, err)
+		return
+	}
+
+	updated, err := h.store.GetVariantByID(v.ID)
+	if err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	utils.WriteJSON(w, http.StatusOK, updated)
+}
+
+func (h *Handler) handleDeleteVariant(w http.ResponseWriter, r *http.Request) {
+	existing, ok := h.pathVariant(w, r)
+	if !ok {
+		return
+	}
+
+	if err := h.store.DeleteVariant(existing.ID); err != nil {
+		writeStoreError(w, err)
+		return
+	}
+
+	utils.WriteJSON(w, http.StatusOK, map[string]string{"message": "variant deleted"})
+}
+
+func (h *Handler) writeMatrix(w http.ResponseWriter, productID int) {
+	options, variants, err := h.store.GetProductVariants(productID)
+	if err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	utils.WriteJSON(w, http.StatusOK, map[string]any{
+		"productId": productID,
+		"options":   options,
+		"variants":  variants,
+	})
+}
+
+// the variant in the path, which must belong to the product in the path
+func (h *Handler) pathVariant(w http.ResponseWriter, r *http.Request) (*types.ProductVariant, bool) {
+	productID, ok := pathID(w, r, "id", "product")
+	if !ok {
+		return nil, false
+	}
+	variantID, ok := pathID(w, r, "variantId", "variant")
+	if !ok {
+		return nil, false
+	}
+
+	v, err := h.store.GetVariantByID(variantID)
+	if err == nil && v.ProductID != productID {
+		err = ErrVariantNotFound
+	}
+	if err != nil {
+		writeStoreError(w, err)
+		return nil, false
+	}
+
+	return v, true
+}
+
+func pathID(w http.ResponseWriter, r *http.Request, key string, what string) (int, bool) {
+	id, err := strconv.Atoi(mux.Vars(r)[key])
+	if err != nil {
+		utils.WriteError(w, http.StatusBadRequest, fmt.Errorf("invalid %s ID", what))
+		return 0, false
+	}
+
+	return id, true
+}
+
+// parses and validates the payload ... the options are checked against the
+// product's by the store
+func parseVariant(w http.ResponseWriter, r *http.Request) (types.ProductVariant, bool) {
+	payload := types.VariantPayload{Price: types.NewMoney(0, config.Envs.DefaultCurrency)}
+	if err := utils.ParseJSON(r, &payload); err != nil {
+		utils.WriteError(w, http.StatusBadRequest, err)
+		return types.ProductVariant{}, false
+	}
+
+	if err := utils.Validate.Struct(payload); err != nil {
+		errors := err.(validator.ValidationErrors)
+		utils.WriteError(w, http.StatusBadRequest, fmt.Errorf("invalid payload %w", errors))
+		return types.ProductVariant{}, false
+	}
+
+	v := types.ProductVariant{
+		SKU:           payload.SKU,
+		Price:         payload.Price,
+		PriceOverride: !payload.Price.IsZero(),
+		Image:         payload.Image,
+		Quantity:      payload.Quantity,
+		Options:       make([]types.VariantOption, 0, len(payload.Options)),
+	}
+	for name, value := range payload.Options {
+		v.Options = append(v.Options, types.VariantOption{Name: name, Value: value})
+	}
+	sort.Slice(v.Options, func(i, j int) bool { return v.Options[i].Name < v.Options[j].Name })
+
+	return v, true
+}
+
+func writeStoreError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, ErrProductNotFound), errors.Is(err, ErrVariantNotFound):
+		utils.WriteError(w, http.StatusNotFound, err)
+	case errors.Is(err, ErrInvalidOptions):
+		utils.WriteError(w, http.StatusBadRequest, err)
+	case errors.Is(err, ErrDuplicateVariant), errors.Is(err, ErrOptionsInUse):
+		utils.WriteError(w, http.StatusConflict, err)
+	default:
+		utils.WriteError(w, http.StatusInternalServerError, err)
+	}
+}
@@ -0,0 +1,272 @@
+package variant
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/eugenius-watchman/ecom_go_rest_api/cmd/service/auth/authtest"
+	"github.com/eugenius-watchman/ecom_go_rest_api/types"
+	"github.com/gorilla/mux"
+)
+
+func TestVariantServiceHandlers(t *testing.T) {
+	store := &mockVariantStore{
+		products: map[int]types.Money{1: types.NewMoney(2000, "USD"), 2: types.NewMoney(1000, "USD")},
+		options:  map[int][]types.ProductOption{},
+		variants: map[int]types.ProductVariant{},
+	}
+	handler := NewHandler(store, userStore)
+
+	router := mux.NewRouter()
+	handler.RegisterRoutes(router)
+
+	send := func(method, path string, userID int, payload any) *httptest.ResponseRecorder {
+		return authtest.Serve(t, router, method, path, userID, payload)
+	}
+
+	create := func(payload map[string]any) types.ProductVariant {
+		rr := send(http.MethodPost, "/products/1/variants", 1, payload)
+		if rr.Code != http.StatusCreated {
+			t.Fatalf("expected status code %d, got %d: %s", http.StatusCreated, rr.Code, rr.Body)
+		}
+		var v types.ProductVariant
+		json.NewDecoder(rr.Body).Decode(&v)
+		return v
+	}
+
+	options := types.ProductOptionsPayload{Options: []types.ProductOption{
+		{Name: "Size", Values: []string{"M", "L"}},
+		{Name: "Colour", Values: []string{"Red", "Blue"}},
+	}}
+
+	t.Run("should forbid customers from managing variants", func(t *testing.T) {
+		if rr := send(http.MethodPut, "/products/1/options", 0, options); rr.Code != http.StatusUnauthorized {
+			t.Errorf("expected status code %d, got %d", http.StatusUnauthorized, rr.Code)
+		}
+		if rr := send(http.MethodPut, "/products/1/options", 2, options); rr.Code != http.StatusForbidden {
+			t.Errorf("expected status code %d, got %d", http.StatusForbidden, rr.Code)
+		}
+		if rr := send(http.MethodPost, "/products/1/variants", 2, map[string]any{}); rr.Code != http.StatusForbidden {
+			t.Errorf("expected status code %d, got %d", http.StatusForbidden, rr.Code)
+		}
+	})
+
+	t.Run("should set the product's options", func(t *testing.T) {
+		rr := send(http.MethodPut, "/products/1/options", 1, options)
+		if rr.Code != http.StatusOK {
+			t.Fatalf("expected status code %d, got %d: %s", http.StatusOK, rr.Code, rr.Body)
+		}
+		var response struct {
+			Options []types.ProductOption `json:"options"`
+		}
+		json.NewDecoder(rr.Body).Decode(&response)
+		if len(response.Options) != 2 || response.Options[1].Name != "Colour" {
+			t.Errorf("unexpected options %+v", response.Options)
+		}
+
+		if rr := send(http.MethodPut, "/products/99/options", 1, options); rr.Code != http.StatusNotFound {
+			t.Errorf("expected status code %d, got %d", http.StatusNotFound, rr.Code)
+		}
+
+		bad := map[string]types.ProductOptionsPayload{
+			"a duplicate option": {Options: []types.ProductOption{{Name: "Size", Values: []string{"M"}}, {Name: "size", Values: []string{"L"}}}},
+			"no values":          {Options: []types.ProductOption{{Name: "Size"}}},
+			"too many options": {Options: []types.ProductOption{
+				{Name: "A", Values: []string{"1"}}, {Name: "B", Values: []string{"1"}},
+				{Name: "C", Values: []string{"1"}}, {Name: "D", Values: []string{"1"}},
+			}},
+		}
+		for name, payload := range bad {
+			if rr := send(http.MethodPut, "/products/2/options", 1, payload); rr.Code != http.StatusBadRequest {
+				t.Errorf("%s: expected status code %d, got %d", name, http.StatusBadRequest, rr.Code)
+			}
+		}
+	})
+
+	mRed := create(map[string]any{"sku": "TS-M-RED", "quantity": 3, "options": map[string]string{"Size": "M", "Colour": "Red"}})
+	lBlue := create(map[string]any{"sku": "TS-L-BLUE", "price": "25.00", "image": "https://example.com/l-blue.png", "quantity": 2,
+		"options": map[string]string{"Colour": "Blue", "Size": "L"}})
+
+	t.Run("should create variants with their own price or the product's", func(t *testing.T) {
+		if mRed.PriceOverride || mRed.Price.Amount != 2000 || mRed.Quantity != 3 {
+			t.Errorf("unexpected M / Red variant %+v", mRed)
+		}
+		if !lBlue.PriceOverride || lBlue.Price.Amount != 2500 {
+			t.Errorf("unexpected L / Blue variant %+v", lBlue)
+		}
+		// in the product's order
+		if got := Title(lBlue.Options); got != "L / Blue" {
+			t.Errorf("expected L / Blue, got %s", got)
+		}
+	})
+
+	t.Run("should reject bad variants", func(t *testing.T) {
+		tests := map[string]struct {
+			payload map[string]any
+			code    int
+		}{
+			"a missing sku":       {map[string]any{"quantity": 1, "options": map[string]string{"Size": "L", "Colour": "Red"}}, http.StatusBadRequest},
+			"negative stock":      {map[string]any{"sku": "X", "quantity": -1, "options": map[string]string{"Size": "L", "Colour": "Red"}}, http.StatusBadRequest},
+			"a missing option":    {map[string]any{"sku": "X", "options": map[string]string{"Size": "L"}}, http.StatusBadRequest},
+			"an unknown value":    {map[string]any{"sku": "X", "options": map[string]string{"Size": "XL", "Colour": "Red"}}, http.StatusBadRequest},
+			"a taken sku":         {map[string]any{"sku": "TS-M-RED", "options": map[string]string{"Size": "L", "Colour": "Red"}}, http.StatusConflict},
+			"a taken combination": {map[string]any{"sku": "X", "options": map[string]string{"Size": "M", "Colour": "Red"}}, http.StatusConflict},
+		}
+		for name, tt := range tests {
+			if rr := send(http.MethodPost, "/products/1/variants", 1, tt.payload); rr.Code != tt.code {
+				t.Errorf("%s: expected status code %d, got %d", name, tt.code, rr.Code)
+			}
+		}
+
+		// product 2 has no options to pick from
+		payload := map[string]any{"sku": "HAT", "options": map[string]string{}}
+		if rr := send(http.MethodPost, "/products/2/variants", 1, payload); rr.Code != http.StatusBadRequest {
+			t.Errorf("expected status code %d, got %d", http.StatusBadRequest, rr.Code)
+		}
+	})
+
+	t.Run("should update a variant", func(t *testing.T) {
+		payload := map[string]any{"sku": "TS-M-RED", "quantity": 7, "options": map[string]string{"Size": "M", "Colour": "Blue"}}
+		rr := send(http.MethodPut, fmt.Sprintf("/products/1/variants/%d", mRed.ID), 1, payload)
+		if rr.Code != http.StatusOK {
+			t.Fatalf("expected status code %d, got %d: %s", http.StatusOK, rr.Code, rr.Body)
+		}
+		var updated types.ProductVariant
+		json.NewDecoder(rr.Body).Decode(&updated)
+		if updated.Quantity != 7 || Title(updated.Options) != "M / Blue" {
+			t.Errorf("unexpected variant %+v", updated)
+		}
+
+		// the variant must belong to the product in the path
+		if rr := send(http.MethodPut, fmt.Sprintf("/products/2/variants/%d", mRed.ID), 1, payload); rr.Code != http.StatusNotFound {
+			t.Errorf("expected status code %d, got %d", http.StatusNotFound, rr.Code)
+		}
+
+		payload["sku"] = "TS-L-BLUE"
+		if rr := send(http.MethodPut, fmt.Sprintf("/products/1/variants/%d", mRed.ID), 1, payload); rr.Code != http.StatusConflict {
+			t.Errorf("expected status code %d, got %d", http.StatusConflict, rr.Code)
+		}
+	})
+
+	t.Run("should refuse removing option values in use", func(t *testing.T) {
+		payload := types.ProductOptionsPayload{Options: []types.ProductOption{
+			{Name: "Size", Values: []string{"M", "L", "XL"}},
+			{Name: "Colour", Values: []string{"Red"}},
+		}}
+		if rr := send(http.MethodPut, "/products/1/options", 1, payload); rr.Code != http.StatusConflict {
+			t.Errorf("expected status code %d, got %d", http.StatusConflict, rr.Code)
+		}
+	})
+
+	t.Run("should delete a variant", func(t *testing.T) {
+		path := fmt.Sprintf("/products/1/variants/%d", lBlue.ID)
+		if rr := send(http.MethodDelete, path, 1, nil); rr.Code != http.StatusOK {
+			t.Errorf("expected status code %d, got %d", http.StatusOK, rr.Code)
+		}
+		if rr := send(http.MethodDelete, path, 1, nil); rr.Code != http.StatusNotFound {
+			t.Errorf("expected status code %d, got %d", http.StatusNotFound, rr.Code)
+		}
+	})
+}
+
+// mockVariantStore checks options like the store does under its locks
+type mockVariantStore struct {
+	products map[int]types.Money // product ID to its price
+	options  map[int][]types.ProductOption
+	variants map[int]types.ProductVariant
+	nextID   int
+}
+
+func (m *mockVariantStore) GetProductVariants(productID int) ([]types.ProductOption, []types.ProductVariant, error) {
+	variants := []types.ProductVariant{}
+	for id := 1; id <= m.nextID; id++ {
+		if v, ok := m.variants[id]; ok && v.ProductID == productID {
+			variants = append(variants, v)
+		}
+	}
+	return append([]types.ProductOption{}, m.options[productID]...), variants, nil
+}
+
+func (m *mockVariantStore) GetVariantByID(id int) (*types.ProductVariant, error) {
+	v, ok := m.variants[id]
+	if !ok {
+		return nil, ErrVariantNotFound
+	}
+	if !v.PriceOverride {
+		v.Price = m.products[v.ProductID]
+	}
+	return &v, nil
+}
+
+func (m *mockVariantStore) GetVariantBySKU(sku string) (*types.ProductVariant, error) {
+	for _, v := range m.variants {
+		if v.SKU == sku {
+			return &v, nil
+		}
+	}
+	return nil, ErrVariantNotFound
+}
+
+func (m *mockVariantStore) SetProductOptions(productID int, options []types.ProductOption) error {
+	if _, ok := m.products[productID]; !ok {
+		return ErrProductNotFound
+	}
+	_, variants, _ := m.GetProductVariants(productID)
+	if err := CheckOptions(options, variants); err != nil {
+		return err
+	}
+	m.options[productID] = options
+	return nil
+}
+
+func (m *mockVariantStore) CreateVariant(v types.ProductVariant) (int, error) {
+	if err := m.check(&v); err != nil {
+		return 0, err
+	}
+	m.nextID++
+	v.ID = m.nextID
+	m.variants[v.ID] = v
+	return v.ID, nil
+}
+
+func (m *mockVariantStore) UpdateVariant(v types.ProductVariant) error {
+	if err := m.check(&v); err != nil {
+		return err
+	}
+	m.variants[v.ID] = v
+	return nil
+}
+
+func (m *mockVariantStore) DeleteVariant(id int) error {
+	if _, ok := m.variants[id]; !ok {
+		return ErrVariantNotFound
+	}
+	delete(m.variants, id)
+	return nil
+}
+
+func (m *mockVariantStore) check(v *types.ProductVariant) error {
+	if _, ok := m.products[v.ProductID]; !ok {
+		return ErrProductNotFound
+	}
+	picked := make(map[string]string, len(v.Options))
+	for _, option := range v.Options {
+		picked[option.Name] = option.Value
+	}
+	matched, err := MatchOptions(m.options[v.ProductID], picked)
+	if err != nil {
+		return err
+	}
+	for _, other := range m.variants {
+		if other.ProductID == v.ProductID && other.ID != v.ID && combination(other.Options) == combination(matched) {
+			return ErrDuplicateVariant
+		}
+	}
+	v.Options = matched
+	return nil
+}
+
+var userStore = &authtest.UserStore{Roles: map[int]string{1: types.RoleAdmin, 2: types.RoleCustomer}}
@@ -0,0 +1,471 @@
+package variant
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+
+	"github.com/eugenius-watchman/ecom_go_rest_api/config"
+	"github.com/eugenius-watchman/ecom_go_rest_api/types"
+)
+
+type Store struct {
+	db *sql.DB
+}
+
+func NewStore(db *sql.DB) *Store {
+	return &Store{db: db}
+}
+
+// a variant without its own price or image shows the product's
+const selectVariant = `
+	SELECT v.id, v.productId, v.sku, COALESCE(v.price, p.price), v.price IS NOT NULL,
+		IF(v.image = '', p.image, v.image), v.quantity, v.createdAt
+	FROM product_variants v
+	JOIN products p ON p.id = v.productId`
+
+func (s *Store) GetProductVariants(productID int) ([]types.ProductOption, []types.ProductVariant, error) {
+	options, _, err := loadOptions(s.db, productID)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	variants, err := queryVariants(s.db, selectVariant+" WHERE v.productId = ? ORDER BY v.id", productID)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return options, variants, nil
+}
+
+func (s *Store) GetVariantByID(id int) (*types.ProductVariant, error) {
+	return getVariant(s.db, selectVariant+" WHERE v.id = ?", id)
+}
+
+func (s *Store) GetVariantBySKU(sku string) (*types.ProductVariant, error) {
+	return getVariant(s.db, selectVariant+" WHERE v.sku = ?", sku)
+}
+
+// LockProductVariants reads the product's variants inside tx and locks
+// them until the transaction ends, so checkout can take their stock
+func LockProductVariants(tx *sql.Tx, productID int) ([]types.ProductVariant, error) {
+	return queryVariants(tx, selectVariant+" WHERE v.productId = ? ORDER BY v.id FOR UPDATE", productID)
+}
+
+// options are matched by name and values by value, so values the variants
+// use keep their IDs ... only unused ones are removed
+func (s *Store) SetProductOptions(productID int, options []types.ProductOption) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	if err := lockProduct(tx, productID); err != nil {
+		return err
+	}
+
+	variants, err := LockProductVariants(tx, productID)
+	if err != nil {
+		return err
+	}
+	if err := CheckOptions(options, variants); err != nil {
+		return err
+	}
+
+	optionIDs := []any{productID}
+	for i, option := range options {
+		const upsertOption = `
+			INSERT INTO product_options (productId, name, position) VALUES (?, ?, ?)
+			ON DUPLICATE KEY UPDATE name = VALUES(name), position = VALUES(position)`
+		if _, err := tx.Exec(upsertOption, productID, option.Name, i); err != nil {
+			return fmt.Errorf("failed to save option %s: %w", option.Name, err)
+		}
+
+		var optionID int
+		if err := tx.QueryRow("SELECT id FROM product_options WHERE productId = ? AND name = ?", productID, option.Name).Scan(&optionID); err != nil {
+			return fmt.Errorf("failed to get option %s: %w", option.Name, err)
+		}
+		optionIDs = append(optionIDs, optionID)
+
+		valueIDs := []any{optionID}
+		for j, value := range option.Values {
+			const upsertValue = `
+				INSERT INTO product_option_values (optionId, value, position) VALUES (?, ?, ?)
+				ON DUPLICATE KEY UPDATE value = VALUES(value), position = VALUES(position)`
+			if _, err := tx.Exec(upsertValue, optionID, value, j); err != nil {
+				return fmt.Errorf("failed to save value %s of %s: %w", value, option.Name, err)
+			}
+
+			var valueID int
+			if err := tx.QueryRow("SELECT id FROM product_option_values WHERE optionId = ? AND value = ?", optionID, value).Scan(&valueID); err != nil {
+				return fmt.Errorf("failed to get value %s of %s: %w", value, option.Name, err)
+			}
+			valueIDs = append(valueIDs, valueID)
+		}
+
+		query := "DELETE FROM product_option_values WHERE optionId = ?" + notIn(len(valueIDs)-1)
+		if _, err := tx.Exec(query, valueIDs...); err != nil {
+			return fmt.Errorf("failed to remove values of %s: %w", option.Name, err)
+		}
+	}
+
+	// their values go with them
+	query := "DELETE FROM product_options WHERE productId = ?" + notIn(len(optionIDs)-1)
+	if _, err := tx.Exec(query, optionIDs...); err != nil {
+		return fmt.Errorf("failed to remove options: %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+
+	return nil
+}
+
+func (s *Store) CreateVariant(v types.ProductVariant) (int, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return 0, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	valueIDs, err := checkVariant(tx, v)
+	if err != nil {
+		return 0, err
+	}
+
+	const query = `INSERT INTO product_variants (productId, sku, price, image, quantity) VALUES (?, ?, ?, ?, ?)`
+	result, err := tx.Exec(query, v.ProductID, v.SKU, variantPrice(v), v.Image, v.Quantity)
+	if err != nil {
+		return 0, fmt.Errorf("failed to create variant: %w", err)
+	}
+
+	id, err := result.LastInsertId()
+	if err != nil {
+		return 0, fmt.Errorf("failed to get variant ID: %w", err)
+	}
+
+	if err := setVariantValues(tx, int(id), valueIDs); err != nil {
+		return 0, err
+	}
+	if err := syncProductQuantity(tx, v.ProductID); err != nil {
+		return 0, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, fmt.Errorf("failed to commit transaction: %w", err)
+	}
+
+	return int(id), nil
+}
+
+func (s *Store) UpdateVariant(v types.ProductVariant) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	valueIDs, err := checkVariant(tx, v)
+	if err != nil {
+		return err
+	}
+
+	const query = `UPDATE product_variants SET sku = ?, price = ?, image = ?, quantity = ? WHERE id = ? AND productId = ?`
+	if _, err := tx.Exec(query, v.SKU, variantPrice(v), v.Image, v.Quantity, v.ID, v.ProductID); err != nil {
+		return fmt.Errorf("failed to update variant: %w", err)
+	}
+
+	if _, err := tx.Exec("DELETE FROM product_variant_values WHERE variantId = ?", v.ID); err != nil {
+		return fmt.Errorf("failed to clear variant options: %w", err)
+	}
+	if err := setVariantValues(tx, v.ID, valueIDs); err != nil {
+		return err
+	}
+	if err := syncProductQuantity(tx, v.ProductID); err != nil {
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+
+	return nil
+}
+
+// carts holding the variant lose the line, orders keep their snapshot
+func (s *Store) DeleteVariant(id int) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	var productID int
+	if err := tx.QueryRow("SELECT productId FROM product_variants WHERE id = ?", id).Scan(&productID); err != nil {
+		if err == sql.ErrNoRows {
+			return ErrVariantNotFound
+		}
+		return fmt.Errorf("failed to get variant: %w", err)
+	}
+
+	// the product first, in the same order as every other variant write
+	if err := lockProduct(tx, productID); err != nil {
+		return err
+	}
+
+	if _, err := tx.Exec("DELETE FROM cart_items WHERE productId = ? AND variantId = ?", productID, id); err != nil {
+		return fmt.Errorf("failed to remove variant from carts: %w", err)
+	}
+
+	result, err := tx.Exec("DELETE FROM product_variants WHERE id = ?", id)
+	if err != nil {
+		return fmt.Errorf("failed to delete variant: %w", err)
+	}
+	if n, err := result.RowsAffected(); err != nil {
+		return fmt.Errorf("failed to check rows affected: %w", err)
+	} else if n == 0 {
+		return ErrVariantNotFound
+	}
+
+	if err := syncProductQuantity(tx, productID); err != nil {
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+
+	return nil
+}
+
+// checkVariant locks the product and its variants, then checks v's options
+// against the product's and the other variants ... it returns the IDs of
+// v's option values
+func checkVariant(tx *sql.Tx, v types.ProductVariant) ([]int, error) {
+	if err := lockProduct(tx, v.ProductID); err != nil {
+		return nil, err
+	}
+
+	variants, err := LockProductVariants(tx, v.ProductID)
+	if err != nil {
+		return nil, err
+	}
+
+	if v.ID != 0 {
+		found := false
+		for _, other := range variants {
+			found = found || other.ID == v.ID
+		}
+		if !found {
+			return nil, ErrVariantNotFound
+		}
+	}
+
+	options, valueIDs, err := loadOptions(tx, v.ProductID)
+	if err != nil {
+		return nil, err
+	}
+
+	// the options may have changed since the handler matched them
+	picked := make(map[string]string, len(v.Options))
+	for _, option := range v.Options {
+		picked[option.Name] = option.Value
+	}
+	matched, err := MatchOptions(options, picked)
+	if err != nil {
+		return nil, err
+	}
+
+	key := combination(matched)
+	for _, other := range variants {
+		if other.ID != v.ID && combination(other.Options) == key {
+			return nil, fmt.Errorf("%w: %s", ErrDuplicateVariant, other.SKU)
+		}
+	}
+
+	ids := make([]int, len(matched))
+	for i, option := range matched {
+		ids[i] = valueIDs[option]
+	}
+
+	return ids, nil
+}
+
+func setVariantValues(tx *sql.Tx, variantID int, valueIDs []int) error {
+	for _, valueID := range valueIDs {
+		if _, err := tx.Exec("INSERT INTO product_variant_values (variantId, optionValueId) VALUES (?, ?)", variantID, valueID); err != nil {
+			return fmt.Errorf("failed to set variant options: %w", err)
+		}
+	}
+
+	return nil
+}
+
+// a product with variants is stocked through them
+func syncProductQuantity(tx *sql.Tx, productID int) error {
+	const query = `
+		UPDATE products SET quantity = (
+			SELECT COALESCE(SUM(quantity), 0) FROM product_variants WHERE productId = ?
+		) WHERE id = ?`
+
+	if _, err := tx.Exec(query, productID, productID); err != nil {
+		return fmt.Errorf("failed to update product quantity: %w", err)
+	}
+
+	return nil
+}
+
+func lockProduct(tx *sql.Tx, productID int) error {
+	var id int
+	if err := tx.QueryRow("SELECT id FROM products WHERE id = ? FOR UPDATE", productID).Scan(&id); err != nil {
+		if err == sql.ErrNoRows {
+			return ErrProductNotFound
+		}
+		return fmt.Errorf("failed to get product: %w", err)
+	}
+
+	return nil
+}
+
+// NULL sells at the product's price
+func variantPrice(v types.ProductVariant) any {
+	if !v.PriceOverride {
+		return nil
+	}
+	return v.Price
+}
+
+// loadOptions returns the product's options in order, along with the ID
+// of each of their values
+func loadOptions(q querier, productID int) ([]types.ProductOption, map[types.VariantOption]int, error) {
+	const query = `
+		SELECT o.name, ov.id, ov.value
+		FROM product_options o
+		JOIN product_option_values ov ON ov.optionId = o.id
+		WHERE o.productId = ?
+		ORDER BY o.position, ov.position`
+
+	rows, err := q.Query(query, productID)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to query product options: %w", err)
+	}
+	defer rows.Close()
+
+	options := []types.ProductOption{}
+	valueIDs := map[types.VariantOption]int{}
+	for rows.Next() {
+		var value types.VariantOption
+		var valueID int
+		if err := rows.Scan(&value.Name, &valueID, &value.Value); err != nil {
+			return nil, nil, fmt.Errorf("failed to scan product option: %w", err)
+		}
+
+		if len(options) == 0 || options[len(options)-1].Name != value.Name {
+			options = append(options, types.ProductOption{Name: value.Name})
+		}
+		last := &options[len(options)-1]
+		last.Values = append(last.Values, value.Value)
+		valueIDs[value] = valueID
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, nil, fmt.Errorf("rows iteration error: %w", err)
+	}
+
+	return options, valueIDs, nil
+}
+
+func getVariant(q querier, query string, args ...any) (*types.ProductVariant, error) {
+	variants, err := queryVariants(q, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	if len(variants) == 0 {
+		return nil, ErrVariantNotFound
+	}
+
+	return &variants[0], nil
+}
+
+func queryVariants(q querier, query string, args ...any) ([]types.ProductVariant, error) {
+	rows, err := q.Query(query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query variants: %w", err)
+	}
+	defer rows.Close()
+
+	variants := []types.ProductVariant{}
+	for rows.Next() {
+		v := types.ProductVariant{Price: types.NewMoney(0, config.Envs.DefaultCurrency), Options: []types.VariantOption{}}
+		if err := rows.Scan(&v.ID, &v.ProductID, &v.SKU, &v.Price, &v.PriceOverride, &v.Image, &v.Quantity, &v.CreatedAt); err != nil {
+			return nil, fmt.Errorf("failed to scan variant: %w", err)
+		}
+		variants = append(variants, v)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("rows iteration error: %w", err)
+	}
+	rows.Close()
+
+	if len(variants) == 0 {
+		return variants, nil
+	}
+
+	return variants, loadVariantOptions(q, variants)
+}
+
+func loadVariantOptions(q querier, variants []types.ProductVariant) error {
+	args := make([]any, len(variants))
+	byID := make(map[int]*types.ProductVariant, len(variants))
+	for i := range variants {
+		args[i] = variants[i].ID
+		byID[variants[i].ID] = &variants[i]
+	}
+
+	query := fmt.Sprintf(`
+		SELECT vv.variantId, o.name, ov.value
+		FROM product_variant_values vv
+		JOIN product_option_values ov ON ov.id = vv.optionValueId
+		JOIN product_options o ON o.id = ov.optionId
+		WHERE vv.variantId IN (?%s)
+		ORDER BY vv.variantId, o.position`, strings.Repeat(", ?", len(args)-1))
+
+	rows, err := q.Query(query, args...)
+	if err != nil {
+		return fmt.Errorf("failed to query variant options: %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var variantID int
+		var option types.VariantOption
+		if err := rows.Scan(&variantID, &option.Name, &option.Value); err != nil {
+			return fmt.Errorf("failed to scan variant option: %w", err)
+		}
+		v := byID[variantID]
+		v.Options = append(v.Options, option)
+	}
+
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("rows iteration error: %w", err)
+	}
+
+	return nil
+}
+
+// notIn excludes the n IDs after the first argument, or nothing when n is 0
+func notIn(n int) string {
+	if n == 0 {
+		return ""
+	}
+	return fmt.Sprintf(" AND id NOT IN (?%s)", strings.Repeat(", ?", n-1))
+}
+
+// querier is satisfied by both *sql.DB and *sql.Tx
+type querier interface {
+	QueryRow(query string, args ...any) *sql.Row
+	Query(query string, args ...any) (*sql.Rows, error)
+}
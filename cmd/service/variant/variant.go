@@ -0,0 +1,126 @@
+package variant
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/eugenius-watchman/ecom_go_rest_api/types"
+)
+
+var (
+	ErrProductNotFound = errors.New("product not found")
+	ErrVariantNotFound = errors.New("variant not found")
+	// returned when a product sold in variants is bought without one
+	ErrVariantRequired  = errors.New("product has variants, a variant ID is required")
+	ErrInvalidOptions   = errors.New("invalid variant options")
+	ErrDuplicateVariant = errors.New("a variant with these options already exists")
+	// returned when an option change would leave a variant without a value
+	ErrOptionsInUse = errors.New("options are in use by variants")
+)
+
+// MatchOptions turns the option values picked for a variant into one value
+// per option of the product, in the product's order
+func MatchOptions(options []types.ProductOption, picked map[string]string) ([]types.VariantOption, error) {
+	if len(options) == 0 {
+		return nil, fmt.Errorf("%w: the product has no options", ErrInvalidOptions)
+	}
+	if len(picked) != len(options) {
+		return nil, fmt.Errorf("%w: expected a value for each of %s", ErrInvalidOptions, optionNames(options))
+	}
+
+	matched := make([]types.VariantOption, 0, len(options))
+	for _, option := range options {
+		value, ok := picked[option.Name]
+		if !ok {
+			return nil, fmt.Errorf("%w: missing a value for %s", ErrInvalidOptions, option.Name)
+		}
+		if !slices.Contains(option.Values, value) {
+			return nil, fmt.Errorf("%w: %s is not a value of %s", ErrInvalidOptions, value, option.Name)
+		}
+		matched = append(matched, types.VariantOption{Name: option.Name, Value: value})
+	}
+
+	return matched, nil
+}
+
+// Title names a variant by its option values, like "M / Red"
+func Title(options []types.VariantOption) string {
+	values := make([]string, len(options))
+	for i, option := range options {
+		values[i] = option.Value
+	}
+	return strings.Join(values, " / ")
+}
+
+// Find picks the variant a cart or checkout line is for ... variantID is
+// 0 for a product sold without variants
+func Find(variants []types.ProductVariant, productID int, variantID int) (*types.ProductVariant, error) {
+	if variantID == 0 {
+		if len(variants) > 0 {
+			return nil, fmt.Errorf("%w: product %d", ErrVariantRequired, productID)
+		}
+		return nil, nil
+	}
+
+	for i := range variants {
+		if variants[i].ID == variantID {
+			return &variants[i], nil
+		}
+	}
+
+	return nil, fmt.Errorf("%w: variant %d of product %d", ErrVariantNotFound, variantID, productID)
+}
+
+// CheckOptions validates a product's options and, when the product already
+// has variants, that each of them still has exactly one value per option
+func CheckOptions(options []types.ProductOption, variants []types.ProductVariant) error {
+	// names and values are unique regardless of case, like the db compares them
+	names := map[string]bool{}
+	for _, option := range options {
+		name := strings.ToLower(option.Name)
+		if names[name] {
+			return fmt.Errorf("%w: option %s is listed twice", ErrInvalidOptions, option.Name)
+		}
+		names[name] = true
+
+		values := map[string]bool{}
+		for _, value := range option.Values {
+			if values[strings.ToLower(value)] {
+				return fmt.Errorf("%w: %s is listed twice in %s", ErrInvalidOptions, value, option.Name)
+			}
+			values[strings.ToLower(value)] = true
+		}
+	}
+
+	for _, variant := range variants {
+		picked := make(map[string]string, len(variant.Options))
+		for _, option := range variant.Options {
+			picked[option.Name] = option.Value
+		}
+		if _, err := MatchOptions(options, picked); err != nil {
+			return fmt.Errorf("%w: variant %s", ErrOptionsInUse, variant.SKU)
+		}
+	}
+
+	return nil
+}
+
+// combination identifies a variant by its option values, whatever their order
+func combination(options []types.VariantOption) string {
+	values := make([]string, len(options))
+	for i, option := range options {
+		values[i] = option.Name + "\x00" + option.Value
+	}
+	slices.Sort(values)
+	return strings.Join(values, "\x01")
+}
+
+func optionNames(options []types.ProductOption) string {
+	names := make([]string, len(options))
+	for i, option := range options {
+		names[i] = option.Name
+	}
+	return strings.Join(names, ", ")
+}
@@ -0,0 +1,122 @@
+package variant
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/eugenius-watchman/ecom_go_rest_api/types"
+)
+
+var shirtOptions = []types.ProductOption{
+	{Name: "Size", Values: []string{"S", "M", "L"}},
+	{Name: "Colour", Values: []string{"Red", "Blue"}},
+}
+
+func TestMatchOptions(t *testing.T) {
+	matched, err := MatchOptions(shirtOptions, map[string]string{"Colour": "Blue", "Size": "M"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// in the product's order, whatever order they were picked in
+	if got := Title(matched); got != "M / Blue" {
+		t.Errorf("expected M / Blue, got %s", got)
+	}
+
+	tests := map[string]map[string]string{
+		"a missing option":  {"Size": "M"},
+		"an unknown option": {"Size": "M", "Fit": "Slim"},
+		"an unknown value":  {"Size": "XXL", "Colour": "Red"},
+		"an extra option":   {"Size": "M", "Colour": "Red", "Fit": "Slim"},
+		"a value's case":    {"Size": "m", "Colour": "Red"},
+	}
+	for name, picked := range tests {
+		if _, err := MatchOptions(shirtOptions, picked); !errors.Is(err, ErrInvalidOptions) {
+			t.Errorf("%s: expected ErrInvalidOptions, got %v", name, err)
+		}
+	}
+
+	if _, err := MatchOptions(nil, map[string]string{}); !errors.Is(err, ErrInvalidOptions) {
+		t.Errorf("expected a product without options to take no variants, got %v", err)
+	}
+}
+
+func TestFind(t *testing.T) {
+	variants := []types.ProductVariant{{ID: 10, SKU: "TS-M"}, {ID: 11, SKU: "TS-L"}}
+
+	if v, err := Find(variants, 1, 11); err != nil || v.SKU != "TS-L" {
+		t.Errorf("expected TS-L, got %v, %v", v, err)
+	}
+	if _, err := Find(variants, 1, 0); !errors.Is(err, ErrVariantRequired) {
+		t.Errorf("expected ErrVariantRequired, got %v", err)
+	}
+	if _, err := Find(variants, 1, 12); !errors.Is(err, ErrVariantNotFound) {
+		t.Errorf("expected ErrVariantNotFound, got %v", err)
+	}
+
+	// a product sold without variants
+	if v, err := Find(nil, 2, 0); v != nil || err != nil {
+		t.Errorf("expected no variant and no error, got %v, %v", v, err)
+	}
+	if _, err := Find(nil, 2, 10); !errors.Is(err, ErrVariantNotFound) {
+		t.Errorf("expected ErrVariantNotFound, got %v", err)
+	}
+}
+
+func TestCheckOptions(t *testing.T) {
+	variants := []types.ProductVariant{
+		{SKU: "TS-M-RED", Options: []types.VariantOption{{Name: "Size", Value: "M"}, {Name: "Colour", Value: "Red"}}},
+	}
+
+	t.Run("should allow adding and reordering values", func(t *testing.T) {
+		options := []types.ProductOption{
+			{Name: "Colour", Values: []string{"Green", "Red"}},
+			{Name: "Size", Values: []string{"M", "XL"}},
+		}
+		if err := CheckOptions(options, variants); err != nil {
+			t.Errorf("unexpected error %v", err)
+		}
+	})
+
+	t.Run("should refuse leaving a variant without a value", func(t *testing.T) {
+		tests := map[string][]types.ProductOption{
+			"a value in use removed":   {{Name: "Size", Values: []string{"L"}}, {Name: "Colour", Values: []string{"Red"}}},
+			"an option in use removed": {{Name: "Size", Values: []string{"M"}}},
+			"an option added":          append(append([]types.ProductOption{}, shirtOptions...), types.ProductOption{Name: "Fit", Values: []string{"Slim"}}),
+		}
+		for name, options := range tests {
+			if err := CheckOptions(options, variants); !errors.Is(err, ErrOptionsInUse) {
+				t.Errorf("%s: expected ErrOptionsInUse, got %v", name, err)
+			}
+		}
+
+		// without variants anything goes
+		if err := CheckOptions(tests["an option added"], nil); err != nil {
+			t.Errorf("unexpected error %v", err)
+		}
+	})
+
+	t.Run("should refuse duplicates regardless of case", func(t *testing.T) {
+		tests := map[string][]types.ProductOption{
+			"names":  {{Name: "Size", Values: []string{"M"}}, {Name: "size", Values: []string{"L"}}},
+			"values": {{Name: "Size", Values: []string{"M", "m"}}},
+		}
+		for name, options := range tests {
+			if err := CheckOptions(options, nil); !errors.Is(err, ErrInvalidOptions) {
+				t.Errorf("%s: expected ErrInvalidOptions, got %v", name, err)
+			}
+		}
+	})
+}
+
+func TestCombination(t *testing.T) {
+	a := []types.VariantOption{{Name: "Size", Value: "M"}, {Name: "Colour", Value: "Red"}}
+	b := []types.VariantOption{{Name: "Colour", Value: "Red"}, {Name: "Size", Value: "M"}}
+	c := []types.VariantOption{{Name: "Size", Value: "M"}, {Name: "Colour", Value: "Blue"}}
+
+	if combination(a) != combination(b) {
+		t.Error("expected the same values in another order to be the same combination")
+	}
+	if combination(a) == combination(c) {
+		t.Error("expected different values to be different combinations")
+	}
+}
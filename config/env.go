@@ -18,6 +18,26 @@ type Config struct {
 	DBName                 string
 	JWTExpirationInSeconds int64
 	JWTSecret              string
+
+	RefreshTokenExpirationInSeconds int64
+
+	IdempotencyKeyTTLInSeconds int64
+
+	DefaultCurrency      string
+	ShopCountry          string
+	ShopName             string
+	ShopAddress          string // lines separated by ";"
+	ShopTaxID            string
+	PaymentProvider      string
+	PaymentWebhookSecret string
+
+	PaymentWebhookToleranceInSeconds int64
+
+	ExchangeRatesFile string
+
+	CartTokenSecret              string
+	GuestCartTTLInSeconds        int64
+	GuestCartGCIntervalInSeconds int64
 }
 
 // avoid initialising function everytime
@@ -34,7 +54,27 @@ func initConfig() Config {
 		DBAddress:              fmt.Sprintf("%s:%s", getEnv("DB_HOST", "127.0.0.1"), getEnv("DB_PORT", "3306")),
 		DBName:                 getEnv("DB_NAME", "ecommerce"),
 		JWTSecret:              getEnv("JWT_SECRET", "not-secret-secret-anymore?"),
-		JWTExpirationInSeconds: getEnvAsInt("JWT_EXP", 3600*24*7),
+		JWTExpirationInSeconds: getEnvAsInt("JWT_EXP", 60*15),
+
+		RefreshTokenExpirationInSeconds: getEnvAsInt("REFRESH_TOKEN_EXP", 3600*24*30),
+
+		IdempotencyKeyTTLInSeconds: getEnvAsInt("IDEMPOTENCY_KEY_TTL", 3600*24),
+
+		DefaultCurrency:      getEnv("DEFAULT_CURRENCY", "USD"),
+		ShopCountry:          getEnv("SHOP_COUNTRY", "US"),
+		ShopName:             getEnv("SHOP_NAME", "Ecom Store"),
+		ShopAddress:          getEnv("SHOP_ADDRESS", ""),
+		ShopTaxID:            getEnv("SHOP_TAX_ID", ""),
+		PaymentProvider:      getEnv("PAYMENT_PROVIDER", "fake"),
+		PaymentWebhookSecret: getEnv("PAYMENT_WEBHOOK_SECRET", "not-secret-webhook-secret"),
+
+		PaymentWebhookToleranceInSeconds: getEnvAsInt("PAYMENT_WEBHOOK_TOLERANCE", 60*5),
+
+		ExchangeRatesFile: getEnv("EXCHANGE_RATES_FILE", "cmd/rates/exchange_rates.json"),
+
+		CartTokenSecret:              getEnv("CART_TOKEN_SECRET", "not-secret-cart-secret"),
+		GuestCartTTLInSeconds:        getEnvAsPositiveInt("GUEST_CART_TTL", 3600*24*7),
+		GuestCartGCIntervalInSeconds: getEnvAsPositiveInt("GUEST_CART_GC_INTERVAL", 3600),
 	}
 }
 
@@ -58,3 +98,13 @@ func getEnvAsInt(key string, fallback int64) int64 {
 
 	return fallback
 }
+
+// for durations a zero or negative value makes no sense for, e.g. a ticker
+// interval ... those fall back like a value that doesn't parse
+func getEnvAsPositiveInt(key string, fallback int64) int64 {
+	if i := getEnvAsInt(key, fallback); i > 0 {
+		return i
+	}
+
+	return fallback
+}
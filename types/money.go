@@ -0,0 +1,307 @@
+package types
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"strconv"
+	"strings"
+)
+
+// Money is an exact amount in the minor units (cents) of an ISO 4217
+// currency. JSON carries it as a decimal string ("19.99") and the db as
+// DECIMAL, so no float ever touches a price or a total.
+type Money struct {
+	Amount   int64
+	Currency string
+}
+
+// currencies without the usual two decimal places
+var minorUnits = map[string]int{
+	"CLP": 0,
+	"ISK": 0,
+	"JPY": 0,
+	"KRW": 0,
+	"VND": 0,
+}
+
+// MinorUnits returns how many decimal places currency uses
+func MinorUnits(currency string) int {
+	if digits, ok := minorUnits[strings.ToUpper(currency)]; ok {
+		return digits
+	}
+
+	return 2
+}
+
+func NewMoney(amount int64, currency string) Money {
+	return Money{Amount: amount, Currency: currency}
+}
+
+// ParseMoney reads a decimal such as "19.99" or "-5" exactly. Digits past
+// the currency's minor units are only accepted when they are zeros.
+func ParseMoney(s, currency string) (Money, error) {
+	digits := MinorUnits(currency)
+
+	value := strings.TrimSpace(s)
+	negative := strings.HasPrefix(value, "-")
+	value = strings.TrimPrefix(strings.TrimPrefix(value, "-"), "+")
+
+	whole, fraction, _ := strings.Cut(value, ".")
+	if whole == "" && fraction == "" {
+		return Money{}, fmt.Errorf("invalid amount %q", s)
+	}
+
+	if len(fraction) > digits {
+		if strings.Trim(fraction[digits:], "0") != "" {
+			return Money{}, fmt.Errorf("invalid amount %q: more than %d decimal places", s, digits)
+		}
+		fraction = fraction[:digits]
+	}
+	fraction += strings.Repeat("0", digits-len(fraction))
+
+	for _, c := range whole + fraction {
+		if c < '0' || c > '9' {
+			return Money{}, fmt.Errorf("invalid amount %q", s)
+		}
+	}
+
+	// ".00" in a currency without decimals leaves nothing to parse
+	var amount int64
+	if whole+fraction != "" {
+		var err error
+		amount, err = strconv.ParseInt(whole+fraction, 10, 64)
+		if err != nil {
+			return Money{}, fmt.Errorf("invalid amount %q: %w", s, err)
+		}
+	}
+
+	if negative {
+		amount = -amount
+	}
+
+	return Money{Amount: amount, Currency: currency}, nil
+}
+
+// Add and Sub panic on a currency mismatch ... mixing currencies is always
+// a bug. A zero value without a currency takes the other side's.
+func (m Money) Add(other Money) Money {
+	return Money{Amount: m.Amount + other.Amount, Currency: m.sameCurrency(other)}
+}
+
+func (m Money) Sub(other Money) Money {
+	return Money{Amount: m.Amount - other.Amount, Currency: m.sameCurrency(other)}
+}
+
+// Mul is for quantities, it never rounds
+func (m Money) Mul(quantity int64) Money {
+	return Money{Amount: m.Amount * quantity, Currency: m.Currency}
+}
+
+// MulRate returns m * numerator / denominator rounded half away from zero
+// to the minor unit, the same rule MySQL applies to DECIMAL
+func (m Money) MulRate(numerator, denominator int64) Money {
+	if denominator == 0 {
+		panic("money: division by zero")
+	}
+
+	product := new(big.Int).Mul(big.NewInt(m.Amount), big.NewInt(numerator))
+	den := big.NewInt(denominator)
+
+	quotient, remainder := new(big.Int).QuoRem(product, den, new(big.Int))
+
+	// |2r| >= |den| rounds away from zero
+	twice := new(big.Int).Abs(new(big.Int).Lsh(remainder, 1))
+	if twice.Cmp(new(big.Int).Abs(den)) >= 0 {
+		if (product.Sign() < 0) != (den.Sign() < 0) {
+			quotient.Sub(quotient, big.NewInt(1))
+		} else {
+			quotient.Add(quotient, big.NewInt(1))
+		}
+	}
+
+	return Money{Amount: quotient.Int64(), Currency: m.Currency}
+}
+
+// Percent applies a rate in basis points (1/100 of a percent), so 825 is
+// 8.25%, rounded like MulRate ... used for tax and percentage discounts
+func (m Money) Percent(basisPoints int64) Money {
+	return m.MulRate(basisPoints, 10000)
+}
+
+// Allocate splits m across weights without losing a cent. Shares are
+// rounded down and the leftover minor units go to the earliest shares,
+// so the parts always add up to m.
+func (m Money) Allocate(weights []int64) []Money {
+	parts := make([]Money, len(weights))
+
+	var total int64
+	for _, weight := range weights {
+		total += weight
+	}
+
+	if total == 0 {
+		for i := range parts {
+			parts[i] = Money{Currency: m.Currency}
+		}
+		if len(parts) > 0 {
+			parts[0].Amount = m.Amount
+		}
+		return parts
+	}
+
+	var allocated int64
+	for i, weight := range weights {
+		share := new(big.Int).Mul(big.NewInt(m.Amount), big.NewInt(weight))
+		share.Quo(share, big.NewInt(total))
+		parts[i] = Money{Amount: share.Int64(), Currency: m.Currency}
+		allocated += parts[i].Amount
+	}
+
+	step := int64(1)
+	if m.Amount < 0 {
+		step = -1
+	}
+	for i := 0; allocated != m.Amount && len(parts) > 0; i = (i + 1) % len(parts) {
+		if weights[i] == 0 {
+			continue
+		}
+		parts[i].Amount += step
+		allocated += step
+	}
+
+	return parts
+}
+
+func (m Money) IsZero() bool {
+	return m.Amount == 0
+}
+
+func (m Money) IsNegative() bool {
+	return m.Amount < 0
+}
+
+// Cmp returns -1, 0 or +1 like strings.Compare
+func (m Money) Cmp(other Money) int {
+	m.sameCurrency(other)
+
+	switch {
+	case m.Amount < other.Amount:
+		return -1
+	case m.Amount > other.Amount:
+		return 1
+	}
+
+	return 0
+}
+
+func (m Money) Min(other Money) Money {
+	if m.Cmp(other) <= 0 {
+		return m
+	}
+
+	return other
+}
+
+// String formats the amount as a plain decimal without the currency
+func (m Money) String() string {
+	digits := MinorUnits(m.Currency)
+
+	amount := m.Amount
+	sign := ""
+	if amount < 0 {
+		sign = "-"
+	}
+
+	abs := strconv.FormatUint(absUint64(amount), 10)
+	if digits == 0 {
+		return sign + abs
+	}
+
+	if len(abs) <= digits {
+		abs = strings.Repeat("0", digits-len(abs)+1) + abs
+	}
+
+	return sign + abs[:len(abs)-digits] + "." + abs[len(abs)-digits:]
+}
+
+func (m Money) MarshalJSON() ([]byte, error) {
+	return json.Marshal(m.String())
+}
+
+// UnmarshalJSON accepts "19.99" as well as a bare 19.99. The currency is
+// not part of the value ... it keeps whatever m already had.
+func (m *Money) UnmarshalJSON(data []byte) error {
+	value := string(data)
+	if value == "null" {
+		return nil
+	}
+
+	if unquoted, err := strconv.Unquote(value); err == nil {
+		value = unquoted
+	}
+
+	parsed, err := ParseMoney(value, m.Currency)
+	if err != nil {
+		return err
+	}
+
+	m.Amount = parsed.Amount
+	return nil
+}
+
+// Scan reads a DECIMAL column. Like UnmarshalJSON it keeps m.Currency, so
+// scan the currency column first when there is one.
+func (m *Money) Scan(src any) error {
+	var value string
+	switch v := src.(type) {
+	case []byte:
+		value = string(v)
+	case string:
+		value = v
+	case int64:
+		value = strconv.FormatInt(v, 10)
+	case float64:
+		value = strconv.FormatFloat(v, 'f', -1, 64)
+	case nil:
+		m.Amount = 0
+		return nil
+	default:
+		return fmt.Errorf("cannot scan %T into Money", src)
+	}
+
+	parsed, err := ParseMoney(value, m.Currency)
+	if err != nil {
+		return err
+	}
+
+	m.Amount = parsed.Amount
+	return nil
+}
+
+// Value writes the decimal string so DECIMAL columns get the exact amount
+func (m Money) Value() (driver.Value, error) {
+	return m.String(), nil
+}
+
+func (m Money) sameCurrency(other Money) string {
+	switch {
+	case m.Currency == other.Currency:
+		return m.Currency
+	case m.Currency == "" && m.Amount == 0:
+		return other.Currency
+	case other.Currency == "" && other.Amount == 0:
+		return m.Currency
+	}
+
+	panic(fmt.Sprintf("money: currency mismatch %s and %s", m.Currency, other.Currency))
+}
+
+func absUint64(n int64) uint64 {
+	if n < 0 {
+		return uint64(-(n + 1)) + 1
+	}
+
+	return uint64(n)
+}
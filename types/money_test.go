@@ -0,0 +1,149 @@
+package types
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestParseMoney(t *testing.T) {
+	cases := []struct {
+		in       string
+		currency string
+		want     int64
+	}{
+		{"19.99", "USD", 1999},
+		{"19.9", "USD", 1990},
+		{"19", "USD", 1900},
+		{"0.01", "USD", 1},
+		{"-5.25", "USD", -525},
+		{"19.990", "USD", 1999},
+		{"1500", "JPY", 1500},
+		{"1500.00", "JPY", 1500},
+	}
+	for _, tc := range cases {
+		got, err := ParseMoney(tc.in, tc.currency)
+		if err != nil {
+			t.Errorf("ParseMoney(%q): %v", tc.in, err)
+			continue
+		}
+		if got.Amount != tc.want || got.Currency != tc.currency {
+			t.Errorf("ParseMoney(%q) = %+v, want %d %s", tc.in, got, tc.want, tc.currency)
+		}
+	}
+
+	for _, in := range []string{"", "abc", "1.2.3", "19.999", "1e3", "1500.5"} {
+		currency := "USD"
+		if in == "1500.5" {
+			currency = "JPY"
+		}
+		if _, err := ParseMoney(in, currency); err == nil {
+			t.Errorf("expected ParseMoney(%q) to fail", in)
+		}
+	}
+}
+
+func TestMoneyString(t *testing.T) {
+	cases := []struct {
+		money Money
+		want  string
+	}{
+		{NewMoney(1999, "USD"), "19.99"},
+		{NewMoney(5, "USD"), "0.05"},
+		{NewMoney(0, "USD"), "0.00"},
+		{NewMoney(-1, "USD"), "-0.01"},
+		{NewMoney(1500, "JPY"), "1500"},
+	}
+	for _, tc := range cases {
+		if got := tc.money.String(); got != tc.want {
+			t.Errorf("%+v.String() = %q, want %q", tc.money, got, tc.want)
+		}
+	}
+}
+
+func TestMoneyRounding(t *testing.T) {
+	cases := []struct {
+		amount      int64
+		basisPoints int64
+		want        int64
+	}{
+		{1000, 825, 83},   // 82.5 rounds up
+		{1001, 825, 83},   // 82.58
+		{999, 825, 82},    // 82.41
+		{-1000, 825, -83}, // away from zero
+		{3, 5000, 2},      // 1.5
+		{1, 4999, 0},      // 0.4999
+	}
+	for _, tc := range cases {
+		if got := NewMoney(tc.amount, "USD").Percent(tc.basisPoints); got.Amount != tc.want {
+			t.Errorf("%d * %d bp = %d, want %d", tc.amount, tc.basisPoints, got.Amount, tc.want)
+		}
+	}
+}
+
+func TestMoneyAllocate(t *testing.T) {
+	parts := NewMoney(100, "USD").Allocate([]int64{1, 1, 1})
+	if parts[0].Amount != 34 || parts[1].Amount != 33 || parts[2].Amount != 33 {
+		t.Errorf("unexpected split %+v", parts)
+	}
+
+	parts = NewMoney(1000, "USD").Allocate([]int64{1999, 0, 501})
+	var sum int64
+	for _, part := range parts {
+		sum += part.Amount
+	}
+	if sum != 1000 || parts[1].Amount != 0 {
+		t.Errorf("unexpected split %+v", parts)
+	}
+}
+
+func TestMoneyJSON(t *testing.T) {
+	var product struct {
+		Price Money `json:"price"`
+	}
+
+	if err := json.Unmarshal([]byte(`{"price":"19.99"}`), &product); err != nil {
+		t.Fatal(err)
+	}
+	if product.Price.Amount != 1999 {
+		t.Errorf("expected 1999, got %d", product.Price.Amount)
+	}
+
+	// bare numbers are read from their text, never through a float
+	if err := json.Unmarshal([]byte(`{"price":0.29}`), &product); err != nil {
+		t.Fatal(err)
+	}
+	if product.Price.Amount != 29 {
+		t.Errorf("expected 29, got %d", product.Price.Amount)
+	}
+
+	product.Price = NewMoney(1050, "USD")
+	marshalled, _ := json.Marshal(product)
+	if string(marshalled) != `{"price":"10.50"}` {
+		t.Errorf("unexpected JSON %s", marshalled)
+	}
+}
+
+func TestMoneyScan(t *testing.T) {
+	var m Money
+	if err := m.Scan([]byte("123.45")); err != nil {
+		t.Fatal(err)
+	}
+	if m.Amount != 12345 {
+		t.Errorf("expected 12345, got %d", m.Amount)
+	}
+
+	value, _ := m.Value()
+	if value != "123.45" {
+		t.Errorf("expected 123.45, got %v", value)
+	}
+}
+
+func TestMoneyCurrencyMismatch(t *testing.T) {
+	defer func() {
+		if recover() == nil {
+			t.Error("expected adding different currencies to panic")
+		}
+	}()
+
+	NewMoney(100, "USD").Add(NewMoney(100, "EUR"))
+}
@@ -1,20 +1,97 @@
 package types
 
-import "time"
+import (
+	"net/http"
+	"time"
+)
+
+// user roles ... matches the users.role ENUM
+const (
+	RoleCustomer = "customer"
+	RoleStaff    = "staff"
+	RoleAdmin    = "admin"
+)
 
 type UserStore interface {
 	GetUserByEmail(email string) (*User, error)
 	GetUserByID(id int) (*User, error)
 	CreateUser(User) error
+	UpdateUserRole(id int, role string) error
+}
+
+type RefreshTokenStore interface {
+	CreateRefreshToken(RefreshToken) error
+	GetRefreshTokenByHash(hash string) (*RefreshToken, error)
+	RotateRefreshToken(id int, next RefreshToken) error
+	RevokeRefreshTokenFamily(familyID string) error
 }
 
 type ProductStore interface {
-	GetProducts() ([]Product, error)
+	// GetProducts returns one page of products matching query, in its order
+	GetProducts(query ProductQuery) ([]Product, error)
+	// CountProducts counts every product matching query's filters
+	CountProducts(query ProductQuery) (int, error)
 	GetProductByID(id int) (*Product, error)
 	CreateProduct(Product) error//
 	UpdateProduct(id int, product Product) error
 	ProductExists(id int) (bool, error)
 	UpdateProductQuantity(id int, newQuantity int) error
+	// GetProductPrices returns the explicit prices in currency, keyed by
+	// product ID ... products without one are missing from the map
+	GetProductPrices(ids []int, currency string) (map[int]Money, error)
+	SetProductPrice(productID int, price Money) error
+	// DeleteProduct hides the product from the catalog and checkout, its
+	// row stays for the orders it's on
+	DeleteProduct(id int) error
+	RestoreProduct(id int) error
+}
+
+type VariantStore interface {
+	// GetProductVariants returns the product's options and variants, both
+	// empty for a product sold without variants
+	GetProductVariants(productID int) ([]ProductOption, []ProductVariant, error)
+	GetVariantByID(id int) (*ProductVariant, error)
+	GetVariantBySKU(sku string) (*ProductVariant, error)
+	// SetProductOptions replaces the product's options, refusing a change
+	// that would leave a variant without a value
+	SetProductOptions(productID int, options []ProductOption) error
+	// variant writes keep the product's quantity the sum of its variants'
+	CreateVariant(ProductVariant) (int, error)
+	UpdateVariant(ProductVariant) error
+	DeleteVariant(id int) error
+}
+
+// ProductPricer puts products in the customer's currency, using an
+// explicit product price when there is one and the exchange rate otherwise
+type ProductPricer interface {
+	PriceProducts(products []Product, currency string) error
+	// PriceVariants does the same for variants, a variant with its own
+	// price is always converted at the exchange rate
+	PriceVariants(variants []ProductVariant, currency string) error
+}
+
+// SearchIndex finds products by the words in their name and description
+type SearchIndex interface {
+	Search(query SearchQuery) (*SearchResult, error)
+}
+
+// CurrencyConverter puts amounts kept in the default currency, like
+// shipping rates, into the customer's currency
+type CurrencyConverter interface {
+	ConvertAmount(amount Money, currency string) (Money, error)
+}
+
+type ExchangeRateStore interface {
+	GetExchangeRate(currency string) (*ExchangeRate, error)
+	// ReplaceExchangeRates swaps the whole table in one transaction
+	ReplaceExchangeRates(rates []ExchangeRate) error
+}
+
+// units of Currency for one unit of the default currency
+type ExchangeRate struct {
+	Currency  string    `json:"currency"`
+	Rate      string    `json:"rate"` // exact decimal, e.g. "0.92150000"
+	UpdatedAt time.Time `json:"updatedAt"`
 }
 
 type Product struct {
@@ -22,9 +99,87 @@ type Product struct {
 	Name        string    `json:"name"`
 	Description string    `json:"description"`
 	Image       string    `json:"image"`
-	Price       float64   `json:"price"`
+	Price       Money     `json:"price"`
+	Currency    string    `json:"currency"`
 	Quantity    int       `json:"quantity"`
-	CreatedAt   time.Time `json:"createdAt"`
+	TaxClass    string    `json:"taxClass"` // picks the tax rate
+	// for shipping, in grams and millimetres ... 0 when unknown
+	Weight    int       `json:"weight"`
+	Length    int       `json:"length"`
+	Width     int       `json:"width"`
+	Height    int       `json:"height"`
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+// columns products can be listed by
+type ProductSort string
+
+const (
+	ProductSortCreatedAt ProductSort = "createdAt"
+	ProductSortPrice     ProductSort = "price"
+	ProductSortName      ProductSort = "name"
+)
+
+// ProductQuery picks a page of products ... zero values don't filter
+type ProductQuery struct {
+	// in the default currency, the one prices are stored in
+	MinPrice      *Money
+	MaxPrice      *Money
+	InStock       bool
+	CategoryID    int
+	CreatedAfter  *time.Time
+	CreatedBefore *time.Time
+
+	Sort ProductSort
+	Desc bool
+	// only products after this one in the sort order
+	After *ProductCursor
+	Limit int
+}
+
+// ProductCursor is the last product of the previous page, only the field
+// being sorted by and the ID are used
+type ProductCursor struct {
+	CreatedAt time.Time
+	Price     Money
+	Name      string
+	ID        int
+}
+
+// ProductOption is one way a product comes in, like size, with its values
+// in display order
+type ProductOption struct {
+	Name   string   `json:"name" validate:"required,max=64"`
+	Values []string `json:"values" validate:"required,min=1,max=100,unique,dive,required,max=64"`
+}
+
+// ProductVariant is one combination of option values with its own SKU and
+// stock ... a product with variants is only sold through them
+type ProductVariant struct {
+	ID        int    `json:"id"`
+	ProductID int    `json:"productId"`
+	SKU       string `json:"sku"`
+	// the product's price unless PriceOverride is set
+	Price         Money `json:"price"`
+	PriceOverride bool  `json:"priceOverride"`
+	// the product's image unless the variant has its own
+	Image    string `json:"image"`
+	Quantity int    `json:"quantity"`
+	// a value for every option of the product, in the product's order
+	Options   []VariantOption `json:"options"`
+	CreatedAt time.Time       `json:"createdAt"`
+}
+
+type VariantOption struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
+
+// LineKey tells the lines of a cart or an order apart, VariantID is 0 for
+// a product sold without variants
+type LineKey struct {
+	ProductID int
+	VariantID int
 }
 
 type User struct {
@@ -33,10 +188,23 @@ type User struct {
 	FirstName string    `json:"firstName"`
 	LastName  string    `json:"lastName"`
 	Email     string    `json:"email"`
-	Password  string    `json:"password"`
+	Password  string    `json:"-"`
+	Role      string    `json:"role"`
 	CreatedAt time.Time `json:"createdAt"`
 }
 
+// refresh tokens are opaque ... only the hash is stored
+type RefreshToken struct {
+	ID        int        `json:"id"`
+	UserID    int        `json:"userId"`
+	FamilyID  string     `json:"familyId"` // shared by every rotation of one login
+	TokenHash string     `json:"-"`
+	ExpiresAt time.Time  `json:"expiresAt"`
+	RotatedAt *time.Time `json:"rotatedAt"`
+	RevokedAt *time.Time `json:"revokedAt"`
+	CreatedAt time.Time  `json:"createdAt"`
+}
+
 type RegisterUserPayload struct {
 	// Go field name ... JSON field nam
 	FirstName string `json:"firstName" validate:"required"`
@@ -51,53 +219,877 @@ type LoginUserPayload struct {
 	Password string `json:"password" validate:"required"`
 }
 
+type UpdateUserRolePayload struct {
+	Role string `json:"role" validate:"required,oneof=customer staff admin"`
+}
+
+type RefreshTokenPayload struct {
+	RefreshToken string `json:"refreshToken" validate:"required"`
+}
+
+type AddressStore interface {
+	GetUserAddresses(userID int) ([]UserAddress, error)
+	GetUserAddress(userID int, id int) (*UserAddress, error)
+	GetDefaultAddress(userID int) (*UserAddress, error)
+	CreateUserAddress(UserAddress) (int, error)
+	UpdateUserAddress(UserAddress) error
+	DeleteUserAddress(userID int, id int) error
+	SetDefaultAddress(userID int, id int) error
+}
+
+// Address is a postal address ... the address package checks it against
+// its country's format
+type Address struct {
+	Name       string `json:"name" validate:"required,max=255"`
+	Line1      string `json:"line1" validate:"required,max=255"`
+	Line2      string `json:"line2" validate:"max=255"`
+	City       string `json:"city" validate:"required,max=128"`
+	Region     string `json:"region" validate:"max=64"`
+	PostalCode string `json:"postalCode" validate:"max=32"`
+	Country    string `json:"country" validate:"required,iso3166_1_alpha2"`
+	Phone      string `json:"phone" validate:"max=32"`
+}
+
+// one entry of a user's address book
+type UserAddress struct {
+	ID     int    `json:"id"`
+	UserID int    `json:"userId"`
+	Label  string `json:"label"` // e.g. home or work
+	Address
+	IsDefault bool      `json:"isDefault"`
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+type UserAddressPayload struct {
+	Label string `json:"label" validate:"max=64"`
+	Address
+	// the first address is always made the default
+	Default bool `json:"default"`
+}
+
 type CreateProductPayload struct {
 	Name        string  `json:"name" validate:"required"`
 	Description string  `json:"description" validate:"required"`
 	Image       string  `json:"image" validate:"required"`
-	Price       float64 `json:"price" validate:"required"`
+	Price       Money   `json:"price" validate:"required"`
 	Quantity    int     `json:"quantity" validate:"required"`
+	TaxClass    string  `json:"taxClass" validate:"omitempty,max=32"`
+	Weight      int     `json:"weight" validate:"min=0"`
+	Length      int     `json:"length" validate:"min=0"`
+	Width       int     `json:"width" validate:"min=0"`
+	Height      int     `json:"height" validate:"min=0"`
 }
 
 type UpdateProductPayload struct {
 	Name        string  `json:"name" validate:"omitempty"`
 	Description string  `json:"description" validate:"omitempty"`
 	Image       string  `json:"image" validate:"omitempty,url"`
-	Price       float64 `json:"price" validate:"omitempty,min=0"`
+	Price       Money   `json:"price" validate:"omitempty,min=0"`
 	Quantity    int     `json:"quantity" validate:"omitempty,min=0"`
+	TaxClass    string  `json:"taxClass" validate:"omitempty,max=32"`
+	// nil leaves the current value
+	Weight *int `json:"weight" validate:"omitempty,min=0"`
+	Length *int `json:"length" validate:"omitempty,min=0"`
+	Width  *int `json:"width" validate:"omitempty,min=0"`
+	Height *int `json:"height" validate:"omitempty,min=0"`
+}
+
+// PUT /products/{id}/prices/{currency}
+type SetProductPricePayload struct {
+	Price Money `json:"price" validate:"required,gt=0"`
 }
 
 type CartStore interface {
+	// WithinTx runs fn in a single db transaction, rolled back if fn errors
+	WithinTx(fn func(tx CheckoutTx) error) error
+	// GetOrCreateCart returns the user's cart, creating an empty one the first time
+	GetOrCreateCart(userID int) (*Cart, error)
+	GetCartItems(cartID int) ([]CartItem, error)
+	// SetCartItem inserts the line or replaces its quantity and price
+	SetCartItem(cartID int, item CartItem) error
+	// RemoveCartItem reports false when the line wasn't in the cart,
+	// variantID is 0 for a product without variants
+	RemoveCartItem(cartID int, productID int, variantID int) (bool, error)
+
+	// guest carts belong to whoever holds the token and expire unless used
+	CreateGuestCart(token string, expiresAt time.Time) (*Cart, error)
+	// GetGuestCart treats an expired cart as missing
+	GetGuestCart(token string) (*Cart, error)
+	RenewGuestCart(cartID int, expiresAt time.Time) error
+	// MergeGuestCart writes items to the user's cart and deletes the guest
+	// cart in one transaction
+	MergeGuestCart(guestCartID int, userCartID int, items []CartItem) error
+	DeleteExpiredGuestCarts(before time.Time) (int64, error)
+}
+
+// GuestCartMerger moves the anonymous cart a request carries into the
+// user's cart once they log in
+type GuestCartMerger interface {
+	MergeGuestCart(w http.ResponseWriter, r *http.Request, userID int) error
+}
+
+// writes made by checkout ... only usable inside CartStore.WithinTx
+type CheckoutTx interface {
+	GetProductForUpdate(id int) (*Product, error)
+	DecrementProductQuantity(id int, quantity int) error
+	// GetProductVariantsForUpdate locks the product's variants, empty for
+	// a product sold without them
+	GetProductVariantsForUpdate(productID int) ([]ProductVariant, error)
+	// DecrementVariantQuantity takes the units off the variant and its
+	// product's total
+	DecrementVariantQuantity(id int, quantity int) error
 	CreateOrder(Order) (int, error)
-	CreateOrderItem(OrderItem) error
+	CreateOrderItem(OrderItem) (int, error)
+	CreateOrderItemTax(OrderItemTax) error
+	// GetPromotionForUpdate locks the promotion so its usage count can't
+	// move until the transaction ends
+	GetPromotionForUpdate(code string) (*Promotion, error)
+	CountPromotionRedemptions(promotionID int, userID int) (int, error)
+	// GetProductCategoryIDs returns the categories of each product and
+	// their ancestors, so a promotion on a category covers its subcategories
+	GetProductCategoryIDs(productIDs []int) (map[int][]int, error)
+	// RecordPromotionRedemption bumps the usage count and remembers who used it
+	RecordPromotionRedemption(promotionID int, userID int, orderID int) error
+	CreateOrderDiscount(OrderDiscount) error
+	// locks the cart's lines so two checkouts can't both consume them
+	GetCartItemsForUpdate(cartID int) ([]CartItem, error)
+	ClearCart(cartID int) error
+}
+
+type OrderStore interface {
 	GetOrderByID(id int) (*Order, error)
-	GetOrdersByUserID(userID int) ([]Order, error)
+	GetOrdersByUserID(userID int, limit, offset int) ([]Order, error)
+	CountOrdersByUserID(userID int) (int, error)
+	GetOrderItemsByOrderID(orderID int) ([]OrderItem, error)
+	GetOrderDiscountsByOrderID(orderID int) ([]OrderDiscount, error)
+	// UpdateOrderStatus only applies if the order is still in status from,
+	// and records the change in the order's history
+	UpdateOrderStatus(orderID int, from, to OrderStatus, changedBy int, reason string) error
+	GetOrderStatusHistory(orderID int) ([]OrderStatusChange, error)
+	// WithinTx runs fn in a single db transaction, rolled back if fn errors
+	WithinTx(fn func(tx OrderTx) error) error
 }
 
+// writes made by cancellations and refunds ... only usable inside
+// OrderStore.WithinTx
+type OrderTx interface {
+	// GetOrderForUpdate locks the order until the transaction ends
+	GetOrderForUpdate(id int) (*Order, error)
+	GetOrderItems(orderID int) ([]OrderItem, error)
+	// CancelOrder restocks every item and moves the order to cancelled
+	CancelOrder(order *Order, cancelledBy int, reason string) error
+	// RecordRefund stores an issued refund, restocks its items if asked and
+	// moves the order to refunded or partially_refunded, a cancelled order
+	// stays cancelled
+	RecordRefund(refund Refund) (int, error)
+}
+
+// gives back what was captured for an order being cancelled ... implemented
+// by payment.Service
+type OrderRefunder interface {
+	RefundCancelledOrder(tx OrderTx, order *Order, refundedBy int, reason string) error
+}
+
+type InvoiceStore interface {
+	// IssueInvoiceNumber gives the order the next number of the year
+	// issuedAt falls in, or returns the one it already has ... numbers
+	// never skip
+	IssueInvoiceNumber(orderID int, issuedAt time.Time) (string, time.Time, error)
+}
+
+// order statuses ... matches the orders.status ENUM
+type OrderStatus string
+
+const (
+	OrderStatusPending   OrderStatus = "pending"
+	OrderStatusPaid      OrderStatus = "paid"
+	OrderStatusShipped   OrderStatus = "shipped"
+	OrderStatusDelivered OrderStatus = "delivered"
+	OrderStatusCancelled OrderStatus = "cancelled"
+
+	OrderStatusPartiallyRefunded OrderStatus = "partially_refunded"
+	OrderStatusRefunded          OrderStatus = "refunded"
+)
+
 type Order struct {
-	ID        int       `json:"id"`
-	UserID    int       `json:"userId"`
-	Total     float64   `json:"total"`
-	Status    string    `json:"status"` // paid pending shipped
-	Address   string    `json:"address"`
-	CreatedAt time.Time `json:"createdAt"`
+	ID        int         `json:"id"`
+	UserID    int         `json:"userId"`
+	Currency  string      `json:"currency"`
+	Total     Money       `json:"total"`
+	Status    OrderStatus `json:"status"`
+	CreatedAt time.Time   `json:"createdAt"`
+
+	RefundedTotal Money `json:"refundedTotal"`
+
+	// Total = Subtotal - DiscountTotal + TaxTotal - TaxIncluded + ShippingTotal
+	Subtotal Money `json:"subtotal"`
+	// taken off by a promotion
+	DiscountTotal Money `json:"discountTotal"`
+	TaxTotal      Money `json:"taxTotal"`
+	// the part of TaxTotal already in the prices
+	TaxIncluded   Money `json:"taxIncluded"`
+	ShippingTotal Money `json:"shippingTotal"`
+	// code of the shipping method chosen at checkout
+	ShippingMethod string `json:"shippingMethod"`
+
+	// copied from the address book at checkout ... the order is taxed
+	// where it ships
+	ShippingAddress Address `json:"shippingAddress"`
+	BillingAddress  Address `json:"billingAddress"`
+
+	// set once the first invoice is downloaded
+	InvoiceNumber string     `json:"invoiceNumber,omitempty"`
+	InvoicedAt    *time.Time `json:"invoicedAt,omitempty"`
+}
+
+// one row of order_status_history
+type OrderStatusChange struct {
+	ID         int         `json:"id"`
+	OrderID    int         `json:"orderId"`
+	FromStatus OrderStatus `json:"fromStatus"`
+	ToStatus   OrderStatus `json:"toStatus"`
+	ChangedBy  *int        `json:"changedBy"` // nil when changed by the system
+	Reason     string      `json:"reason"`
+	CreatedAt  time.Time   `json:"createdAt"`
+}
+
+type UpdateOrderStatusPayload struct {
+	Status OrderStatus `json:"status" validate:"required,oneof=pending paid shipped delivered cancelled"`
+	Reason string      `json:"reason" validate:"max=255"`
+}
+
+type CancelOrderPayload struct {
+	Reason string `json:"reason" validate:"max=255"`
 }
 
 type OrderItem struct {
-	ID        int     `json:"id"`
-	OrderID   int     `json:"orderId"`
-	ProductID int     `json:"productId"`
-	Quantity  int     `json:"quantity"`
-	Price     float64 `json:"price"` // price at time of purchase
+	ID           int     `json:"id"`
+	OrderID      int     `json:"orderId"`
+	ProductID    int     `json:"productId"`
+	VariantID    int     `json:"variantId,omitempty"`
+	SKU          string  `json:"sku,omitempty"` // snapshot at time of purchase
+	ProductName  string  `json:"productName"`  // snapshot at time of purchase
+	ProductImage string  `json:"productImage"` // snapshot at time of purchase
+	Quantity     int     `json:"quantity"`
+	Price        Money   `json:"price"` // price at time of purchase
+	// the line's share of the order discount, across all its units
+	Discount Money `json:"discount"`
+	// all tax on the line, included in the price or not
+	Tax Money `json:"tax"`
+	// what the line cost the customer, discount and tax taken into account
+	Total Money          `json:"total"`
+	Taxes []OrderItemTax `json:"taxes"`
+
+	RefundedQuantity int `json:"refundedQuantity"`
+}
+
+// one tax rate applied to an order item ... kept for invoicing
+type OrderItemTax struct {
+	ID          int    `json:"id"`
+	OrderItemID int    `json:"orderItemId"`
+	Name        string `json:"name"`
+	Rate        int    `json:"rate"` // basis points
+	Inclusive   bool   `json:"inclusive"`
+	// the line's amount net of tax
+	Taxable Money `json:"taxable"`
+	Amount  Money `json:"amount"`
+}
+
+// money returned on a paid order
+type Refund struct {
+	ID               int          `json:"id"`
+	OrderID          int          `json:"orderId"`
+	PaymentID        int          `json:"paymentId"`
+	ProviderRefundID string       `json:"providerRefundId"`
+	Amount           Money        `json:"amount"`
+	Currency         string       `json:"currency"`
+	Reason           string       `json:"reason"`
+	Restock          bool         `json:"restock"`
+	Items            []RefundItem `json:"items"`
+	CreatedBy        int          `json:"createdBy"`
+	CreatedAt        time.Time    `json:"createdAt"`
+}
+
+type RefundItem struct {
+	OrderItemID int `json:"orderItemId" validate:"required"`
+	Quantity    int `json:"quantity" validate:"required,min=1"`
+}
+
+// either items (refunded at what the customer paid for them, discount and
+// tax included) or an amount, not both
+type CreateRefundPayload struct {
+	Items   []RefundItem `json:"items" validate:"required_without=Amount,excluded_with=Amount,dive"`
+	Amount  Money        `json:"amount" validate:"required_without=Items,excluded_with=Items,omitempty,gt=0"`
+	Restock bool         `json:"restock"`
+	Reason  string       `json:"reason" validate:"max=255"`
+}
+
+// GET /orders
+type OrderListResponse struct {
+	Orders []Order `json:"orders"`
+	Page   int     `json:"page"`
+	Limit  int     `json:"limit"`
+	Total  int     `json:"total"`
+}
+
+// GET /products
+type ProductListResponse struct {
+	Products []Product `json:"products"`
+	Limit    int       `json:"limit"`
+	// pass as ?cursor= for the next page, empty on the last one
+	NextCursor string `json:"nextCursor,omitempty"`
+	// only with ?total=true
+	Total *int `json:"total,omitempty"`
+}
+
+type SearchQuery struct {
+	Text       string
+	CategoryID int
+	InStock    bool
+	Limit      int
+	Offset     int
+}
+
+// SearchResult is one page of hits, best first, with the total and the
+// facets counted over every hit
+type SearchResult struct {
+	Hits   []SearchHit  `json:"hits"`
+	Total  int          `json:"total"`
+	Facets SearchFacets `json:"facets"`
+	// nothing matched the words as typed, the hits are close spellings
+	Fuzzy bool `json:"fuzzy"`
+}
+
+type SearchHit struct {
+	Product Product `json:"product"`
+	Score   float64 `json:"score"`
+	// HTML escaped, matched words wrapped in <mark>
+	Snippet string `json:"snippet"`
+}
+
+type SearchFacets struct {
+	Categories  []CategoryFacet   `json:"categories"`
+	PriceRanges []PriceRangeFacet `json:"priceRanges"`
+	InStock     int               `json:"inStock"`
+}
+
+type CategoryFacet struct {
+	ID    int    `json:"id"`
+	Name  string `json:"name"`
+	Slug  string `json:"slug"`
+	Count int    `json:"count"`
+}
+
+// prices from Min up to but not including Max, nil Max has no upper bound
+type PriceRangeFacet struct {
+	Min   Money  `json:"min"`
+	Max   *Money `json:"max,omitempty"`
+	Count int    `json:"count"`
+}
+
+type Category struct {
+	ID   int    `json:"id"`
+	Name string `json:"name"`
+	Slug string `json:"slug"`
+	// nil for a top level category
+	ParentID  *int      `json:"parentId"`
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+// a category with its subcategories, for GET /categories
+type CategoryNode struct {
+	Category
+	Children []CategoryNode `json:"children"`
+}
+
+type CategoryPayload struct {
+	Name string `json:"name" validate:"required,max=255"`
+	// made from the name when empty
+	Slug string `json:"slug" validate:"max=255"`
+	// only read on create, moving has its own endpoint
+	ParentID *int `json:"parentId" validate:"omitempty,min=1"`
+}
+
+// nil ParentID moves the category to the top level
+type MoveCategoryPayload struct {
+	ParentID *int `json:"parentId" validate:"omitempty,min=1"`
+}
+
+type ProductCategoriesPayload struct {
+	CategoryIDs []int `json:"categoryIds" validate:"required,dive,min=1"`
+}
+
+// GET /products/{id}
+type ProductDetailResponse struct {
+	Product
+	// the path from the top level to each of the product's categories
+	Breadcrumbs [][]Category `json:"breadcrumbs"`
+	// the variant matrix, both empty for a product without variants
+	Options  []ProductOption  `json:"options"`
+	Variants []ProductVariant `json:"variants"`
+}
+
+type ProductOptionsPayload struct {
+	Options []ProductOption `json:"options" validate:"max=3,dive"`
+}
+
+type VariantPayload struct {
+	SKU string `json:"sku" validate:"required,max=64"`
+	// 0 or left out sells at the product's price
+	Price Money  `json:"price" validate:"min=0"`
+	Image string `json:"image" validate:"omitempty,url,max=255"`
+	// units in stock
+	Quantity int `json:"quantity" validate:"min=0"`
+	// option name to value, one for every option of the product
+	Options map[string]string `json:"options" validate:"required"`
+}
+
+// GET /products/search
+type SearchResponse struct {
+	Query string `json:"query"`
+	SearchResult
+	Limit  int `json:"limit"`
+	Offset int `json:"offset"`
+}
+
+// GET /orders/{id}
+type OrderDetailResponse struct {
+	Order
+	Items     []OrderItem     `json:"items"`
+	Discounts []OrderDiscount `json:"discounts"`
+}
+
+type IdempotencyStore interface {
+	// ReserveIdempotencyKey claims the key, or returns the record that
+	// already holds it with reserved == false
+	ReserveIdempotencyKey(record IdempotencyRecord) (existing *IdempotencyRecord, reserved bool, err error)
+	CompleteIdempotencyKey(userID int, key string, status int, body []byte) error
+	DeleteIdempotencyKey(userID int, key string) error
+}
+
+// stored response of the first request made with an Idempotency-Key
+type IdempotencyRecord struct {
+	UserID         int
+	Key            string
+	RequestHash    string
+	ResponseStatus int // 0 while the first request is still running
+	ResponseBody   []byte
+	ExpiresAt      time.Time
+	CreatedAt      time.Time
+}
+
+// PaymentProvider is a payment gateway ... amounts are in minor units
+// (cents) of the given currency
+type PaymentProvider interface {
+	Name() string
+	CreateIntent(orderID int, amount int64, currency string) (*PaymentIntent, error)
+	Confirm(intentID string, method PaymentMethod) (*PaymentIntent, error)
+	Capture(intentID string) (*PaymentIntent, error)
+	// Cancel abandons an intent that hasn't been confirmed or is waiting on
+	// customer action, so it can never be charged
+	Cancel(intentID string) (*PaymentIntent, error)
+	Refund(intentID string, amount int64) (*PaymentRefund, error)
+	// VerifyWebhookSignature checks the signature header of a webhook,
+	// rejecting stale timestamps
+	VerifyWebhookSignature(payload []byte, signature string) error
+	ParseWebhookEvent(payload []byte) (*PaymentEvent, error)
+}
+
+type PaymentEventType string
+
+const (
+	PaymentEventSucceeded PaymentEventType = "payment.succeeded"
+	PaymentEventFailed    PaymentEventType = "payment.failed"
+)
+
+// asynchronous notification from a payment provider
+type PaymentEvent struct {
+	ID            string           `json:"id"`
+	Type          PaymentEventType `json:"type"`
+	IntentID      string           `json:"intentId"`
+	FailureReason string           `json:"failureReason"`
+}
+
+type PaymentStatus string
+
+const (
+	PaymentStatusRequiresPaymentMethod PaymentStatus = "requires_payment_method"
+	PaymentStatusRequiresAction        PaymentStatus = "requires_action" // e.g. 3-D Secure
+	PaymentStatusRequiresCapture       PaymentStatus = "requires_capture"
+	PaymentStatusSucceeded             PaymentStatus = "succeeded"
+	PaymentStatusFailed                PaymentStatus = "failed"
+)
+
+type PaymentIntent struct {
+	ID            string        `json:"id"`
+	Amount        int64         `json:"amount"`
+	Currency      string        `json:"currency"`
+	Status        PaymentStatus `json:"status"`
+	FailureReason string        `json:"failureReason,omitempty"`
+}
+
+type PaymentRefund struct {
+	ID       string `json:"id"`
+	IntentID string `json:"intentId"`
+	Amount   int64  `json:"amount"`
+}
+
+// card details are passed straight to the provider and never stored ...
+// ThreeDSecure carries the customer's answer when resuming a payment
+type PaymentMethod struct {
+	CardNumber   string `json:"cardNumber" validate:"required,numeric,min=12,max=19"`
+	ThreeDSecure string `json:"threeDSecure" validate:"omitempty,oneof=passed failed"`
+}
+
+// pays for orders ... implemented by payment.Service
+type PaymentService interface {
+	PayOrder(order *Order, method PaymentMethod) (*Payment, error)
+}
+
+type PaymentStore interface {
+	// CreatePayment records a new attempt, refused unless the order is
+	// pending and its earlier attempts all failed
+	CreatePayment(Payment) (int, error)
+	UpdatePayment(Payment) error
+	GetPaymentByID(id int) (*Payment, error)
+	GetPaymentsByOrderID(orderID int) ([]Payment, error)
+	GetPaymentByProviderIntentID(provider, intentID string) (*Payment, error)
+	// RecordWebhookEvent returns false if the event was seen before
+	RecordWebhookEvent(provider, eventID string, eventType PaymentEventType) (bool, error)
+	DeleteWebhookEvent(provider, eventID string) error
+}
+
+// one payment attempt against an order
+type Payment struct {
+	ID               int           `json:"id"`
+	OrderID          int           `json:"orderId"`
+	Provider         string        `json:"provider"`
+	ProviderIntentID string        `json:"providerIntentId"`
+	Amount           Money         `json:"amount"`
+	Currency         string        `json:"currency"`
+	Status           PaymentStatus `json:"status"`
+	FailureReason    string        `json:"failureReason"`
+	CreatedAt        time.Time     `json:"createdAt"`
+	UpdatedAt        time.Time     `json:"updatedAt"`
+}
+
+type ConfirmPaymentPayload struct {
+	ThreeDSecure string `json:"threeDSecure" validate:"required,oneof=passed failed"`
 }
 
 // checkout
 type CheckoutPayload struct {
-	Address string         `json:"address" validate:"required"`
-	Items   []CheckoutItem `json:"items" validate:"required,min=1"`
+	Items []CheckoutItem `json:"items" validate:"required_without=FromCart,excluded_with=FromCart,omitempty,min=1,dive"`
+	// checks out the stored cart instead of items
+	FromCart bool `json:"fromCart"`
+	// goes ahead with a stored cart whose prices moved since the items were
+	// added, otherwise checkout answers 409 with the changes
+	AcceptPriceChanges bool `json:"acceptPriceChanges"`
+	// optional discount code
+	PromotionCode string `json:"promotionCode" validate:"omitempty,max=64"`
+	// code of a method from GET /shipping/quote
+	ShippingMethod string `json:"shippingMethod" validate:"required,max=64"`
+	// a saved address or one given inline, the user's default address
+	// when neither is
+	ShippingAddressID int      `json:"shippingAddressId" validate:"omitempty,min=1,excluded_with=ShippingAddress"`
+	ShippingAddress   *Address `json:"shippingAddress" validate:"omitempty"`
+	// the shipping address unless given
+	BillingAddressID int      `json:"billingAddressId" validate:"omitempty,min=1,excluded_with=BillingAddress"`
+	BillingAddress   *Address `json:"billingAddress" validate:"omitempty"`
+	// optional ... pays for the order straight away
+	Payment *PaymentMethod `json:"payment" validate:"omitempty"`
 }
 
 type CheckoutItem struct {
 	ProductID int `json:"productId" validate:"required"`
+	// required for a product sold in variants
+	VariantID int `json:"variantId" validate:"omitempty,min=1"`
 	Quantity  int `json:"quantity" validate:"required,min=1"`
 }
+
+// Cart belongs to a user, or to a guest when UserID is 0
+type Cart struct {
+	ID     int `json:"id"`
+	UserID int `json:"userId"`
+	// only guest carts expire
+	ExpiresAt *time.Time `json:"expiresAt,omitempty"`
+	CreatedAt time.Time  `json:"createdAt"`
+	UpdatedAt time.Time  `json:"updatedAt"`
+}
+
+// CartItem is a stored line ... Price is the unit price when it was added,
+// in the currency the customer was shopping in
+type CartItem struct {
+	CartID    int       `json:"cartId"`
+	ProductID int       `json:"productId"`
+	VariantID int       `json:"variantId,omitempty"`
+	Quantity  int       `json:"quantity"`
+	Price     Money     `json:"price"`
+	AddedAt   time.Time `json:"addedAt"`
+}
+
+// CartView is the cart re-checked against current stock and prices
+type CartView struct {
+	Currency string     `json:"currency"`
+	Items    []CartLine `json:"items"`
+	Subtotal Money      `json:"subtotal"`
+	// true when a price moved or a line can't be bought as it stands
+	HasChanges bool `json:"hasChanges"`
+}
+
+type CartLine struct {
+	ProductID int `json:"productId"`
+	// set for a product sold in variants
+	VariantID int             `json:"variantId,omitempty"`
+	SKU       string          `json:"sku,omitempty"`
+	Options   []VariantOption `json:"options,omitempty"`
+	Name      string          `json:"name"`
+	Image     string          `json:"image"`
+	Quantity  int             `json:"quantity"`
+	// current unit price
+	Price Money `json:"price"`
+	// unit price when the item was added, only set when it differs
+	PreviousPrice *Money `json:"previousPrice,omitempty"`
+	LineTotal     Money  `json:"lineTotal"`
+	// units in stock, Available is false when it's less than Quantity or
+	// the product is gone
+	InStock   int  `json:"inStock"`
+	Available bool `json:"available"`
+}
+
+// a line whose price moved between adding it and checking out
+type CartPriceChange struct {
+	ProductID     int   `json:"productId"`
+	VariantID     int   `json:"variantId,omitempty"`
+	PreviousPrice Money `json:"previousPrice"`
+	Price         Money `json:"price"`
+}
+
+type AddCartItemPayload struct {
+	ProductID int `json:"productId" validate:"required"`
+	// required for a product sold in variants
+	VariantID int `json:"variantId" validate:"omitempty,min=1"`
+	Quantity  int `json:"quantity" validate:"required,min=1"`
+}
+
+type UpdateCartItemPayload struct {
+	Quantity int `json:"quantity" validate:"required,min=1"`
+}
+
+type CategoryStore interface {
+	GetCategories() ([]Category, error)
+	GetCategoryByID(id int) (*Category, error)
+	GetCategoryBySlug(slug string) (*Category, error)
+	CreateCategory(Category) (int, error)
+	// UpdateCategory renames a category, it stays where it is in the tree
+	UpdateCategory(Category) error
+	// MoveCategory puts a category and everything under it below parentID,
+	// in one transaction that refuses to nest a category inside itself
+	MoveCategory(id int, parentID *int) error
+	// DeleteCategory refuses a category that has subcategories
+	DeleteCategory(id int) error
+	// GetProductBreadcrumbs returns the path from the top level to each
+	// category of the product, leaving out paths inside longer ones
+	GetProductBreadcrumbs(productID int) ([][]Category, error)
+	// SetProductCategories replaces the categories of a product
+	SetProductCategories(productID int, categoryIDs []int) error
+}
+
+type PromotionStore interface {
+	GetPromotions() ([]Promotion, error)
+	GetPromotionByID(id int) (*Promotion, error)
+	GetPromotionByCode(code string) (*Promotion, error)
+	CreatePromotion(Promotion) (int, error)
+	UpdatePromotion(Promotion) error
+	DeletePromotion(id int) error
+}
+
+type PromotionType string
+
+const (
+	PromotionPercent      PromotionType = "percent"
+	PromotionFixed        PromotionType = "fixed"
+	PromotionFreeShipping PromotionType = "free_shipping"
+	// buy BuyQuantity and the cheapest GetQuantity of them are free
+	PromotionBuyXGetY PromotionType = "buy_x_get_y"
+)
+
+// Promotion is a discount code. Amounts are in Currency and only apply to
+// orders in that currency, a percentage applies in any currency unless
+// there's a minimum spend.
+type Promotion struct {
+	ID          int           `json:"id"`
+	Code        string        `json:"code"`
+	Description string        `json:"description"`
+	Type        PromotionType `json:"type"`
+	PercentOff  int           `json:"percentOff"`
+	AmountOff   Money         `json:"amountOff"`
+	Currency    string        `json:"currency"`
+	MinSpend    Money         `json:"minSpend"`
+	BuyQuantity int           `json:"buyQuantity"`
+	GetQuantity int           `json:"getQuantity"`
+	StartsAt    *time.Time    `json:"startsAt"`
+	EndsAt      *time.Time    `json:"endsAt"`
+	// nil means unlimited
+	UsageLimit   *int `json:"usageLimit"`
+	PerUserLimit *int `json:"perUserLimit"`
+	UsageCount   int  `json:"usageCount"`
+	// no products or categories means every item is eligible
+	ProductIDs  []int     `json:"productIds"`
+	CategoryIDs []int     `json:"categoryIds"`
+	Active      bool      `json:"active"`
+	CreatedAt   time.Time `json:"createdAt"`
+}
+
+// amounts are decimal strings read in Currency
+type PromotionPayload struct {
+	Code         string        `json:"code" validate:"required,max=64,excludesall= "`
+	Description  string        `json:"description" validate:"max=255"`
+	Type         PromotionType `json:"type" validate:"required,oneof=percent fixed free_shipping buy_x_get_y"`
+	PercentOff   int           `json:"percentOff" validate:"required_if=Type percent,omitempty,min=1,max=100"`
+	AmountOff    string        `json:"amountOff" validate:"required_if=Type fixed"`
+	Currency     string        `json:"currency" validate:"omitempty,len=3"`
+	MinSpend     string        `json:"minSpend"`
+	BuyQuantity  int           `json:"buyQuantity" validate:"required_if=Type buy_x_get_y,omitempty,min=1"`
+	GetQuantity  int           `json:"getQuantity" validate:"required_if=Type buy_x_get_y,omitempty,min=1"`
+	StartsAt     *time.Time    `json:"startsAt"`
+	EndsAt       *time.Time    `json:"endsAt"`
+	UsageLimit   *int          `json:"usageLimit" validate:"omitempty,min=1"`
+	PerUserLimit *int          `json:"perUserLimit" validate:"omitempty,min=1"`
+	ProductIDs   []int         `json:"productIds" validate:"omitempty,dive,min=1"`
+	CategoryIDs  []int         `json:"categoryIds" validate:"omitempty,dive,min=1"`
+	// defaults to true
+	Active *bool `json:"active"`
+}
+
+// a promotion applied to an order
+type OrderDiscount struct {
+	ID           int           `json:"id"`
+	OrderID      int           `json:"orderId"`
+	PromotionID  int           `json:"promotionId"`
+	Code         string        `json:"code"`
+	Type         PromotionType `json:"type"`
+	Amount       Money         `json:"amount"`
+	FreeShipping bool          `json:"freeShipping"`
+}
+
+const TaxClassStandard = "standard"
+
+// TaxCalculator works out the tax on each line of an order taxed at address
+type TaxCalculator interface {
+	CalculateTax(address TaxAddress, lines []TaxableLine) ([]LineTax, error)
+}
+
+type TaxRateStore interface {
+	// GetTaxRates returns every rate of the country, for all regions and classes
+	GetTaxRates(country string) ([]TaxRate, error)
+}
+
+type TaxAddress struct {
+	Country string
+	Region  string
+}
+
+// TaxableLine is an order line after discounts, Amount covers all its units
+type TaxableLine struct {
+	ProductID int
+	VariantID int
+	TaxClass  string
+	Amount    Money
+}
+
+// LineTax is the tax on one TaxableLine, one entry in Taxes per rate applied
+type LineTax struct {
+	ProductID int
+	VariantID int
+	Tax       Money
+	// the part of Tax already in the line's amount
+	Included Money
+	Taxes    []OrderItemTax
+}
+
+// one row of tax_rates ... a rate without a region applies to the whole
+// country and adds up with the region's own rates
+type TaxRate struct {
+	ID        int    `json:"id"`
+	Country   string `json:"country"`
+	Region    string `json:"region"`
+	TaxClass  string `json:"taxClass"`
+	Name      string `json:"name"`
+	Rate      int    `json:"rate"` // basis points, 2000 is 20%
+	Inclusive bool   `json:"inclusive"`
+}
+
+type ShippingStore interface {
+	GetShippingMethods() ([]ShippingMethod, error)
+	GetShippingMethodByID(id int) (*ShippingMethod, error)
+	GetShippingMethodByCode(code string) (*ShippingMethod, error)
+	CreateShippingMethod(ShippingMethod) (int, error)
+	UpdateShippingMethod(ShippingMethod) error
+	DeleteShippingMethod(id int) error
+}
+
+// ShippingCalculator prices shipping a parcel, in the parcel's currency
+type ShippingCalculator interface {
+	// QuoteShipping returns every active method that ships the parcel,
+	// cheapest first
+	QuoteShipping(parcel Parcel) ([]ShippingQuote, error)
+	QuoteShippingMethod(code string, parcel Parcel) (*ShippingQuote, error)
+}
+
+type ShippingRateType string
+
+const (
+	ShippingFlat ShippingRateType = "flat"
+	// Price plus PerKg for every kilogram
+	ShippingWeight ShippingRateType = "weight"
+	// Price, or nothing once the order reaches FreeOver
+	ShippingFreeOver ShippingRateType = "free_over"
+)
+
+// ShippingMethod amounts are in the default currency and converted at
+// quote time
+type ShippingMethod struct {
+	ID       int              `json:"id"`
+	Code     string           `json:"code"`
+	Name     string           `json:"name"`
+	Type     ShippingRateType `json:"type"`
+	Price    Money            `json:"price"`
+	PerKg    Money            `json:"perKg"`
+	FreeOver Money            `json:"freeOver"`
+	// grams, 0 means no limit
+	MaxWeight int `json:"maxWeight"`
+	// ISO country codes, none means everywhere
+	Countries []string  `json:"countries"`
+	Active    bool      `json:"active"`
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+// amounts are decimal strings in the default currency
+type ShippingMethodPayload struct {
+	Code      string           `json:"code" validate:"required,max=64,excludesall= "`
+	Name      string           `json:"name" validate:"required,max=255"`
+	Type      ShippingRateType `json:"type" validate:"required,oneof=flat weight free_over"`
+	Price     string           `json:"price" validate:"required"`
+	PerKg     string           `json:"perKg" validate:"required_if=Type weight"`
+	FreeOver  string           `json:"freeOver" validate:"required_if=Type free_over"`
+	MaxWeight int              `json:"maxWeight" validate:"min=0"`
+	Countries []string         `json:"countries" validate:"omitempty,dive,iso3166_1_alpha2"`
+	// defaults to true
+	Active *bool `json:"active"`
+}
+
+// Parcel is what a quote is worked out from
+type Parcel struct {
+	// chargeable weight in grams
+	Weight int
+	// what the items cost before discounts, in the customer's currency
+	Subtotal Money
+	Country  string
+}
+
+type ShippingQuote struct {
+	Method string `json:"method"`
+	Name   string `json:"name"`
+	Price  Money  `json:"price"`
+}
@@ -4,11 +4,26 @@ import (
 	"encoding/json"
 	"fmt"
 	"net/http"
+	"reflect"
 
+	"github.com/eugenius-watchman/ecom_go_rest_api/types"
 	"github.com/go-playground/validator/v10"
 )
 
-var Validate = validator.New()
+var Validate = newValidator()
+
+// money is validated on its minor units, so min=0 or gt=0 work as usual
+func newValidator() *validator.Validate {
+	v := validator.New()
+	v.RegisterCustomTypeFunc(func(field reflect.Value) any {
+		if money, ok := field.Interface().(types.Money); ok {
+			return money.Amount
+		}
+		return nil
+	}, types.Money{})
+
+	return v
+}
 
 func ParseJSON(r *http.Request, payload any) error {
 	if r.Body == nil {